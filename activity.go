@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	activityBucket  = time.Minute
+	activityBuckets = 24 * 60 // 24h of one-minute buckets
+)
+
+// activity counts broadcast messages per minute over a fixed 24h ring, so its
+// memory does not grow with traffic. Counting is lock-free; a ticker in the
+// room advances the current bucket.
+type activity struct {
+	buckets [activityBuckets]atomic.Int64
+	current atomic.Int64 // Minutes since the Unix epoch of the bucket being filled
+	rotate  sync.Mutex   // Serializes advance; record never takes it
+}
+
+func newActivity(now time.Time) *activity {
+	a := &activity{}
+	a.current.Store(now.Unix() / int64(activityBucket/time.Second))
+	return a
+}
+
+func (a *activity) record() {
+	a.buckets[a.current.Load()%activityBuckets].Add(1)
+}
+
+// advance moves the current bucket up to now, zeroing every bucket it passes
+// so a stalled ticker cannot leave stale counts in the ring.
+func (a *activity) advance(now time.Time) {
+	a.rotate.Lock()
+	defer a.rotate.Unlock()
+	target := now.Unix() / int64(activityBucket/time.Second)
+	cur := a.current.Load()
+	if target-cur > activityBuckets {
+		cur = target - activityBuckets
+	}
+	for cur < target {
+		cur++
+		a.buckets[cur%activityBuckets].Store(0)
+		a.current.Store(cur)
+	}
+}
+
+// ActivitySeries is the body of /activity: message counts per bucket, oldest
+// first, with the last entry being the bucket still being filled.
+type ActivitySeries struct {
+	Start         time.Time `json:"start"`
+	BucketSeconds int       `json:"bucket_seconds"`
+	Counts        []int64   `json:"counts"`
+}
+
+func (a *activity) series() ActivitySeries {
+	cur := a.current.Load()
+	first := cur - activityBuckets + 1
+	counts := make([]int64, activityBuckets)
+	for i := range counts {
+		counts[i] = a.buckets[(first+int64(i))%activityBuckets].Load()
+	}
+	return ActivitySeries{
+		Start:         time.Unix(first*int64(activityBucket/time.Second), 0).UTC(),
+		BucketSeconds: int(activityBucket / time.Second),
+		Counts:        counts,
+	}
+}
+
+func (cr *ChatRoom) rotateActivity() {
+	defer cr.stopWorker(workerActivity)
+	ticker := cr.clock.NewTicker(activityBucket)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C():
+			cr.activity.advance(now)
+			cr.workers.ran(workerActivity, now)
+		case <-cr.done:
+			return
+		}
+	}
+}
+
+func (cr *ChatRoom) HandleActivity(w http.ResponseWriter, r *http.Request) {
+	cr.activity.advance(cr.clock.Now())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cr.activity.series())
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"chatroom/testutil"
+)
+
+func TestActivityRingRotatesAndForgetsOldBuckets(t *testing.T) {
+	start := time.Date(2026, 1, 2, 3, 4, 0, 0, time.UTC)
+	a := newActivity(start)
+	a.record()
+	a.record()
+	a.advance(start.Add(time.Minute + 30*time.Second))
+	a.record()
+
+	s := a.series()
+	if len(s.Counts) != activityBuckets || s.BucketSeconds != 60 {
+		t.Fatalf("series has %d buckets of %ds, want %d of 60s", len(s.Counts), s.BucketSeconds, activityBuckets)
+	}
+	if want := start.Add(2*time.Minute - 24*time.Hour); !s.Start.Equal(want) {
+		t.Errorf("series starts at %v, want %v", s.Start, want)
+	}
+	if got := s.Counts[activityBuckets-2:]; got[0] != 2 || got[1] != 1 {
+		t.Errorf("last two buckets %v, want [2 1]", got)
+	}
+
+	// A stall longer than the ring leaves nothing of the old counts behind.
+	a.advance(start.Add(25 * time.Hour))
+	for i, n := range a.series().Counts {
+		if n != 0 {
+			t.Fatalf("bucket %d still holds %d after a day without ticks", i, n)
+		}
+	}
+}
+
+func TestActivityCountsBroadcastMessagesPerMinute(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 0, 0, time.UTC))
+	_, h := newTestRoom(t, WithClock(clk))
+	token := join(t, h, "alice")
+	series := func() []int64 {
+		t.Helper()
+		var s ActivitySeries
+		if w := do(h, "GET", "/activity"); w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &s) != nil {
+			t.Fatalf("activity: %d %s", w.Code, w.Body)
+		}
+		return s.Counts
+	}
+
+	for _, body := range []string{"one", "two", "three"} {
+		send(h, token, body)
+	}
+	eventually(t, "three messages in the current minute", func() bool {
+		return series()[activityBuckets-1] == 3
+	})
+	clk.Advance(time.Minute)
+	send(h, token, "four")
+	eventually(t, "the next minute to count the fourth message", func() bool {
+		c := series()
+		return c[activityBuckets-2] == 3 && c[activityBuckets-1] == 1
+	})
+}
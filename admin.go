@@ -0,0 +1,35 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// WithAdminToken enables the admin API. Requests authenticate with
+// "Authorization: Bearer <token>". With no token configured every admin
+// endpoint is refused.
+func WithAdminToken(token string) Option {
+	return func(cr *ChatRoom) {
+		cr.adminToken = token
+	}
+}
+
+func (cr *ChatRoom) isAdmin(r *http.Request) bool {
+	if cr.adminToken == "" {
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(cr.adminToken)) == 1
+}
+
+// requireAdmin wraps h so that only requests carrying the admin token reach it.
+func (cr *ChatRoom) requireAdmin(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !cr.isAdmin(r) {
+			http.Error(w, "Admin credentials required", http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
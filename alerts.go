@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	alertInterval = 10 * time.Second
+	// A firing alert resolves only once its metric falls below this fraction
+	// of the threshold, so a value hovering at the threshold does not flap.
+	alertClearRatio = 0.8
+	webhookTimeout  = 5 * time.Second
+	maxAlertEvents  = 50
+
+	alertFiring   = "firing"
+	alertResolved = "resolved"
+
+	metricDropRate   = "drop_rate"
+	metricQueueDepth = "broadcast_queue_depth"
+	metricP99        = "delivery_p99_ms"
+)
+
+// AlertConfig sets the thresholds the room watches. A zero threshold turns
+// that alert off.
+type AlertConfig struct {
+	DropRate   float64       // Fraction of deliveries dropped in an interval
+	QueueDepth int           // Messages waiting for the broadcast loop
+	P99        time.Duration // Delivery latency p99 over an interval
+	For        time.Duration // How long a condition must hold before firing or resolving
+	Notify     string        // Client ID sent each event as an ephemeral system message
+	Webhook    string        // URL each event is POSTed to as JSON
+	Client     *http.Client  // Used for Webhook instead of the shared outbound client
+}
+
+func (c AlertConfig) enabled() bool {
+	return c.DropRate > 0 || c.QueueDepth > 0 || c.P99 > 0
+}
+
+// AlertEvent records an alert starting or clearing.
+type AlertEvent struct {
+	Metric    string    `json:"metric"`
+	State     string    `json:"state"`
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	Time      time.Time `json:"time"`
+}
+
+type alertState struct {
+	firing bool
+	since  time.Time // When the metric crossed towards the other state; zero when it has not
+}
+
+// alerts holds the watcher's state. The counters are the totals at the last
+// check, so each check looks only at its own interval.
+type alerts struct {
+	mu        sync.Mutex
+	cfg       AlertConfig
+	states    map[string]*alertState
+	events    []AlertEvent // Most recent last, at most maxAlertEvents
+	delivered uint64
+	dropped   uint64
+	latency   [len(latencyBuckets) + 1]uint64
+}
+
+// WithAlerts watches drop rate, queue depth and delivery p99, logging an
+// event when a threshold is crossed for cfg.For and again when it clears.
+func WithAlerts(cfg AlertConfig) Option {
+	return func(cr *ChatRoom) {
+		cr.alerts.cfg = cfg
+	}
+}
+
+func (cr *ChatRoom) watchAlerts() {
+	defer cr.stopWorker(workerAlerts)
+	ticker := cr.clock.NewTicker(alertInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C():
+			cr.checkAlerts(now)
+			cr.workers.ran(workerAlerts, now)
+		case <-cr.done:
+			return
+		}
+	}
+}
+
+// checkAlerts samples each metric over the interval since the last check.
+func (cr *ChatRoom) checkAlerts(now time.Time) {
+	a := &cr.alerts
+	delivered, dropped := cr.delivered.Load(), cr.dropped.Load()
+	counts, _ := cr.latency.snapshot()
+
+	a.mu.Lock()
+	var recent [len(latencyBuckets) + 1]uint64
+	var total uint64
+	for i := range counts {
+		recent[i] = counts[i] - a.latency[i]
+		total += recent[i]
+	}
+	dropRate := 0.0
+	if n := delivered - a.delivered + dropped - a.dropped; n > 0 {
+		dropRate = float64(dropped-a.dropped) / float64(n)
+	}
+	a.delivered, a.dropped, a.latency = delivered, dropped, counts
+	cfg := a.cfg
+	var events []AlertEvent
+	if cfg.DropRate > 0 {
+		events = a.evaluateLocked(events, metricDropRate, dropRate, cfg.DropRate, now)
+	}
+	if cfg.QueueDepth > 0 {
+		events = a.evaluateLocked(events, metricQueueDepth, float64(len(cr.broadcast)), float64(cfg.QueueDepth), now)
+	}
+	if cfg.P99 > 0 {
+		p99 := roundMillis(quantile(recent, total, 0.99) * 1000)
+		events = a.evaluateLocked(events, metricP99, p99, float64(cfg.P99)/float64(time.Millisecond), now)
+	}
+	a.mu.Unlock()
+
+	for _, e := range events {
+		cr.emitAlert(e)
+	}
+}
+
+// evaluateLocked flips an alert once its metric has stayed past the
+// threshold (or, while firing, below alertClearRatio of it) for cfg.For.
+func (a *alerts) evaluateLocked(events []AlertEvent, metric string, value, threshold float64, now time.Time) []AlertEvent {
+	st := a.states[metric]
+	if st == nil {
+		st = &alertState{}
+		a.states[metric] = st
+	}
+	crossing := value >= threshold
+	if st.firing {
+		crossing = value < threshold*alertClearRatio
+	}
+	if !crossing {
+		st.since = time.Time{}
+		return events
+	}
+	if st.since.IsZero() {
+		st.since = now
+	}
+	if now.Sub(st.since) < a.cfg.For {
+		return events
+	}
+	st.firing, st.since = !st.firing, time.Time{}
+	e := AlertEvent{Metric: metric, State: alertResolved, Value: value, Threshold: threshold, Time: now}
+	if st.firing {
+		e.State = alertFiring
+	}
+	a.events = append(a.events, e)
+	if len(a.events) > maxAlertEvents {
+		a.events = a.events[len(a.events)-maxAlertEvents:]
+	}
+	return append(events, e)
+}
+
+func (cr *ChatRoom) emitAlert(e AlertEvent) {
+	log.Printf("alert state=%s metric=%s value=%g threshold=%g", e.State, e.Metric, e.Value, e.Threshold)
+	cfg := cr.alerts.cfg
+	if cfg.Notify != "" {
+		body := fmt.Sprintf("Alert %s: %s is %g (threshold %g)", e.State, e.Metric, e.Value, e.Threshold)
+		if err := cr.SendEphemeral(cfg.Notify, Message{Body: body}); err != nil {
+			log.Printf("alert notice to %s not delivered: %v", cfg.Notify, err)
+		}
+	}
+	if cfg.Webhook != "" {
+		cr.goTracked(workerWebhook, func() { cr.postAlert(cfg.Webhook, e) })
+	}
+}
+
+func (cr *ChatRoom) postAlert(url string, e AlertEvent) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	cr.deliverWebhook(cr.webhookClient(), url, body)
+}
+
+func (cr *ChatRoom) webhookClient() *http.Client {
+	if cr.alerts.cfg.Client != nil {
+		return cr.alerts.cfg.Client
+	}
+	return cr.outbound
+}
+
+// Alerts is the body of /admin/alerts.
+type Alerts struct {
+	Firing []string     `json:"firing"`
+	Events []AlertEvent `json:"events"`
+}
+
+func (cr *ChatRoom) Alerts() Alerts {
+	a := &cr.alerts
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	out := Alerts{Firing: []string{}, Events: append([]AlertEvent{}, a.events...)}
+	for metric, st := range a.states {
+		if st.firing {
+			out.Firing = append(out.Firing, metric)
+		}
+	}
+	sort.Strings(out.Firing)
+	return out
+}
+
+func (cr *ChatRoom) HandleAlerts(w http.ResponseWriter, r *http.Request) {
+	if !cr.alerts.cfg.enabled() {
+		http.Error(w, "Alerting is not configured", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cr.Alerts())
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"chatroom/testutil"
+)
+
+// An alert fires only once its metric has stayed past the threshold for the
+// configured window, keeps firing while the metric hovers just under it,
+// and resolves once it has stayed clearly below; every change reaches the
+// log, the notify client and the webhook.
+func TestAlertsFireAndResolveWithHysteresis(t *testing.T) {
+	hooks := make(chan AlertEvent, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e AlertEvent
+		body, _ := io.ReadAll(r.Body)
+		if json.Unmarshal(body, &e) == nil {
+			hooks <- e
+		}
+	}))
+	defer srv.Close()
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 0, 0, time.UTC))
+	cr, h := newTestRoom(t, WithClock(clk), WithAlerts(AlertConfig{
+		DropRate: 0.5,
+		For:      2 * alertInterval,
+		Notify:   "ops",
+		Webhook:  srv.URL,
+		Client:   srv.Client(),
+	}))
+	token := join(t, h, "ops")
+
+	// interval drops dropped of the next 100 deliveries and checks the
+	// alerts one interval later. The checks are made here rather than by
+	// the watcher, whose ticker never fires while the clock stands still.
+	now := clk.Now()
+	interval := func(dropped uint64) {
+		cr.delivered.Add(100 - dropped)
+		cr.dropped.Add(dropped)
+		now = now.Add(alertInterval)
+		cr.checkAlerts(now)
+	}
+	firing := func() bool {
+		a := cr.Alerts()
+		return len(a.Firing) == 1 && a.Firing[0] == metricDropRate
+	}
+
+	for _, dropped := range []uint64{60, 60} {
+		if interval(dropped); firing() {
+			t.Fatal("fired before the drop rate held for the window")
+		}
+	}
+	if interval(60); !firing() {
+		t.Fatal("did not fire after the drop rate held for the window")
+	}
+	// Hovering between the clear level (0.4) and the threshold is not a
+	// recovery, and a brief dip below it does not resolve the alert.
+	for _, dropped := range []uint64{45, 49, 10, 45, 10} {
+		if interval(dropped); !firing() {
+			t.Fatalf("resolved at a drop rate of %d%%", dropped)
+		}
+	}
+	interval(10)
+	if interval(10); firing() {
+		t.Fatal("still firing after the drop rate stayed low for the window")
+	}
+
+	events := cr.Alerts().Events
+	if len(events) != 2 || events[0].State != alertFiring || events[1].State != alertResolved || events[0].Value != 0.6 {
+		t.Fatalf("alert events %+v, want one firing at 0.6 and one resolved", events)
+	}
+	// Webhooks are posted in the background, so they may arrive in either
+	// order.
+	posted := map[string]AlertEvent{}
+	for range events {
+		select {
+		case got := <-hooks:
+			posted[got.State] = got
+		case <-time.After(10 * time.Second):
+			t.Fatalf("webhook got %d of %d events", len(posted), len(events))
+		}
+	}
+	for _, want := range events {
+		if got := posted[want.State]; got.Metric != want.Metric || !got.Time.Equal(want.Time) {
+			t.Errorf("webhook got %+v, want %+v", got, want)
+		}
+	}
+	for _, state := range []string{alertFiring, alertResolved} {
+		var m Message
+		if err := json.Unmarshal(pollFrom(t, h, "ops", token, systemSender), &m); err != nil || !strings.HasPrefix(m.Body, "Alert "+state+": drop_rate") {
+			t.Errorf("ops notice: %q %v, want the %s event", m.Body, err, state)
+		}
+	}
+	if w := do(h, "GET", "/admin/alerts", asAdmin...); w.Code != http.StatusOK {
+		t.Errorf("/admin/alerts: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestQueueDepthAlertAndDisabledAlerting(t *testing.T) {
+	_, h := newTestRoom(t)
+	if w := do(h, "GET", "/admin/alerts", asAdmin...); w.Code != http.StatusNotFound {
+		t.Errorf("/admin/alerts without thresholds: %d, want 404", w.Code)
+	}
+
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 0, 0, time.UTC))
+	cr, _ := newTestRoom(t, WithClock(clk), WithBroadcastBuffer(4), WithAlerts(AlertConfig{QueueDepth: 2}),
+		WithChaos(Chaos{LatencyMs: 60000}))
+	idle := clk.Waiters()
+	for _, body := range []string{"held", "queued 1", "queued 2"} {
+		if err := cr.Publish(Message{From: "bench", Body: body}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	eventually(t, "the broadcast loop to hold the first message", func() bool { return clk.Waiters() > idle })
+	cr.checkAlerts(clk.Now())
+	if a := cr.Alerts(); len(a.Firing) != 1 || a.Firing[0] != metricQueueDepth {
+		t.Errorf("firing %v with 2 messages queued, want the queue depth alert", a.Firing)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const messageTypePending = "pending"
+
+var errNotPending = errors.New("client has no pending join request")
+
+// WithJoinApproval makes joins without admin credentials wait for an admin to
+// approve them. Requests not decided within timeout expire. Pending clients
+// receive no broadcasts, cannot send and are not counted as members.
+func WithJoinApproval(timeout time.Duration) Option {
+	return func(cr *ChatRoom) {
+		if timeout > 0 {
+			cr.joinApproval = timeout
+		}
+	}
+}
+
+// awaitApproval arms the expiry of c's join request.
+func (cr *ChatRoom) awaitApproval(clientID string, c *client) {
+	cr.clock.AfterFunc(cr.joinApproval, func() {
+		cr.mutex.Lock()
+		defer cr.mutex.Unlock()
+		if cr.closed || cr.clients[clientID] != c || !c.pending {
+			return
+		}
+		delete(cr.clients, clientID)
+		cr.rosterChangedLocked()
+		cr.retireLocked(c, messageTypeEphemeral, "Your join request expired")
+	})
+}
+
+func (cr *ChatRoom) Approve(clientID, actor string) error {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	c, exists := cr.clients[clientID]
+	if !exists || !c.pending {
+		return errNotPending
+	}
+	c.pending = false
+	cr.rosterChangedLocked()
+	cr.recordPresenceLocked(eventJoin, clientID, c)
+	c.joinSeq = cr.events.last()
+	cr.audit.add(AuditEntry{Action: "approve", Actor: actor, Target: clientID})
+	return nil
+}
+
+// Deny rejects a pending join. The requester is told, including the reason
+// if one is given, before its registration closes.
+func (cr *ChatRoom) Deny(clientID, reason, actor string) error {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	c, exists := cr.clients[clientID]
+	if !exists || !c.pending {
+		return errNotPending
+	}
+	delete(cr.clients, clientID)
+	cr.rosterChangedLocked()
+	notice := "Your join request was denied"
+	if reason != "" {
+		notice += ": " + reason
+	}
+	cr.retireLocked(c, messageTypeEphemeral, notice)
+	cr.audit.add(AuditEntry{Action: "deny", Actor: actor, Target: clientID, Detail: reason})
+	return nil
+}
+
+// PendingJoin is one join request waiting in /admin/pending.
+type PendingJoin struct {
+	ID    string    `json:"id"`
+	Since time.Time `json:"since"`
+}
+
+func (cr *ChatRoom) Pending() []PendingJoin {
+	list := []PendingJoin{}
+	cr.mutex.Lock()
+	for id, c := range cr.clients {
+		if c.pending {
+			list = append(list, PendingJoin{ID: id, Since: c.pendingSince})
+		}
+	}
+	cr.mutex.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Since.Before(list[j].Since) })
+	return list
+}
+
+// pendingNotice returns the "pending approval" event for a poll by c, once
+// per join request.
+func (cr *ChatRoom) pendingNotice(c *client) (Message, bool) {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	if !c.pending || c.toldPending {
+		return Message{}, false
+	}
+	c.toldPending = true
+	msg := Message{
+		ID:   cr.ids.NewID(),
+		From: systemSender,
+		Body: "Your join request is awaiting approval",
+		Time: cr.clock.Now(),
+		Type: messageTypePending,
+	}
+	msg.line = formatLine(msg)
+	return msg, true
+}
+
+func (cr *ChatRoom) HandlePending(w http.ResponseWriter, r *http.Request) {
+	p, err := parsePage(r, "pending")
+	if err != nil {
+		pageFailed(w, err)
+		return
+	}
+	list := cr.Pending()
+	if p.limit > 0 {
+		idx, next := p.page(len(list), func(i int) string { return timeKey(list[i].Since, list[i].ID) })
+		paged := make([]PendingJoin, 0, len(idx))
+		for _, i := range idx {
+			paged = append(paged, list[i])
+		}
+		list = paged
+		setNextCursor(w, next)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// HandleApprove decides a pending join: POST /admin/approve?id=<id> or
+// POST /admin/deny?id=<id>[&reason=...].
+func (cr *ChatRoom) HandleApprove(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID := r.URL.Query().Get("id")
+	if clientID == "" {
+		http.Error(w, "Client ID is required", http.StatusBadRequest)
+		return
+	}
+	approve := r.URL.Path == "/admin/approve"
+	var err error
+	if approve {
+		err = cr.Approve(clientID, actorAdmin)
+	} else {
+		reason := r.URL.Query().Get("reason")
+		if err := validateMessage(reason); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = cr.Deny(clientID, reason, actorAdmin)
+	}
+	if err != nil {
+		http.Error(w, "No pending join request for "+clientID, http.StatusNotFound)
+		return
+	}
+	if approve {
+		fmt.Fprintf(w, "Client %s approved", clientID)
+		return
+	}
+	fmt.Fprintf(w, "Client %s denied", clientID)
+}
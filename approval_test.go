@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"chatroom/testutil"
+)
+
+// newApprovalRoom returns a room whose join requests expire after a minute,
+// sooner than its longest poll, with alice already in it as an
+// admin-approved member.
+func newApprovalRoom(t *testing.T) (*testutil.FakeClock, http.Handler, string) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	_, h := newTestRoom(t, WithClock(clk), WithJoinApproval(time.Minute),
+		WithPollWait(PollWait{Min: time.Second, Preferred: 30 * time.Second, Max: 2 * time.Minute}))
+	w := do(h, "POST", "/join?id=alice", asAdmin...)
+	if w.Code != http.StatusOK {
+		t.Fatalf("admin join: %d %s", w.Code, w.Body)
+	}
+	return clk, h, w.Header().Get(tokenHeader)
+}
+
+// requestJoin asks to join id and returns its send token.
+func requestJoin(t *testing.T, h http.Handler, id string) string {
+	t.Helper()
+	w := do(h, "POST", "/join?id="+id)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("join request %s: %d %s, want 202", id, w.Code, w.Body)
+	}
+	return w.Header().Get(tokenHeader)
+}
+
+func pendingIDs(t *testing.T, h http.Handler) []string {
+	t.Helper()
+	var list []PendingJoin
+	if w := do(h, "GET", "/admin/pending", asAdmin...); json.Unmarshal(w.Body.Bytes(), &list) != nil {
+		t.Fatalf("pending: %d %s", w.Code, w.Body)
+	}
+	var ids []string
+	for _, p := range list {
+		ids = append(ids, p.ID)
+	}
+	return ids
+}
+
+// A pending client is told it is waiting, receives nothing and cannot send
+// until approved, and then gets only what is sent after the approval.
+func TestPendingJoinsReceiveTrafficOnlyOnceApproved(t *testing.T) {
+	_, h, ta := newApprovalRoom(t)
+	tc := requestJoin(t, h, "carol")
+	if got := pendingIDs(t, h); len(got) != 1 || got[0] != "carol" {
+		t.Fatalf("pending joins %v, want carol", got)
+	}
+	var m Message
+	if w := do(h, "GET", "/messages?format=json&wait=0&id=carol", tokenHeader, tc); json.Unmarshal(w.Body.Bytes(), &m) != nil || m.Type != messageTypePending {
+		t.Errorf("carol's first poll: %d %s, want the pending notice", w.Code, w.Body)
+	}
+	if w := send(h, tc, "let me in"); w.Code == http.StatusOK {
+		t.Error("a pending client could send")
+	}
+	send(h, ta, "before approval")
+	if w := pollOnce(h, "alice", ta); w.Body.String() != "alice: before approval\n" {
+		t.Fatalf("alice's echo: %d %q", w.Code, w.Body)
+	}
+
+	if w := do(h, "POST", "/admin/approve?id=carol", asAdmin...); w.Code != http.StatusOK {
+		t.Fatalf("approve: %d %s", w.Code, w.Body)
+	}
+	send(h, ta, "after approval")
+	if w := pollOnce(h, "carol", tc); w.Body.String() != "alice: after approval\n" {
+		t.Errorf("carol's poll after approval: %d %q", w.Code, w.Body)
+	}
+	if w := send(h, tc, "thanks"); w.Code != http.StatusOK {
+		t.Errorf("send after approval: %d %s", w.Code, w.Body)
+	}
+	if got := pendingIDs(t, h); len(got) != 0 {
+		t.Errorf("pending joins after approval: %v", got)
+	}
+	if w := do(h, "POST", "/admin/approve?id=carol", asAdmin...); w.Code != http.StatusNotFound {
+		t.Errorf("approving a member again: %d, want 404", w.Code)
+	}
+}
+
+// A denied or expired request ends the requester's open poll with a notice
+// saying why.
+func TestDeniedAndExpiredJoinsAreToldWhy(t *testing.T) {
+	clk, h, _ := newApprovalRoom(t)
+	waiting := make(map[string]<-chan *httptest.ResponseRecorder)
+	for _, id := range []string{"carol", "dave"} {
+		token := requestJoin(t, h, id)
+		pollOnce(h, id, token) // The pending notice
+		waiting[id] = pollAsync(t, clk, h, id, token, "90")
+	}
+	if w := do(h, "POST", "/admin/deny?id=carol&reason=members+only", asAdmin...); w.Code != http.StatusOK {
+		t.Fatalf("deny: %d %s", w.Code, w.Body)
+	}
+	if w := <-waiting["carol"]; !strings.Contains(w.Body.String(), "denied: members only") {
+		t.Errorf("carol's poll across the denial: %d %q", w.Code, w.Body)
+	}
+
+	clk.Advance(time.Minute)
+	if got := pendingIDs(t, h); len(got) != 0 {
+		t.Errorf("pending joins after the timeout: %v", got)
+	}
+	if w := <-waiting["dave"]; !strings.Contains(w.Body.String(), "expired") {
+		t.Errorf("dave's poll across the expiry: %d %q", w.Code, w.Body)
+	}
+	requestJoin(t, h, "dave")
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const auditLogSize = 1000
+
+// AuditEntry records one moderation or administrative action.
+type AuditEntry struct {
+	Seq    uint64    `json:"seq"` // Numbers entries in the order they were recorded
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	Actor  string    `json:"actor"`
+	Target string    `json:"target,omitempty"`
+	Detail string    `json:"detail,omitempty"`
+	// IP and UserAgent identify the target's connection, when it has one.
+	IP        string `json:"ip,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+}
+
+// auditLog keeps the most recent entries in memory, oldest first.
+type auditLog struct {
+	mu      sync.Mutex
+	entries []AuditEntry
+	seq     uint64
+	now     func() time.Time // The room's clock
+}
+
+func (a *auditLog) add(e AuditEntry) {
+	if e.Time.IsZero() {
+		e.Time = a.now()
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.seq++
+	e.Seq = a.seq
+	if len(a.entries) == auditLogSize {
+		copy(a.entries, a.entries[1:])
+		a.entries = a.entries[:auditLogSize-1]
+	}
+	a.entries = append(a.entries, e)
+}
+
+func (a *auditLog) list() []AuditEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]AuditEntry{}, a.entries...)
+}
+
+func (cr *ChatRoom) HandleAudit(w http.ResponseWriter, r *http.Request) {
+	p, err := parsePage(r, "audit")
+	if err != nil {
+		pageFailed(w, err)
+		return
+	}
+	entries := cr.audit.list()
+	idx := make([]int, len(entries))
+	for i := range idx {
+		idx[i] = i
+	}
+	if p.limit > 0 {
+		var next string
+		idx, next = p.page(len(entries), func(i int) string { return fmt.Sprintf("%020d", entries[i].Seq) })
+		setNextCursor(w, next)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	lw := newListWriter(w)
+	lw.begin()
+	for _, i := range idx {
+		lw.item(entries[i])
+	}
+	lw.end()
+	lw.done()
+}
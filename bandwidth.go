@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// pollOverheadBytes estimates the status line and headers of a long-poll
+// response, which a metered client pays for on top of the message itself.
+const pollOverheadBytes = 160
+
+// Framings a delivery is written in, each with its own overhead.
+const (
+	framePoll   = iota // The body of its own long-poll response, text or JSON
+	frameStream        // One NDJSON line in the chunked body of a streamed poll
+	frameInproc        // Handed to an in-process subscriber as is
+)
+
+// bandwidth tracks the bytes delivered to one client in the current minute.
+// Guarded by ChatRoom.mutex.
+type bandwidth struct {
+	minute  int64 // Minutes since the Unix epoch that used counts
+	used    int64
+	cap     int64 // Bytes per minute requested by the client; 0 for none
+	dropped int64 // Deliveries skipped because they are bigger than the whole cap
+}
+
+// wireCost is the bytes writing the encoded delivery b in framing f costs.
+func wireCost(b []byte, f int) int64 {
+	n := int64(len(b))
+	switch f {
+	case framePoll:
+		n += pollOverheadBytes
+	case frameStream:
+		n += int64(len(strconv.FormatInt(n, 16))) + 4 // Chunk size line and trailing CRLF
+	}
+	return n
+}
+
+// effectiveCapLocked is the tighter of the client's own cap and the cap for
+// its role.
+func (cr *ChatRoom) effectiveCapLocked(c *client) int64 {
+	limit := c.bw.cap
+	if rc := cr.roleCaps[c.role]; rc > 0 && (limit == 0 || rc < limit) {
+		limit = rc
+	}
+	return limit
+}
+
+// paceLocked reports whether a delivery of cost bytes fits owner's cap for
+// the current minute. When it does not, it returns how long until the next
+// minute, when it will; a delivery bigger than the whole cap never fits and
+// gets no wait. The caller charges the cost once the delivery is made.
+func (cr *ChatRoom) paceLocked(owner *client, cost int64, now time.Time) (time.Duration, bool) {
+	bw := &owner.bw
+	if m := now.Unix() / 60; m != bw.minute {
+		bw.minute, bw.used = m, 0
+	}
+	limit := cr.effectiveCapLocked(owner)
+	if limit == 0 || bw.used+cost <= limit {
+		return 0, true
+	}
+	if cost > limit {
+		return 0, false
+	}
+	return time.Unix((bw.minute+1)*60, 0).Sub(now), false
+}
+
+// Quota is the body of /quota.
+type Quota struct {
+	ID          string `json:"id"`
+	MinuteBytes int64  `json:"minute_bytes"`         // Delivered so far this minute
+	CapBytes    int64  `json:"cap_bytes,omitempty"`  // Effective bytes-per-minute cap
+	ClientCap   int64  `json:"client_cap,omitempty"` // The client's own request
+	Dropped     int64  `json:"dropped"`              // Deliveries skipped for being bigger than the whole cap
+}
+
+func (cr *ChatRoom) Quota(clientID string) (Quota, error) {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	c, exists := cr.clients[clientID]
+	if !exists {
+		return Quota{}, errClientNotFound
+	}
+	q := Quota{ID: clientID, CapBytes: cr.effectiveCapLocked(c), ClientCap: c.bw.cap, Dropped: c.bw.dropped}
+	if c.bw.minute == cr.clock.Now().Unix()/60 {
+		q.MinuteBytes = c.bw.used
+	}
+	return q, nil
+}
+
+func (cr *ChatRoom) HandleQuota(w http.ResponseWriter, r *http.Request) {
+	clientID, ok := cr.sender(w, r)
+	if !ok {
+		return
+	}
+	q, err := cr.Quota(clientID)
+	if err != nil {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(q)
+}
+
+// parseCap reads a bytes-per-minute cap; 0 removes it.
+func parseCap(s string) (int64, bool) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	return n, err == nil && n >= 0
+}
+
+// HandleBandwidthCap sets the caller's own cap:
+// POST /me/bandwidth-cap?bytes=<per minute> with the send token, 0 to
+// remove it.
+func (cr *ChatRoom) HandleBandwidthCap(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID, ok := cr.sender(w, r)
+	if !ok {
+		return
+	}
+	limit, ok := parseCap(r.URL.Query().Get("bytes"))
+	if !ok {
+		http.Error(w, "A non-negative bytes value is required", http.StatusBadRequest)
+		return
+	}
+	cr.mutex.Lock()
+	c, exists := cr.clients[clientID]
+	if exists {
+		c.bw.cap = limit
+	}
+	cr.mutex.Unlock()
+	if !exists {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+	fmt.Fprintf(w, "Bandwidth cap for %s set to %d bytes per minute", clientID, limit)
+}
+
+// HandleRoleBandwidthCap sets a hard cap for every client with a role:
+// POST /admin/bandwidth-cap?role=<member|spectator>&bytes=<per minute>.
+func (cr *ChatRoom) HandleRoleBandwidthCap(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	role := r.URL.Query().Get("role")
+	if role != roleMember && role != roleSpectator {
+		http.Error(w, "Role must be member or spectator", http.StatusBadRequest)
+		return
+	}
+	limit, ok := parseCap(r.URL.Query().Get("bytes"))
+	if !ok {
+		http.Error(w, "bytes must be a non-negative integer", http.StatusBadRequest)
+		return
+	}
+	cr.mutex.Lock()
+	cr.roleCaps[role] = limit
+	cr.mutex.Unlock()
+	cr.audit.add(AuditEntry{Action: "bandwidth_cap", Actor: actorAdmin, Target: role, Detail: strconv.FormatInt(limit, 10)})
+	fmt.Fprintf(w, "Bandwidth cap for %ss set to %d bytes per minute", role, limit)
+}
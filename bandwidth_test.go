@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"chatroom/testutil"
+)
+
+func quota(t *testing.T, h http.Handler, id, token string) Quota {
+	t.Helper()
+	w := do(h, "GET", "/quota?id="+id, tokenHeader, token)
+	var q Quota
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &q) != nil {
+		t.Fatalf("quota: %d %s", w.Code, w.Body)
+	}
+	return q
+}
+
+func setCap(t *testing.T, h http.Handler, id, token string, bytes int) {
+	t.Helper()
+	w := do(h, "POST", "/me/bandwidth-cap?id="+id+"&bytes="+strconv.Itoa(bytes), tokenHeader, token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("bandwidth cap: %d %s", w.Code, w.Body)
+	}
+}
+
+// Deliveries are charged the bytes actually written plus the framing.
+func TestBandwidthChargesEncodedBytes(t *testing.T) {
+	cr, h := newTestRoom(t)
+	ta := join(t, h, "alice")
+	tb := join(t, h, "bob")
+	send(h, ta, "plain")
+	send(h, ta, "json")
+	plain := pollOnce(h, "bob", tb)
+	asJSON := do(h, "GET", "/messages?wait=0&format=json&id=bob", tokenHeader, tb)
+	if plain.Code != http.StatusOK || asJSON.Code != http.StatusOK {
+		t.Fatalf("polls: %d, %d", plain.Code, asJSON.Code)
+	}
+	want := int64(plain.Body.Len() + asJSON.Body.Len() + 2*pollOverheadBytes)
+	if got := quota(t, h, "bob", tb).MinuteBytes; got != want {
+		t.Errorf("charged %d bytes, want %d", got, want)
+	}
+
+	cr.mutex.Lock()
+	cr.clients["bob"].bw.used = 0
+	cr.mutex.Unlock()
+	send(h, ta, "streamed")
+	s := do(h, "GET", "/messages?stream=true&max=1&wait=0&id=bob", tokenHeader, tb)
+	n := int64(s.Body.Len())
+	if got, want := quota(t, h, "bob", tb).MinuteBytes, n+int64(len(strconv.FormatInt(n, 16)))+4; got != want {
+		t.Errorf("streamed delivery charged %d bytes, want %d", got, want)
+	}
+}
+
+// Under a cap, deliveries wait for the next minute instead of being lost,
+// and the queue behind them keeps only the newest.
+func TestBandwidthCapPacesAndDropsOldest(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 30, 0, time.UTC)
+	clk := testutil.NewFakeClock(start)
+	cr, h := newTestRoom(t, WithClock(clk), WithSessionQueue(3),
+		WithPollWait(PollWait{Min: time.Second, Preferred: 50 * time.Second, Max: time.Minute}))
+	ta := join(t, h, "alice")
+	tb := join(t, h, "bob")
+	line := len("alice: m0\n") + pollOverheadBytes
+	setCap(t, h, "bob", tb, 2*line)
+	for _, m := range []string{"m0", "m1", "m2"} {
+		send(h, ta, m)
+	}
+	for _, want := range []string{"alice: m0\n", "alice: m1\n"} {
+		if w := do(h, "GET", "/messages?wait=50&id=bob", tokenHeader, tb); w.Body.String() != want {
+			t.Fatalf("got %d %q, want %q", w.Code, w.Body, want)
+		}
+	}
+
+	// m2 is over the cap: the poll waits for the next minute.
+	base := clk.Waiters()
+	polled := make(chan string)
+	go func() {
+		polled <- do(h, "GET", "/messages?wait=50&id=bob", tokenHeader, tb).Body.String()
+	}()
+	eventually(t, "the poll to wait for the cap", func() bool { return clk.Waiters() >= base+2 })
+	// Meanwhile the queue fills, and its oldest are evicted.
+	for _, m := range []string{"m3", "m4", "m5", "m6"} {
+		send(h, ta, m)
+	}
+	eventually(t, "the queue to overflow", func() bool {
+		cr.mutex.Lock()
+		defer cr.mutex.Unlock()
+		return cr.clients["bob"].overflowed == 2
+	})
+	clk.Advance(30 * time.Second)
+	got := <-polled
+	if got != "alice: m4\n" {
+		t.Errorf("after the minute rolled over got %q, want m4 (m2 and m3 evicted)", got)
+	}
+	if q := quota(t, h, "bob", tb); q.MinuteBytes != int64(line) || q.Dropped != 0 {
+		t.Errorf("quota %+v, want one delivery this minute and nothing dropped", q)
+	}
+}
+
+func TestBandwidthCapDropsDeliveriesBiggerThanTheCap(t *testing.T) {
+	_, h := newTestRoom(t)
+	ta := join(t, h, "alice")
+	tb := join(t, h, "bob")
+	setCap(t, h, "bob", tb, pollOverheadBytes+len("alice: short\n"))
+	send(h, ta, "this message is far too long for the cap")
+	send(h, ta, "short")
+	if w := pollOnce(h, "bob", tb); w.Body.String() != "alice: short\n" {
+		t.Errorf("got %d %q", w.Code, w.Body)
+	}
+	if q := quota(t, h, "bob", tb); q.Dropped != 1 {
+		t.Errorf("quota %+v, want one dropped", q)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// BenchmarkPublish publishes to a room of idle clients while the broadcast
+// loop fans each message out, reporting the allocations of the send path
+// per message. It does not wait for deliveries; BenchmarkPublishDeliver
+// measures the whole send-to-deliver path.
+func BenchmarkPublish(b *testing.B) {
+	for _, clients := range []int{100, 1000} {
+		b.Run(fmt.Sprintf("clients=%d", clients), func(b *testing.B) {
+			cr := NewChatRoom()
+			defer cr.Close()
+			for i := 0; i < clients; i++ {
+				cr.AddClient(fmt.Sprintf("user%d", i))
+			}
+			msg := Message{From: "bench", Body: "the quick brown fox jumps over the lazy dog"}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := cr.Publish(msg); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
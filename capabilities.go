@@ -0,0 +1,102 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// capabilitiesHeader carries the server's capabilities, as name/version
+// pairs, on the /join response.
+const capabilitiesHeader = "X-Convo-Capabilities"
+
+// Optional protocol features a session can declare with capabilities= on
+// /join. Events and fields a session did not declare are skipped or
+// stripped on delivery, so a feature added later cannot break an older
+// client.
+const (
+	capKinds     = "kinds"     // Structured messages: kind and payload fields
+	capPreviews  = "previews"  // Link preview events
+	capPolls     = "polls"     // Poll tally events
+	capEntities  = "entities"  // Mention, URL and code span entities
+	capKeywords  = "keywords"  // Keyword watch events
+	capFullBody  = "fullbody"  // Whole bodies instead of folded ones
+	capHighlight = "highlight" // Syntax highlighting of code messages and highlight events
+)
+
+var serverCapabilities = map[string]int{
+	capKinds:     1,
+	capPreviews:  1,
+	capPolls:     1,
+	capEntities:  1,
+	capKeywords:  1,
+	capFullBody:  1,
+	capHighlight: 1,
+}
+
+// legacyCapabilities are what a session that declares none receives: the
+// features that predate negotiation. Capabilities added from now on must be
+// declared.
+var legacyCapabilities = map[string]bool{
+	capKinds:    true,
+	capPreviews: true,
+}
+
+// parseCapabilities reads a comma-separated capabilities list, ignoring
+// names the server does not know so newer clients can join older servers.
+func parseCapabilities(s string) map[string]bool {
+	caps := make(map[string]bool)
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := serverCapabilities[name]; ok {
+			caps[name] = true
+		}
+	}
+	return caps
+}
+
+func capabilitiesList() string {
+	names := make([]string, 0, len(serverCapabilities))
+	for name, v := range serverCapabilities {
+		names = append(names, name+"/"+strconv.Itoa(v))
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func (c *client) can(capability string) bool {
+	if c.caps == nil {
+		return legacyCapabilities[capability]
+	}
+	return c.caps[capability]
+}
+
+// adapt downgrades m for a session, reporting false when the session should
+// not see it at all.
+func (c *client) adapt(m Message) (Message, bool) {
+	if m.Type == messageTypePreview && !c.can(capPreviews) {
+		return m, false
+	}
+	if m.Type == messageTypeTally && !c.can(capPolls) {
+		return m, false
+	}
+	if m.Type == messageTypeKeyword && !c.can(capKeywords) {
+		return m, false
+	}
+	if m.Type == messageTypeHighlight && !c.can(capHighlight) {
+		return m, false
+	}
+	if m.Highlight != nil && !c.can(capHighlight) {
+		m.Highlight, m.envelope = nil, nil
+	}
+	if m.unfolded != nil && c.can(capFullBody) {
+		m = unfold(m)
+	}
+	if m.Entities != nil && !c.can(capEntities) {
+		m.Entities, m.envelope = nil, nil
+	}
+	if m.Kind != "" && !c.can(capKinds) {
+		m.Kind, m.Payload, m.envelope = "", nil, nil
+	}
+	return m, true
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"chatroom/testutil"
+)
+
+func TestParseCapabilitiesIgnoresUnknownNames(t *testing.T) {
+	caps := parseCapabilities(" kinds, msgpack,,polls ")
+	if len(caps) != 2 || !caps[capKinds] || !caps[capPolls] {
+		t.Errorf("parsed %v, want kinds and polls", caps)
+	}
+	if caps := parseCapabilities(""); caps == nil || len(caps) != 0 {
+		t.Errorf("parsed an empty list as %v, want an empty set", caps)
+	}
+}
+
+// A session that declares no capabilities sees only what predates
+// negotiation; one that declares some sees exactly those.
+func TestAdaptDowngradesForUndeclaredCapabilities(t *testing.T) {
+	legacy := &client{}
+	bare := &client{caps: parseCapabilities("")}
+	full := &client{caps: parseCapabilities("kinds,polls,entities")}
+
+	tally := Message{Type: messageTypeTally}
+	for _, tc := range []struct {
+		c    *client
+		want bool
+	}{{legacy, false}, {bare, false}, {full, true}} {
+		if _, ok := tc.c.adapt(tally); ok != tc.want {
+			t.Errorf("tally delivered to a session with %v: %v, want %v", tc.c.caps, ok, tc.want)
+		}
+	}
+	if _, ok := legacy.adapt(Message{Type: messageTypePreview}); !ok {
+		t.Error("a legacy session lost preview events")
+	}
+
+	m := Message{Body: "see @bob", Kind: "code", Payload: json.RawMessage(`{}`), Entities: []Entity{{Type: "mention"}}}
+	for _, tc := range []struct {
+		c              *client
+		kind, entities bool
+	}{{legacy, true, false}, {bare, false, false}, {full, true, true}} {
+		got, ok := tc.c.adapt(m)
+		if !ok || (got.Kind != "") != tc.kind || (got.Payload != nil) != tc.kind || (got.Entities != nil) != tc.entities {
+			t.Errorf("session with %v received kind %q payload %s entities %v", tc.c.caps, got.Kind, got.Payload, got.Entities)
+		}
+	}
+}
+
+func TestJoinEchoesServerCapabilities(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	_, h := newTestRoom(t, WithClock(clk))
+	w := do(h, "POST", "/join?id=alice&capabilities=kinds,batch")
+	if w.Code != http.StatusOK {
+		t.Fatalf("join: %d %s", w.Code, w.Body)
+	}
+	got := w.Header().Get(capabilitiesHeader)
+	for name, v := range serverCapabilities {
+		if want := name + "/" + strconv.Itoa(v); !strings.Contains(got, want) {
+			t.Errorf("%s %q does not list %s", capabilitiesHeader, got, want)
+		}
+	}
+
+	// alice declared kinds, bob declared nothing that survives parsing.
+	ta := w.Header().Get(tokenHeader)
+	w = do(h, "POST", "/join?id=bob&capabilities=batch")
+	if w.Code != http.StatusOK {
+		t.Fatalf("join: %d %s", w.Code, w.Body)
+	}
+	tb := w.Header().Get(tokenHeader)
+	if code, body := sendKind(h, ta, "location", `{"lat": 51.5, "lng": -0.12}`); code != http.StatusOK {
+		t.Fatalf("send: %d %s", code, body)
+	}
+	for _, tc := range []struct {
+		id, token string
+		kind      string
+	}{{"alice", ta, "location"}, {"bob", tb, ""}} {
+		var m Message
+		if err := json.Unmarshal(pollFrom(t, h, tc.id, tc.token, "alice"), &m); err != nil {
+			t.Fatal(err)
+		}
+		if m.Kind != tc.kind || m.Body != "fallback" {
+			t.Errorf("%s received kind %q body %q, want kind %q", tc.id, m.Kind, m.Body, tc.kind)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+const maxChaosLatency = time.Minute
+
+var errInvalidChaos = errors.New("latency_ms must be 0-60000 and rates between 0 and 1")
+
+// Chaos is the fault injection applied in chaos mode, meant for client
+// developers testing retry, dedup and gap detection against a real server.
+// Faults are injected where real ones happen: latency in the broadcast loop,
+// drops at delivery and 503s from /send.
+type Chaos struct {
+	LatencyMs     int     `json:"latency_ms"`      // Added to every broadcast before fan-out
+	DropRate      float64 `json:"drop_rate"`       // Chance each delivery to a client is dropped
+	SendErrorRate float64 `json:"send_error_rate"` // Chance /send answers 503 without publishing
+}
+
+func (c Chaos) validate() error {
+	if c.LatencyMs < 0 || time.Duration(c.LatencyMs)*time.Millisecond > maxChaosLatency ||
+		c.DropRate < 0 || c.DropRate > 1 || c.SendErrorRate < 0 || c.SendErrorRate > 1 {
+		return errInvalidChaos
+	}
+	return nil
+}
+
+// WithChaos turns chaos mode on with the given initial faults. Without it
+// the room never injects faults and /admin/chaos cannot enable them.
+func WithChaos(c Chaos) Option {
+	return func(cr *ChatRoom) {
+		if c.validate() == nil {
+			cr.chaos = &c
+		}
+	}
+}
+
+// Chaos returns the current faults, or nil when chaos mode is off.
+func (cr *ChatRoom) Chaos() *Chaos {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	if cr.chaos == nil {
+		return nil
+	}
+	c := *cr.chaos
+	return &c
+}
+
+// chaosDelay holds up the broadcast loop by the configured latency. It
+// reports false if the room closed meanwhile.
+func (cr *ChatRoom) chaosDelay() bool {
+	c := cr.Chaos()
+	if c == nil || c.LatencyMs == 0 {
+		return true
+	}
+	timer := cr.clock.NewTimer(time.Duration(c.LatencyMs) * time.Millisecond)
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+		return true
+	case <-cr.done:
+		return false
+	}
+}
+
+// chaosDropLocked decides whether to drop one delivery.
+func (cr *ChatRoom) chaosDropLocked() bool {
+	return cr.chaos != nil && cr.chaos.DropRate > 0 && rand.Float64() < cr.chaos.DropRate
+}
+
+func (cr *ChatRoom) chaosSendError() bool {
+	c := cr.Chaos()
+	return c != nil && c.SendErrorRate > 0 && rand.Float64() < c.SendErrorRate
+}
+
+func (cr *ChatRoom) HandleChaos(w http.ResponseWriter, r *http.Request) {
+	if cr.Chaos() == nil {
+		http.Error(w, "Chaos mode is off; start the server with -chaos", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var c Chaos
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxMessageLength)).Decode(&c); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		if err := c.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		cr.mutex.Lock()
+		cr.chaos = &c
+		cr.mutex.Unlock()
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cr.Chaos())
+}
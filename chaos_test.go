@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"chatroom/testutil"
+)
+
+func setChaos(h http.Handler, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/admin/chaos", strings.NewReader(body))
+	req.Header.Set(asAdmin[0], asAdmin[1])
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestChaosIsOffUnlessEnabledAtStart(t *testing.T) {
+	_, h := newTestRoom(t)
+	if w := setChaos(h, `{"drop_rate": 1}`); w.Code != http.StatusNotFound {
+		t.Errorf("enabling chaos at runtime: %d %s, want 404", w.Code, w.Body)
+	}
+	var hl Health
+	if w := do(h, "GET", "/healthz"); json.Unmarshal(w.Body.Bytes(), &hl) != nil || hl.Status != "ok" || hl.Chaos != nil {
+		t.Errorf("healthz without chaos: %d %s", w.Code, w.Body)
+	}
+}
+
+// Each fault is injected where a real one would happen, and /healthz marks
+// the instance while chaos mode is on.
+func TestChaosInjectsSendErrorsDropsAndLatency(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	cr, h := newTestRoom(t, WithClock(clk), WithChaos(Chaos{}))
+	ta := join(t, h, "alice")
+	tb := join(t, h, "bob")
+	var hl Health
+	if w := do(h, "GET", "/healthz"); json.Unmarshal(w.Body.Bytes(), &hl) != nil || hl.Status != "chaos" || hl.Chaos == nil {
+		t.Errorf("healthz in chaos mode: %d %s", w.Code, w.Body)
+	}
+	if w := setChaos(h, `{"drop_rate": 2}`); w.Code != http.StatusBadRequest {
+		t.Errorf("out of range drop rate: %d, want 400", w.Code)
+	}
+	bobQueued := func() int {
+		cr.mutex.Lock()
+		defer cr.mutex.Unlock()
+		return cr.clients["bob"].queue.len()
+	}
+
+	setChaos(h, `{"send_error_rate": 1}`)
+	if w := send(h, ta, "fails"); w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("send with send_error_rate 1: %d, want 503 with Retry-After", w.Code)
+	}
+
+	setChaos(h, `{"drop_rate": 1}`)
+	if w := send(h, ta, "dropped"); w.Code != http.StatusOK {
+		t.Fatalf("send with drop_rate 1: %d %s", w.Code, w.Body)
+	}
+	eventually(t, "the deliveries to alice and bob to be dropped", func() bool { return cr.dropped.Load() == 2 })
+	if n := bobQueued(); n != 0 {
+		t.Errorf("bob has %d queued after every delivery was dropped", n)
+	}
+
+	setChaos(h, `{"latency_ms": 1000}`)
+	idle := clk.Waiters()
+	send(h, ta, "late")
+	eventually(t, "the broadcast loop to hold the message", func() bool { return clk.Waiters() > idle })
+	if n := bobQueued(); n != 0 {
+		t.Errorf("bob has %d queued before the latency passed", n)
+	}
+	clk.Advance(time.Second)
+	if w := pollOnce(h, "bob", tb); w.Body.String() != "alice: late\n" {
+		t.Errorf("bob's poll after the latency: %d %q", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,384 @@
+// Command convoctl administers a chat server over its admin API.
+//
+//	convoctl [-server URL] [-token-file path] [-json] <command> [args]
+//
+// The admin token comes from CONVO_ADMIN_TOKEN or the first line of
+// -token-file, and the server from CONVO_SERVER or -server. Lists print as
+// tables and everything else as key: value lines unless -json is given,
+// which prints the server's JSON as is. The exit status is 0 on success, 1
+// when the request fails and 2 on usage errors.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+type ctl struct {
+	server string
+	token  string
+	json   bool
+	out    io.Writer
+	client *http.Client
+}
+
+// command is one subcommand; run returns errUsage for malformed arguments.
+type command struct {
+	usage   string
+	summary string
+	minArgs int
+	run     func(c *ctl, args []string) error
+}
+
+var errUsage = errors.New("usage")
+
+var commands = map[string]command{
+	"stats": {"stats", "Show room statistics", 0, func(c *ctl, args []string) error {
+		return c.show(http.MethodGet, "/stats", nil, nil, nil)
+	}},
+	"clients": {"clients", "List members and spectators", 0, func(c *ctl, args []string) error {
+		return c.clients()
+	}},
+	"kick": {"kick <id>", "End every session of a client", 1, func(c *ctl, args []string) error {
+		return c.show(http.MethodPost, "/admin/revoke", url.Values{"id": {args[0]}}, nil, nil)
+	}},
+	"mute": {"mute <id> <duration> [reason]", "Mute a client, e.g. mute bob 10m spam", 2, func(c *ctl, args []string) error {
+		return c.show(http.MethodPost, "/admin/mute", url.Values{"id": {args[0]}, "duration": {args[1]}, "reason": {strings.Join(args[2:], " ")}}, nil, nil)
+	}},
+	"shadow-mute": {"shadow-mute <id> <duration> [reason]", "Mute a client without telling it", 2, func(c *ctl, args []string) error {
+		return c.show(http.MethodPost, "/admin/mute", url.Values{"id": {args[0]}, "duration": {args[1]}, "reason": {strings.Join(args[2:], " ")}, "shadow": {"true"}}, nil, nil)
+	}},
+	"unmute": {"unmute <id>", "Lift a mute", 1, func(c *ctl, args []string) error {
+		return c.show(http.MethodPost, "/admin/unmute", url.Values{"id": {args[0]}}, nil, nil)
+	}},
+	"mutes": {"mutes", "List active mutes", 0, func(c *ctl, args []string) error {
+		return c.show(http.MethodGet, "/admin/mutes", nil, nil, []string{"client_id", "until", "auto", "shadow", "reason"})
+	}},
+	"notify": {"notify <id> <message>", "Send an ephemeral system message to one client", 2, func(c *ctl, args []string) error {
+		return c.show(http.MethodPost, "/admin/ephemeral", url.Values{"id": {args[0]}, "message": {strings.Join(args[1:], " ")}, "urgent": {"true"}}, nil, nil)
+	}},
+	"welcome": {"welcome [text]", "Show or set the welcome sent to new joiners", 0, func(c *ctl, args []string) error {
+		if len(args) == 0 {
+			return c.show(http.MethodGet, "/admin/welcome", nil, nil, nil)
+		}
+		return c.show(http.MethodPost, "/admin/welcome", nil, map[string]any{"text": strings.Join(args, " ")}, nil)
+	}},
+	"maintenance": {"maintenance [on [message] | off]", "Show or change maintenance mode", 0, func(c *ctl, args []string) error {
+		if len(args) == 0 {
+			return c.show(http.MethodGet, "/admin/maintenance", nil, nil, nil)
+		}
+		if args[0] != "on" && args[0] != "off" {
+			return errUsage
+		}
+		return c.show(http.MethodPost, "/admin/maintenance", nil, map[string]any{"enabled": args[0] == "on", "message": strings.Join(args[1:], " ")}, nil)
+	}},
+	"pending": {"pending", "List joins awaiting approval", 0, func(c *ctl, args []string) error {
+		return c.show(http.MethodGet, "/admin/pending", nil, nil, []string{"id", "since"})
+	}},
+	"approve": {"approve <id>", "Approve a pending join", 1, func(c *ctl, args []string) error {
+		return c.show(http.MethodPost, "/admin/approve", url.Values{"id": {args[0]}}, nil, nil)
+	}},
+	"deny": {"deny <id> [reason]", "Deny a pending join", 1, func(c *ctl, args []string) error {
+		return c.show(http.MethodPost, "/admin/deny", url.Values{"id": {args[0]}, "reason": {strings.Join(args[1:], " ")}}, nil, nil)
+	}},
+	"alerts": {"alerts", "Show firing alerts and recent alert events", 0, func(c *ctl, args []string) error {
+		return c.show(http.MethodGet, "/admin/alerts", nil, nil, nil)
+	}},
+	"dlq": {"dlq [replay [id]]", "List dead-lettered webhooks, or replay them", 0, func(c *ctl, args []string) error {
+		switch {
+		case len(args) == 0:
+			return c.show(http.MethodGet, "/admin/dlq", nil, nil, []string{"id", "url", "failed", "attempts", "reason"})
+		case args[0] == "replay" && len(args) <= 2:
+			q := url.Values{}
+			if len(args) == 2 {
+				q.Set("id", args[1])
+			}
+			return c.show(http.MethodPost, "/admin/dlq/replay", q, nil, nil)
+		}
+		return errUsage
+	}},
+	"audit": {"audit [-f]", "Print the audit log; -f keeps printing new entries", 0, func(c *ctl, args []string) error {
+		follow := len(args) == 1 && args[0] == "-f"
+		if len(args) > 0 && !follow {
+			return errUsage
+		}
+		return c.audit(follow)
+	}},
+}
+
+// do sends an admin request and returns the response body, turning non-2xx
+// responses into errors carrying the server's message.
+func (c *ctl) do(method, path string, q url.Values, body any) ([]byte, string, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, "", err
+		}
+		r = bytes.NewReader(b)
+	}
+	u := c.server + path
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	req, err := http.NewRequest(method, u, r)
+	if err != nil {
+		return nil, "", err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, "", fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(b)))
+	}
+	return b, resp.Header.Get("Content-Type"), nil
+}
+
+// show performs a request and prints the response: raw with -json or for
+// text responses, as a table when columns are given, and as key: value
+// lines otherwise.
+func (c *ctl) show(method, path string, q url.Values, body any, columns []string) error {
+	b, ctype, err := c.do(method, path, q, body)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(ctype, "application/json") {
+		fmt.Fprintln(c.out, strings.TrimSpace(string(b)))
+		return nil
+	}
+	if c.json {
+		var v any
+		if err := json.Unmarshal(b, &v); err != nil {
+			return err
+		}
+		enc := json.NewEncoder(c.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	if columns != nil {
+		var rows []map[string]any
+		if err := json.Unmarshal(b, &rows); err != nil {
+			return err
+		}
+		c.table(columns, rows)
+		return nil
+	}
+	var obj map[string]any
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return err
+	}
+	c.fields(obj)
+	return nil
+}
+
+func (c *ctl) table(columns []string, rows []map[string]any) {
+	tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.ToUpper(strings.Join(columns, "\t")))
+	for _, row := range rows {
+		cells := make([]string, len(columns))
+		for i, col := range columns {
+			cells[i] = cell(row[col])
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	tw.Flush()
+}
+
+func (c *ctl) fields(obj map[string]any) {
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	tw := tabwriter.NewWriter(c.out, 0, 4, 1, ' ', 0)
+	for _, k := range keys {
+		fmt.Fprintf(tw, "%s:\t%s\n", k, cell(obj[k]))
+	}
+	tw.Flush()
+}
+
+func cell(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64, bool:
+		return fmt.Sprint(v)
+	default:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+}
+
+func (c *ctl) clients() error {
+	b, _, err := c.do(http.MethodGet, "/clients", nil, nil)
+	if err != nil {
+		return err
+	}
+	var list struct {
+		Members    []map[string]any `json:"members"`
+		Spectators []map[string]any `json:"spectators"`
+	}
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	var rows []map[string]any
+	for role, infos := range map[string][]map[string]any{"member": list.Members, "spectator": list.Spectators} {
+		for _, info := range infos {
+			info["role"] = role
+			rows = append(rows, info)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return cell(rows[i]["id"]) < cell(rows[j]["id"]) })
+	if c.json {
+		enc := json.NewEncoder(c.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rows)
+	}
+	c.table([]string{"id", "role", "status", "transport", "ip", "user_agent"}, rows)
+	return nil
+}
+
+// audit prints the audit log and, when following, polls for entries newer
+// than the last one printed.
+func (c *ctl) audit(follow bool) error {
+	columns := []string{"time", "action", "actor", "target", "detail", "ip"}
+	var last string
+	for {
+		b, _, err := c.do(http.MethodGet, "/admin/audit", nil, nil)
+		if err != nil {
+			return err
+		}
+		var entries []map[string]any
+		if err := json.Unmarshal(b, &entries); err != nil {
+			return err
+		}
+		var fresh []map[string]any
+		for _, e := range entries {
+			if cell(e["time"]) > last {
+				fresh = append(fresh, e)
+			}
+		}
+		if len(fresh) > 0 {
+			last = cell(fresh[len(fresh)-1]["time"])
+		}
+		switch {
+		case c.json:
+			enc := json.NewEncoder(c.out)
+			for _, e := range fresh {
+				if err := enc.Encode(e); err != nil {
+					return err
+				}
+			}
+		case len(fresh) > 0 || !follow:
+			c.table(columns, fresh)
+		}
+		if !follow {
+			return nil
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+func usage(fs *flag.FlagSet) {
+	w := fs.Output()
+	fmt.Fprintln(w, "usage: convoctl [flags] <command> [args]\n\ncommands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, name := range names {
+		fmt.Fprintf(tw, "  %s\t%s\n", commands[name].usage, commands[name].summary)
+	}
+	tw.Flush()
+	fmt.Fprintln(w, "\nflags:")
+	fs.PrintDefaults()
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Getenv, os.Stdout, os.Stderr))
+}
+
+// run is convoctl with its arguments, environment and output given, and
+// returns the exit status.
+func run(argv []string, getenv func(string) string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("convoctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	server := fs.String("server", "", "server base URL (default $CONVO_SERVER or http://localhost:8080)")
+	tokenFile := fs.String("token-file", "", "file whose first line is the admin token (default $CONVO_ADMIN_TOKEN)")
+	asJSON := fs.Bool("json", false, "print JSON instead of tables")
+	fs.Usage = func() { usage(fs) }
+	if err := fs.Parse(argv); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+
+	c := &ctl{server: *server, token: getenv("CONVO_ADMIN_TOKEN"), json: *asJSON, out: stdout, client: &http.Client{Timeout: 30 * time.Second}}
+	if c.server == "" {
+		c.server = getenv("CONVO_SERVER")
+	}
+	if c.server == "" {
+		c.server = "http://localhost:8080"
+	}
+	c.server = strings.TrimRight(c.server, "/")
+	if *tokenFile != "" {
+		b, err := os.ReadFile(*tokenFile)
+		if err != nil {
+			fmt.Fprintln(stderr, "convoctl:", err)
+			return 2
+		}
+		c.token, _, _ = strings.Cut(strings.TrimSpace(string(b)), "\n")
+	}
+
+	args := fs.Args()
+	if len(args) == 0 {
+		usage(fs)
+		return 2
+	}
+	cmd, ok := commands[args[0]]
+	if !ok || len(args)-1 < cmd.minArgs {
+		if ok {
+			fmt.Fprintln(stderr, "usage: convoctl", cmd.usage)
+		} else {
+			usage(fs)
+		}
+		return 2
+	}
+	if err := cmd.run(c, args[1:]); err != nil {
+		if err == errUsage {
+			fmt.Fprintln(stderr, "usage: convoctl", cmd.usage)
+			return 2
+		}
+		fmt.Fprintln(stderr, "convoctl:", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// request is what the fake server saw of one call.
+type request struct {
+	method, path, query, body, auth string
+}
+
+// fakeServer answers each path with a canned reply and records requests.
+type fakeServer struct {
+	mu       sync.Mutex
+	requests []request
+	replies  map[string]reply
+}
+
+type reply struct {
+	status int
+	ctype  string
+	body   string
+}
+
+func newFakeServer(t *testing.T, replies map[string]reply) (*fakeServer, string) {
+	f := &fakeServer{replies: replies}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		f.mu.Lock()
+		f.requests = append(f.requests, request{r.Method, r.URL.Path, r.URL.RawQuery, string(b), r.Header.Get("Authorization")})
+		f.mu.Unlock()
+		rep, ok := f.replies[r.Method+" "+r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if rep.ctype != "" {
+			w.Header().Set("Content-Type", rep.ctype)
+		}
+		if rep.status != 0 {
+			w.WriteHeader(rep.status)
+		}
+		io.WriteString(w, rep.body)
+	}))
+	t.Cleanup(srv.Close)
+	return f, srv.URL
+}
+
+// convoctl runs the command against base with the admin token in the
+// environment and returns its exit status and output.
+func convoctl(base string, args ...string) (int, string, string) {
+	env := map[string]string{"CONVO_SERVER": base, "CONVO_ADMIN_TOKEN": "secret"}
+	var stdout, stderr bytes.Buffer
+	code := run(args, func(k string) string { return env[k] }, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+const jsonType = "application/json"
+
+var canned = map[string]reply{
+	"GET /stats":              {ctype: jsonType, body: `{"clients":2,"topic":"go"}`},
+	"GET /clients":            {ctype: jsonType, body: `{"generation":3,"members":[{"id":"bob","transport":"poll","status":"busy"},{"id":"alice","transport":"stream","status":"online","ip":"192.0.2.1"}],"spectators":[{"id":"carol","transport":"poll","status":"online"}]}`},
+	"POST /admin/revoke":      {body: "Client bob revoked"},
+	"POST /admin/mute":        {body: "Client bob muted"},
+	"POST /admin/unmute":      {body: "Client bob unmuted"},
+	"GET /admin/mutes":        {ctype: jsonType, body: `[{"client_id":"bob","until":"2026-01-02T03:04:05Z","auto":false,"shadow":true,"reason":"spam"}]`},
+	"POST /admin/ephemeral":   {body: "Ephemeral message sent to bob"},
+	"GET /admin/welcome":      {ctype: jsonType, body: `{"text":"Hello"}`},
+	"POST /admin/welcome":     {ctype: jsonType, body: `{"text":"Hi there"}`},
+	"GET /admin/maintenance":  {ctype: jsonType, body: `{"enabled":false}`},
+	"POST /admin/maintenance": {ctype: jsonType, body: `{"enabled":true,"message":"back soon"}`},
+	"GET /admin/pending":      {ctype: jsonType, body: `[{"id":"dave","since":"2026-01-02T03:04:05Z"}]`},
+	"POST /admin/approve":     {body: "Client dave approved"},
+	"POST /admin/deny":        {body: "Client dave denied"},
+	"GET /admin/alerts":       {ctype: jsonType, body: `{"firing":[]}`},
+	"GET /admin/dlq":          {ctype: jsonType, body: `[{"id":"d1","url":"http://hook.example","failed":"2026-01-02T03:04:05Z","attempts":5,"reason":"timeout"}]`},
+	"POST /admin/dlq/replay":  {body: "Replayed 1 webhook"},
+	"GET /admin/audit":        {ctype: jsonType, body: `[{"time":"2026-01-02T03:04:05Z","action":"mute","actor":"admin","target":"bob","detail":"10m"}]`},
+}
+
+func TestSubcommands(t *testing.T) {
+	cases := []struct {
+		args []string
+		want request // Only method, path, query and body are compared
+		out  string
+	}{
+		{[]string{"stats"}, request{method: "GET", path: "/stats"},
+			"clients: 2\ntopic:   go\n"},
+		{[]string{"clients"}, request{method: "GET", path: "/clients"},
+			"ID     ROLE       STATUS  TRANSPORT  IP         USER_AGENT\n" +
+				"alice  member     online  stream     192.0.2.1  \n" +
+				"bob    member     busy    poll                  \n" +
+				"carol  spectator  online  poll                  \n"},
+		{[]string{"kick", "bob"}, request{method: "POST", path: "/admin/revoke", query: "id=bob"},
+			"Client bob revoked\n"},
+		{[]string{"mute", "bob", "10m", "spam", "again"}, request{method: "POST", path: "/admin/mute", query: "duration=10m&id=bob&reason=spam+again"},
+			"Client bob muted\n"},
+		{[]string{"shadow-mute", "bob", "10m"}, request{method: "POST", path: "/admin/mute", query: "duration=10m&id=bob&reason=&shadow=true"},
+			"Client bob muted\n"},
+		{[]string{"unmute", "bob"}, request{method: "POST", path: "/admin/unmute", query: "id=bob"},
+			"Client bob unmuted\n"},
+		{[]string{"mutes"}, request{method: "GET", path: "/admin/mutes"},
+			"CLIENT_ID  UNTIL                 AUTO   SHADOW  REASON\n" +
+				"bob        2026-01-02T03:04:05Z  false  true    spam\n"},
+		{[]string{"notify", "bob", "please", "stop"}, request{method: "POST", path: "/admin/ephemeral", query: "id=bob&message=please+stop&urgent=true"},
+			"Ephemeral message sent to bob\n"},
+		{[]string{"welcome"}, request{method: "GET", path: "/admin/welcome"},
+			"text: Hello\n"},
+		{[]string{"welcome", "Hi", "there"}, request{method: "POST", path: "/admin/welcome", body: `{"text":"Hi there"}`},
+			"text: Hi there\n"},
+		{[]string{"maintenance"}, request{method: "GET", path: "/admin/maintenance"},
+			"enabled: false\n"},
+		{[]string{"maintenance", "on", "back", "soon"}, request{method: "POST", path: "/admin/maintenance", body: `{"enabled":true,"message":"back soon"}`},
+			"enabled: true\nmessage: back soon\n"},
+		{[]string{"pending"}, request{method: "GET", path: "/admin/pending"},
+			"ID    SINCE\ndave  2026-01-02T03:04:05Z\n"},
+		{[]string{"approve", "dave"}, request{method: "POST", path: "/admin/approve", query: "id=dave"},
+			"Client dave approved\n"},
+		{[]string{"deny", "dave", "no", "thanks"}, request{method: "POST", path: "/admin/deny", query: "id=dave&reason=no+thanks"},
+			"Client dave denied\n"},
+		{[]string{"alerts"}, request{method: "GET", path: "/admin/alerts"},
+			"firing: []\n"},
+		{[]string{"dlq"}, request{method: "GET", path: "/admin/dlq"},
+			"ID  URL                  FAILED                ATTEMPTS  REASON\n" +
+				"d1  http://hook.example  2026-01-02T03:04:05Z  5         timeout\n"},
+		{[]string{"dlq", "replay", "d1"}, request{method: "POST", path: "/admin/dlq/replay", query: "id=d1"},
+			"Replayed 1 webhook\n"},
+		{[]string{"audit"}, request{method: "GET", path: "/admin/audit"},
+			"TIME                  ACTION  ACTOR  TARGET  DETAIL  IP\n" +
+				"2026-01-02T03:04:05Z  mute    admin  bob     10m     \n"},
+	}
+	for _, tc := range cases {
+		t.Run(strings.Join(tc.args, " "), func(t *testing.T) {
+			f, base := newFakeServer(t, canned)
+			code, out, errOut := convoctl(base, tc.args...)
+			if code != 0 || errOut != "" {
+				t.Fatalf("exit %d, stderr %q", code, errOut)
+			}
+			if out != tc.out {
+				t.Errorf("output:\n%s\nwant:\n%s", out, tc.out)
+			}
+			if len(f.requests) != 1 {
+				t.Fatalf("%d requests, want 1: %+v", len(f.requests), f.requests)
+			}
+			got := f.requests[0]
+			if got.auth != "Bearer secret" {
+				t.Errorf("Authorization %q", got.auth)
+			}
+			got.auth = ""
+			if got != tc.want {
+				t.Errorf("request %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSubcommandsCoverEveryCommand(t *testing.T) {
+	// Keep TestSubcommands in step with the command table.
+	tested := map[string]bool{"stats": true, "clients": true, "kick": true, "mute": true, "shadow-mute": true,
+		"unmute": true, "mutes": true, "notify": true, "welcome": true, "maintenance": true, "pending": true,
+		"approve": true, "deny": true, "alerts": true, "dlq": true, "audit": true}
+	for name := range commands {
+		if !tested[name] {
+			t.Errorf("convoctl %s has no test", name)
+		}
+	}
+}
+
+func TestJSONOutput(t *testing.T) {
+	_, base := newFakeServer(t, canned)
+	code, out, _ := convoctl(base, "-json", "stats")
+	if want := "{\n  \"clients\": 2,\n  \"topic\": \"go\"\n}\n"; code != 0 || out != want {
+		t.Errorf("-json stats: exit %d, output %q, want %q", code, out, want)
+	}
+	code, out, _ = convoctl(base, "-json", "clients")
+	if code != 0 || !strings.HasPrefix(out, "[\n  {\n") || !strings.Contains(out, `"role": "spectator"`) {
+		t.Errorf("-json clients: exit %d, output %s", code, out)
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	_, base := newFakeServer(t, map[string]reply{
+		"POST /admin/revoke": {status: http.StatusNotFound, body: "Client not found\n"},
+		"GET /stats":         {status: http.StatusUnauthorized, body: "Admin token required\n"},
+	})
+	cases := []struct {
+		args   []string
+		code   int
+		stderr string
+	}{
+		{[]string{"kick", "nobody"}, 1, "convoctl: POST /admin/revoke: 404 Not Found: Client not found\n"},
+		{[]string{"stats"}, 1, "convoctl: GET /stats: 401 Unauthorized: Admin token required\n"},
+		{[]string{"kick"}, 2, "usage: convoctl kick <id>\n"},
+		{[]string{"maintenance", "maybe"}, 2, "usage: convoctl maintenance [on [message] | off]\n"},
+		{[]string{"dlq", "purge"}, 2, "usage: convoctl dlq [replay [id]]\n"},
+		{[]string{"audit", "-x"}, 2, "usage: convoctl audit [-f]\n"},
+		{[]string{"frobnicate"}, 2, ""},
+		{nil, 2, ""},
+		{[]string{"-h"}, 0, ""},
+	}
+	for _, tc := range cases {
+		code, _, errOut := convoctl(base, tc.args...)
+		if code != tc.code || tc.stderr != "" && errOut != tc.stderr {
+			t.Errorf("convoctl %q: exit %d, stderr %q; want %d, %q", tc.args, code, errOut, tc.code, tc.stderr)
+		}
+	}
+	// An unreachable server is a failed request, not a usage error.
+	code, _, errOut := convoctl("http://127.0.0.1:1", "stats")
+	if code != 1 || !strings.HasPrefix(errOut, "convoctl: ") {
+		t.Errorf("unreachable server: exit %d, stderr %q", code, errOut)
+	}
+}
+
+func TestTokenFile(t *testing.T) {
+	f, base := newFakeServer(t, canned)
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("from-file\nignored\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if code, _, errOut := convoctl(base, "-token-file", path, "stats"); code != 0 {
+		t.Fatalf("exit %d: %s", code, errOut)
+	}
+	if got := f.requests[0].auth; got != "Bearer from-file" {
+		t.Errorf("Authorization %q, want the token from the file", got)
+	}
+	if code, _, _ := convoctl(base, "-token-file", filepath.Join(t.TempDir(), "missing"), "stats"); code != 2 {
+		t.Errorf("missing token file: exit %d, want 2", code)
+	}
+}
@@ -0,0 +1,367 @@
+// Command convoload is a load generator for the chat server. It joins N
+// simulated clients to a target server, has each long-poll for messages and
+// send at a fixed rate, and prints a JSON summary of delivery latency,
+// delivery gaps and error counts when the run ends.
+//
+// Runs with the same -seed produce the same client IDs, join schedule,
+// send jitter and message sizes, so two server builds can be compared
+// under the same load.
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	tokenHeader          = "X-Convo-Token"
+	requestSigningHeader = "X-Convo-Request-Signing"
+	requestSecretHeader  = "X-Convo-Request-Secret"
+	timestampHeader      = "X-Convo-Timestamp"
+	nonceHeader          = "X-Convo-Nonce"
+	signatureHeader      = "X-Convo-Signature"
+)
+
+// message is the part of the server's JSON envelope the load test reads.
+type message struct {
+	From string `json:"from"`
+	Body string `json:"body"`
+	Type string `json:"type"`
+	Seq  uint64 `json:"seq"`
+}
+
+type config struct {
+	target   string
+	clients  int
+	rate     float64 // Sends per second per client
+	size     int     // Message body bytes
+	duration time.Duration
+	ramp     time.Duration // Joins are spread evenly over this long
+	wait     int           // Long-poll wait in seconds
+	seed     int64
+}
+
+// results accumulates the run. Guarded by mu.
+type results struct {
+	mu         sync.Mutex
+	joined     int
+	sent       int
+	received   int
+	gaps       uint64
+	latencies  []time.Duration
+	joinErrors map[string]int
+	sendErrors map[string]int
+	pollErrors map[string]int
+}
+
+func (r *results) fail(m map[string]int, what string) {
+	r.mu.Lock()
+	m[what]++
+	r.mu.Unlock()
+}
+
+// Summary is the machine-readable report printed at the end of a run.
+type Summary struct {
+	Target     string         `json:"target"`
+	Seed       int64          `json:"seed"`
+	Clients    int            `json:"clients"`
+	Joined     int            `json:"joined"`
+	Duration   string         `json:"duration"`
+	Sent       int            `json:"sent"`
+	Received   int            `json:"received"`
+	Gaps       uint64         `json:"gaps"` // Messages missing from a sender's Seq sequence, summed over receivers
+	Latency    Latency        `json:"latency_ms"`
+	JoinErrors map[string]int `json:"join_errors,omitempty"`
+	SendErrors map[string]int `json:"send_errors,omitempty"`
+	PollErrors map[string]int `json:"poll_errors,omitempty"`
+}
+
+type Latency struct {
+	P50 float64 `json:"p50"`
+	P95 float64 `json:"p95"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+func percentile(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(p * float64(len(sorted)-1))
+	return float64(sorted[i]) / float64(time.Millisecond)
+}
+
+func (r *results) summary(cfg config) Summary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sort.Slice(r.latencies, func(i, j int) bool { return r.latencies[i] < r.latencies[j] })
+	s := Summary{
+		Target:     cfg.target,
+		Seed:       cfg.seed,
+		Clients:    cfg.clients,
+		Joined:     r.joined,
+		Duration:   cfg.duration.String(),
+		Sent:       r.sent,
+		Received:   r.received,
+		Gaps:       r.gaps,
+		JoinErrors: r.joinErrors,
+		SendErrors: r.sendErrors,
+		PollErrors: r.pollErrors,
+	}
+	s.Latency.P50 = percentile(r.latencies, 0.50)
+	s.Latency.P95 = percentile(r.latencies, 0.95)
+	s.Latency.P99 = percentile(r.latencies, 0.99)
+	s.Latency.Max = percentile(r.latencies, 1)
+	return s
+}
+
+type loadClient struct {
+	id     string
+	token  string
+	secret []byte // Request signing secret, when the server requires signing
+	nonce  atomic.Uint64
+	rng    *rand.Rand
+	last   map[string]uint64 // Last Seq received from each sender
+}
+
+func outcome(resp *http.Response, err error) string {
+	if err != nil {
+		return "transport"
+	}
+	return strconv.Itoa(resp.StatusCode)
+}
+
+func (c *loadClient) join(ctx context.Context, cfg config) error {
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, cfg.target+"/join?id="+url.QueryEscape(c.id), nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		if err == nil {
+			resp.Body.Close()
+		}
+		return fmt.Errorf("%s", outcome(resp, err))
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	c.token = resp.Header.Get(tokenHeader)
+	if resp.Header.Get(requestSigningHeader) != "" {
+		secret, err := base64.RawURLEncoding.DecodeString(resp.Header.Get(requestSecretHeader))
+		if err != nil {
+			return fmt.Errorf("bad request secret")
+		}
+		c.secret = secret
+	}
+	return nil
+}
+
+// authorize adds the send token to a bodiless request and, when the server
+// requires it, signs the request.
+func (c *loadClient) authorize(req *http.Request) {
+	req.Header.Set(tokenHeader, c.token)
+	if c.secret == nil {
+		return
+	}
+	ts := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	nonce := strconv.FormatUint(c.nonce.Add(1), 10)
+	body := sha256.Sum256(nil)
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write([]byte(req.Method + "\n" + req.URL.RequestURI() + "\n" + ts + "\n" + nonce + "\n" + hex.EncodeToString(body[:])))
+	req.Header.Set(timestampHeader, ts)
+	req.Header.Set(nonceHeader, nonce)
+	req.Header.Set(signatureHeader, base64.RawURLEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+// body carries the send time so receivers can measure latency without
+// trusting the server's clock, padded to the configured size.
+func (c *loadClient) body(cfg config) string {
+	b := "t=" + strconv.FormatInt(time.Now().UnixNano(), 10) + " "
+	if n := cfg.size - len(b); n > 0 {
+		pad := make([]byte, n)
+		for i := range pad {
+			pad[i] = 'a' + byte(c.rng.Intn(26))
+		}
+		b += string(pad)
+	}
+	return b
+}
+
+func (c *loadClient) send(ctx context.Context, cfg config, res *results) {
+	if cfg.rate <= 0 {
+		return
+	}
+	interval := time.Duration(float64(time.Second) / cfg.rate)
+	for {
+		// Up to ±25% jitter keeps clients from sending in lockstep.
+		jitter := time.Duration((c.rng.Float64() - 0.5) * 0.5 * float64(interval))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval + jitter):
+		}
+		q := url.Values{"message": {c.body(cfg)}}
+		req, _ := http.NewRequestWithContext(ctx, http.MethodPost, cfg.target+"/send?"+q.Encode(), nil)
+		c.authorize(req)
+		resp, err := http.DefaultClient.Do(req)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil || resp.StatusCode != http.StatusOK {
+			res.fail(res.sendErrors, outcome(resp, err))
+		} else {
+			res.mu.Lock()
+			res.sent++
+			res.mu.Unlock()
+		}
+		if err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	}
+}
+
+func (c *loadClient) poll(ctx context.Context, cfg config, res *results) {
+	for ctx.Err() == nil {
+		u := fmt.Sprintf("%s/messages?id=%s&format=json&wait=%d", cfg.target, url.QueryEscape(c.id), cfg.wait)
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		c.authorize(req)
+		resp, err := http.DefaultClient.Do(req)
+		if ctx.Err() != nil {
+			return
+		}
+		switch {
+		case err != nil:
+			res.fail(res.pollErrors, "transport")
+			time.Sleep(100 * time.Millisecond)
+			continue
+		case resp.StatusCode == http.StatusGatewayTimeout:
+			// Nothing arrived within the wait.
+		case resp.StatusCode != http.StatusOK:
+			res.fail(res.pollErrors, strconv.Itoa(resp.StatusCode))
+		default:
+			var m message
+			if json.NewDecoder(resp.Body).Decode(&m) == nil {
+				c.record(m, time.Now(), res)
+			}
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+}
+
+func (c *loadClient) record(m message, now time.Time, res *results) {
+	ts, ok := strings.CutPrefix(m.Body, "t=")
+	if m.Type != "" || !ok {
+		return
+	}
+	ts, _, _ = strings.Cut(ts, " ")
+	sentAt, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return
+	}
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	res.received++
+	res.latencies = append(res.latencies, now.Sub(time.Unix(0, sentAt)))
+	// Count gaps from the first message seen, since a receiver that joined
+	// late never expected what came before.
+	if last, seen := c.last[m.From]; seen && m.Seq > last+1 {
+		res.gaps += m.Seq - last - 1
+	}
+	if m.Seq > c.last[m.From] {
+		c.last[m.From] = m.Seq
+	}
+}
+
+func (c *loadClient) leave(cfg config) {
+	req, _ := http.NewRequest(http.MethodPost, cfg.target+"/leave?id="+url.QueryEscape(c.id), nil)
+	c.authorize(req)
+	resp, err := http.DefaultClient.Do(req)
+	if err == nil {
+		resp.Body.Close()
+	}
+}
+
+func main() {
+	var cfg config
+	flag.StringVar(&cfg.target, "target", "http://localhost:8080", "base URL of the server under test")
+	flag.IntVar(&cfg.clients, "clients", 10, "number of simulated clients")
+	flag.Float64Var(&cfg.rate, "rate", 1, "messages per second sent by each client (0 only receives)")
+	flag.IntVar(&cfg.size, "size", 64, "message body size in bytes")
+	flag.DurationVar(&cfg.duration, "duration", 30*time.Second, "how long to generate load, ramp-up included")
+	flag.DurationVar(&cfg.ramp, "ramp", 0, "spread joins evenly over this long instead of joining all at once")
+	flag.IntVar(&cfg.wait, "wait", 5, "long-poll wait in seconds")
+	flag.Int64Var(&cfg.seed, "seed", 1, "seed for client IDs, join order, send jitter and message contents")
+	flag.Parse()
+	cfg.target = strings.TrimRight(cfg.target, "/")
+	if cfg.clients <= 0 || cfg.duration <= 0 || cfg.ramp < 0 || cfg.ramp >= cfg.duration {
+		log.Fatal("need -clients > 0, -duration > 0 and 0 <= -ramp < -duration")
+	}
+	if cfg.size > 4096 {
+		log.Fatal("-size must be at most 4096, the server's message limit")
+	}
+
+	res := &results{joinErrors: map[string]int{}, sendErrors: map[string]int{}, pollErrors: map[string]int{}}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.duration)
+	defer cancel()
+	seeds := rand.New(rand.NewSource(cfg.seed))
+	clients := make([]*loadClient, cfg.clients)
+	for i := range clients {
+		clients[i] = &loadClient{
+			id:   fmt.Sprintf("load-%d-%d", cfg.seed, i),
+			rng:  rand.New(rand.NewSource(seeds.Int63())),
+			last: make(map[string]uint64),
+		}
+	}
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i, c := range clients {
+		if cfg.ramp > 0 {
+			at := start.Add(time.Duration(int64(cfg.ramp) * int64(i) / int64(cfg.clients)))
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Until(at)):
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if err := c.join(ctx, cfg); err != nil {
+			res.fail(res.joinErrors, err.Error())
+			continue
+		}
+		res.mu.Lock()
+		res.joined++
+		res.mu.Unlock()
+		wg.Add(2)
+		go func(c *loadClient) { defer wg.Done(); c.poll(ctx, cfg, res) }(c)
+		go func(c *loadClient) { defer wg.Done(); c.send(ctx, cfg, res) }(c)
+	}
+	wg.Wait()
+	for _, c := range clients {
+		if c.token != "" {
+			c.leave(cfg)
+		}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(res.summary(cfg))
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	messageTypeAction = "action"
+	messageTypeTopic  = "topic"
+	shrug             = `¯\_(ツ)_/¯`
+)
+
+var errUnknownCommand = errors.New("unknown command")
+
+// CommandInvocation is a slash command as typed by a client.
+type CommandInvocation struct {
+	Sender string
+	Name   string // Without the leading slash
+	Args   string // Everything after the name, trimmed
+	Admin  bool   // Whether the request carried admin credentials
+}
+
+// CommandFunc handles a slash command. It returns the message to broadcast,
+// or nil when the command only has side effects. A message of type
+// "ephemeral" is a reply instead: it is delivered to the sender alone and
+// its body also answers the /send. A returned error is shown to the sender
+// and nothing is broadcast.
+type CommandFunc func(cr *ChatRoom, inv CommandInvocation) (*Message, error)
+
+type command struct {
+	usage string
+	run   CommandFunc
+}
+
+// RegisterCommand adds or replaces the slash command /name. usage is shown in
+// the list of available commands.
+func (cr *ChatRoom) RegisterCommand(name, usage string, fn CommandFunc) {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	cr.commands[name] = command{usage: usage, run: fn}
+}
+
+func (cr *ChatRoom) registerBuiltinCommands() {
+	cr.commands = make(map[string]command)
+	cr.RegisterCommand("me", "/me <action>", cmdMe)
+	cr.RegisterCommand("shrug", "/shrug [text]", cmdShrug)
+	cr.RegisterCommand("topic", "/topic <text> (moderators)", cmdTopic)
+	cr.RegisterCommand("whois", "/whois <id>", cmdWhois)
+}
+
+// availableCommands lists registered commands for error messages.
+func (cr *ChatRoom) availableCommands() string {
+	cr.mutex.Lock()
+	usages := make([]string, 0, len(cr.commands))
+	for _, c := range cr.commands {
+		usages = append(usages, c.usage)
+	}
+	cr.mutex.Unlock()
+	sort.Strings(usages)
+	return strings.Join(usages, ", ")
+}
+
+// parseCommand splits "/name args" into name and args. A body starting with
+// "//" is not a command; it is sent with one slash removed.
+func parseCommand(body string) (name, args string, ok bool) {
+	if !strings.HasPrefix(body, "/") || strings.HasPrefix(body, "//") {
+		return "", "", false
+	}
+	name, args, _ = strings.Cut(body[1:], " ")
+	return name, strings.TrimSpace(args), true
+}
+
+// runCommand executes a slash command typed by sender.
+func (cr *ChatRoom) runCommand(sender, name, args string, admin bool) (*Message, error) {
+	cr.mutex.Lock()
+	c, ok := cr.commands[name]
+	cr.mutex.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w /%s; available commands: %s", errUnknownCommand, name, cr.availableCommands())
+	}
+	return c.run(cr, CommandInvocation{Sender: sender, Name: name, Args: args, Admin: admin})
+}
+
+func cmdMe(cr *ChatRoom, inv CommandInvocation) (*Message, error) {
+	if inv.Args == "" {
+		return nil, errors.New("usage: /me <action>")
+	}
+	return &Message{From: inv.Sender, Type: messageTypeAction, Body: inv.Args}, nil
+}
+
+func cmdShrug(cr *ChatRoom, inv CommandInvocation) (*Message, error) {
+	body := shrug
+	if inv.Args != "" {
+		body = inv.Args + " " + shrug
+	}
+	return &Message{From: inv.Sender, Body: body}, nil
+}
+
+func cmdTopic(cr *ChatRoom, inv CommandInvocation) (*Message, error) {
+	if !inv.Admin {
+		return nil, errors.New("only moderators can set the topic")
+	}
+	cr.mutex.Lock()
+	cr.topic = inv.Args
+	cr.mutex.Unlock()
+	cr.audit.add(AuditEntry{Action: "topic", Actor: inv.Sender, Detail: inv.Args})
+	body := inv.Sender + " cleared the topic"
+	if inv.Args != "" {
+		body = inv.Sender + " set the topic: " + inv.Args
+	}
+	return &Message{From: systemSender, Type: messageTypeTopic, Body: body}, nil
+}
+
+func cmdWhois(cr *ChatRoom, inv CommandInvocation) (*Message, error) {
+	if inv.Args == "" {
+		return nil, errors.New("usage: /whois <id>")
+	}
+	// Only admins and the client itself see past invisibility, as in /clients.
+	cr.mutex.Lock()
+	c, online := cr.clients[inv.Args]
+	if online && c.invisible && !inv.Admin && inv.Args != inv.Sender {
+		online = false
+	}
+	var info string
+	if online {
+		status := statusOnline
+		if c.inDNDLocked(cr.clock.Now()) {
+			status = statusBusy
+		}
+		info = fmt.Sprintf("%s is %s as a %s via %s", inv.Args, status, c.role, c.transport)
+		if c.invisible {
+			info += " (invisible)"
+		}
+	}
+	cr.mutex.Unlock()
+	if !online {
+		info = inv.Args + " is not online"
+	}
+	return &Message{Type: messageTypeEphemeral, Body: info}, nil
+}
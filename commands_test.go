@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestWhoisRepliesInResponseAndEphemerally(t *testing.T) {
+	_, h := newTestRoom(t)
+	ta := join(t, h, "alice")
+	join(t, h, "bob")
+	w := send(h, ta, "/whois bob")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "bob is online") {
+		t.Fatalf("whois: %d %s", w.Code, w.Body)
+	}
+	m := pollJSON(t, h, "alice", ta)
+	if m.Type != messageTypeEphemeral || !strings.Contains(m.Body, "bob is online") {
+		t.Errorf("alice got %+v, want the whois reply", m)
+	}
+	if w := send(h, ta, "/whois carol"); !strings.Contains(w.Body.String(), "carol is not online") {
+		t.Errorf("whois carol: %d %s", w.Code, w.Body)
+	}
+}
+
+// /whois tells other clients no more than /clients does.
+func TestWhoisRespectsPresenceAndDoNotDisturb(t *testing.T) {
+	cr, h := newTestRoom(t)
+	ta := join(t, h, "alice")
+	tb := join(t, h, "bob")
+	if err := cr.SetPresence("bob", presenceInvisible); err != nil {
+		t.Fatal(err)
+	}
+	whois := func(what string, headers ...string) string {
+		t.Helper()
+		w := do(h, "POST", "/send?message="+url.QueryEscape("/whois bob"), headers...)
+		if w.Code != http.StatusOK {
+			t.Fatalf("whois as %s: %d %s", what, w.Code, w.Body)
+		}
+		return w.Body.String()
+	}
+	if got := whois("alice", tokenHeader, ta); !strings.Contains(got, "bob is not online") {
+		t.Errorf("alice's whois of invisible bob: %s", got)
+	}
+	if got := whois("bob", tokenHeader, tb); !strings.Contains(got, "bob is online") {
+		t.Errorf("bob's whois of bob: %s", got)
+	}
+	if got := do(h, "POST", "/send?id=alice&message="+url.QueryEscape("/whois bob"), asAdmin...).Body.String(); !strings.Contains(got, "bob is online") || !strings.Contains(got, "(invisible)") {
+		t.Errorf("admin whois of invisible bob: %s", got)
+	}
+
+	if err := cr.SetPresence("bob", presenceVisible); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cr.SetDoNotDisturb("bob", 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := whois("alice", tokenHeader, ta); !strings.Contains(got, "bob is busy") {
+		t.Errorf("alice's whois of bob in do-not-disturb: %s", got)
+	}
+}
@@ -0,0 +1,82 @@
+//go:build !nodebug
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+)
+
+// Internals is the body of /debug/internals: what the room believes is
+// running, to compare against the goroutine profile.
+type Internals struct {
+	Workers             map[string]int       `json:"workers"`  // Live goroutines by kind
+	LastRun             map[string]time.Time `json:"last_run"` // When each periodic worker last ran
+	Goroutines          int                  `json:"goroutines"`
+	Clients             int                  `json:"clients"`
+	Sessions            int                  `json:"sessions"`
+	Pending             int                  `json:"pending"`
+	Departing           int                  `json:"departing"`
+	Embargoes           int                  `json:"embargoes"`
+	BroadcastQueueDepth int                  `json:"broadcast_queue_depth"`
+	BroadcastQueueCap   int                  `json:"broadcast_queue_capacity"`
+	SessionQueueDepth   int                  `json:"session_queue_depth"`     // Deliveries waiting across all sessions
+	SessionQueueCap     int                  `json:"session_queue_capacity"`  // Limit for each session
+	SessionOverflows    int64                `json:"session_queue_overflows"` // Oldest deliveries dropped from full queues
+}
+
+func (cr *ChatRoom) Internals() Internals {
+	in := Internals{
+		Goroutines:          runtime.NumGoroutine(),
+		BroadcastQueueDepth: len(cr.broadcast),
+		BroadcastQueueCap:   cap(cr.broadcast),
+		SessionQueueCap:     cr.sessionQueue,
+	}
+	in.Workers, in.LastRun = cr.workers.snapshot()
+	cr.mutex.Lock()
+	for _, c := range cr.clients {
+		in.Clients++
+		in.Sessions += 1 + len(c.extra)
+		for _, s := range append([]*client{c}, c.extra...) {
+			in.SessionQueueDepth += s.queue.len()
+			in.SessionOverflows += s.overflowed
+		}
+		if c.pending {
+			in.Pending++
+		}
+	}
+	in.Departing = len(cr.departing)
+	in.Embargoes = len(cr.embargoes)
+	cr.mutex.Unlock()
+	return in
+}
+
+func (cr *ChatRoom) HandleInternals(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cr.Internals())
+}
+
+// debugRoutes serves /debug/internals and net/http/pprof behind the admin
+// token. Build with -tags nodebug to leave them out.
+func (cr *ChatRoom) debugRoutes() []route {
+	return []route{
+		{pattern: "/debug/internals", methods: []string{"GET"}, summary: "Internal goroutine, queue and timer accounting", json: true, admin: true,
+			handler: cr.HandleInternals},
+		{pattern: "/debug/pprof/", path: "/debug/pprof/{profile}", methods: []string{"GET"}, summary: "Runtime profiles (net/http/pprof)", admin: true,
+			params:  []routeParam{pathParam("profile", "Profile name, e.g. goroutine or heap; empty for the index")},
+			handler: pprof.Index},
+		{pattern: "/debug/pprof/cmdline", methods: []string{"GET"}, summary: "Command line of the running server", admin: true,
+			handler: pprof.Cmdline},
+		{pattern: "/debug/pprof/profile", methods: []string{"GET"}, summary: "CPU profile", admin: true,
+			params:      []routeParam{query("seconds", "Profile duration (default 30)", false)},
+			longRunning: true, handler: pprof.Profile},
+		{pattern: "/debug/pprof/symbol", methods: []string{"GET", "POST"}, summary: "Look up program counters", admin: true,
+			handler: pprof.Symbol},
+		{pattern: "/debug/pprof/trace", methods: []string{"GET"}, summary: "Execution trace", admin: true,
+			params:      []routeParam{query("seconds", "Trace duration (default 1)", false)},
+			longRunning: true, handler: pprof.Trace},
+	}
+}
@@ -0,0 +1,8 @@
+//go:build nodebug
+
+package main
+
+// debugRoutes is empty in nodebug builds.
+func (cr *ChatRoom) debugRoutes() []route {
+	return nil
+}
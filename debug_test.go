@@ -0,0 +1,84 @@
+//go:build !nodebug
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+// liveWorkers is the room's worker count without kinds that have none.
+func liveWorkers(cr *ChatRoom) map[string]int {
+	live := cr.Internals().Workers
+	for kind, n := range live {
+		if n == 0 {
+			delete(live, kind)
+		}
+	}
+	return live
+}
+
+// After 1000 clients have joined, sent, polled, subscribed and left, the
+// room is back to the workers and goroutines it started with.
+func TestJoinLeaveChurnReturnsWorkersToBaseline(t *testing.T) {
+	cr, h := newTestRoom(t)
+	baseWorkers := liveWorkers(cr)
+	baseGoroutines := runtime.NumGoroutine()
+
+	for i := 0; i < 1000; i++ {
+		id := fmt.Sprintf("churn%d", i)
+		token := join(t, h, id)
+		if w := send(h, token, "hello from "+id); w.Code != http.StatusOK {
+			t.Fatalf("send %s: %d %s", id, w.Code, w.Body)
+		}
+		pollOnce(h, id, token)
+		if i%10 == 0 {
+			ctx, cancel := context.WithCancel(context.Background())
+			if _, err := cr.Subscribe(ctx, "sub"+id); err != nil {
+				t.Fatalf("subscribe %s: %v", "sub"+id, err)
+			}
+			cancel()
+		}
+		if w := do(h, "POST", "/leave", tokenHeader, token); w.Code != http.StatusOK {
+			t.Fatalf("leave %s: %d %s", id, w.Code, w.Body)
+		}
+	}
+
+	eventually(t, "workers back to baseline", func() bool {
+		return reflect.DeepEqual(liveWorkers(cr), baseWorkers)
+	})
+	eventually(t, "goroutines back to baseline", func() bool {
+		return runtime.NumGoroutine() <= baseGoroutines
+	})
+	eventually(t, "no clients left behind", func() bool {
+		in := cr.Internals()
+		return in.Clients == 0 && in.Sessions == 0 && in.Departing == 0 && in.SessionQueueDepth == 0
+	})
+}
+
+func TestInternalsIsAdminOnly(t *testing.T) {
+	_, h := newTestRoom(t)
+	token := join(t, h, "alice")
+	if w := do(h, "GET", "/debug/internals", tokenHeader, token); w.Code != http.StatusUnauthorized && w.Code != http.StatusForbidden {
+		t.Errorf("internals for a client: got %d, want 401 or 403", w.Code)
+	}
+	w := do(h, "GET", "/debug/internals", asAdmin...)
+	var in Internals
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &in) != nil {
+		t.Fatalf("internals for an admin: %d %s", w.Code, w.Body)
+	}
+	if in.Clients != 1 || in.Workers[workerBroadcast] != 1 {
+		t.Errorf("internals: %d clients, %d broadcasters; want 1 and 1", in.Clients, in.Workers[workerBroadcast])
+	}
+	if w := do(h, "GET", "/debug/pprof/goroutine?debug=1"); w.Code == http.StatusOK {
+		t.Error("pprof is served without the admin token")
+	}
+	if w := do(h, "GET", "/debug/pprof/goroutine?debug=1", asAdmin...); w.Code != http.StatusOK {
+		t.Errorf("pprof for an admin: %d", w.Code)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	maxDeadLetters = 256
+	// Headers on a replayed webhook delivery. The payload is sent unchanged.
+	replayHeader       = "X-Convo-Replay"
+	originalTimeHeader = "X-Convo-Original-Time"
+)
+
+var errDeadLetterNotFound = errors.New("dead letter not found")
+
+// webhookBackoff is how long to wait before each retry of a failed webhook
+// delivery. Once it is used up the payload goes to the dead-letter queue.
+var webhookBackoff = []time.Duration{time.Second, 5 * time.Second, 25 * time.Second}
+
+// DeadLetter is a webhook delivery that failed every attempt.
+type DeadLetter struct {
+	ID       string          `json:"id"`
+	URL      string          `json:"url"`
+	Payload  json.RawMessage `json:"payload"`
+	Created  time.Time       `json:"created"` // When the payload was first sent
+	Failed   time.Time       `json:"failed"`  // When the last attempt failed
+	Attempts int             `json:"attempts"`
+	Reason   string          `json:"reason"`
+}
+
+// deadLetters holds failed deliveries, oldest first, evicting the oldest
+// when full.
+type deadLetters struct {
+	mu      sync.Mutex
+	letters []DeadLetter
+}
+
+func (q *deadLetters) add(d DeadLetter) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.letters) >= maxDeadLetters {
+		q.letters = q.letters[1:]
+	}
+	q.letters = append(q.letters, d)
+}
+
+func (q *deadLetters) list() []DeadLetter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]DeadLetter{}, q.letters...)
+}
+
+func (q *deadLetters) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.letters)
+}
+
+// take removes and returns the letter with ID id, or every letter when id
+// is empty.
+func (q *deadLetters) take(id string) []DeadLetter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if id == "" {
+		out := q.letters
+		q.letters = nil
+		return out
+	}
+	for i, d := range q.letters {
+		if d.ID == id {
+			q.letters = append(q.letters[:i:i], q.letters[i+1:]...)
+			return []DeadLetter{d}
+		}
+	}
+	return nil
+}
+
+// postWebhook POSTs payload to url once.
+func (cr *ChatRoom) postWebhook(client *http.Client, url string, payload []byte, replayOf time.Time) error {
+	ctx, cancel := context.WithTimeout(cr.ctx, webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if !replayOf.IsZero() {
+		req.Header.Set(replayHeader, "true")
+		req.Header.Set(originalTimeHeader, replayOf.UTC().Format(time.RFC3339Nano))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("returned %s", resp.Status)
+	}
+	return nil
+}
+
+// deliverWebhook POSTs payload to url, retrying on webhookBackoff, and
+// dead-letters it if every attempt fails. Closing the room stops the
+// retries without dead-lettering.
+func (cr *ChatRoom) deliverWebhook(client *http.Client, url string, payload []byte) {
+	created := cr.clock.Now()
+	cr.recordWebhook()
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = cr.postWebhook(client, url, payload, time.Time{}); err == nil {
+			return
+		}
+		log.Printf("webhook %s: attempt %d: %v", url, attempt+1, err)
+		if attempt == len(webhookBackoff) {
+			break
+		}
+		select {
+		case <-cr.clock.After(webhookBackoff[attempt]):
+		case <-cr.done:
+			return
+		}
+	}
+	cr.dlq.add(DeadLetter{
+		ID:       cr.ids.NewID(),
+		URL:      url,
+		Payload:  payload,
+		Created:  created,
+		Failed:   cr.clock.Now(),
+		Attempts: len(webhookBackoff) + 1,
+		Reason:   err.Error(),
+	})
+}
+
+// ReplayResult reports a dead-letter replay.
+type ReplayResult struct {
+	Replayed int          `json:"replayed"`
+	Failed   []DeadLetter `json:"failed,omitempty"` // Back in the queue with the new reason
+}
+
+// Replay sends dead letters again, once each, with the original payload and
+// a replay marker. id selects one letter; empty replays all of them.
+func (cr *ChatRoom) Replay(id string) (ReplayResult, error) {
+	letters := cr.dlq.take(id)
+	if id != "" && len(letters) == 0 {
+		return ReplayResult{}, errDeadLetterNotFound
+	}
+	var res ReplayResult
+	for _, d := range letters {
+		if err := cr.postWebhook(cr.webhookClient(), d.URL, d.Payload, d.Created); err != nil {
+			d.Attempts++
+			d.Failed, d.Reason = cr.clock.Now(), err.Error()
+			cr.dlq.add(d)
+			res.Failed = append(res.Failed, d)
+			continue
+		}
+		res.Replayed++
+	}
+	cr.audit.add(AuditEntry{Action: "dlq_replay", Actor: actorAdmin, Target: id, Detail: fmt.Sprintf("%d replayed, %d failed", res.Replayed, len(res.Failed))})
+	return res, nil
+}
+
+func (cr *ChatRoom) HandleDeadLetters(w http.ResponseWriter, r *http.Request) {
+	p, err := parsePage(r, "dlq")
+	if err != nil {
+		pageFailed(w, err)
+		return
+	}
+	letters := cr.dlq.list()
+	if p.limit > 0 {
+		idx, next := p.page(len(letters), func(i int) string { return timeKey(letters[i].Created, letters[i].ID) })
+		paged := make([]DeadLetter, 0, len(idx))
+		for _, i := range idx {
+			paged = append(paged, letters[i])
+		}
+		letters = paged
+		setNextCursor(w, next)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(letters)
+}
+
+// HandleReplay serves POST /admin/dlq/replay[?id=<id>].
+func (cr *ChatRoom) HandleReplay(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	res, err := cr.Replay(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Dead letter not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
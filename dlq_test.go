@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"chatroom/testutil"
+)
+
+// webhookTarget is a webhook receiver that fails while broken is set and
+// records the requests it accepted.
+type webhookTarget struct {
+	broken   atomic.Bool
+	attempts atomic.Int32
+	mu       sync.Mutex
+	accepted []*http.Request
+}
+
+func (wt *webhookTarget) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	wt.attempts.Add(1)
+	if wt.broken.Load() {
+		http.Error(w, "down for maintenance", http.StatusInternalServerError)
+		return
+	}
+	wt.mu.Lock()
+	wt.accepted = append(wt.accepted, r)
+	wt.mu.Unlock()
+}
+
+func newDLQRoom(t *testing.T) (*ChatRoom, http.Handler, *testutil.FakeClock, *webhookTarget, string) {
+	target := &webhookTarget{}
+	srv := httptest.NewServer(target)
+	t.Cleanup(srv.Close)
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	cr, h := newTestRoom(t, WithClock(clk), WithAlerts(AlertConfig{Client: srv.Client()}))
+	return cr, h, clk, target, srv.URL
+}
+
+// A delivery that fails every retry is dead-lettered with the reason, and
+// replaying it once the target is fixed sends the original payload marked
+// as a replay.
+func TestFailedWebhooksAreDeadLetteredAndReplayed(t *testing.T) {
+	cr, h, clk, target, url := newDLQRoom(t)
+	target.broken.Store(true)
+	created := clk.Now()
+	done := make(chan struct{})
+	go func() {
+		cr.deliverWebhook(cr.webhookClient(), url, []byte(`{"event":"test"}`))
+		close(done)
+	}()
+	eventually(t, "the first attempt", func() bool { return target.attempts.Load() == 1 })
+	for i, d := range webhookBackoff {
+		// Advancing before the retry waits is harmless, so keep going
+		// until it has been made.
+		eventually(t, "the next retry", func() bool {
+			clk.Advance(d)
+			return target.attempts.Load() > int32(i+1)
+		})
+	}
+	<-done
+	if n := target.attempts.Load(); n != int32(len(webhookBackoff)+1) {
+		t.Errorf("%d attempts, want %d", n, len(webhookBackoff)+1)
+	}
+
+	var letters []DeadLetter
+	if w := do(h, "GET", "/admin/dlq", asAdmin...); json.Unmarshal(w.Body.Bytes(), &letters) != nil || len(letters) != 1 {
+		t.Fatalf("dead letters: %d %s", w.Code, w.Body)
+	}
+	d := letters[0]
+	if d.URL != url || string(d.Payload) != `{"event":"test"}` || !d.Created.Equal(created) || d.Attempts != 4 || !strings.Contains(d.Reason, "500") {
+		t.Errorf("dead letter %+v", d)
+	}
+	if w := do(h, "GET", "/metrics"); !strings.Contains(w.Body.String(), "\nconvo_dead_letters 1\n") {
+		t.Errorf("/metrics does not report one dead letter")
+	}
+
+	if w := do(h, "POST", "/admin/dlq/replay?id=missing", asAdmin...); w.Code != http.StatusNotFound {
+		t.Errorf("replay of a missing letter: %d, want 404", w.Code)
+	}
+	target.broken.Store(false)
+	var res ReplayResult
+	if w := do(h, "POST", "/admin/dlq/replay?id="+d.ID, asAdmin...); json.Unmarshal(w.Body.Bytes(), &res) != nil || res.Replayed != 1 {
+		t.Fatalf("replay: %d %s", w.Code, w.Body)
+	}
+	target.mu.Lock()
+	r := target.accepted[0]
+	target.mu.Unlock()
+	if r.Header.Get(replayHeader) != "true" || r.Header.Get(originalTimeHeader) != created.Format(time.RFC3339Nano) {
+		t.Errorf("replay headers %v, want the replay marker and the original time", r.Header)
+	}
+	if n := cr.dlq.len(); n != 0 {
+		t.Errorf("%d dead letters after the replay, want 0", n)
+	}
+}
+
+// A bulk replay that fails puts the letters back with the new reason, and
+// the queue evicts the oldest letters when full.
+func TestDeadLetterBulkReplayAndEviction(t *testing.T) {
+	cr, h, clk, target, url := newDLQRoom(t)
+	target.broken.Store(true)
+	for i := 0; i < maxDeadLetters+1; i++ {
+		cr.dlq.add(DeadLetter{ID: cr.ids.NewID(), URL: url, Payload: json.RawMessage(`{}`), Created: clk.Now(), Attempts: 4})
+	}
+	letters := cr.dlq.list()
+	if len(letters) != maxDeadLetters {
+		t.Fatalf("%d dead letters, want the %d newest", len(letters), maxDeadLetters)
+	}
+
+	clk.Advance(time.Hour)
+	var res ReplayResult
+	if w := do(h, "POST", "/admin/dlq/replay", asAdmin...); json.Unmarshal(w.Body.Bytes(), &res) != nil || res.Replayed != 0 || len(res.Failed) != maxDeadLetters {
+		t.Fatalf("bulk replay against a broken target: %d %.200s", w.Code, w.Body)
+	}
+	after := cr.dlq.list()
+	if len(after) != maxDeadLetters || after[0].ID != letters[0].ID || after[0].Attempts != 5 || !after[0].Failed.Equal(clk.Now()) {
+		t.Errorf("first letter after the failed replay: %+v", after[0])
+	}
+
+	target.broken.Store(false)
+	if w := do(h, "POST", "/admin/dlq/replay", asAdmin...); json.Unmarshal(w.Body.Bytes(), &res) != nil || res.Replayed != maxDeadLetters || cr.dlq.len() != 0 {
+		t.Errorf("bulk replay: %d %.200s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	statusOnline = "online"
+	statusBusy   = "busy"
+)
+
+// SetDoNotDisturb turns do-not-disturb on for clientID, for d or until it is
+// turned off when d is zero. Broadcasts are delivered as usual; DND only
+// holds back direct messages that are not marked urgent. Expiry is silent:
+// the client simply shows as online again.
+func (cr *ChatRoom) SetDoNotDisturb(clientID string, d time.Duration) (time.Time, error) {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	c, exists := cr.clients[clientID]
+	if !exists {
+		return time.Time{}, errClientNotFound
+	}
+	c.dnd = true
+	c.dndUntil = time.Time{}
+	cr.rosterChangedLocked()
+	if d > 0 {
+		c.dndUntil = cr.clock.Now().Add(d)
+	}
+	return c.dndUntil, nil
+}
+
+func (cr *ChatRoom) ClearDoNotDisturb(clientID string) error {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	c, exists := cr.clients[clientID]
+	if !exists {
+		return errClientNotFound
+	}
+	c.dnd = false
+	cr.rosterChangedLocked()
+	return nil
+}
+
+func (cr *ChatRoom) DoNotDisturb(clientID string) bool {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	c, exists := cr.clients[clientID]
+	return exists && c.inDNDLocked(cr.clock.Now())
+}
+
+func (c *client) inDNDLocked(now time.Time) bool {
+	return c.dnd && (c.dndUntil.IsZero() || now.Before(c.dndUntil))
+}
+
+// HandleDoNotDisturb turns the caller's DND on with
+// POST /me/dnd[?duration=30m] and off with DELETE.
+func (cr *ChatRoom) HandleDoNotDisturb(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "POST, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID, ok := cr.sender(w, r)
+	if !ok {
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		var d time.Duration
+		if s := r.URL.Query().Get("duration"); s != "" {
+			var err error
+			if d, err = time.ParseDuration(s); err != nil || d <= 0 {
+				http.Error(w, "Invalid duration", http.StatusBadRequest)
+				return
+			}
+		}
+		until, err := cr.SetDoNotDisturb(clientID, d)
+		if err != nil {
+			http.Error(w, "Client not found", http.StatusNotFound)
+			return
+		}
+		if until.IsZero() {
+			fmt.Fprintf(w, "Do not disturb is on for %s", clientID)
+			return
+		}
+		fmt.Fprintf(w, "Do not disturb is on for %s until %s", clientID, until.Format(time.RFC3339))
+	case http.MethodDelete:
+		if err := cr.ClearDoNotDisturb(clientID); err != nil {
+			http.Error(w, "Client not found", http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, "Do not disturb is off for %s", clientID)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"chatroom/testutil"
+)
+
+// status returns id's status as /clients lists it.
+func status(t *testing.T, h http.Handler, id string) string {
+	t.Helper()
+	var list ClientList
+	if w := do(h, "GET", "/clients"); json.Unmarshal(w.Body.Bytes(), &list) != nil {
+		t.Fatalf("clients: %d %s", w.Code, w.Body)
+	}
+	for _, c := range list.Members {
+		if c.ID == id {
+			return c.Status
+		}
+	}
+	t.Fatalf("%s is not listed", id)
+	return ""
+}
+
+// DND shows the client as busy and holds back direct messages that are not
+// urgent, while broadcasts still arrive; it lapses silently.
+func TestDoNotDisturbHoldsBackDirectMessagesUntilItLapses(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	cr, h := newTestRoom(t, WithClock(clk))
+	ta := join(t, h, "alice")
+	tb := join(t, h, "bob")
+	if w := do(h, "POST", "/me/dnd?duration=30m", tokenHeader, ta); w.Code != http.StatusOK {
+		t.Fatalf("dnd: %d %s", w.Code, w.Body)
+	}
+	if got := status(t, h, "alice"); got != statusBusy {
+		t.Errorf("alice's status in DND: %q, want busy", got)
+	}
+	if w := do(h, "POST", "/admin/ephemeral?id=alice&message=psst", asAdmin...); w.Code != http.StatusConflict {
+		t.Errorf("direct message in DND: %d %s, want 409", w.Code, w.Body)
+	}
+	if w := do(h, "POST", "/admin/ephemeral?id=alice&message=fire&urgent=true", asAdmin...); w.Code != http.StatusOK {
+		t.Errorf("urgent direct message in DND: %d %s", w.Code, w.Body)
+	}
+	pollFrom(t, h, "alice", ta, systemSender)
+	send(h, tb, "for everyone")
+	if w := pollOnce(h, "alice", ta); w.Body.String() != "bob: for everyone\n" {
+		t.Errorf("broadcast in DND: %d %q", w.Code, w.Body)
+	}
+
+	last := cr.events.last()
+	clk.Advance(30 * time.Minute)
+	if got := status(t, h, "alice"); got != statusOnline {
+		t.Errorf("alice's status after DND lapsed: %q, want online", got)
+	}
+	if cr.events.last() != last {
+		t.Error("DND lapsing recorded an event")
+	}
+	if w := do(h, "POST", "/admin/ephemeral?id=alice&message=psst", asAdmin...); w.Code != http.StatusOK {
+		t.Errorf("direct message after DND lapsed: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestDoNotDisturbWithoutADurationLastsUntilTurnedOff(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	_, h := newTestRoom(t, WithClock(clk))
+	ta := join(t, h, "alice")
+	if w := do(h, "POST", "/me/dnd?duration=soon", tokenHeader, ta); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid duration: %d, want 400", w.Code)
+	}
+	do(h, "POST", "/me/dnd", tokenHeader, ta)
+	clk.Advance(24 * time.Hour)
+	if got := status(t, h, "alice"); got != statusBusy {
+		t.Errorf("alice's status a day into open-ended DND: %q, want busy", got)
+	}
+	if w := do(h, "DELETE", "/me/dnd", tokenHeader, ta); w.Code != http.StatusOK {
+		t.Fatalf("dnd off: %d %s", w.Code, w.Body)
+	}
+	if got := status(t, h, "alice"); got != statusOnline {
+		t.Errorf("alice's status after turning DND off: %q, want online", got)
+	}
+}
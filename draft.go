@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+)
+
+// maxDrafts bounds the drafts kept; saving past it evicts the stalest.
+const maxDrafts = 4096
+
+// Draft is a client's unsent message, kept so it can be picked up from
+// another device. The last write wins; Updated is the server time of that
+// write, so a device can tell its own save was overwritten.
+type Draft struct {
+	Text    string    `json:"text"`
+	Updated time.Time `json:"updated"`
+}
+
+// SaveDraft replaces clientID's draft. Drafts belong to the ID, not the
+// session, and outlive leaving.
+func (cr *ChatRoom) SaveDraft(clientID, text string) (Draft, error) {
+	if err := validateMessage(text); err != nil {
+		return Draft{}, err
+	}
+	d := Draft{Text: text, Updated: cr.clock.Now()}
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	if _, ok := cr.drafts[clientID]; !ok && len(cr.drafts) >= maxDrafts {
+		stalest := ""
+		for id, old := range cr.drafts {
+			if stalest == "" || old.Updated.Before(cr.drafts[stalest].Updated) {
+				stalest = id
+			}
+		}
+		delete(cr.drafts, stalest)
+	}
+	cr.drafts[clientID] = d
+	return d, nil
+}
+
+func (cr *ChatRoom) Draft(clientID string) (Draft, bool) {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	d, ok := cr.drafts[clientID]
+	return d, ok
+}
+
+func (cr *ChatRoom) ClearDraft(clientID string) {
+	cr.mutex.Lock()
+	delete(cr.drafts, clientID)
+	cr.mutex.Unlock()
+}
+
+// HandleDraft serves the caller's draft: GET /me/draft, PUT /me/draft with
+// the text as the request body, and DELETE /me/draft. Sending a message
+// clears it.
+func (cr *ChatRoom) HandleDraft(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodPut, http.MethodDelete:
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID, ok := cr.sender(w, r)
+	if !ok {
+		return
+	}
+	var d Draft
+	switch r.Method {
+	case http.MethodGet:
+		if d, ok = cr.Draft(clientID); !ok {
+			http.Error(w, "No draft", http.StatusNotFound)
+			return
+		}
+	case http.MethodPut:
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxMessageLength))
+		if err != nil {
+			http.Error(w, errMessageTooLong.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if d, err = cr.SaveDraft(clientID, string(body)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case http.MethodDelete:
+		cr.ClearDraft(clientID)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d)
+}
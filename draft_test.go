@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"chatroom/testutil"
+)
+
+func putDraft(h http.Handler, token, text string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("PUT", "/me/draft", strings.NewReader(text))
+	req.Header.Set(tokenHeader, token)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func getDraft(t *testing.T, h http.Handler, token string) (Draft, int) {
+	t.Helper()
+	var d Draft
+	w := do(h, "GET", "/me/draft", tokenHeader, token)
+	if w.Code == http.StatusOK && json.Unmarshal(w.Body.Bytes(), &d) != nil {
+		t.Fatalf("draft: %s", w.Body)
+	}
+	return d, w.Code
+}
+
+// The last save wins and carries the server time, a draft outlives leaving,
+// and sending a message clears it.
+func TestDraftsAreLastWriteWinsUntilSent(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	_, h := newTestRoom(t, WithClock(clk))
+	token := join(t, h, "alice")
+	if _, code := getDraft(t, h, token); code != http.StatusNotFound {
+		t.Errorf("draft before saving one: %d, want 404", code)
+	}
+	putDraft(h, token, "from the laptop")
+	saved := clk.Now()
+	clk.Advance(time.Second)
+	var d Draft
+	if w := putDraft(h, token, "from the phone"); w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &d) != nil || !d.Updated.Equal(saved.Add(time.Second)) {
+		t.Fatalf("second save: %d %s", w.Code, w.Body)
+	}
+	if got, _ := getDraft(t, h, token); got != d {
+		t.Errorf("draft %+v, want the last save %+v", got, d)
+	}
+	if w := putDraft(h, token, strings.Repeat("x", maxMessageLength+1)); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized draft: %d, want 413", w.Code)
+	}
+
+	do(h, "POST", "/leave", tokenHeader, token)
+	token = join(t, h, "alice")
+	if got, _ := getDraft(t, h, token); got.Text != "from the phone" {
+		t.Errorf("draft after rejoining: %+v", got)
+	}
+	send(h, token, "from the phone")
+	if _, code := getDraft(t, h, token); code != http.StatusNotFound {
+		t.Errorf("draft after sending: %d, want 404", code)
+	}
+
+	putDraft(h, token, "again")
+	if w := do(h, "DELETE", "/me/draft", tokenHeader, token); w.Code != http.StatusNoContent {
+		t.Errorf("clear draft: %d, want 204", w.Code)
+	}
+	if _, code := getDraft(t, h, token); code != http.StatusNotFound {
+		t.Errorf("draft after clearing: %d, want 404", code)
+	}
+}
+
+func TestDraftsEvictTheStalestWhenFull(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	cr, _ := newTestRoom(t, WithClock(clk))
+	for i := 0; i < maxDrafts; i++ {
+		cr.SaveDraft(fmt.Sprintf("user%d", i), "draft")
+		clk.Advance(time.Millisecond)
+	}
+	cr.SaveDraft("user0", "refreshed") // Now user1's is the stalest
+	cr.SaveDraft("newcomer", "draft")
+	if _, ok := cr.Draft("user1"); ok {
+		t.Error("the stalest draft was kept")
+	}
+	for _, id := range []string{"user0", "user2", "newcomer"} {
+		if _, ok := cr.Draft(id); !ok {
+			t.Errorf("%s's draft was evicted", id)
+		}
+	}
+}
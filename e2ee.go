@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	// kindE2EE marks a message whose body is base64 ciphertext the server
+	// cannot read. Its payload is client metadata, such as the algorithm
+	// and key IDs, passed through unread.
+	kindE2EE = "e2ee"
+	// maxCiphertextLength caps the decoded body of an E2EE message; its
+	// base64 encoding then fits maxMessageLength.
+	maxCiphertextLength = maxMessageLength / 4 * 3
+	maxPublicKeyLength  = 1024
+)
+
+var (
+	errE2EERequired   = errors.New("this room is end-to-end encrypted: send kind=e2ee with a base64 ciphertext body")
+	errE2EEOff        = errors.New("this room is not end-to-end encrypted: kind=e2ee is not accepted")
+	errBadCiphertext  = fmt.Errorf("an e2ee body must be standard base64 of at most %d bytes of ciphertext", maxCiphertextLength)
+	errBadPublicKey   = fmt.Errorf("key must be standard base64 of at most %d bytes", maxPublicKeyLength)
+	errE2EENotEnabled = errors.New("end-to-end encryption is off; start the server with -e2ee")
+)
+
+// WithE2EE makes the room end-to-end encrypted. Every chat message must then
+// be kind=e2ee, and the server never sees plaintext. It still orders
+// messages, keeps their ciphertext in history and tracks membership, and
+// /keys lets members find each other's public keys. Everything that reads
+// bodies skips these messages: redaction, the spam filter, commands,
+// entities, link previews, translation and keyword watches. Summaries only
+// see ciphertext.
+func WithE2EE(on bool) Option {
+	return func(cr *ChatRoom) {
+		cr.e2ee = on
+	}
+}
+
+// opaque reports whether the server cannot read m's body.
+func (m Message) opaque() bool {
+	return m.Kind == kindE2EE
+}
+
+// checkE2EE keeps plaintext out of an E2EE room and ciphertext out of any
+// other, so clients cannot mistake which mode applies.
+func (cr *ChatRoom) checkE2EE(kind, body string) error {
+	switch {
+	case cr.e2ee && kind != kindE2EE:
+		return errE2EERequired
+	case !cr.e2ee && kind == kindE2EE:
+		return errE2EEOff
+	case kind != kindE2EE:
+		return nil
+	}
+	if b, err := base64.StdEncoding.DecodeString(body); err != nil || len(b) > maxCiphertextLength {
+		return errBadCiphertext
+	}
+	return nil
+}
+
+// validateE2EE accepts any payload object: it is the clients' metadata. The
+// ciphertext body is checked by checkE2EE.
+func validateE2EE(json.RawMessage) error {
+	return nil
+}
+
+// PublicKey is a key a client published for others to encrypt to.
+type PublicKey struct {
+	ID      string    `json:"id"`
+	Key     string    `json:"key"` // Base64, in whatever format the clients agree on
+	Updated time.Time `json:"updated"`
+}
+
+// PublishKey sets clientID's public key, replacing any earlier one. It
+// outlives the session, so a client that joins again keeps its key.
+func (cr *ChatRoom) PublishKey(clientID, key string) (PublicKey, error) {
+	if !cr.e2ee {
+		return PublicKey{}, errE2EENotEnabled
+	}
+	if b, err := base64.StdEncoding.DecodeString(key); err != nil || len(b) == 0 || len(b) > maxPublicKeyLength {
+		return PublicKey{}, errBadPublicKey
+	}
+	k := PublicKey{ID: clientID, Key: key, Updated: cr.clock.Now()}
+	cr.mutex.Lock()
+	cr.publicKeys[clientID] = k
+	cr.mutex.Unlock()
+	return k, nil
+}
+
+// RosterKeys lists the public keys of joined clients, by ID. Invisible
+// clients are left out, as in /clients, except for caller itself.
+func (cr *ChatRoom) RosterKeys(caller string) []PublicKey {
+	keys := []PublicKey{}
+	cr.mutex.Lock()
+	for id, c := range cr.clients {
+		k, ok := cr.publicKeys[id]
+		if ok && !c.pending && (!c.invisible || id == caller) {
+			keys = append(keys, k)
+		}
+	}
+	cr.mutex.Unlock()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys
+}
+
+// HandleKeys serves GET /keys, the public keys of the room's members, for
+// joined clients and admins.
+func (cr *ChatRoom) HandleKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !cr.e2ee {
+		http.Error(w, errE2EENotEnabled.Error(), http.StatusNotFound)
+		return
+	}
+	caller, _, _ := cr.senderSession(r)
+	if !cr.isAdmin(r) && !cr.joinedCaller(r) {
+		http.Error(w, "Reading keys requires the "+tokenHeader+" header of a joined client", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cr.RosterKeys(caller))
+}
+
+// HandleMyKey serves the caller's public key: GET /me/key, and PUT /me/key
+// with {"key": "<base64>"}.
+func (cr *ChatRoom) HandleMyKey(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodPut:
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !cr.e2ee {
+		http.Error(w, errE2EENotEnabled.Error(), http.StatusNotFound)
+		return
+	}
+	clientID, ok := cr.sender(w, r)
+	if !ok {
+		return
+	}
+	var k PublicKey
+	if r.Method == http.MethodPut {
+		var req struct {
+			Key string `json:"key"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 2*maxPublicKeyLength)).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		var err error
+		if k, err = cr.PublishKey(clientID, req.Key); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		cr.mutex.Lock()
+		k, ok = cr.publicKeys[clientID]
+		cr.mutex.Unlock()
+		if !ok {
+			http.Error(w, "No key published", http.StatusNotFound)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(k)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"chatroom/testutil"
+)
+
+func putKey(h http.Handler, token, key string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("PUT", "/me/key", strings.NewReader(`{"key": "`+key+`"}`))
+	req.Header.Set(tokenHeader, token)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func sendCiphertext(h http.Handler, token, body string) *httptest.ResponseRecorder {
+	q := url.Values{"message": {body}, "kind": {kindE2EE}, "payload": {`{"alg": "x25519"}`}}
+	return do(h, "POST", "/send?"+q.Encode(), tokenHeader, token)
+}
+
+// An E2EE room takes only ciphertext, and delivers it untouched by the
+// filters that read bodies.
+func TestE2EERoomsPassCiphertextThrough(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	_, h := newTestRoom(t, WithClock(clk), WithE2EE(true), WithRedaction(nil))
+	ta, tb := join(t, h, "alice"), join(t, h, "bob")
+	if w := send(h, ta, "plaintext"); w.Code != http.StatusBadRequest {
+		t.Errorf("plaintext send: %d, want 400", w.Code)
+	}
+	tooLong := base64.StdEncoding.EncodeToString(make([]byte, maxCiphertextLength+1))
+	if w := sendCiphertext(h, ta, "not base64!"); w.Code != http.StatusBadRequest {
+		t.Errorf("send text as ciphertext: %d, want 400", w.Code)
+	}
+	if w := sendCiphertext(h, ta, tooLong); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("send oversized ciphertext: %d, want 413", w.Code)
+	}
+
+	// Valid base64 that, were it text, would be redacted as an AWS key.
+	ciphertext := "AKIA" + strings.Repeat("Q", 16)
+	if w := sendCiphertext(h, ta, ciphertext); w.Code != http.StatusOK {
+		t.Fatalf("send ciphertext: %d %s", w.Code, w.Body)
+	}
+	var m Message
+	json.Unmarshal(pollFrom(t, h, "bob", tb, "alice"), &m)
+	if m.Kind != kindE2EE || m.Body != ciphertext || m.Entities != nil || string(m.Payload) != `{"alg":"x25519"}` {
+		t.Errorf("bob received kind %q body %q entities %v payload %s, want the ciphertext untouched", m.Kind, m.Body, m.Entities, m.Payload)
+	}
+}
+
+func TestCiphertextIsRefusedInOtherRooms(t *testing.T) {
+	_, h := newTestRoom(t)
+	token := join(t, h, "alice")
+	if w := sendCiphertext(h, token, "AAAA"); w.Code != http.StatusBadRequest {
+		t.Errorf("ciphertext send: %d, want 400", w.Code)
+	}
+	for _, path := range []string{"/keys", "/me/key"} {
+		if w := do(h, "GET", path, tokenHeader, token); w.Code != http.StatusNotFound {
+			t.Errorf("%s: %d, want 404", path, w.Code)
+		}
+	}
+}
+
+// Members publish public keys and fetch the roster's; a key outlives its
+// session.
+func TestPublicKeys(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	_, h := newTestRoom(t, WithClock(clk), WithE2EE(true))
+	ta, tb := join(t, h, "alice"), join(t, h, "bob")
+	if w := do(h, "GET", "/me/key", tokenHeader, ta); w.Code != http.StatusNotFound {
+		t.Errorf("key before publishing: %d, want 404", w.Code)
+	}
+	for _, key := range []string{"", "not base64!", base64.StdEncoding.EncodeToString(make([]byte, maxPublicKeyLength+1))} {
+		if w := putKey(h, ta, key); w.Code != http.StatusBadRequest {
+			t.Errorf("publish %.20q: %d, want 400", key, w.Code)
+		}
+	}
+	aliceKey, bobKey := base64.StdEncoding.EncodeToString([]byte("alice public")), base64.StdEncoding.EncodeToString([]byte("bob public"))
+	putKey(h, ta, aliceKey)
+	clk.Advance(time.Second)
+	var k PublicKey
+	if w := putKey(h, tb, bobKey); json.Unmarshal(w.Body.Bytes(), &k) != nil || k.ID != "bob" || k.Key != bobKey || !k.Updated.Equal(clk.Now()) {
+		t.Errorf("publish bob's key: %d %s", w.Code, w.Body)
+	}
+
+	var keys []PublicKey
+	json.Unmarshal(do(h, "GET", "/keys", tokenHeader, tb).Body.Bytes(), &keys)
+	if len(keys) != 2 || keys[0].ID != "alice" || keys[0].Key != aliceKey || keys[1].ID != "bob" {
+		t.Errorf("keys %+v, want alice's and bob's", keys)
+	}
+	if w := do(h, "GET", "/keys"); w.Code != http.StatusForbidden {
+		t.Errorf("keys without a token: %d, want 403", w.Code)
+	}
+
+	do(h, "POST", "/leave", tokenHeader, ta)
+	keys = nil
+	json.Unmarshal(do(h, "GET", "/keys", tokenHeader, tb).Body.Bytes(), &keys)
+	if len(keys) != 1 || keys[0].ID != "bob" {
+		t.Errorf("keys %+v after alice left, want bob's only", keys)
+	}
+	ta = join(t, h, "alice")
+	if w := do(h, "GET", "/me/key", tokenHeader, ta); !strings.Contains(w.Body.String(), aliceKey) {
+		t.Errorf("alice's key after rejoining: %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"chatroom/clock"
+)
+
+const maxEmbargo = 24 * time.Hour
+
+var errInvalidEmbargo = errors.New("embargo_until must be a future RFC 3339 time or Unix milliseconds at most 24h ahead")
+
+// Embargo is a message held back until its release time.
+type Embargo struct {
+	Message Message   `json:"message"`
+	Until   time.Time `json:"until"`
+}
+
+type embargo struct {
+	Embargo
+	timer clock.Timer
+}
+
+// parseEmbargo accepts RFC 3339 (with sub-second precision) or Unix
+// milliseconds.
+func parseEmbargo(s string, now time.Time) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		ms, perr := strconv.ParseInt(s, 10, 64)
+		if perr != nil {
+			return time.Time{}, errInvalidEmbargo
+		}
+		t = time.UnixMilli(ms)
+	}
+	if !t.After(now) || t.Sub(now) > maxEmbargo {
+		return time.Time{}, errInvalidEmbargo
+	}
+	return t, nil
+}
+
+// Embargo holds msg until the given time and then publishes it with that
+// time as its timestamp, so every client receives it together no matter
+// when it was submitted. It returns the message ID, which cancels it. The
+// message is numbered in its sender's Seq when it is released, after
+// whatever the sender had sent by then.
+func (cr *ChatRoom) Embargo(msg Message, until time.Time) (string, error) {
+	if err := validateMessage(msg.Body); err != nil {
+		return "", err
+	}
+	msg.ID = cr.ids.NewID()
+	msg.Time = until
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	if cr.closed {
+		return "", errRoomClosed
+	}
+	e := &embargo{Embargo: Embargo{Message: msg, Until: until}}
+	e.timer = cr.clock.AfterFunc(until.Sub(cr.clock.Now()), func() { cr.release(msg.ID) })
+	cr.embargoes[msg.ID] = e
+	return msg.ID, nil
+}
+
+// release publishes an embargoed message through its sender's sequencer, or
+// without a Seq once the sender has left.
+func (cr *ChatRoom) release(id string) {
+	cr.mutex.Lock()
+	e, ok := cr.embargoes[id]
+	if !ok {
+		cr.mutex.Unlock()
+		return
+	}
+	delete(cr.embargoes, id)
+	sender := cr.clients[e.Message.From]
+	cr.mutex.Unlock()
+	msg := e.Message
+	var err error
+	if sender != nil {
+		err = cr.publishFrom(sender, &msg)
+	} else {
+		err = cr.Publish(msg)
+	}
+	if err != nil && err != errRoomClosed && err != errShuttingDown {
+		log.Printf("embargoed message %s not released: %v", id, err)
+	}
+}
+
+// CancelEmbargo drops an embargoed message before its release. Only its
+// sender, or an admin, may cancel it.
+func (cr *ChatRoom) CancelEmbargo(id, sender string, admin bool) bool {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	e, ok := cr.embargoes[id]
+	if !ok || !admin && e.Message.From != sender {
+		return false
+	}
+	e.timer.Stop()
+	delete(cr.embargoes, id)
+	return true
+}
+
+// Embargoes lists held messages, soonest first: all of them for admins,
+// otherwise only sender's own.
+func (cr *ChatRoom) Embargoes(sender string, admin bool) []Embargo {
+	list := []Embargo{}
+	cr.mutex.Lock()
+	for _, e := range cr.embargoes {
+		if admin || e.Message.From == sender {
+			list = append(list, e.Embargo)
+		}
+	}
+	cr.mutex.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Until.Before(list[j].Until) })
+	return list
+}
+
+// HandleEmbargoes lists the sender's held messages with GET /embargoes and
+// cancels one with DELETE /embargoes?message=<message ID>, both with the
+// sender's token. Admins list and cancel everyone's without one.
+func (cr *ChatRoom) HandleEmbargoes(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodDelete:
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	admin := cr.isAdmin(r)
+	clientID := r.URL.Query().Get("id")
+	if !admin {
+		var ok bool
+		if clientID, ok = cr.sender(w, r); !ok {
+			return
+		}
+	}
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(cr.Embargoes(clientID, admin))
+	case http.MethodDelete:
+		id := r.URL.Query().Get("message")
+		if id == "" {
+			http.Error(w, "Message ID is required", http.StatusBadRequest)
+			return
+		}
+		if !cr.CancelEmbargo(id, clientID, admin) {
+			http.Error(w, "No embargoed message "+id+" from "+clientID, http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, "Embargoed message %s canceled", id)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"chatroom/testutil"
+)
+
+// embargoSend holds message from token's sender for an hour and returns
+// its ID.
+func embargoSend(t *testing.T, h http.Handler, token, message string) string {
+	t.Helper()
+	until := url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339))
+	w := do(h, "POST", "/send?format=json&embargo_until="+until+"&message="+url.QueryEscape(message), tokenHeader, token)
+	var res SendResult
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &res) != nil {
+		t.Fatalf("embargoed send: %d %s", w.Code, w.Body)
+	}
+	return res.Message.ID
+}
+
+func listEmbargoes(t *testing.T, h http.Handler, headers ...string) []Embargo {
+	t.Helper()
+	w := do(h, "GET", "/embargoes", headers...)
+	var list []Embargo
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &list) != nil {
+		t.Fatalf("list embargoes: %d %s", w.Code, w.Body)
+	}
+	return list
+}
+
+func TestEmbargoesRequireTheSendersToken(t *testing.T) {
+	_, h := newTestRoom(t)
+	ta := join(t, h, "alice")
+	tb := join(t, h, "bob")
+	id := embargoSend(t, h, ta, "later")
+
+	if w := do(h, "GET", "/embargoes?id=alice"); w.Code != http.StatusUnauthorized {
+		t.Errorf("list without a token: %d %s", w.Code, w.Body)
+	}
+	if w := do(h, "GET", "/embargoes?id=alice", tokenHeader, tb); w.Code != http.StatusForbidden {
+		t.Errorf("list alice's with bob's token: %d %s", w.Code, w.Body)
+	}
+	if list := listEmbargoes(t, h, tokenHeader, tb); len(list) != 0 {
+		t.Errorf("bob sees %d embargoes", len(list))
+	}
+	if list := listEmbargoes(t, h, tokenHeader, ta); len(list) != 1 || list[0].Message.ID != id {
+		t.Errorf("alice sees %+v", list)
+	}
+	if list := listEmbargoes(t, h, asAdmin...); len(list) != 1 {
+		t.Errorf("admin sees %d embargoes, want 1", len(list))
+	}
+
+	cancel := "/embargoes?message=" + id
+	if w := do(h, "DELETE", cancel+"&id=alice"); w.Code != http.StatusUnauthorized {
+		t.Errorf("cancel without a token: %d %s", w.Code, w.Body)
+	}
+	if w := do(h, "DELETE", cancel, tokenHeader, tb); w.Code != http.StatusNotFound {
+		t.Errorf("cancel with bob's token: %d %s", w.Code, w.Body)
+	}
+	if w := do(h, "DELETE", cancel, tokenHeader, ta); w.Code != http.StatusOK {
+		t.Errorf("cancel with alice's token: %d %s", w.Code, w.Body)
+	}
+
+	id = embargoSend(t, h, ta, "later again")
+	if w := do(h, "DELETE", "/embargoes?message="+id, asAdmin...); w.Code != http.StatusOK {
+		t.Errorf("admin cancel: %d %s", w.Code, w.Body)
+	}
+}
+
+// Released embargoes are numbered in their sender's Seq, so receivers see
+// every sender's messages gap-free and in order, however they interleave.
+func TestEmbargoReleaseKeepsSeqOrder(t *testing.T) {
+	const senders, perSender = 50, 20
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	cr, h := newTestRoom(t, WithClock(clk), WithSessionQueue(2*senders*perSender))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watch, err := cr.Subscribe(ctx, "watcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := make([]string, senders)
+	for i := range tokens {
+		tokens[i] = join(t, h, fmt.Sprintf("user%d", i))
+	}
+	var bad errorsOf
+	var wg sync.WaitGroup
+	for i, token := range tokens {
+		wg.Add(1)
+		go func(i int, token string) {
+			defer wg.Done()
+			for j := 0; j < perSender; j++ {
+				target := "/send?message=" + fmt.Sprint(j)
+				if j%4 == 0 {
+					until := clk.Now().Add(time.Duration(200+i%5*50) * time.Millisecond).UnixMilli()
+					target += "&embargo_until=" + fmt.Sprint(until)
+				}
+				if w := do(h, "POST", target, tokenHeader, token); w.Code != http.StatusOK {
+					bad.add("send %d from user%d: %d %s", j, i, w.Code, w.Body)
+				}
+			}
+		}(i, token)
+	}
+	wg.Wait()
+	bad.report(t)
+	if t.Failed() {
+		return
+	}
+	// Every embargo falls due at once, racing the sends already queued.
+	clk.Advance(time.Second)
+
+	last := make(map[string]uint64)
+	timeout := time.After(10 * time.Second)
+	for got := 0; got < senders*perSender; {
+		select {
+		case m := <-watch:
+			if m.From == systemSender || m.Type != "" {
+				continue
+			}
+			if m.Seq != last[m.From]+1 {
+				t.Fatalf("%s: Seq %d after %d", m.From, m.Seq, last[m.From])
+			}
+			last[m.From] = m.Seq
+			got++
+		case <-timeout:
+			t.Fatalf("timed out with %d embargoes still held", len(cr.Embargoes("", true)))
+		}
+	}
+}
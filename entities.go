@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Entity types.
+const (
+	entityMention = "mention"
+	entityURL     = "url"
+	entityCode    = "code"
+)
+
+// Entity marks a span of a message body for clients to render. Offset and
+// Length count Unicode code points, not bytes, so clients in any language
+// can slice the body with them.
+type Entity struct {
+	Type     string `json:"type"`
+	Offset   int    `json:"offset"`
+	Length   int    `json:"length"`
+	ClientID string `json:"client_id,omitempty"` // Mentioned client
+	URL      string `json:"url,omitempty"`       // Normalized URL
+	Token    string `json:"token,omitempty"`     // Highlight class of a token: keyword, string, comment or number
+}
+
+var (
+	codeSpanPattern = regexp.MustCompile("`[^`\n]+`")
+	mentionPattern  = regexp.MustCompile(`@[A-Za-z0-9._@-]+`)
+)
+
+// entities parses body once, at send time. Code spans are found first and
+// nothing inside one is a mention or URL. Mentions of clients that are not
+// registered are left as text.
+func (cr *ChatRoom) entities(body string) []Entity {
+	// Most messages have none; skip the patterns and the lock for them.
+	if !strings.ContainsAny(body, "@`:") {
+		return nil
+	}
+	var spans [][2]int // Byte ranges, for building offsets at the end
+	var found []Entity
+	add := func(start, end int, e Entity) {
+		spans = append(spans, [2]int{start, end})
+		found = append(found, e)
+	}
+	code := codeSpanPattern.FindAllStringIndex(body, -1)
+	for _, loc := range code {
+		add(loc[0], loc[1], Entity{Type: entityCode})
+	}
+	inCode := func(i int) bool {
+		for _, loc := range code {
+			if i >= loc[0] && i < loc[1] {
+				return true
+			}
+		}
+		return false
+	}
+	for _, loc := range urlPattern.FindAllStringIndex(body, -1) {
+		raw := strings.TrimRight(body[loc[0]:loc[1]], ".,;:!?)]}'")
+		u, err := url.Parse(raw)
+		if inCode(loc[0]) || err != nil || u.Host == "" {
+			continue
+		}
+		u.Scheme, u.Host = strings.ToLower(u.Scheme), strings.ToLower(u.Host)
+		add(loc[0], loc[0]+len(raw), Entity{Type: entityURL, URL: u.String()})
+	}
+	cr.mutex.Lock()
+	for _, loc := range mentionPattern.FindAllStringIndex(body, -1) {
+		// An @ inside a word, such as an email address, is not a mention.
+		if inCode(loc[0]) || loc[0] > 0 && validClientID(body[loc[0]-1:loc[0]]) {
+			continue
+		}
+		id := strings.TrimRight(body[loc[0]+1:loc[1]], ".")
+		// Marking a mention of an invisible client would give away that
+		// it is here.
+		if c, ok := cr.clients[id]; ok && !c.invisible {
+			add(loc[0], loc[0]+1+len(id), Entity{Type: entityMention, ClientID: id})
+		}
+	}
+	cr.mutex.Unlock()
+	for i, s := range spans {
+		found[i].Offset = utf8.RuneCountInString(body[:s[0]])
+		found[i].Length = utf8.RuneCountInString(body[s[0]:s[1]])
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].Offset < found[j].Offset })
+	return found
+}
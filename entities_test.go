@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"chatroom/testutil"
+)
+
+func TestEntitiesOffsetsCountCodePoints(t *testing.T) {
+	cr, h := newTestRoom(t)
+	join(t, h, "bob")
+	body := "héllo 👋 @bob, see https://Example.COM/Path. `@bob https://x.io` @nobody me@bob"
+	got := cr.entities(body)
+	want := []Entity{
+		{Type: entityMention, Offset: 8, Length: 4, ClientID: "bob"},
+		{Type: entityURL, Offset: 18, Length: 24, URL: "https://example.com/Path"},
+		{Type: entityCode, Offset: 44, Length: 19},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("entities %+v, want %+v", got, want)
+	}
+	runes := []rune(body)
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("entity %d: %+v, want %+v (%q)", i, got[i], want[i], string(runes[got[i].Offset:got[i].Offset+got[i].Length]))
+		}
+	}
+	if got := cr.entities("nothing to see here"); got != nil {
+		t.Errorf("entities of plain text: %+v", got)
+	}
+}
+
+// Entities reach sessions that declared them, on the message as sent.
+func TestEntitiesAreDeliveredToDeclaringSessions(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	_, h := newTestRoom(t, WithClock(clk))
+	ta := join(t, h, "alice")
+	w := do(h, "POST", "/join?id=bob&capabilities=entities")
+	if w.Code != http.StatusOK {
+		t.Fatalf("join: %d %s", w.Code, w.Body)
+	}
+	tb := w.Header().Get(tokenHeader)
+	send(h, ta, "ping @bob")
+	var m Message
+	if err := json.Unmarshal(pollFrom(t, h, "bob", tb, "alice"), &m); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Entities) != 1 || m.Entities[0] != (Entity{Type: entityMention, Offset: 5, Length: 4, ClientID: "bob"}) {
+		t.Errorf("bob received entities %+v, want the mention", m.Entities)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+const messageTypeEphemeral = "ephemeral"
+
+var errNotDelivered = errors.New("recipient's delivery queue is full")
+
+// SendEphemeral delivers msg to clientID alone. It bypasses the broadcast
+// queue, so it is never indexed, counted as activity or previewed. The
+// message always has type "ephemeral" and, unless set, comes from the
+// system sender. It is queued for each of the recipient's sessions that has
+// room; unlike a broadcast it never evicts a delivery already waiting, and
+// it fails with errNotDelivered when every session's queue is full.
+func (cr *ChatRoom) SendEphemeral(clientID string, msg Message) error {
+	if err := validateMessage(msg.Body); err != nil {
+		return err
+	}
+	msg.Type = messageTypeEphemeral
+	if msg.From == "" {
+		msg.From = systemSender
+	}
+	if msg.ID == "" {
+		msg.ID = cr.ids.NewID()
+	}
+	if msg.Time.IsZero() {
+		msg.Time = cr.clock.Now()
+	}
+	msg.line = formatLine(msg)
+
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	c, ok := cr.clients[clientID]
+	if !ok {
+		if _, ok := cr.departing[clientID]; ok {
+			return errClientDeparting
+		}
+		return errClientNotFound
+	}
+	delivered := cr.offerLocked(c, msg)
+	for _, s := range c.extra {
+		delivered = cr.offerLocked(s, msg) || delivered
+	}
+	if !delivered {
+		return errNotDelivered
+	}
+	return nil
+}
+
+// HandleEphemeral lets admin-authenticated bots send an ephemeral message:
+// POST /admin/ephemeral?id=<recipient>&message=<text>. Recipients in
+// do-not-disturb are skipped unless urgent=true.
+func (cr *ChatRoom) HandleEphemeral(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID := r.URL.Query().Get("id")
+	message := r.URL.Query().Get("message")
+	if clientID == "" || message == "" {
+		http.Error(w, "Client ID and message are required", http.StatusBadRequest)
+		return
+	}
+	if r.URL.Query().Get("urgent") != "true" && cr.DoNotDisturb(clientID) {
+		http.Error(w, clientID+" is in do-not-disturb mode; pass urgent=true to deliver anyway", http.StatusConflict)
+		return
+	}
+	if err := cr.SendEphemeral(clientID, Message{Body: message}); err != nil {
+		writeEphemeralError(w, err)
+		return
+	}
+	fmt.Fprintf(w, "Ephemeral message delivered to %s", clientID)
+}
+
+// writeEphemeralError answers a request whose ephemeral message could not
+// be delivered.
+func writeEphemeralError(w http.ResponseWriter, err error) {
+	switch err {
+	case errClientNotFound:
+		http.Error(w, "Client not found", http.StatusNotFound)
+	case errClientDeparting:
+		http.Error(w, "Client is leaving", http.StatusGone)
+	case errNotDelivered:
+		w.Header().Set("Retry-After", retryAfterSeconds)
+		http.Error(w, "Client has too many undelivered messages", http.StatusServiceUnavailable)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestEphemeralIsQueuedForThePoller(t *testing.T) {
+	cr, h := newTestRoom(t)
+	tb := join(t, h, "bob")
+	// Sent while bob is between polls: it waits for him.
+	if err := cr.SendEphemeral("bob", Message{Body: "psst"}); err != nil {
+		t.Fatalf("SendEphemeral: %v", err)
+	}
+	if m := pollJSON(t, h, "bob", tb); m.Type != messageTypeEphemeral || m.Body != "psst" {
+		t.Errorf("got %+v", m)
+	}
+}
+
+// An ephemeral never evicts a waiting delivery; it fails instead, and is
+// accepted again once the client has caught up.
+func TestEphemeralRespectsTheSessionQueueLimit(t *testing.T) {
+	cr, h := newTestRoom(t, WithSessionQueue(2))
+	ta := join(t, h, "alice")
+	tb := join(t, h, "bob")
+	send(h, tb, "one")
+	send(h, tb, "two")
+	eventually(t, "alice's queue to fill", func() bool {
+		cr.mutex.Lock()
+		defer cr.mutex.Unlock()
+		return cr.clients["alice"].queue.len() == 2
+	})
+	if err := cr.SendEphemeral("alice", Message{Body: "psst"}); err != errNotDelivered {
+		t.Fatalf("SendEphemeral to a full queue: %v, want errNotDelivered", err)
+	}
+	if w := do(h, "POST", "/admin/ephemeral?id=alice&message=psst", asAdmin...); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("/admin/ephemeral to a full queue: %d %s", w.Code, w.Body)
+	}
+	if w := send(h, ta, "/whois bob"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("/whois with a full queue: %d %s", w.Code, w.Body)
+	}
+	for _, want := range []string{"one", "two"} {
+		if m := pollJSON(t, h, "alice", ta); m.Body != want {
+			t.Fatalf("got %+v, want %q", m, want)
+		}
+	}
+	if w := send(h, ta, "/whois bob"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "bob is online") {
+		t.Errorf("/whois once caught up: %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// joinSeqHeader carries the event seq a /join registered at. Events up
+	// to it are history; the new session is delivered the messages after.
+	joinSeqHeader = "X-Convo-Join-Seq"
+
+	maxEvents          = 4096
+	defaultEventsLimit = 100
+	maxEventsLimit     = 1000
+
+	eventMessage = "message" // A chat message; events use the message's Type instead
+	eventJoin    = "join"
+	eventLeave   = "leave"
+)
+
+var errCursorExpired = errors.New("cursor is older than the retained events")
+
+// Event is one change to the room in /events. Seq numbers every event the
+// room has recorded, whatever its type, so a client that remembers the last
+// Seq it saw can pick up exactly where it left off.
+type Event struct {
+	Seq      uint64    `json:"seq"`
+	Type     string    `json:"type"` // message, join, leave, or the type of an event message such as preview or poll_tally
+	Time     time.Time `json:"time"`
+	Message  *Message  `json:"message,omitempty"`   // Set for message events
+	ClientID string    `json:"client_id,omitempty"` // Who joined or left
+
+	hidden bool // The client was invisible; left out for non-admins
+}
+
+// EventPage is the body of /events.
+type EventPage struct {
+	Events []Event `json:"events"`
+	Cursor uint64  `json:"cursor"` // Pass as cursor= to get the events after these
+}
+
+// eventLog keeps the last maxEvents events. Every append happens under
+// ChatRoom.mutex: messages by the broadcast loop just before it delivers
+// them, and joins and leaves as the registry changes. Event order is
+// therefore the order clients saw things happen, and /events and /messages
+// can never disagree.
+type eventLog struct {
+	mu   sync.Mutex
+	ring [maxEvents]Event
+	seq  uint64 // Seq of the last event appended
+}
+
+func (l *eventLog) append(e Event) uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seq++
+	e.Seq = l.seq
+	if e.Message != nil {
+		e.Message.EventSeq = e.Seq
+	}
+	l.ring[l.seq%maxEvents] = e
+	return e.Seq
+}
+
+// last returns the seq of the latest event.
+func (l *eventLog) last() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.seq
+}
+
+// after returns up to limit events with Seq greater than cursor, keeping
+// those whose type is in types when types is non-empty. It reports
+// errCursorExpired when events after cursor have already been dropped.
+func (l *eventLog) after(cursor uint64, types map[string]bool, admin bool, limit int) (EventPage, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	page := EventPage{Events: []Event{}, Cursor: cursor}
+	if cursor > l.seq {
+		page.Cursor = l.seq
+		return page, nil
+	}
+	if l.seq > maxEvents && cursor < l.seq-maxEvents {
+		return page, errCursorExpired
+	}
+	for seq := cursor + 1; seq <= l.seq && len(page.Events) < limit; seq++ {
+		e := l.ring[seq%maxEvents]
+		page.Cursor = seq
+		if e.hidden && !admin || len(types) > 0 && !types[e.Type] {
+			continue
+		}
+		page.Events = append(page.Events, e)
+	}
+	return page, nil
+}
+
+// recordMessageLocked appends a broadcast message to the event log and
+// returns its seq.
+func (cr *ChatRoom) recordMessageLocked(msg Message) uint64 {
+	typ := msg.Type
+	if typ == "" {
+		typ = eventMessage
+	}
+	msg.envelope = nil
+	return cr.events.append(Event{Type: typ, Time: msg.Time, Message: &msg})
+}
+
+// recordPresenceLocked appends a join or leave of c.
+func (cr *ChatRoom) recordPresenceLocked(typ, clientID string, c *client) {
+	cr.events.append(Event{Type: typ, Time: cr.clock.Now(), ClientID: clientID, hidden: c.invisible})
+}
+
+// joinedCaller reports whether r carries the send token of a joined,
+// approved client.
+func (cr *ChatRoom) joinedCaller(r *http.Request) bool {
+	_, c, err := cr.senderSession(r)
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	return err == nil && !c.pending
+}
+
+// HandleEvents serves GET /events?cursor=<seq>: the room's events after
+// cursor, oldest first, for joined clients and admins. cursor defaults to 0
+// (everything retained); types=message,join narrows the types returned and
+// limit caps the page. A cursor whose events have been dropped gets 410.
+func (cr *ChatRoom) HandleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	admin := cr.isAdmin(r)
+	if !admin && !cr.joinedCaller(r) {
+		http.Error(w, "Reading events requires the "+tokenHeader+" header of a joined client", http.StatusForbidden)
+		return
+	}
+	q := r.URL.Query()
+	var cursor uint64
+	if s := q.Get("cursor"); s != "" {
+		var err error
+		if cursor, err = strconv.ParseUint(s, 10, 64); err != nil {
+			http.Error(w, "cursor must be the seq of an event", http.StatusBadRequest)
+			return
+		}
+	}
+	limit := defaultEventsLimit
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxEventsLimit)
+	}
+	var types map[string]bool
+	if s := q.Get("types"); s != "" {
+		types = make(map[string]bool)
+		for _, t := range strings.Split(s, ",") {
+			types[strings.TrimSpace(t)] = true
+		}
+	}
+	page, err := cr.events.after(cursor, types, admin, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// drainBurst empties c's queue until the message with body end arrives,
+// returning the event seqs of the messages from sender before it.
+func drainBurst(t *testing.T, cr *ChatRoom, c *client, sender, end string) map[uint64]bool {
+	t.Helper()
+	got := make(map[uint64]bool)
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		cr.mutex.Lock()
+		for {
+			m, ok := c.queue.pop()
+			if !ok {
+				break
+			}
+			if m.From != sender {
+				continue
+			}
+			if m.Body == end {
+				cr.mutex.Unlock()
+				return got
+			}
+			if got[m.EventSeq] {
+				t.Errorf("event seq %d delivered twice", m.EventSeq)
+			}
+			got[m.EventSeq] = true
+		}
+		cr.mutex.Unlock()
+		if time.Now().After(deadline) {
+			t.Fatalf("the end of the burst never arrived; %d messages so far", len(got))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Clients joining while a burst is being broadcast get exactly the burst
+// messages numbered above their X-Convo-Join-Seq: nothing from before the
+// join, and nothing straddling it lost.
+func TestJoinsDuringABurstGetExactlyWhatFollowsTheirJoinSeq(t *testing.T) {
+	const burst, joiners = 10000, 100
+	cr, h := newTestRoom(t, WithSessionQueue(2*burst), WithSendTimeout(time.Minute))
+	join(t, h, "witness")
+
+	var published atomic.Int64
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < burst; i++ {
+			if err := cr.Publish(Message{From: "burst", Body: strconv.Itoa(i)}); err != nil {
+				done <- err
+				return
+			}
+			published.Add(1)
+		}
+		done <- nil
+	}()
+
+	joinSeqs := make(map[string]uint64, joiners)
+	for i := 0; i < joiners; i++ {
+		// Spread the joins over the burst.
+		for published.Load() < int64(i*burst/joiners) {
+			time.Sleep(50 * time.Microsecond)
+		}
+		id := fmt.Sprintf("joiner%03d", i)
+		w := do(h, "POST", "/join?id="+id)
+		if w.Code != http.StatusOK {
+			t.Fatalf("join %s: %d %s", id, w.Code, w.Body)
+		}
+		seq, err := strconv.ParseUint(w.Header().Get(joinSeqHeader), 10, 64)
+		if err != nil {
+			t.Fatalf("join %s: %s %q", id, joinSeqHeader, w.Header().Get(joinSeqHeader))
+		}
+		joinSeqs[id] = seq
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	// Published once everyone has joined, so every session sees it.
+	if err := cr.Publish(Message{From: "burst", Body: "end"}); err != nil {
+		t.Fatal(err)
+	}
+
+	cr.mutex.Lock()
+	sessions := make(map[string]*client, len(cr.clients))
+	for id, c := range cr.clients {
+		sessions[id] = c
+	}
+	cr.mutex.Unlock()
+	all := drainBurst(t, cr, sessions["witness"], "burst", "end")
+	if len(all) != burst {
+		t.Fatalf("the witness, joined before the burst, got %d of %d messages", len(all), burst)
+	}
+	var straddled int
+	for id, joinSeq := range joinSeqs {
+		got := drainBurst(t, cr, sessions[id], "burst", "end")
+		want := 0
+		for seq := range all {
+			if seq > joinSeq {
+				want++
+				if !got[seq] {
+					t.Errorf("%s joined at %d and missed event seq %d", id, joinSeq, seq)
+				}
+			}
+		}
+		for seq := range got {
+			if seq <= joinSeq {
+				t.Errorf("%s joined at %d and got event seq %d from before", id, joinSeq, seq)
+			}
+		}
+		if len(got) != want {
+			t.Errorf("%s joined at %d: got %d messages, want %d", id, joinSeq, len(got), want)
+		}
+		if want > 0 && want < burst {
+			straddled++
+		}
+	}
+	if straddled == 0 {
+		t.Error("no join landed inside the burst")
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// exportJobTTL is how long a prepared export can be downloaded.
+const exportJobTTL = time.Hour
+
+// Export job states.
+const (
+	exportPreparing = "preparing"
+	exportReady     = "ready"
+)
+
+// Export is the body of /me/export: what the server holds that the caller
+// authored or configured, and nothing of anyone else's.
+type Export struct {
+	ID        string         `json:"id"`
+	Exported  time.Time      `json:"exported"`
+	Messages  []Message      `json:"messages"`  // Their messages and /me actions still in the event log, oldest first, unfolded
+	Embargoed []Embargo      `json:"embargoed"` // Their messages waiting to be released
+	Draft     *Draft         `json:"draft,omitempty"`
+	Settings  ExportSettings `json:"settings"`
+	Shares    []ShareInfo    `json:"shares"`
+}
+
+// ExportSettings are the caller's per-client settings.
+type ExportSettings struct {
+	Language     string     `json:"language,omitempty"`
+	Capabilities []string   `json:"capabilities,omitempty"` // Declared on join; empty when none were
+	Keywords     []string   `json:"keywords"`
+	Visibility   string     `json:"visibility"`
+	DND          bool       `json:"dnd"`
+	DNDUntil     *time.Time `json:"dnd_until,omitempty"`
+	BandwidthCap int64      `json:"bandwidth_cap,omitempty"`
+	RateClass    string     `json:"rate_class"`
+}
+
+// exportChunk is how many messages authoredAfter returns at most, so an
+// export streams them without holding the log's lock while it writes.
+const exportChunk = 256
+
+// authoredAfter returns up to n messages from clientID in the log after
+// seq, oldest first, and the seq to continue from; zero once the log is
+// exhausted. /me actions and other typed messages count as authored too.
+// Shadow-muted messages never reach the log, so a shadow mute
+// cannot be read off an export.
+func (l *eventLog) authoredAfter(clientID string, seq uint64, n int) ([]Message, uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.seq > maxEvents && seq < l.seq-maxEvents {
+		seq = l.seq - maxEvents
+	}
+	var out []Message
+	for seq < l.seq && len(out) < n {
+		seq++
+		m := l.ring[seq%maxEvents].Message
+		if m == nil || m.From != clientID {
+			continue
+		}
+		if m.unfolded != nil {
+			out = append(out, unfold(*m))
+		} else {
+			out = append(out, *m)
+		}
+	}
+	if seq == l.seq {
+		seq = 0
+	}
+	return out, seq
+}
+
+func (l *eventLog) authored(clientID string) []Message {
+	out := []Message{}
+	for seq := uint64(0); ; {
+		var chunk []Message
+		chunk, seq = l.authoredAfter(clientID, seq, exportChunk)
+		out = append(out, chunk...)
+		if seq == 0 {
+			return out
+		}
+	}
+}
+
+// Export gathers clientID's data.
+func (cr *ChatRoom) Export(clientID string) (Export, error) {
+	e, err := cr.exportSettings(clientID)
+	if err != nil {
+		return e, err
+	}
+	e.Messages = cr.events.authored(clientID)
+	return e, nil
+}
+
+// exportSettings gathers all of clientID's data but its messages.
+func (cr *ChatRoom) exportSettings(clientID string) (Export, error) {
+	cr.mutex.Lock()
+	c, exists := cr.clients[clientID]
+	if !exists {
+		cr.mutex.Unlock()
+		return Export{}, errClientNotFound
+	}
+	s := ExportSettings{
+		Language:     c.lang,
+		Keywords:     append([]string{}, c.keywords.words...),
+		Visibility:   presenceVisible,
+		DND:          c.dnd,
+		BandwidthCap: c.bw.cap,
+		RateClass:    cr.rates.classOf(clientID),
+	}
+	for name := range c.caps {
+		s.Capabilities = append(s.Capabilities, name)
+	}
+	if c.invisible {
+		s.Visibility = presenceInvisible
+	}
+	if c.dnd && !c.dndUntil.IsZero() {
+		until := c.dndUntil
+		s.DNDUntil = &until
+	}
+	cr.mutex.Unlock()
+	sort.Strings(s.Capabilities)
+	e := Export{
+		ID:        clientID,
+		Exported:  cr.clock.Now(),
+		Embargoed: cr.Embargoes(clientID, false),
+		Settings:  s,
+		Shares:    cr.Shares(clientID),
+	}
+	if d, ok := cr.Draft(clientID); ok {
+		e.Draft = &d
+	}
+	return e, nil
+}
+
+// ExportJob is an export being prepared in the background, as returned by
+// POST /me/export and by GET /me/export/{jobID} until it is ready.
+type ExportJob struct {
+	ID      string    `json:"id"`
+	Status  string    `json:"status"` // preparing or ready
+	Created time.Time `json:"created"`
+	URL     string    `json:"url"` // Where to download the export; it expires an hour after the job was created
+}
+
+type exportJob struct {
+	ExportJob
+	owner string
+	body  []byte // The encoded Export once it is ready
+}
+
+// StartExport prepares clientID's export in the background and returns the
+// job to collect it from. A client has at most one export in preparation;
+// asking again returns that one.
+func (cr *ChatRoom) StartExport(clientID string) (ExportJob, error) {
+	now := cr.clock.Now()
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	if cr.closed {
+		return ExportJob{}, errRoomClosed
+	}
+	if _, exists := cr.clients[clientID]; !exists {
+		return ExportJob{}, errClientNotFound
+	}
+	cr.expireExportsLocked(now)
+	for _, j := range cr.exportJobs {
+		if j.owner == clientID && j.Status == exportPreparing {
+			return j.ExportJob, nil
+		}
+	}
+	id := cr.ids.NewID()
+	j := &exportJob{ExportJob: ExportJob{ID: id, Status: exportPreparing, Created: now, URL: "/me/export/" + id}, owner: clientID}
+	cr.exportJobs[id] = j
+	// Added under the mutex so it cannot race with Close's Wait.
+	cr.startWorker(workerExport)
+	go cr.prepareExport(j)
+	return j.ExportJob, nil
+}
+
+// prepareExport encodes j's export. If its owner left in the meantime there
+// is nothing to export, and the job is dropped.
+func (cr *ChatRoom) prepareExport(j *exportJob) {
+	defer cr.stopWorker(workerExport)
+	e, err := cr.Export(j.owner)
+	var body []byte
+	if err == nil {
+		body, err = json.Marshal(e)
+	}
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	if err != nil {
+		delete(cr.exportJobs, j.ID)
+		return
+	}
+	j.Status, j.body = exportReady, body
+}
+
+func (cr *ChatRoom) expireExportsLocked(now time.Time) {
+	for id, j := range cr.exportJobs {
+		if now.Sub(j.Created) >= exportJobTTL {
+			delete(cr.exportJobs, id)
+		}
+	}
+}
+
+// exportJobOf returns the job with ID id if clientID started it and it
+// has not expired.
+func (cr *ChatRoom) exportJobOf(clientID, id string) (exportJob, bool) {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	cr.expireExportsLocked(cr.clock.Now())
+	j, ok := cr.exportJobs[id]
+	if !ok || j.owner != clientID {
+		return exportJob{}, false
+	}
+	return *j, true
+}
+
+// HandleExport serves GET /me/export, a copy of the caller's own data
+// streamed on the request, and POST /me/export, which prepares the same
+// copy in the background for download from /me/export/{jobID}.
+func (cr *ChatRoom) HandleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID, ok := cr.sender(w, r)
+	if !ok {
+		return
+	}
+	if r.Method == http.MethodPost {
+		j, err := cr.StartExport(clientID)
+		switch err {
+		case nil:
+		case errRoomClosed:
+			http.Error(w, "Chat room is closed", http.StatusGone)
+			return
+		default:
+			http.Error(w, "Client not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", j.URL)
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(j)
+		return
+	}
+	e, err := cr.exportSettings(clientID)
+	if err != nil {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+clientID+`-export.json"`)
+	// Written field by field as an Export, streaming the messages from
+	// the log a chunk at a time.
+	lw := newListWriter(w)
+	lw.raw("{")
+	lw.field("id", e.ID, true)
+	lw.field("exported", e.Exported, false)
+	lw.raw(`,"messages":`)
+	lw.begin()
+	for seq := uint64(0); lw.err == nil; {
+		var chunk []Message
+		chunk, seq = cr.events.authoredAfter(clientID, seq, exportChunk)
+		for _, m := range chunk {
+			lw.item(m)
+		}
+		if seq == 0 {
+			break
+		}
+	}
+	lw.end()
+	lw.field("embargoed", e.Embargoed, false)
+	if e.Draft != nil {
+		lw.field("draft", e.Draft, false)
+	}
+	lw.field("settings", e.Settings, false)
+	lw.field("shares", e.Shares, false)
+	lw.raw("}")
+	lw.done()
+}
+
+// HandleExportJob serves GET /me/export/{jobID}: the job, with 202, while
+// the export is being prepared, and then the export itself until the job
+// expires. Only the client that started the job can see it.
+func (cr *ChatRoom) HandleExportJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID, ok := cr.sender(w, r)
+	if !ok {
+		return
+	}
+	j, ok := cr.exportJobOf(clientID, strings.TrimPrefix(r.URL.Path, "/me/export/"))
+	if !ok {
+		http.Error(w, "Export job not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if j.body == nil {
+		w.Header().Set("Retry-After", retryAfterSeconds)
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(j.ExportJob)
+		return
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="`+clientID+`-export.json"`)
+	w.Write(j.body)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"chatroom/testutil"
+)
+
+// An export holds everything the caller wrote, /me actions included, and
+// nothing anyone else did.
+func TestExportIncludesActions(t *testing.T) {
+	cr, h := newTestRoom(t)
+	ta := join(t, h, "alice")
+	tb := join(t, h, "bob")
+	for _, s := range []struct{ token, message string }{
+		{ta, "hello"}, {tb, "hi alice"}, {ta, "/me waves"}, {tb, "/me nods"}, {ta, "/shrug"},
+	} {
+		if w := send(h, s.token, s.message); w.Code != http.StatusOK {
+			t.Fatalf("send %q: %d %s", s.message, w.Code, w.Body)
+		}
+	}
+	eventually(t, "the sends to be logged", func() bool { return cr.events.last() >= 7 })
+
+	w := do(h, "GET", "/me/export", tokenHeader, ta)
+	var e Export
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &e) != nil {
+		t.Fatalf("export: %d %s", w.Code, w.Body)
+	}
+	want := []struct{ typ, body string }{{"", "hello"}, {messageTypeAction, "waves"}, {"", shrug}}
+	if len(e.Messages) != len(want) {
+		t.Fatalf("exported %d messages, want %d: %+v", len(e.Messages), len(want), e.Messages)
+	}
+	for i, m := range e.Messages {
+		if m.From != "alice" || m.Type != want[i].typ || m.Body != want[i].body {
+			t.Errorf("message %d: %s [%s] %q, want alice [%s] %q", i, m.From, m.Type, m.Body, want[i].typ, want[i].body)
+		}
+	}
+	direct, err := cr.Export("alice")
+	if err != nil || len(direct.Messages) != len(want) {
+		t.Errorf("Export: %d messages, %v; want %d like /me/export", len(direct.Messages), err, len(want))
+	}
+}
+
+// POST /me/export prepares the export in the background; its job can be
+// downloaded only by its owner, and only until it expires.
+func TestExportJobs(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	cr, h := newTestRoom(t, WithClock(clk))
+	ta := join(t, h, "alice")
+	tb := join(t, h, "bob")
+	if w := send(h, ta, "hello"); w.Code != http.StatusOK {
+		t.Fatalf("send: %d %s", w.Code, w.Body)
+	}
+	eventually(t, "the send to be logged", func() bool { return cr.events.last() >= 3 })
+
+	w := do(h, "POST", "/me/export", tokenHeader, ta)
+	var job ExportJob
+	if w.Code != http.StatusAccepted || json.Unmarshal(w.Body.Bytes(), &job) != nil {
+		t.Fatalf("start export: %d %s", w.Code, w.Body)
+	}
+	if job.Status != exportPreparing || job.URL != "/me/export/"+job.ID || w.Header().Get("Location") != job.URL {
+		t.Errorf("job %+v, Location %q", job, w.Header().Get("Location"))
+	}
+
+	var ready *httptest.ResponseRecorder
+	eventually(t, "the export to be ready", func() bool {
+		ready = do(h, "GET", job.URL, tokenHeader, ta)
+		return ready.Code != http.StatusAccepted
+	})
+	var e Export
+	if ready.Code != http.StatusOK || json.Unmarshal(ready.Body.Bytes(), &e) != nil {
+		t.Fatalf("download: %d %s", ready.Code, ready.Body)
+	}
+	if e.ID != "alice" || len(e.Messages) != 1 || e.Messages[0].Body != "hello" {
+		t.Errorf("downloaded %+v, want alice's one message", e)
+	}
+
+	if w := do(h, "GET", job.URL, tokenHeader, tb); w.Code != http.StatusNotFound {
+		t.Errorf("bob downloading alice's export: %d %s", w.Code, w.Body)
+	}
+	if w := do(h, "GET", job.URL); w.Code != http.StatusUnauthorized {
+		t.Errorf("download without a token: %d %s", w.Code, w.Body)
+	}
+	if w := do(h, "GET", "/me/export/nonesuch", tokenHeader, ta); w.Code != http.StatusNotFound {
+		t.Errorf("unknown job: %d %s", w.Code, w.Body)
+	}
+	clk.Advance(exportJobTTL)
+	if w := do(h, "GET", job.URL, tokenHeader, ta); w.Code != http.StatusNotFound {
+		t.Errorf("download after %s: %d %s", exportJobTTL, w.Code, w.Body)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	codeFence  = "```"
+	foldMarker = " …"
+)
+
+// WithFolding folds chat messages longer than n bytes: they are delivered
+// cut to about n bytes with Folded set, and the whole body is served by
+// GET /messages/{id}/full while the message is retained. Sessions that
+// declared the fullbody capability get whole bodies. Zero turns folding off.
+func WithFolding(n int) Option {
+	return func(cr *ChatRoom) {
+		if n >= 0 {
+			cr.foldLength = n
+		}
+	}
+}
+
+// unfolded is what folding cut from a message.
+type unfolded struct {
+	body     string
+	entities []Entity
+}
+
+// foldPoint returns where to cut body to at most n bytes: on a rune
+// boundary, and before any code fence the cut would leave open. A fence
+// that opens the body is closed again by the caller instead, since cutting
+// before it would leave nothing.
+func foldPoint(body string, n int) (cut int, inFence bool) {
+	cut = n
+	for cut > 0 && !utf8.RuneStart(body[cut]) {
+		cut--
+	}
+	if strings.Count(body[:cut], codeFence)%2 == 1 {
+		if open := strings.LastIndex(body[:cut], codeFence); open > 0 {
+			return open, false
+		}
+		return cut, true
+	}
+	return cut, false
+}
+
+// fold cuts msg down to the fold length if it is a longer chat message,
+// keeping the whole body and its entities for /messages/{id}/full and
+// fullbody sessions.
+func (cr *ChatRoom) fold(msg *Message) {
+	if cr.foldLength == 0 || len(msg.Body) <= cr.foldLength || msg.Type != "" || msg.Kind != "" {
+		return
+	}
+	cut, inFence := foldPoint(msg.Body, cr.foldLength)
+	msg.unfolded = &unfolded{body: msg.Body, entities: msg.Entities}
+	msg.Folded, msg.FullLength = true, len(msg.Body)
+	shown := strings.TrimRight(msg.Body[:cut], " \n")
+	runes := utf8.RuneCountInString(shown)
+	var kept []Entity
+	for _, e := range msg.Entities {
+		if e.Offset+e.Length <= runes {
+			kept = append(kept, e)
+		}
+	}
+	msg.Entities = kept
+	if inFence {
+		shown += "\n" + codeFence
+	}
+	msg.Body = shown + foldMarker
+}
+
+// unfold restores the whole body of a folded delivery for a fullbody
+// session. The translation, if any, is of the folded text and is dropped.
+func unfold(m Message) Message {
+	m.Body, m.Entities = m.unfolded.body, m.unfolded.entities
+	m.Folded, m.FullLength, m.Translated, m.unfolded = false, 0, "", nil
+	m.line, m.envelope = formatLine(m), nil
+	return m
+}
+
+// HandleFullMessage serves GET /messages/{id}/full to joined clients: the
+// whole body of a retained message, folded or not, as text or, with
+// format=json, as the message it was delivered as with the body restored.
+func (cr *ChatRoom) HandleFullMessage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	messageID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/messages/"), "/full")
+	clientID, ok := cr.sender(w, r)
+	if !ok {
+		return
+	}
+	cr.mutex.Lock()
+	c, exists := cr.clients[clientID]
+	joined := exists && !c.pending
+	cr.mutex.Unlock()
+	if !joined {
+		http.Error(w, "Only clients in the chat can read messages", http.StatusForbidden)
+		return
+	}
+	msg, ok := cr.recent.get(messageID)
+	if !ok || msg.Type != "" {
+		http.Error(w, "Message not found or no longer retained", http.StatusNotFound)
+		return
+	}
+	if msg.unfolded != nil {
+		msg = unfold(msg)
+	}
+	if r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(msg)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(msg.Body))
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"chatroom/testutil"
+)
+
+func TestFoldPointKeepsRunesAndCodeFencesWhole(t *testing.T) {
+	for _, tc := range []struct {
+		body    string
+		n       int
+		cut     int
+		inFence bool
+	}{
+		{"ééééé", 3, 2, false}, // Not inside the second é
+		{"plain text here", 5, 5, false},
+		{"see ```go\nfmt.Println()\n``` done", 15, 4, false}, // Before the fence the cut would leave open
+		{"```\nlog line one\nlog line two\n```", 12, 12, true},
+	} {
+		cut, inFence := foldPoint(tc.body, tc.n)
+		if cut != tc.cut || inFence != tc.inFence {
+			t.Errorf("foldPoint(%q, %d) = %d, %v; want %d, %v", tc.body, tc.n, cut, inFence, tc.cut, tc.inFence)
+		}
+	}
+}
+
+// Long messages are delivered folded, except to sessions that declared
+// fullbody, and the whole body stays available to members.
+func TestLongMessagesAreFolded(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	_, h := newTestRoom(t, WithClock(clk), WithFolding(20))
+	ta, tb := join(t, h, "alice"), join(t, h, "bob")
+	w := do(h, "POST", "/join?id=carol&capabilities=fullbody")
+	if w.Code != http.StatusOK {
+		t.Fatalf("join: %d %s", w.Code, w.Body)
+	}
+	tc := w.Header().Get(tokenHeader)
+	long := "stack trace follows: " + strings.Repeat("frame ", 10)
+	id := sentID(t, h, ta, long)
+
+	var m Message
+	json.Unmarshal(pollFrom(t, h, "bob", tb, "alice"), &m)
+	if !m.Folded || m.FullLength != len(long) || m.Body != "stack trace follows:"+foldMarker {
+		t.Errorf("bob received %q folded=%v full_length=%d", m.Body, m.Folded, m.FullLength)
+	}
+	m = Message{}
+	json.Unmarshal(pollFrom(t, h, "carol", tc, "alice"), &m)
+	if m.Folded || m.Body != long {
+		t.Errorf("carol received %q folded=%v, want the whole body", m.Body, m.Folded)
+	}
+
+	if w := do(h, "GET", "/messages/"+id+"/full", tokenHeader, tb); w.Code != http.StatusOK || w.Body.String() != long {
+		t.Errorf("full body: %d %q", w.Code, w.Body)
+	}
+	m = Message{}
+	if w := do(h, "GET", "/messages/"+id+"/full?format=json", tokenHeader, tb); json.Unmarshal(w.Body.Bytes(), &m) != nil || m.Folded || m.Body != long || m.ID != id {
+		t.Errorf("full message: %d %s", w.Code, w.Body)
+	}
+	if w := do(h, "GET", "/messages/"+id+"/full"); w.Code != http.StatusUnauthorized {
+		t.Errorf("full body without a token: %d, want 401", w.Code)
+	}
+	if w := do(h, "GET", "/messages/01HZZZZZZZZZZZZZZZZZZZZZZZ/full", tokenHeader, tb); w.Code != http.StatusNotFound {
+		t.Errorf("full body of an unknown message: %d, want 404", w.Code)
+	}
+
+	send(h, ta, "short enough")
+	m = Message{}
+	json.Unmarshal(pollFrom(t, h, "bob", tb, "alice"), &m)
+	if m.Folded || m.Body != "short enough" {
+		t.Errorf("short message delivered as %q folded=%v", m.Body, m.Folded)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Health is the body of /healthz.
+type Health struct {
+	Status      string      `json:"status"`
+	Instance    string      `json:"instance"`
+	Maintenance Maintenance `json:"maintenance"`
+	// Chaos is present only in chaos mode, whose faults make this instance
+	// unfit for real traffic.
+	Chaos *Chaos `json:"chaos,omitempty"`
+}
+
+func (cr *ChatRoom) HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	h := Health{Status: "ok", Instance: cr.instanceID, Maintenance: cr.Maintenance(), Chaos: cr.Chaos()}
+	switch {
+	case h.Maintenance.Enabled:
+		h.Status = "maintenance"
+	case h.Chaos != nil:
+		h.Status = "chaos"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h)
+}
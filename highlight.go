@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	kindCode             = "code"
+	messageTypeHighlight = "highlight"
+	entityToken          = "token"
+
+	// Token classes of highlight entities.
+	tokenKeyword = "keyword"
+	tokenString  = "string"
+	tokenComment = "comment"
+	tokenNumber  = "number"
+
+	// maxCodePayloadLength is the payload limit of code messages, which
+	// are allowed to be longer than text.
+	maxCodePayloadLength = 4 * maxMessageLength
+	// highlightBudget is how long a send waits for highlighting before
+	// delivering the message without it.
+	highlightBudget = 2 * time.Millisecond
+	// languageAuto asks the server to detect a code message's language.
+	languageAuto = "auto"
+)
+
+// lexer describes a language well enough to find its keywords, strings,
+// comments and numbers.
+type lexer struct {
+	keywords     map[string]bool
+	lineComments []string
+	blockComment [2]string // Opening and closing delimiters; empty for none
+	quotes       string    // Characters that open and close strings
+}
+
+func words(s string) map[string]bool {
+	m := make(map[string]bool)
+	for _, w := range strings.Fields(s) {
+		m[w] = true
+	}
+	return m
+}
+
+var cLike = [2]string{"/*", "*/"}
+
+var lexers = map[string]*lexer{
+	"go": {keywords: words("break case chan const continue default defer else fallthrough for func go goto if import interface map package range return select struct switch type var nil true false"),
+		lineComments: []string{"//"}, blockComment: cLike, quotes: "\"'`"},
+	"python": {keywords: words("and as assert async await break class continue def del elif else except finally for from global if import in is lambda nonlocal not or pass raise return try while with yield None True False"),
+		lineComments: []string{"#"}, quotes: "\"'"},
+	"javascript": {keywords: words("async await break case catch class const continue debugger default delete do else export extends finally for function if import in instanceof let new of return super switch this throw try typeof var void while yield null undefined true false interface type enum implements"),
+		lineComments: []string{"//"}, blockComment: cLike, quotes: "\"'`"},
+	"rust": {keywords: words("as async await break const continue crate dyn else enum extern fn for if impl in let loop match mod move mut pub ref return self Self static struct super trait type unsafe use where while true false"),
+		lineComments: []string{"//"}, blockComment: cLike, quotes: "\""},
+	"c": {keywords: words("auto break case char const continue default do double else enum extern float for goto if inline int long register return short signed sizeof static struct switch typedef union unsigned void volatile while class namespace public private protected template typename new delete this true false nullptr bool"),
+		lineComments: []string{"//"}, blockComment: cLike, quotes: "\"'"},
+	"java": {keywords: words("abstract boolean break byte case catch char class const continue default do double else enum extends final finally float for if implements import instanceof int interface long new package private protected public return short static super switch synchronized this throw throws try void volatile while null true false var"),
+		lineComments: []string{"//"}, blockComment: cLike, quotes: "\"'"},
+	"shell": {keywords: words("if then else elif fi case esac for while until do done in function return local export"),
+		lineComments: []string{"#"}, quotes: "\"'"},
+	"sql": {keywords: words("select from where and or not insert into values update set delete create table drop alter index join left right inner outer on group by order having limit as distinct null is in like union SELECT FROM WHERE AND OR NOT INSERT INTO VALUES UPDATE SET DELETE CREATE TABLE DROP ALTER INDEX JOIN LEFT RIGHT INNER OUTER ON GROUP BY ORDER HAVING LIMIT AS DISTINCT NULL IS IN LIKE UNION"),
+		lineComments: []string{"--"}, blockComment: cLike, quotes: "'\""},
+	"json": {keywords: words("true false null"), quotes: "\""},
+}
+
+var languageAliases = map[string]string{
+	"golang": "go", "py": "python", "js": "javascript", "ts": "javascript", "typescript": "javascript",
+	"rs": "rust", "cpp": "c", "c++": "c", "h": "c", "sh": "shell", "bash": "shell", "zsh": "shell",
+}
+
+// detectLanguage guesses the language of code from telltale openings. It
+// returns "" when nothing matches, which leaves the code unhighlighted.
+func detectLanguage(code string) string {
+	t := strings.TrimSpace(code)
+	switch {
+	case strings.HasPrefix(t, "#!") && strings.Contains(strings.SplitN(t, "\n", 2)[0], "sh"):
+		return "shell"
+	case strings.HasPrefix(t, "#!") && strings.Contains(strings.SplitN(t, "\n", 2)[0], "python"):
+		return "python"
+	case strings.HasPrefix(t, "package ") || strings.Contains(t, "func ") && strings.Contains(t, "{"):
+		return "go"
+	case strings.Contains(t, "def ") && strings.Contains(t, ":") || strings.HasPrefix(t, "import ") && !strings.Contains(t, ";"):
+		return "python"
+	case strings.Contains(t, "fn ") && strings.Contains(t, "let "):
+		return "rust"
+	case strings.Contains(t, "#include"):
+		return "c"
+	case strings.Contains(t, "function") || strings.Contains(t, "=>") || strings.Contains(t, "const "):
+		return "javascript"
+	case strings.HasPrefix(strings.ToUpper(t), "SELECT ") || strings.HasPrefix(strings.ToUpper(t), "INSERT "):
+		return "sql"
+	case (strings.HasPrefix(t, "{") || strings.HasPrefix(t, "[")) && json.Valid([]byte(t)):
+		return "json"
+	}
+	return ""
+}
+
+func lexerFor(language, code string) *lexer {
+	language = strings.ToLower(language)
+	if language == languageAuto {
+		language = detectLanguage(code)
+	}
+	if alias, ok := languageAliases[language]; ok {
+		language = alias
+	}
+	return lexers[language]
+}
+
+func isIdent(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= utf8.RuneSelf
+}
+
+// tokenize returns token entities over code, in order and not overlapping.
+// It is a single pass with no backtracking, so its cost is linear in the
+// length of the code.
+func (l *lexer) tokenize(code string) []Entity {
+	var spans [][2]int
+	var classes []string
+	add := func(start, end int, class string) {
+		spans, classes = append(spans, [2]int{start, end}), append(classes, class)
+	}
+	for i := 0; i < len(code); {
+		c := code[i]
+		rest := code[i:]
+		if l.blockComment[0] != "" && strings.HasPrefix(rest, l.blockComment[0]) {
+			end := strings.Index(rest[len(l.blockComment[0]):], l.blockComment[1])
+			if end < 0 {
+				end = len(rest)
+			} else {
+				end += len(l.blockComment[0]) + len(l.blockComment[1])
+			}
+			add(i, i+end, tokenComment)
+			i += end
+			continue
+		}
+		comment := false
+		for _, p := range l.lineComments {
+			if strings.HasPrefix(rest, p) {
+				comment = true
+				break
+			}
+		}
+		if comment {
+			end := strings.IndexByte(rest, '\n')
+			if end < 0 {
+				end = len(rest)
+			}
+			add(i, i+end, tokenComment)
+			i += end
+			continue
+		}
+		if strings.IndexByte(l.quotes, c) >= 0 {
+			j := i + 1
+			for j < len(code) && code[j] != c {
+				// Backquoted strings are raw; others may escape the quote
+				// and, except in those, end at a newline.
+				if c != '`' && code[j] == '\\' {
+					j++
+				} else if c != '`' && code[j] == '\n' {
+					break
+				}
+				j++
+			}
+			end := min(j+1, len(code))
+			add(i, end, tokenString)
+			i = end
+			continue
+		}
+		if isIdent(c) {
+			j := i
+			for j < len(code) && isIdent(code[j]) {
+				j++
+			}
+			if c >= '0' && c <= '9' {
+				add(i, j, tokenNumber)
+			} else if l.keywords[code[i:j]] {
+				add(i, j, tokenKeyword)
+			}
+			i = j
+			continue
+		}
+		i++
+	}
+	// Spans are in order, so offsets in runes come from one walk.
+	found := make([]Entity, len(spans))
+	pos, runes := 0, 0
+	for k, s := range spans {
+		runes += utf8.RuneCountInString(code[pos:s[0]])
+		length := utf8.RuneCountInString(code[s[0]:s[1]])
+		found[k] = Entity{Type: entityToken, Offset: runes, Length: length, Token: classes[k]}
+		runes, pos = runes+length, s[1]
+	}
+	return found
+}
+
+// WithHighlighting turns syntax highlighting of code messages on or off.
+// It is on by default.
+func WithHighlighting(enabled bool) Option {
+	return func(cr *ChatRoom) {
+		cr.highlightOff = !enabled
+	}
+}
+
+// highlight tokenizes the code of a code message, setting msg.Highlight if
+// that takes less than highlightBudget. Otherwise msg goes out without it
+// and, once tokenizing finishes, a highlight event referring to it follows;
+// msg is given its ID now for that.
+func (cr *ChatRoom) highlight(msg *Message) {
+	if cr.highlightOff || msg.Kind != kindCode {
+		return
+	}
+	var p struct {
+		Language string `json:"language"`
+		Code     string `json:"code"`
+	}
+	if json.Unmarshal(msg.Payload, &p) != nil {
+		return
+	}
+	l := lexerFor(p.Language, p.Code)
+	if l == nil {
+		return
+	}
+	done := make(chan []Entity, 1)
+	go func() { done <- l.tokenize(p.Code) }()
+	select {
+	case msg.Highlight = <-done:
+	case <-cr.clock.After(highlightBudget):
+		if msg.ID == "" {
+			msg.ID = cr.ids.NewID()
+		}
+		msg.highlighting = done
+	}
+}
+
+// scheduleHighlight publishes the highlight event of a message whose
+// highlighting overran its budget. It runs when the message is delivered,
+// so the event never overtakes it.
+func (cr *ChatRoom) scheduleHighlight(msg Message) {
+	if msg.highlighting == nil {
+		return
+	}
+	cr.goTracked(workerHighlight, func() {
+		select {
+		case spans := <-msg.highlighting:
+			cr.Publish(Message{From: systemSender, Type: messageTypeHighlight, RefID: msg.ID, Body: "Highlighting for " + msg.ID, Highlight: spans})
+		case <-cr.done:
+		}
+	})
+}
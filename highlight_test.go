@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"chatroom/testutil"
+)
+
+// tokens renders highlight entities over code as class:text pairs.
+func tokens(code string, found []Entity) string {
+	runes := []rune(code)
+	var out []string
+	for _, e := range found {
+		out = append(out, e.Token+":"+string(runes[e.Offset:e.Offset+e.Length]))
+	}
+	return strings.Join(out, " ")
+}
+
+func TestTokenize(t *testing.T) {
+	for _, tc := range []struct {
+		language, code, want string
+	}{
+		{"go", "func f() { // é\n\treturn \"a\\\"b\" + `c` + 42 }", "keyword:func comment:// é keyword:return string:\"a\\\"b\" string:`c` number:42"},
+		{"py", "def f(): # 'not a string'\n    return 'é'", "keyword:def comment:# 'not a string' keyword:return string:'é'"},
+		{"sql", "/* all */ SELECT 1 FROM t -- done", "comment:/* all */ keyword:SELECT number:1 keyword:FROM comment:-- done"},
+		{"go", "x := \"unterminated\ny", "string:\"unterminated\n"},
+	} {
+		if got := tokens(tc.code, lexerFor(tc.language, tc.code).tokenize(tc.code)); got != tc.want {
+			t.Errorf("tokenize %s %q:\n got %s\nwant %s", tc.language, tc.code, got, tc.want)
+		}
+	}
+	if lexerFor("cobol", "MOVE A TO B") != nil {
+		t.Error("an unknown language has a lexer")
+	}
+}
+
+func TestDetectLanguage(t *testing.T) {
+	for code, want := range map[string]string{
+		"package main\n":                   "go",
+		"#!/bin/bash\necho hi":             "shell",
+		"def f(x):\n    return x":          "python",
+		"fn main() { let x = 1; }":         "rust",
+		"#include <stdio.h>":               "c",
+		"const f = (x) => x":               "javascript",
+		"select * from t":                  "sql",
+		`{"a": [1, 2]}`:                    "json",
+		"just some words, not code at all": "",
+	} {
+		if got := detectLanguage(code); got != want {
+			t.Errorf("detectLanguage(%q) = %q, want %q", code, got, want)
+		}
+	}
+}
+
+func codePayload(language, code string) string {
+	b, _ := json.Marshal(map[string]string{"language": language, "code": code})
+	return string(b)
+}
+
+// Code messages carry highlighting for sessions that declared it, and may
+// be longer than text.
+func TestCodeMessagesAreHighlighted(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	_, h := newTestRoom(t, WithClock(clk))
+	ta := join(t, h, "alice")
+	sessions := map[string]string{}
+	for _, id := range []string{"bob", "carol"} {
+		caps := "kinds"
+		if id == "bob" {
+			caps += ",highlight"
+		}
+		w := do(h, "POST", "/join?id="+id+"&capabilities="+caps)
+		sessions[id] = w.Header().Get(tokenHeader)
+	}
+
+	if code, body := sendKind(h, ta, kindCode, codePayload(languageAuto, "package main\n\nfunc main() {}")); code != http.StatusOK {
+		t.Fatalf("send code: %d %s", code, body)
+	}
+	var m Message
+	json.Unmarshal(pollFrom(t, h, "bob", sessions["bob"], "alice"), &m)
+	if len(m.Highlight) != 2 || m.Highlight[0] != (Entity{Type: entityToken, Offset: 0, Length: 7, Token: tokenKeyword}) || m.Highlight[1].Offset != 14 {
+		t.Errorf("bob received highlighting %+v", m.Highlight)
+	}
+	m = Message{}
+	json.Unmarshal(pollFrom(t, h, "carol", sessions["carol"], "alice"), &m)
+	if m.Kind != kindCode || m.Highlight != nil {
+		t.Errorf("carol received %+v, want the code without highlighting", m)
+	}
+
+	long := strings.Repeat("x = 1\n", maxPayloadLength/6)
+	if code, body := sendKind(h, ta, kindCode, codePayload("python", long)); code != http.StatusOK {
+		t.Errorf("send code longer than a text payload: %d %s", code, body)
+	}
+	if code, _ := sendKind(h, ta, kindCode, codePayload("python", strings.Repeat(long, 5))); code != http.StatusBadRequest {
+		t.Errorf("send code over its limit: %d, want 400", code)
+	}
+}
+
+func TestHighlightingCanBeTurnedOff(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	_, h := newTestRoom(t, WithClock(clk), WithHighlighting(false))
+	ta := join(t, h, "alice")
+	tb := do(h, "POST", "/join?id=bob&capabilities=kinds,highlight").Header().Get(tokenHeader)
+	sendKind(h, ta, kindCode, codePayload("go", "func main() {}"))
+	var m Message
+	json.Unmarshal(pollFrom(t, h, "bob", tb, "alice"), &m)
+	if m.Kind != kindCode || m.Highlight != nil {
+		t.Errorf("bob received %+v, want no highlighting", m)
+	}
+}
+
+// Highlighting that overran its budget follows the message as an event.
+func TestLateHighlightingFollowsAsAnEvent(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	cr, h := newTestRoom(t, WithClock(clk))
+	tb := do(h, "POST", "/join?id=bob&capabilities=kinds,highlight").Header().Get(tokenHeader)
+	done := make(chan []Entity, 1)
+	done <- []Entity{{Type: entityToken, Offset: 0, Length: 4, Token: tokenKeyword}}
+	id := cr.ids.NewID()
+	cr.scheduleHighlight(Message{ID: id, highlighting: done})
+
+	var m Message
+	json.Unmarshal(pollFrom(t, h, "bob", tb, systemSender), &m)
+	if m.Type != messageTypeHighlight || m.RefID != id || len(m.Highlight) != 1 {
+		t.Errorf("bob received %+v, want the highlight event", m)
+	}
+	c := &client{caps: parseCapabilities(capKinds)}
+	if _, ok := c.adapt(m); ok {
+		t.Error("a session without highlight would receive the event")
+	}
+}
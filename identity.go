@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// tokenHeader carries the send token /join returns. /send takes the
+	// sender's identity from it rather than from the id parameter.
+	tokenHeader = "X-Convo-Token"
+	// tokenOverlap is how long a rotated-out token keeps working, so
+	// requests already in flight with it do not fail.
+	tokenOverlap = 30 * time.Second
+
+	messageTypeRevoked = "revoked"
+)
+
+var (
+	errBadToken     = errors.New("send token is invalid")
+	errRevokedToken = errors.New("send token has been revoked or rotated out")
+)
+
+func newTokenKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic("token key: " + err.Error())
+	}
+	return key
+}
+
+// sendTokenLocked issues a token for session c of clientID:
+// base64(clientID) "." issued "." base64(HMAC(clientID, sessionID, issued)),
+// where issued is the issue time in Unix nanoseconds. A token is valid while
+// its session is registered and it is the session's current token (or was,
+// until less than tokenOverlap ago), unless it predates the revocation
+// epoch.
+func (cr *ChatRoom) sendTokenLocked(clientID string, c *client) string {
+	c.tokenIssued = cr.clock.Now().UnixNano()
+	issued := strconv.FormatInt(c.tokenIssued, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(clientID)) + "." + issued + "." + cr.tokenMAC(clientID, c.sessionID, issued)
+}
+
+func (cr *ChatRoom) tokenMAC(clientID, sessionID, issued string) string {
+	mac := hmac.New(sha256.New, cr.tokenKey)
+	mac.Write([]byte(clientID + "\x00" + sessionID + "\x00" + issued))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// tokenSessionLocked returns the client ID and session token was issued to.
+// The session may be departing.
+func (cr *ChatRoom) tokenSessionLocked(token string) (string, *client, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", nil, errBadToken
+	}
+	id, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", nil, errBadToken
+	}
+	issued, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", nil, errBadToken
+	}
+	clientID := string(id)
+	owner, exists := cr.clients[clientID]
+	if !exists {
+		owner, exists = cr.departing[clientID]
+	}
+	if !exists {
+		return "", nil, errBadToken
+	}
+	for _, s := range append([]*client{owner}, owner.extra...) {
+		if !hmac.Equal([]byte(parts[2]), []byte(cr.tokenMAC(clientID, s.sessionID, parts[1]))) {
+			continue
+		}
+		current := issued == s.tokenIssued
+		overlapping := issued == s.prevIssued && cr.clock.Now().Before(s.prevUntil)
+		if !current && !overlapping || issued < cr.revokedBefore.UnixNano() {
+			return clientID, s, errRevokedToken
+		}
+		return clientID, s, nil
+	}
+	// A well-formed token for a session that is gone: revoked or replaced.
+	return clientID, nil, errRevokedToken
+}
+
+// senderSession authenticates r by its send token, logging use of revoked
+// tokens with the address they came from.
+func (cr *ChatRoom) senderSession(r *http.Request) (string, *client, error) {
+	cr.mutex.Lock()
+	clientID, c, err := cr.tokenSessionLocked(r.Header.Get(tokenHeader))
+	cr.mutex.Unlock()
+	if err == errRevokedToken {
+		log.Printf("revoked send token for %s used from %s", clientID, cr.remoteIP(r))
+	}
+	return clientID, c, err
+}
+
+// sender works out who is sending a request. Clients authenticate with
+// their send token, and an id parameter must then match it. Admins may
+// send as any client by id alone.
+func (cr *ChatRoom) sender(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := r.URL.Query().Get("id")
+	if r.Header.Get(tokenHeader) == "" {
+		if id != "" && cr.isAdmin(r) {
+			return id, true
+		}
+		http.Error(w, "Sending requires the "+tokenHeader+" header returned by /join", http.StatusUnauthorized)
+		return "", false
+	}
+	tokenID, _, err := cr.senderSession(r)
+	if err != nil {
+		http.Error(w, "Send token is invalid, revoked or its session has ended", http.StatusForbidden)
+		return "", false
+	}
+	if id != "" && id != tokenID {
+		http.Error(w, "id does not match the send token", http.StatusForbidden)
+		return "", false
+	}
+	return tokenID, true
+}
+
+// HandleRotate replaces the caller's send token: POST /me/rotate with the
+// current token. The new one comes back in X-Convo-Token; the old one keeps
+// working for tokenOverlap.
+func (cr *ChatRoom) HandleRotate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID, c, err := cr.senderSession(r)
+	if err != nil {
+		http.Error(w, "Send token is invalid, revoked or its session has ended", http.StatusForbidden)
+		return
+	}
+	cr.mutex.Lock()
+	c.prevIssued, c.prevUntil = c.tokenIssued, cr.clock.Now().Add(tokenOverlap)
+	token := cr.sendTokenLocked(clientID, c)
+	cr.mutex.Unlock()
+	w.Header().Set(tokenHeader, token)
+	fmt.Fprintf(w, "Send token for %s rotated; the old one expires in %s", clientID, tokenOverlap)
+}
+
+// Revoke ends every session of clientID at once.
+func (cr *ChatRoom) Revoke(clientID string) error {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	owner, exists := cr.clients[clientID]
+	if !exists {
+		return errClientNotFound
+	}
+	delete(cr.clients, clientID)
+	delete(cr.recentBodies, clientID)
+	cr.rosterChangedLocked()
+	cr.recordPresenceLocked(eventLeave, clientID, owner)
+	sessions := append([]*client{owner}, owner.extra...)
+	owner.extra = nil
+	for _, s := range sessions {
+		cr.retireLocked(s, messageTypeRevoked, "Your session was revoked by an admin")
+	}
+	cr.audit.add(AuditEntry{Action: "revoke", Actor: actorAdmin, Target: clientID, IP: owner.addr, UserAgent: owner.userAgent})
+	return nil
+}
+
+// RevokeBefore invalidates every send token issued before t. Sessions stay
+// joined but must join again to send.
+func (cr *ChatRoom) RevokeBefore(t time.Time) {
+	cr.mutex.Lock()
+	if t.After(cr.revokedBefore) {
+		cr.revokedBefore = t
+	}
+	cr.mutex.Unlock()
+	cr.audit.add(AuditEntry{Action: "revoke_epoch", Actor: actorAdmin, Detail: t.UTC().Format(time.RFC3339Nano)})
+}
+
+// HandleRevoke serves POST /admin/revoke?id=<id>, which ends a client's
+// sessions, and POST /admin/revoke?before=<RFC 3339 time or "now">, which
+// invalidates every token issued before then.
+func (cr *ChatRoom) HandleRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	switch {
+	case q.Get("id") != "":
+		if err := cr.Revoke(q.Get("id")); err != nil {
+			http.Error(w, "Client not found", http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, "Sessions of %s revoked", q.Get("id"))
+	case q.Get("before") != "":
+		t := cr.clock.Now()
+		if s := q.Get("before"); s != "now" {
+			var err error
+			if t, err = time.Parse(time.RFC3339Nano, s); err != nil {
+				http.Error(w, "before must be an RFC 3339 time or now", http.StatusBadRequest)
+				return
+			}
+		}
+		cr.RevokeBefore(t)
+		fmt.Fprintf(w, "Send tokens issued before %s revoked", t.UTC().Format(time.RFC3339Nano))
+	default:
+		http.Error(w, "id or before is required", http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"chatroom/testutil"
+)
+
+// sentID sends message as token's client and returns the new message's ID.
+func sentID(t *testing.T, h http.Handler, token, message string) string {
+	t.Helper()
+	w := do(h, "POST", "/send?format=json&message="+url.QueryEscape(message), tokenHeader, token)
+	var res SendResult
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &res) != nil {
+		t.Fatalf("send: %d %s", w.Code, w.Body)
+	}
+	return res.Message.ID
+}
+
+// Every per-user route acts for the client the send token names; an id that
+// names someone else is refused rather than trusted.
+func TestPerUserRoutesRefuseImpersonation(t *testing.T) {
+	cr, h := newTestRoom(t)
+	ta := join(t, h, "alice")
+	tb := join(t, h, "bob")
+	msgID := sentID(t, h, ta, "hello")
+	routes := []struct{ method, target string }{
+		{"GET", "/quota?id=alice"},
+		{"POST", "/me/bandwidth-cap?id=alice&bytes=100"},
+		{"POST", "/me/language?id=alice&lang=fr"},
+		{"POST", "/me/dnd?id=alice"},
+		{"DELETE", "/me/dnd?id=alice"},
+		{"POST", "/messages/" + msgID + "/report?id=alice&reason=spam"},
+		{"GET", "/messages?wait=0&id=alice"},
+		{"GET", "/embargoes?id=alice"},
+		{"POST", "/leave?id=alice"},
+	}
+	for _, rt := range routes {
+		if w := do(h, rt.method, rt.target, tokenHeader, tb); w.Code != http.StatusForbidden {
+			t.Errorf("%s %s with bob's token: got %d %s, want 403", rt.method, rt.target, w.Code, w.Body)
+		}
+		if w := do(h, rt.method, rt.target); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s without a token: got %d %s, want 401", rt.method, rt.target, w.Code, w.Body)
+		}
+	}
+
+	cr.mutex.Lock()
+	alice, joined := cr.clients["alice"]
+	if joined && (alice.bw.cap != 0 || alice.lang != "" || alice.dnd) {
+		t.Errorf("alice's settings changed: cap %d, lang %q, dnd %v", alice.bw.cap, alice.lang, alice.dnd)
+	}
+	cr.mutex.Unlock()
+	if !joined {
+		t.Fatal("alice was made to leave")
+	}
+	if n := len(cr.Reports()); n != 0 {
+		t.Errorf("%d reports filed in alice's name", n)
+	}
+}
+
+func TestPerUserRoutesActForTheTokenOrAnAdmin(t *testing.T) {
+	cr, h := newTestRoom(t)
+	ta := join(t, h, "alice")
+	if w := do(h, "POST", "/me/language?lang=fr", tokenHeader, ta); w.Code != http.StatusOK {
+		t.Fatalf("set language with alice's token: %d %s", w.Code, w.Body)
+	}
+	if w := do(h, "POST", "/me/bandwidth-cap?id=alice&bytes=100", asAdmin...); w.Code != http.StatusOK {
+		t.Fatalf("set alice's cap as an admin: %d %s", w.Code, w.Body)
+	}
+	q, err := cr.Quota("alice")
+	if err != nil || q.ClientCap != 100 {
+		t.Errorf("alice's quota: %+v, %v; want a 100 byte cap", q, err)
+	}
+	cr.mutex.Lock()
+	lang := cr.clients["alice"].lang
+	cr.mutex.Unlock()
+	if lang != "fr" {
+		t.Errorf("alice's language = %q, want fr", lang)
+	}
+}
+
+// A rotated-out token keeps working for the overlap and no longer.
+func TestRotatedTokenOverlapsBriefly(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	_, h := newTestRoom(t, WithClock(clk))
+	old := join(t, h, "alice")
+	clk.Advance(time.Second)
+	w := do(h, "POST", "/me/rotate", tokenHeader, old)
+	rotated := w.Header().Get(tokenHeader)
+	if w.Code != http.StatusOK || rotated == "" || rotated == old {
+		t.Fatalf("rotate: %d %s, token %q", w.Code, w.Body, rotated)
+	}
+	for name, token := range map[string]string{"old": old, "new": rotated} {
+		if w := send(h, token, "during the overlap"); w.Code != http.StatusOK {
+			t.Errorf("%s token during the overlap: %d %s", name, w.Code, w.Body)
+		}
+	}
+	clk.Advance(tokenOverlap)
+	if w := send(h, old, "too late"); w.Code != http.StatusForbidden {
+		t.Errorf("old token after the overlap: %d, want 403", w.Code)
+	}
+	if w := send(h, rotated, "still fine"); w.Code != http.StatusOK {
+		t.Errorf("new token after the overlap: %d %s", w.Code, w.Body)
+	}
+	if w := do(h, "POST", "/me/rotate", tokenHeader, old); w.Code != http.StatusForbidden {
+		t.Errorf("rotating with an expired token: %d, want 403", w.Code)
+	}
+}
+
+func TestAdminRevokesAClientsSessions(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	cr, h := newTestRoom(t, WithClock(clk))
+	token := join(t, h, "alice")
+	done := pollAsync(t, clk, h, "alice", token, "30")
+	if w := do(h, "POST", "/admin/revoke?id=alice", asAdmin...); w.Code != http.StatusOK {
+		t.Fatalf("revoke: %d %s", w.Code, w.Body)
+	}
+	if w := <-done; w.Code != statusLoginTimeout || !strings.Contains(w.Body.String(), "revoked by an admin") {
+		t.Errorf("pending poll: %d %q, want 440 with the notice", w.Code, w.Body)
+	}
+	if w := send(h, token, "still here?"); w.Code == http.StatusOK {
+		t.Error("a revoked token could still send")
+	}
+	if w := do(h, "POST", "/admin/revoke?id=alice", asAdmin...); w.Code != http.StatusNotFound {
+		t.Errorf("revoking a client that is gone: %d, want 404", w.Code)
+	}
+	if entries := cr.audit.list(); len(entries) == 0 || entries[len(entries)-1].Action != "revoke" {
+		t.Errorf("audit log %+v, want the revoke", entries)
+	}
+	join(t, h, "alice") // The ID is free again
+}
+
+// The revocation epoch invalidates tokens issued before it but leaves the
+// sessions joined.
+func TestRevocationEpoch(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	cr, h := newTestRoom(t, WithClock(clk))
+	before := join(t, h, "alice")
+	clk.Advance(time.Second)
+	if w := do(h, "POST", "/admin/revoke?before=now", asAdmin...); w.Code != http.StatusOK {
+		t.Fatalf("revoke before now: %d %s", w.Code, w.Body)
+	}
+	clk.Advance(time.Second)
+	after := join(t, h, "bob")
+	if w := send(h, before, "old token"); w.Code != http.StatusForbidden {
+		t.Errorf("token issued before the epoch: %d, want 403", w.Code)
+	}
+	if w := send(h, after, "new token"); w.Code != http.StatusOK {
+		t.Errorf("token issued after the epoch: %d %s", w.Code, w.Body)
+	}
+	if n := cr.Stats().Clients; n != 2 {
+		t.Errorf("%d clients after the epoch, want both still joined", n)
+	}
+
+	// An earlier epoch never moves it back.
+	if w := do(h, "POST", "/admin/revoke?before=2020-01-01T00:00:00Z", asAdmin...); w.Code != http.StatusOK {
+		t.Fatalf("revoke before 2020: %d %s", w.Code, w.Body)
+	}
+	if w := send(h, before, "old token"); w.Code != http.StatusForbidden {
+		t.Errorf("old token after an earlier epoch: %d, want 403", w.Code)
+	}
+	for _, q := range []string{"before=yesterday", ""} {
+		if w := do(h, "POST", "/admin/revoke?"+q, asAdmin...); w.Code != http.StatusBadRequest {
+			t.Errorf("revoke with %q: %d, want 400", q, w.Code)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// IDGenerator produces identifiers that are unique for the lifetime of the
+// process. Messages get their ID from the room's generator when published.
+type IDGenerator interface {
+	NewID() string
+}
+
+// WithIDGenerator replaces the default ULID generator. Rooms that should
+// share one ID space must be given the same generator.
+func WithIDGenerator(g IDGenerator) Option {
+	return func(cr *ChatRoom) {
+		if g != nil {
+			cr.ids = g
+		}
+	}
+}
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULIDGenerator returns 26-character ULIDs: a 48-bit millisecond timestamp
+// followed by 80 bits from crypto/rand. IDs made within the same millisecond
+// increment the random part, so they sort in the order they were generated.
+type ULIDGenerator struct {
+	mu     sync.Mutex
+	lastMs uint64
+	lastHi uint16 // top 16 bits of the random part
+	lastLo uint64 // bottom 64 bits of the random part
+}
+
+// defaultIDs is shared by every room that does not set its own generator,
+// keeping message IDs unique and ordered across rooms in one process.
+var defaultIDs = &ULIDGenerator{}
+
+func (g *ULIDGenerator) NewID() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ms := uint64(time.Now().UnixMilli())
+	if ms <= g.lastMs {
+		ms = g.lastMs
+		g.lastLo++
+		if g.lastLo == 0 {
+			g.lastHi++
+		}
+	} else {
+		var r [10]byte
+		if _, err := rand.Read(r[:]); err != nil {
+			panic(fmt.Sprintf("ulid: reading random bytes: %v", err))
+		}
+		g.lastHi = binary.BigEndian.Uint16(r[:2])
+		g.lastLo = binary.BigEndian.Uint64(r[2:])
+	}
+	g.lastMs = ms
+
+	var b [16]byte
+	b[0] = byte(ms >> 40)
+	b[1] = byte(ms >> 32)
+	b[2] = byte(ms >> 24)
+	b[3] = byte(ms >> 16)
+	b[4] = byte(ms >> 8)
+	b[5] = byte(ms)
+	binary.BigEndian.PutUint16(b[6:8], g.lastHi)
+	binary.BigEndian.PutUint64(b[8:], g.lastLo)
+	return encodeULID(b)
+}
+
+// encodeULID writes the 128-bit value as 26 Crockford base32 characters,
+// most significant bits first (the leading character carries 3 bits).
+func encodeULID(b [16]byte) string {
+	hi := binary.BigEndian.Uint64(b[:8])
+	lo := binary.BigEndian.Uint64(b[8:])
+	var out [26]byte
+	for i := 25; i >= 0; i-- {
+		out[i] = crockford[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
+
+// SequentialGenerator returns Prefix followed by 1, 2, 3, ... It is meant for
+// tests that need predictable IDs.
+type SequentialGenerator struct {
+	Prefix string
+
+	mu   sync.Mutex
+	next uint64
+}
+
+func (g *SequentialGenerator) NewID() string {
+	g.mu.Lock()
+	g.next++
+	n := g.next
+	g.mu.Unlock()
+	return fmt.Sprintf("%s%d", g.Prefix, n)
+}
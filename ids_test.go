@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestULIDsAreUniqueAndSortInOrder(t *testing.T) {
+	g := &ULIDGenerator{}
+	prev := ""
+	for i := 0; i < 10000; i++ {
+		id := g.NewID()
+		if len(id) != 26 || strings.Trim(id, crockford) != "" {
+			t.Fatalf("ID %q is not a 26-character ULID", id)
+		}
+		if id <= prev {
+			t.Fatalf("ID %q does not sort after %q", id, prev)
+		}
+		prev = id
+	}
+}
+
+func TestEncodeULIDUsesEveryBit(t *testing.T) {
+	var zero, ones [16]byte
+	for i := range ones {
+		ones[i] = 0xff
+	}
+	if got := encodeULID(zero); got != strings.Repeat("0", 26) {
+		t.Errorf("zero encodes as %q", got)
+	}
+	if got := encodeULID(ones); got != "7"+strings.Repeat("Z", 25) {
+		t.Errorf("all ones encode as %q", got)
+	}
+}
+
+// Rooms given one generator number their messages from one ID space.
+func TestRoomsSharingAGeneratorNeverReuseAMessageID(t *testing.T) {
+	ids := &SequentialGenerator{Prefix: "m"}
+	_, h1 := newTestRoom(t, WithIDGenerator(ids))
+	_, h2 := newTestRoom(t, WithIDGenerator(ids))
+	t1 := join(t, h1, "alice")
+	t2 := join(t, h2, "alice")
+
+	seen := make(map[string]bool)
+	for _, body := range []string{"one", "two", "three"} {
+		for _, id := range []string{sentID(t, h1, t1, body), sentID(t, h2, t2, body)} {
+			if !strings.HasPrefix(id, "m") || seen[id] {
+				t.Errorf("message ID %q is reused or not from the shared generator", id)
+			}
+			seen[id] = true
+		}
+	}
+}
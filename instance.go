@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+const (
+	instanceCookie = "convo_instance"
+	instanceHeader = "X-Convo-Instance"
+)
+
+// WithInstanceID names this server instance. Sessions are local to the
+// instance they joined, and the ID lets a load balancer (or the client) route
+// follow-up requests back to it.
+func WithInstanceID(id string) Option {
+	return func(cr *ChatRoom) {
+		if id != "" {
+			cr.instanceID = id
+		}
+	}
+}
+
+func newInstanceID() string {
+	var b [6]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic("instance id: " + err.Error())
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// markInstance tells the client which instance holds its session, both as a
+// header and as a cookie for browsers and sticky load balancers.
+func (cr *ChatRoom) markInstance(w http.ResponseWriter) {
+	w.Header().Set(instanceHeader, cr.instanceID)
+	http.SetCookie(w, &http.Cookie{
+		Name:     instanceCookie,
+		Value:    cr.instanceID,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// misdirected answers 421 Misdirected Request when the request belongs to a
+// session on another instance, naming that instance so the caller can retry
+// there instead of getting a confusing 404. Requests without the cookie are
+// assumed to be for this instance.
+func (cr *ChatRoom) misdirected(w http.ResponseWriter, r *http.Request) bool {
+	cookie, err := r.Cookie(instanceCookie)
+	if err != nil || cookie.Value == cr.instanceID {
+		return false
+	}
+	w.Header().Set(instanceHeader, cookie.Value)
+	http.Error(w, "Session belongs to instance "+cookie.Value, http.StatusMisdirectedRequest)
+	return true
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// joinOn joins id on h and returns its token and instance cookie.
+func joinOn(t *testing.T, h http.Handler, id string) (string, *http.Cookie) {
+	t.Helper()
+	w := do(h, "POST", "/join?id="+id)
+	if w.Code != http.StatusOK {
+		t.Fatalf("join %s: %d %s", id, w.Code, w.Body)
+	}
+	for _, c := range w.Result().Cookies() {
+		if c.Name == instanceCookie {
+			return w.Header().Get(tokenHeader), c
+		}
+	}
+	t.Fatalf("join %s set no %s cookie", id, instanceCookie)
+	return "", nil
+}
+
+func TestSessionRequestsOnAnotherInstanceAre421(t *testing.T) {
+	_, a := newTestRoom(t, WithInstanceID("a"))
+	_, b := newTestRoom(t, WithInstanceID("b"))
+	token, cookie := joinOn(t, a, "alice")
+	if cookie.Value != "a" {
+		t.Fatalf("cookie names instance %q, want a", cookie.Value)
+	}
+	headers := []string{tokenHeader, token, "Cookie", cookie.String()}
+	for _, req := range []struct{ method, target string }{
+		{"POST", "/send?message=hi"},
+		{"GET", "/messages?wait=0&id=alice"},
+		{"POST", "/leave?id=alice"},
+	} {
+		w := do(b, req.method, req.target, headers...)
+		if w.Code != http.StatusMisdirectedRequest {
+			t.Errorf("%s %s on b: got %d %s, want 421", req.method, req.target, w.Code, w.Body)
+		}
+		if got := w.Header().Get(instanceHeader); got != "a" {
+			t.Errorf("%s %s on b: %s = %q, want a", req.method, req.target, instanceHeader, got)
+		}
+	}
+	// The session on a is untouched by the misdirected leave.
+	if w := do(a, "POST", "/send?message=hi", headers...); w.Code != http.StatusOK {
+		t.Errorf("send on a: %d %s", w.Code, w.Body)
+	}
+	if w := do(a, "POST", "/leave?id=alice", headers...); w.Code != http.StatusOK {
+		t.Errorf("leave on a: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestRequestsWithoutInstanceCookieAreLocal(t *testing.T) {
+	_, a := newTestRoom(t, WithInstanceID("a"))
+	_, b := newTestRoom(t, WithInstanceID("b"))
+	token, _ := joinOn(t, a, "alice")
+	// b has never seen alice, so her token means nothing there.
+	if w := do(b, "GET", "/messages?wait=0&id=alice", tokenHeader, token); w.Code != http.StatusForbidden {
+		t.Errorf("poll on b without a cookie: got %d %s, want 403", w.Code, w.Body)
+	}
+	if w := do(a, "GET", "/messages?wait=0&id=alice", tokenHeader, token); w.Code == http.StatusMisdirectedRequest {
+		t.Errorf("poll on a without a cookie: 421")
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// These tests drive a real server over HTTP from many goroutines; run them
+// with -race.
+
+// liveClient is one chat client talking to a test server.
+type liveClient struct {
+	base, id, token string
+}
+
+func (c *liveClient) call(method, path string, params url.Values) (int, string, error) {
+	if params == nil {
+		params = url.Values{}
+	}
+	if c.id != "" {
+		params.Set("id", c.id)
+	}
+	req, err := http.NewRequest(method, c.base+path+"?"+params.Encode(), nil)
+	if err != nil {
+		return 0, "", err
+	}
+	if c.token != "" {
+		req.Header.Set(tokenHeader, c.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if path == "/join" && resp.StatusCode == http.StatusOK {
+		c.token = resp.Header.Get(tokenHeader)
+	}
+	return resp.StatusCode, string(body), err
+}
+
+func (c *liveClient) send(message string) (int, string, error) {
+	return c.call("POST", "/send", url.Values{"message": {message}})
+}
+
+func (c *liveClient) poll() (int, string, error) {
+	return c.call("GET", "/messages", url.Values{"wait": {"0"}})
+}
+
+func newLiveServer(t *testing.T, opts ...Option) (*ChatRoom, string) {
+	t.Helper()
+	cr, h := newTestRoom(t, opts...)
+	srv := httptest.NewServer(h)
+	// Registered after newTestRoom's, so it runs first: the room closes
+	// before the server waits for open long polls.
+	t.Cleanup(srv.Close)
+	return cr, srv.URL
+}
+
+// errorsOf collects unexpected outcomes from many goroutines.
+type errorsOf struct {
+	mu   sync.Mutex
+	errs []string
+}
+
+func (e *errorsOf) add(format string, args ...interface{}) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.errs) < 20 {
+		e.errs = append(e.errs, fmt.Sprintf(format, args...))
+	}
+}
+
+func (e *errorsOf) report(t *testing.T) {
+	for _, err := range e.errs {
+		t.Error(err)
+	}
+}
+
+func TestConcurrentJoinSendLeavePoll(t *testing.T) {
+	_, base := newLiveServer(t)
+	const clients, sends = 20, 20
+	var bad errorsOf
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c := &liveClient{base: base, id: fmt.Sprintf("user%d", i)}
+			if code, body, err := c.call("POST", "/join", nil); err != nil || code != http.StatusOK {
+				bad.add("join %s: %d %s %v", c.id, code, body, err)
+				return
+			}
+			left := make(chan struct{})
+			polled := make(chan struct{})
+			go func() {
+				defer close(polled)
+				for {
+					code, body, err := c.poll()
+					switch {
+					case err != nil:
+						bad.add("poll %s: %v", c.id, err)
+						return
+					case code == http.StatusOK, code == http.StatusGatewayTimeout:
+					case code == http.StatusForbidden, code == http.StatusGone:
+						select {
+						case <-left:
+						default:
+							bad.add("poll %s before leaving: %d %s", c.id, code, body)
+						}
+						return
+					default:
+						bad.add("poll %s: %d %s", c.id, code, body)
+						return
+					}
+				}
+			}()
+			for j := 0; j < sends; j++ {
+				if code, body, err := c.send(fmt.Sprintf("%s-%d", c.id, j)); err != nil || code != http.StatusOK {
+					bad.add("send %s: %d %s %v", c.id, code, body, err)
+				}
+			}
+			close(left)
+			if code, body, err := c.call("POST", "/leave", nil); err != nil || code != http.StatusOK {
+				bad.add("leave %s: %d %s %v", c.id, code, body, err)
+			}
+			if code, body, _ := c.send("after leaving"); code == http.StatusOK {
+				bad.add("send %s after leaving succeeded: %s", c.id, body)
+			}
+			<-polled
+		}(i)
+	}
+	wg.Wait()
+	bad.report(t)
+}
+
+// Clients leaving in the middle of a burst neither lose the burst for anyone
+// else nor break the broadcast.
+func TestLeaveDuringBroadcast(t *testing.T) {
+	const burst = 500
+	cr, base := newLiveServer(t, WithSessionQueue(2*burst))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watch, err := cr.Subscribe(ctx, "watcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender := &liveClient{base: base, id: "sender"}
+	if code, body, err := sender.call("POST", "/join", nil); err != nil || code != http.StatusOK {
+		t.Fatalf("join: %d %s %v", code, body, err)
+	}
+	var leavers []*liveClient
+	for i := 0; i < 10; i++ {
+		c := &liveClient{base: base, id: fmt.Sprintf("leaver%d", i)}
+		if code, body, err := c.call("POST", "/join", nil); err != nil || code != http.StatusOK {
+			t.Fatalf("join: %d %s %v", code, body, err)
+		}
+		leavers = append(leavers, c)
+	}
+
+	var bad errorsOf
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < burst; i++ {
+			if code, body, err := sender.send(fmt.Sprint(i)); err != nil || code != http.StatusOK {
+				bad.add("send %d: %d %s %v", i, code, body, err)
+			}
+		}
+	}()
+	for _, c := range leavers {
+		wg.Add(1)
+		go func(c *liveClient) {
+			defer wg.Done()
+			c.poll()
+			if code, body, err := c.call("POST", "/leave", nil); err != nil || code != http.StatusOK {
+				bad.add("leave %s: %d %s %v", c.id, code, body, err)
+			}
+		}(c)
+	}
+	wg.Wait()
+	bad.report(t)
+
+	next := 0
+	timeout := time.After(10 * time.Second)
+	for next < burst {
+		select {
+		case m := <-watch:
+			if m.From != "sender" {
+				continue
+			}
+			if m.Body != fmt.Sprint(next) {
+				t.Fatalf("watcher got %q, want %d", m.Body, next)
+			}
+			next++
+		case <-timeout:
+			t.Fatalf("watcher got %d of %d messages", next, burst)
+		}
+	}
+}
+
+// A send racing just behind the sender's own leave is refused and reaches
+// no one.
+func TestSendRightAfterLeave(t *testing.T) {
+	cr, base := newLiveServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watch, err := cr.Subscribe(ctx, "watcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := 0
+	for i := 0; i < 20; i++ {
+		c := &liveClient{base: base, id: fmt.Sprintf("user%d", i)}
+		if code, body, err := c.call("POST", "/join", nil); err != nil || code != http.StatusOK {
+			t.Fatalf("join: %d %s %v", code, body, err)
+		}
+		left := make(chan int)
+		go func() {
+			code, _, _ := c.call("POST", "/leave", nil)
+			left <- code
+		}()
+		code, body, _ := c.send("ghost")
+		if leaveCode := <-left; leaveCode != http.StatusOK {
+			t.Fatalf("leave: %d", leaveCode)
+		}
+		if code == http.StatusOK {
+			accepted++ // The send won the race; it was sent while joined
+			continue
+		}
+		if code != http.StatusForbidden && code != http.StatusConflict && code != http.StatusNotFound {
+			t.Errorf("send racing leave: %d %s", code, body)
+		}
+		if code, body, _ := c.send("ghost"); code != http.StatusForbidden {
+			t.Errorf("send after leave: got %d %s, want %d", code, body, http.StatusForbidden)
+		}
+	}
+	// The broadcast queue is FIFO, so every accepted send is ahead of this.
+	if err := cr.Publish(Message{From: systemSender, Body: "marker"}); err != nil {
+		t.Fatal(err)
+	}
+	delivered := 0
+	for m := range watch {
+		if m.Body == "marker" {
+			break
+		}
+		if m.Body == "ghost" {
+			delivered++
+		}
+	}
+	if delivered != accepted {
+		t.Errorf("%d sends accepted but %d delivered", accepted, delivered)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/bits"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	challengeTTL = 2 * time.Minute
+	// Joins per minute above which the proof-of-work difficulty grows by a
+	// bit per doubling, up to maxExtraDifficulty bits.
+	joinRateThreshold  = 30
+	maxExtraDifficulty = 8
+	maxDifficulty      = 32
+)
+
+var (
+	errChallengeRequired = errors.New("proof of work required: GET /join/challenge, then send challenge and nonce with /join")
+	errBadChallenge      = errors.New("challenge is invalid or expired")
+	errBadProof          = errors.New("nonce does not solve the challenge")
+	errInviteRequired    = errors.New("a valid invite code is required")
+)
+
+// JoinGate holds the optional checks a join must pass before it is
+// registered. Admin joins skip them.
+type JoinGate struct {
+	// Difficulty is the number of leading zero bits required of
+	// SHA-256(challenge ":" id ":" nonce); 0 disables proof of work.
+	Difficulty int
+	// Secret keys the challenge HMAC. Challenges stay valid across restarts
+	// only if it is set to the same value.
+	Secret []byte
+	// Invites, when non-empty, are the codes accepted in invite=.
+	Invites []string
+}
+
+// WithJoinGate enables proof-of-work and/or invite checks on /join.
+func WithJoinGate(g JoinGate) Option {
+	return func(cr *ChatRoom) {
+		if g.Difficulty > 0 && len(g.Secret) == 0 {
+			g.Secret = make([]byte, 32)
+			if _, err := rand.Read(g.Secret); err != nil {
+				panic("join gate secret: " + err.Error())
+			}
+		}
+		cr.joinGate = g
+	}
+}
+
+// joinRate counts joins in the current and previous minute to scale the
+// proof-of-work difficulty.
+type joinRate struct {
+	mu       sync.Mutex
+	minute   int64
+	current  int
+	previous int
+}
+
+func (jr *joinRate) add(now time.Time) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	jr.roll(now)
+	jr.current++
+}
+
+func (jr *joinRate) perMinute(now time.Time) int {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	jr.roll(now)
+	return max(jr.current, jr.previous)
+}
+
+func (jr *joinRate) roll(now time.Time) {
+	m := now.Unix() / 60
+	switch {
+	case m == jr.minute:
+	case m == jr.minute+1:
+		jr.minute, jr.previous, jr.current = m, jr.current, 0
+	default:
+		jr.minute, jr.previous, jr.current = m, 0, 0
+	}
+}
+
+// difficulty is the configured difficulty plus a bit for every doubling of
+// the join rate past joinRateThreshold.
+func (cr *ChatRoom) difficulty() int {
+	d := cr.joinGate.Difficulty
+	for rate := cr.joins.perMinute(cr.clock.Now()); rate > joinRateThreshold && d-cr.joinGate.Difficulty < maxExtraDifficulty; rate /= 2 {
+		d++
+	}
+	return min(d, maxDifficulty)
+}
+
+// Challenge is the body of /join/challenge.
+type Challenge struct {
+	Challenge  string    `json:"challenge"`
+	Difficulty int       `json:"difficulty"`
+	Expires    time.Time `json:"expires"`
+}
+
+// newChallenge issues "<payload>.<mac>" where payload encodes the expiry,
+// the difficulty and a random nonce. Verifying it needs only the secret.
+func (cr *ChatRoom) newChallenge() Challenge {
+	expires := cr.clock.Now().Add(challengeTTL).Truncate(time.Second)
+	d := cr.difficulty()
+	var payload [8 + 1 + 16]byte
+	binary.BigEndian.PutUint64(payload[:8], uint64(expires.Unix()))
+	payload[8] = byte(d)
+	if _, err := rand.Read(payload[9:]); err != nil {
+		panic("join challenge: " + err.Error())
+	}
+	p := base64.RawURLEncoding.EncodeToString(payload[:])
+	return Challenge{Challenge: p + "." + cr.challengeMAC(p), Difficulty: d, Expires: expires}
+}
+
+func (cr *ChatRoom) challengeMAC(payload string) string {
+	mac := hmac.New(sha256.New, cr.joinGate.Secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// checkProof verifies that nonce solves challenge for clientID.
+func (cr *ChatRoom) checkProof(challenge, clientID, nonce string) error {
+	if challenge == "" || nonce == "" {
+		return errChallengeRequired
+	}
+	p, mac, ok := strings.Cut(challenge, ".")
+	if !ok || !hmac.Equal([]byte(mac), []byte(cr.challengeMAC(p))) {
+		return errBadChallenge
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(p)
+	if err != nil || len(payload) != 8+1+16 {
+		return errBadChallenge
+	}
+	if cr.clock.Now().Unix() > int64(binary.BigEndian.Uint64(payload[:8])) {
+		return errBadChallenge
+	}
+	sum := sha256.Sum256([]byte(challenge + ":" + clientID + ":" + nonce))
+	if leadingZeroBits(sum[:]) < int(payload[8]) {
+		return errBadProof
+	}
+	return nil
+}
+
+func leadingZeroBits(b []byte) int {
+	n := 0
+	for _, c := range b {
+		if c != 0 {
+			return n + bits.LeadingZeros8(c)
+		}
+		n += 8
+	}
+	return n
+}
+
+func (cr *ChatRoom) validInvite(code string) bool {
+	ok := 0
+	for _, inv := range cr.joinGate.Invites {
+		ok |= subtle.ConstantTimeCompare([]byte(code), []byte(inv))
+	}
+	return code != "" && ok == 1
+}
+
+// checkJoinGate runs the configured join checks and answers the request
+// when one fails.
+func (cr *ChatRoom) checkJoinGate(w http.ResponseWriter, r *http.Request, clientID string) bool {
+	g := cr.joinGate
+	if g.Difficulty == 0 && len(g.Invites) == 0 || cr.isAdmin(r) {
+		return true
+	}
+	q := r.URL.Query()
+	if len(g.Invites) > 0 && !cr.validInvite(q.Get("invite")) {
+		http.Error(w, errInviteRequired.Error(), http.StatusForbidden)
+		return false
+	}
+	if g.Difficulty > 0 {
+		switch err := cr.checkProof(q.Get("challenge"), clientID, q.Get("nonce")); err {
+		case nil:
+		case errChallengeRequired:
+			http.Error(w, err.Error(), http.StatusPreconditionRequired)
+			return false
+		default:
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return false
+		}
+	}
+	return true
+}
+
+func (cr *ChatRoom) HandleJoinChallenge(w http.ResponseWriter, r *http.Request) {
+	if cr.joinGate.Difficulty == 0 {
+		http.Error(w, "Proof of work is not required on this server", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(cr.newChallenge())
+}
+
+// parseInvites splits a comma-separated list of invite codes.
+func parseInvites(s string) []string {
+	var out []string
+	for _, code := range strings.Split(s, ",") {
+		if code = strings.TrimSpace(code); code != "" {
+			out = append(out, code)
+		}
+	}
+	return out
+}
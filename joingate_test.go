@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"chatroom/testutil"
+)
+
+func challenge(t *testing.T, h http.Handler) Challenge {
+	t.Helper()
+	var c Challenge
+	if w := do(h, "GET", "/join/challenge"); w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &c) != nil {
+		t.Fatalf("challenge: %d %s", w.Code, w.Body)
+	}
+	return c
+}
+
+// solve finds a nonce that solves c for id and, so the test cannot pass by
+// luck, not for notFor.
+func solve(c Challenge, id, notFor string) string {
+	solves := func(id, nonce string) bool {
+		sum := sha256.Sum256([]byte(c.Challenge + ":" + id + ":" + nonce))
+		return leadingZeroBits(sum[:]) >= c.Difficulty
+	}
+	for n := 0; ; n++ {
+		nonce := strconv.Itoa(n)
+		if solves(id, nonce) && !solves(notFor, nonce) {
+			return nonce
+		}
+	}
+}
+
+func joinWithProof(h http.Handler, id, challenge, nonce string) int {
+	q := url.Values{"id": {id}, "challenge": {challenge}, "nonce": {nonce}}
+	return do(h, "POST", "/join?"+q.Encode()).Code
+}
+
+func TestProofOfWorkGatesJoins(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	gate := JoinGate{Difficulty: 8, Secret: []byte("shared secret")}
+	_, h := newTestRoom(t, WithClock(clk), WithJoinGate(gate))
+	if w := do(h, "POST", "/join?id=alice"); w.Code != http.StatusPreconditionRequired {
+		t.Errorf("join without a proof: %d, want 428", w.Code)
+	}
+	c := challenge(t, h)
+	if c.Difficulty != 8 || !c.Expires.Equal(clk.Now().Add(challengeTTL)) {
+		t.Errorf("challenge %+v, want difficulty 8 expiring in %s", c, challengeTTL)
+	}
+	nonce := solve(c, "alice", "bob")
+	if code := joinWithProof(h, "bob", c.Challenge, nonce); code != http.StatusForbidden {
+		t.Errorf("alice's proof used by bob: %d, want 403", code)
+	}
+	if code := joinWithProof(h, "alice", c.Challenge+"x", nonce); code != http.StatusForbidden {
+		t.Errorf("tampered challenge: %d, want 403", code)
+	}
+	if code := joinWithProof(h, "alice", c.Challenge, nonce); code != http.StatusOK {
+		t.Errorf("solved challenge: %d, want 200", code)
+	}
+	if w := do(h, "POST", "/join?id=operator", asAdmin...); w.Code != http.StatusOK {
+		t.Errorf("admin join without a proof: %d", w.Code)
+	}
+
+	// Verification is stateless: another instance with the same secret
+	// accepts the challenge, until it expires.
+	_, other := newTestRoom(t, WithClock(clk), WithJoinGate(gate))
+	late := solve(c, "carol", "bob")
+	clk.Advance(challengeTTL + time.Second)
+	if code := joinWithProof(other, "carol", c.Challenge, late); code != http.StatusForbidden {
+		t.Errorf("expired challenge: %d, want 403", code)
+	}
+	c = challenge(t, h)
+	if code := joinWithProof(other, "carol", c.Challenge, solve(c, "carol", "bob")); code != http.StatusOK {
+		t.Errorf("challenge from another instance with the same secret: %d, want 200", code)
+	}
+}
+
+func TestProofOfWorkDifficultyScalesWithTheJoinRate(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 0, 0, time.UTC))
+	cr, h := newTestRoom(t, WithClock(clk), WithJoinGate(JoinGate{Difficulty: 4}))
+	// Past 30, 60 and 120 joins a minute: three extra bits.
+	const flood = 4*joinRateThreshold + 4
+	for i := 0; i < flood; i++ {
+		cr.joins.add(clk.Now())
+	}
+	if d := challenge(t, h).Difficulty; d != 7 {
+		t.Errorf("difficulty at %d joins a minute: %d, want 7", flood, d)
+	}
+	clk.Advance(time.Minute)
+	if d := challenge(t, h).Difficulty; d != 7 {
+		t.Errorf("difficulty the minute after the flood: %d, want it still raised", d)
+	}
+	clk.Advance(time.Minute)
+	if d := challenge(t, h).Difficulty; d != 4 {
+		t.Errorf("difficulty two quiet minutes later: %d, want 4", d)
+	}
+}
+
+func TestInvitesGateJoins(t *testing.T) {
+	_, h := newTestRoom(t, WithJoinGate(JoinGate{Invites: parseInvites(" early-bird, friends ")}))
+	for invite, want := range map[string]int{"": http.StatusForbidden, "stranger": http.StatusForbidden, "friends": http.StatusOK} {
+		if w := do(h, "POST", "/join?id=alice&invite="+invite); w.Code != want {
+			t.Errorf("join with invite %q: %d, want %d", invite, w.Code, want)
+		}
+	}
+	if w := do(h, "GET", "/join/challenge"); w.Code != http.StatusNotFound {
+		t.Errorf("challenge without proof of work: %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	messageTypeKeyword = "keyword"
+	maxKeywords        = 20
+	maxKeywordLength   = 64
+	maxKeywordNotices  = 16 // Per session, awaiting its next poll
+)
+
+var (
+	errTooManyKeywords = errors.New("too many keywords")
+	errBadKeyword      = errors.New("keywords must be non-empty text of at most 64 bytes")
+)
+
+// keywordWatch is a client's keyword list and the pattern it compiles to.
+// Guarded by ChatRoom.mutex.
+type keywordWatch struct {
+	words   []string
+	pattern *regexp.Regexp // Whole-word, case-insensitive match of any word; nil when words is empty
+}
+
+func (k *keywordWatch) compile() {
+	k.pattern = nil
+	if len(k.words) == 0 {
+		return
+	}
+	quoted := make([]string, len(k.words))
+	for i, w := range k.words {
+		quoted[i] = regexp.QuoteMeta(w)
+	}
+	k.pattern = regexp.MustCompile(`(?i)(?:^|\W)(` + strings.Join(quoted, "|") + `)(?:\W|$)`)
+}
+
+// match returns the first watched keyword in body, as the client wrote it.
+func (k *keywordWatch) match(body string) (string, bool) {
+	if k.pattern == nil {
+		return "", false
+	}
+	m := k.pattern.FindStringSubmatch(body)
+	if m == nil {
+		return "", false
+	}
+	for _, w := range k.words {
+		if strings.EqualFold(w, m[1]) {
+			return w, true
+		}
+	}
+	return m[1], true
+}
+
+// AddKeyword adds word to clientID's watch list. Adding a word already on
+// it is not an error.
+func (cr *ChatRoom) AddKeyword(clientID, word string) error {
+	word = strings.TrimSpace(word)
+	if word == "" || len(word) > maxKeywordLength || validateMessage(word) != nil {
+		return errBadKeyword
+	}
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	c, exists := cr.clients[clientID]
+	if !exists {
+		return errClientNotFound
+	}
+	for _, w := range c.keywords.words {
+		if strings.EqualFold(w, word) {
+			return nil
+		}
+	}
+	if len(c.keywords.words) >= maxKeywords {
+		return errTooManyKeywords
+	}
+	c.keywords.words = append(c.keywords.words, word)
+	c.keywords.compile()
+	return nil
+}
+
+func (cr *ChatRoom) RemoveKeyword(clientID, word string) error {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	c, exists := cr.clients[clientID]
+	if !exists {
+		return errClientNotFound
+	}
+	for i, w := range c.keywords.words {
+		if strings.EqualFold(w, word) {
+			c.keywords.words = append(c.keywords.words[:i:i], c.keywords.words[i+1:]...)
+			c.keywords.compile()
+			return nil
+		}
+	}
+	return nil
+}
+
+func (cr *ChatRoom) Keywords(clientID string) ([]string, error) {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	c, exists := cr.clients[clientID]
+	if !exists {
+		return nil, errClientNotFound
+	}
+	return append([]string{}, c.keywords.words...), nil
+}
+
+// notifyKeywordLocked raises a keyword event for owner when a broadcast
+// chat message from someone else matches owner's watch list. Ephemeral
+// messages never reach here, so only messages the watcher receives anyway
+// are scanned. A poller is not waiting again right after the message it
+// matched, so polling sessions queue the event for their next poll;
+// in-process ones get it at once.
+func (cr *ChatRoom) notifyKeywordLocked(ownerID string, owner *client, msg Message, now time.Time) {
+	if msg.Type != "" || msg.From == ownerID || msg.From == systemSender || msg.opaque() {
+		return
+	}
+	word, ok := owner.keywords.match(msg.Body)
+	if !ok {
+		return
+	}
+	n := Message{
+		ID:    cr.ids.NewID(),
+		From:  systemSender,
+		Body:  fmt.Sprintf("%s mentioned %q", msg.From, word),
+		Time:  now,
+		Type:  messageTypeKeyword,
+		RefID: msg.ID,
+	}
+	n.line = formatLine(n)
+	for _, s := range append([]*client{owner}, owner.extra...) {
+		switch {
+		case !s.can(capKeywords):
+		case s.transport == transportInproc:
+			cr.sendTo(s, n)
+		case len(s.notices) < maxKeywordNotices:
+			s.notices = append(s.notices, n)
+		}
+	}
+}
+
+// keywordNotice pops the oldest keyword event queued for session c.
+func (cr *ChatRoom) keywordNotice(c *client) (Message, bool) {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	if len(c.notices) == 0 {
+		return Message{}, false
+	}
+	n := c.notices[0]
+	c.notices = c.notices[1:]
+	return n, true
+}
+
+// HandleKeywords lists the caller's watched keywords with GET /me/keywords,
+// adds one with POST ?keyword=<word> and removes one with DELETE
+// ?keyword=<word>. Matching is case-insensitive and on whole words.
+func (cr *ChatRoom) HandleKeywords(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodPost, http.MethodDelete:
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID, ok := cr.sender(w, r)
+	if !ok {
+		return
+	}
+	word := r.URL.Query().Get("keyword")
+	if r.Method != http.MethodGet && word == "" {
+		http.Error(w, "Keyword is required", http.StatusBadRequest)
+		return
+	}
+	var err error
+	switch r.Method {
+	case http.MethodPost:
+		err = cr.AddKeyword(clientID, word)
+	case http.MethodDelete:
+		err = cr.RemoveKeyword(clientID, word)
+	}
+	var words []string
+	if err == nil {
+		words, err = cr.Keywords(clientID)
+	}
+	switch err {
+	case nil:
+	case errClientNotFound:
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	case errTooManyKeywords:
+		http.Error(w, fmt.Sprintf("At most %d keywords can be watched", maxKeywords), http.StatusConflict)
+		return
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{"keywords": words})
+}
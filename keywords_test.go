@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"chatroom/testutil"
+)
+
+func TestKeywordsMatchWholeWordsInAnyCase(t *testing.T) {
+	k := keywordWatch{words: []string{"deploy", "Production"}}
+	k.compile()
+	for body, want := range map[string]string{
+		"PRODUCTION is down":     "Production",
+		"time to re-deploy.":     "deploy",
+		"deploying now":          "",
+		"see deploy(1)":          "deploy",
+		"nothing to watch here":  "",
+		"preproduction is fine!": "",
+	} {
+		if got, _ := k.match(body); got != want {
+			t.Errorf("match(%q) = %q, want %q", body, got, want)
+		}
+	}
+}
+
+func TestKeywordWatchLists(t *testing.T) {
+	_, h := newTestRoom(t)
+	token := join(t, h, "bob")
+	list := func(method, keyword string) (int, []string) {
+		w := do(h, method, "/me/keywords?keyword="+keyword, tokenHeader, token)
+		var res map[string][]string
+		json.Unmarshal(w.Body.Bytes(), &res)
+		return w.Code, res["keywords"]
+	}
+	list("POST", "deploy")
+	if code, words := list("POST", "DEPLOY"); code != http.StatusOK || len(words) != 1 {
+		t.Errorf("adding a keyword twice: %d %v, want it listed once", code, words)
+	}
+	if code, _ := list("POST", strings.Repeat("x", maxKeywordLength+1)); code != http.StatusBadRequest {
+		t.Errorf("overlong keyword: %d, want 400", code)
+	}
+	for i := 1; i < maxKeywords; i++ {
+		list("POST", "word"+strings.Repeat("x", i))
+	}
+	if code, _ := list("POST", "onetoomany"); code != http.StatusConflict {
+		t.Errorf("keyword %d: %d, want 409", maxKeywords+1, code)
+	}
+	if code, words := list("DELETE", "Deploy"); code != http.StatusOK || len(words) != maxKeywords-1 || words[0] == "deploy" {
+		t.Errorf("after removing deploy: %d %v", code, words)
+	}
+	if code, words := list("GET", ""); code != http.StatusOK || len(words) != maxKeywords-1 {
+		t.Errorf("list: %d %v", code, words)
+	}
+}
+
+// A matching message from someone else raises a keyword event for
+// sessions that declared keywords, after the message itself is delivered.
+func TestKeywordMatchesNotifyTheWatcher(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	_, h := newTestRoom(t, WithClock(clk))
+	ta := join(t, h, "alice")
+	w := do(h, "POST", "/join?id=bob&capabilities=keywords")
+	if w.Code != http.StatusOK {
+		t.Fatalf("join: %d %s", w.Code, w.Body)
+	}
+	tb := w.Header().Get(tokenHeader)
+	tc := join(t, h, "carol")
+	for _, token := range []string{tb, tc} {
+		if w := do(h, "POST", "/me/keywords?keyword=deploy", tokenHeader, token); w.Code != http.StatusOK {
+			t.Fatalf("watch: %d %s", w.Code, w.Body)
+		}
+	}
+	send(h, tb, "I will deploy") // A sender's own messages never match
+	id := sentID(t, h, ta, "Deploy is done")
+
+	var m Message
+	if err := json.Unmarshal(pollFrom(t, h, "bob", tb, systemSender), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Type != messageTypeKeyword || m.RefID != id || m.Body != `alice mentioned "deploy"` {
+		t.Errorf("bob's keyword event %+v", m)
+	}
+
+	// carol did not declare keywords and gets only the messages.
+	send(h, ta, "after")
+	for _, want := range []string{"bob: I will deploy\n", "alice: Deploy is done\n", "alice: after\n"} {
+		if w := pollOnce(h, "carol", tc); w.Body.String() != want {
+			t.Errorf("carol's poll: %d %q, want %q", w.Code, w.Body, want)
+		}
+	}
+}
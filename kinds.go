@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	kindText         = "text"
+	maxKindLength    = 32
+	maxPayloadLength = maxMessageLength
+)
+
+var (
+	errUnknownKind    = errors.New("unknown message kind")
+	errInvalidKind    = errors.New("kind must be 1-32 lowercase letters, digits, '-' or '_'")
+	errInvalidPayload = errors.New("payload must be a JSON object")
+)
+
+// KindValidator checks the payload of a message of one kind. The payload is
+// passed through to clients unchanged, so the validator must not rely on
+// being able to rewrite it.
+type KindValidator func(payload json.RawMessage) error
+
+// RegisterKind adds or replaces the structured message kind name. Messages
+// sent with that kind must carry a payload the validator accepts.
+func (cr *ChatRoom) RegisterKind(name string, v KindValidator) {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	cr.kinds[name] = v
+}
+
+// WithArbitraryKinds accepts kinds that are not registered, checking only
+// that their payload is a JSON object.
+func WithArbitraryKinds(allow bool) Option {
+	return func(cr *ChatRoom) {
+		cr.anyKind = allow
+	}
+}
+
+func (cr *ChatRoom) registerBuiltinKinds() {
+	cr.kinds = make(map[string]KindValidator)
+	cr.RegisterKind(kindCode, validateCode)
+	cr.RegisterKind("location", validateLocation)
+	cr.RegisterKind(kindPoll, validatePoll)
+	cr.RegisterKind(kindE2EE, validateE2EE)
+}
+
+// validateKind checks kind and payload as given to /send. The text kind is
+// the plain message and takes no payload.
+func (cr *ChatRoom) validateKind(kind string, payload json.RawMessage) error {
+	if kind == "" || kind == kindText {
+		if len(payload) != 0 {
+			return errors.New("text messages take no payload")
+		}
+		return nil
+	}
+	if !validKindName(kind) {
+		return errInvalidKind
+	}
+	limit := maxPayloadLength
+	if kind == kindCode {
+		limit = maxCodePayloadLength
+	}
+	if len(payload) > limit {
+		return errMessageTooLong
+	}
+	if p := bytes.TrimSpace(payload); len(p) == 0 || p[0] != '{' || !utf8.Valid(p) || !json.Valid(p) {
+		return errInvalidPayload
+	}
+	cr.mutex.Lock()
+	v, ok := cr.kinds[kind]
+	anyKind := cr.anyKind
+	cr.mutex.Unlock()
+	if !ok {
+		if anyKind {
+			return nil
+		}
+		return fmt.Errorf("%w %q; available kinds: %s", errUnknownKind, kind, cr.availableKinds())
+	}
+	return v(payload)
+}
+
+func (cr *ChatRoom) availableKinds() string {
+	cr.mutex.Lock()
+	names := []string{kindText}
+	for name := range cr.kinds {
+		names = append(names, name)
+	}
+	cr.mutex.Unlock()
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func validKindName(kind string) bool {
+	if kind == "" || len(kind) > maxKindLength {
+		return false
+	}
+	for i := 0; i < len(kind); i++ {
+		c := kind[i]
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+func validateCode(payload json.RawMessage) error {
+	var p struct {
+		Language *string `json:"language"`
+		Code     *string `json:"code"`
+	}
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return errInvalidPayload
+	}
+	if p.Code == nil {
+		return errors.New("code payload needs a code field")
+	}
+	if p.Language == nil || *p.Language == "" || len(*p.Language) > maxKindLength {
+		return errors.New("code payload needs a language field of at most 32 characters")
+	}
+	return nil
+}
+
+func validateLocation(payload json.RawMessage) error {
+	var p struct {
+		Lat *float64 `json:"lat"`
+		Lng *float64 `json:"lng"`
+	}
+	if err := json.Unmarshal(payload, &p); err != nil || p.Lat == nil || p.Lng == nil {
+		return errors.New("location payload needs numeric lat and lng fields")
+	}
+	if *p.Lat < -90 || *p.Lat > 90 || *p.Lng < -180 || *p.Lng > 180 {
+		return errors.New("lat must be within ±90 and lng within ±180")
+	}
+	return nil
+}
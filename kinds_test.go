@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"chatroom/testutil"
+)
+
+func sendKind(h http.Handler, token, kind, payload string) (int, string) {
+	q := url.Values{"message": {"fallback"}, "kind": {kind}}
+	if payload != "" {
+		q.Set("payload", payload)
+	}
+	w := do(h, "POST", "/send?"+q.Encode(), tokenHeader, token)
+	return w.Code, w.Body.String()
+}
+
+func TestBuiltinKindsValidateTheirPayloads(t *testing.T) {
+	_, h := newTestRoom(t)
+	token := join(t, h, "alice")
+	for _, tc := range []struct {
+		kind, payload string
+		ok            bool
+	}{
+		{"code", `{"language": "go", "code": "package main"}`, true},
+		{"code", `{"code": "package main"}`, false},
+		{"location", `{"lat": 51.5, "lng": -0.12}`, true},
+		{"location", `{"lat": 91, "lng": 0}`, false},
+		{"location", `{"lat": "north", "lng": 0}`, false},
+		{"location", `[51.5, -0.12]`, false},
+		{"text", `{"extra": true}`, false},
+		{"Shout", `{}`, false},
+		{"sticker", `{"id": 7}`, false},
+	} {
+		code, body := sendKind(h, token, tc.kind, tc.payload)
+		if got := code == http.StatusOK; got != tc.ok {
+			t.Errorf("kind %s with %s: %d %q, want accepted=%v", tc.kind, tc.payload, code, body, tc.ok)
+		}
+	}
+	if _, body := sendKind(h, token, "sticker", `{"id": 7}`); !strings.Contains(body, "code, e2ee, location, poll, text") {
+		t.Errorf("unknown kind error %q does not list the available kinds", body)
+	}
+}
+
+// Embedders can register their own kinds, rooms can accept any kind, and
+// a validated payload reaches receivers that understand kinds unchanged.
+func TestCustomKindsAndPayloadPassThrough(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	cr, h := newTestRoom(t, WithClock(clk))
+	cr.RegisterKind("sticker", func(p json.RawMessage) error {
+		if !bytes.Contains(p, []byte(`"id"`)) {
+			return errors.New("sticker needs an id")
+		}
+		return nil
+	})
+	ta := join(t, h, "alice")
+	w := do(h, "POST", "/join?id=bob&capabilities=kinds")
+	if w.Code != http.StatusOK {
+		t.Fatalf("join with capabilities: %d %s", w.Code, w.Body)
+	}
+	tb := w.Header().Get(tokenHeader)
+
+	if code, body := sendKind(h, ta, "sticker", `{"name": "cat"}`); code != http.StatusBadRequest || !strings.Contains(body, "sticker needs an id") {
+		t.Errorf("sticker without an id: %d %q", code, body)
+	}
+	payload := `{"id": 7, "pack": {"name": "cats", "animated": false}}`
+	if code, body := sendKind(h, ta, "sticker", payload); code != http.StatusOK {
+		t.Fatalf("sticker: %d %s", code, body)
+	}
+	var m Message
+	if err := json.Unmarshal(pollFrom(t, h, "bob", tb, "alice"), &m); err != nil {
+		t.Fatal(err)
+	}
+	var want bytes.Buffer
+	json.Compact(&want, []byte(payload))
+	if m.Kind != "sticker" || string(m.Payload) != want.String() || m.Body != "fallback" {
+		t.Errorf("bob received kind %q payload %s body %q", m.Kind, m.Payload, m.Body)
+	}
+
+	_, open := newTestRoom(t, WithArbitraryKinds(true))
+	token := join(t, open, "alice")
+	if code, body := sendKind(open, token, "anything-goes", `{"x": 1}`); code != http.StatusOK {
+		t.Errorf("unregistered kind in a room that allows any: %d %s", code, body)
+	}
+	if code, _ := sendKind(open, token, "anything-goes", `"just a string"`); code != http.StatusBadRequest {
+		t.Errorf("non-object payload in a room that allows any kind: %d, want 400", code)
+	}
+}
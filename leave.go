@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+const defaultLeaveGrace = 2 * time.Second
+
+var errClientDeparting = errors.New("client is leaving")
+
+// WithLeaveGrace sets how long a client that left keeps receiving
+// deliveries that were queued before it left. Its ID cannot be joined again
+// until then. Zero closes clients as soon as they leave.
+func WithLeaveGrace(d time.Duration) Option {
+	return func(cr *ChatRoom) {
+		if d >= 0 {
+			cr.leaveGrace = d
+		}
+	}
+}
+
+// departLocked unregisters clientID. With a grace period the client becomes
+// a tombstone: it takes no new sends, but a marker queued behind everything
+// already in the broadcast queue closes it once those messages have been
+// delivered, or the grace period closes it if the marker cannot be queued
+// or is slow to arrive.
+func (cr *ChatRoom) departLocked(clientID string, c *client) {
+	delete(cr.clients, clientID)
+	delete(cr.recentBodies, clientID)
+	cr.rosterChangedLocked()
+	cr.recordPresenceLocked(eventLeave, clientID, c)
+	if cr.leaveGrace == 0 || cr.closed {
+		c.close()
+		return
+	}
+	cr.departing[clientID] = c
+	c.leaveTimer = cr.clock.AfterFunc(cr.leaveGrace, func() {
+		cr.mutex.Lock()
+		defer cr.mutex.Unlock()
+		cr.finishDepartureLocked(c)
+	})
+	select {
+	case cr.broadcast <- Message{departed: c}:
+	default:
+	}
+}
+
+// finishDepartureLocked closes a departing client unless that already
+// happened.
+func (cr *ChatRoom) finishDepartureLocked(c *client) {
+	for id, d := range cr.departing {
+		if d == c {
+			delete(cr.departing, id)
+			c.leaveTimer.Stop()
+			c.close()
+			return
+		}
+	}
+}
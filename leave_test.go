@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"chatroom/testutil"
+)
+
+// A client that leaves still receives what was queued before it left, and
+// its ID is held until that has been delivered.
+func TestLeavingClientFlushesQueuedDeliveries(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	_, h := newTestRoom(t, WithClock(clk), WithLeaveGrace(5*time.Minute), WithChaos(Chaos{LatencyMs: 60000}),
+		WithPollWait(PollWait{Min: time.Second, Preferred: 30 * time.Second, Max: 2 * time.Minute}))
+	ta, tb := join(t, h, "alice"), join(t, h, "bob")
+	idle := clk.Waiters()
+	send(h, tb, "before the leave")
+	eventually(t, "the broadcast loop to hold the message", func() bool { return clk.Waiters() > idle })
+	if w := do(h, "POST", "/leave?id=alice", tokenHeader, ta); w.Code != http.StatusOK {
+		t.Fatalf("leave: %d %s", w.Code, w.Body)
+	}
+
+	if w := send(h, ta, "after the leave"); w.Code != http.StatusConflict {
+		t.Errorf("send while departing: %d, want 409", w.Code)
+	}
+	if w := do(h, "POST", "/join?id=alice"); w.Code != http.StatusConflict || w.Header().Get("Retry-After") == "" {
+		t.Errorf("rejoin while departing: %d Retry-After %q, want 409 with Retry-After", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := do(h, "POST", "/admin/ephemeral?id=alice&message=hi", asAdmin...); w.Code != http.StatusGone {
+		t.Errorf("ephemeral while departing: %d, want 410", w.Code)
+	}
+
+	done := pollAsync(t, clk, h, "alice", ta, "90")
+	clk.Advance(time.Minute)
+	if w := <-done; w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "bob: before the leave") {
+		t.Errorf("departing poll: %d %q, want bob's message", w.Code, w.Body)
+	}
+	eventually(t, "alice's ID to be released", func() bool {
+		return do(h, "POST", "/join?id=alice").Code == http.StatusOK
+	})
+}
+
+// The grace period closes a departing client even when the deliveries
+// ahead of it are stuck.
+func TestLeaveGraceClosesStuckClients(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	_, h := newTestRoom(t, WithClock(clk), WithLeaveGrace(10*time.Second), WithChaos(Chaos{LatencyMs: 60000}))
+	ta, tb := join(t, h, "alice"), join(t, h, "bob")
+	idle := clk.Waiters()
+	send(h, tb, "stuck")
+	eventually(t, "the broadcast loop to hold the message", func() bool { return clk.Waiters() > idle })
+	do(h, "POST", "/leave?id=alice", tokenHeader, ta)
+	if w := do(h, "POST", "/join?id=alice"); w.Code != http.StatusConflict {
+		t.Fatalf("rejoin while departing: %d, want 409", w.Code)
+	}
+	clk.Advance(10 * time.Second)
+	eventually(t, "the grace period to release alice's ID", func() bool {
+		return do(h, "POST", "/join?id=alice").Code == http.StatusOK
+	})
+}
+
+func TestZeroLeaveGraceRemovesAtOnce(t *testing.T) {
+	_, h := newTestRoom(t, WithLeaveGrace(0))
+	token := join(t, h, "alice")
+	do(h, "POST", "/leave?id=alice", tokenHeader, token)
+	if w := do(h, "POST", "/join?id=alice"); w.Code != http.StatusOK {
+		t.Errorf("rejoin after leaving with no grace: %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// listFlushEvery is how many list items a streamed response writes between
+// flushes.
+const listFlushEvery = 256
+
+// listWriter streams a JSON response item by item, so a long list is never
+// built in memory as a whole and reaches the client while it is encoded.
+// Callers write the punctuation around the lists themselves. After a write
+// fails, typically because the client went away, it writes nothing more
+// and err is set.
+type listWriter struct {
+	w     io.Writer
+	rc    *http.ResponseController
+	items int // Items in the current list
+	total int // Items since the last flush
+	err   error
+}
+
+func newListWriter(w http.ResponseWriter) *listWriter {
+	return &listWriter{w: w, rc: http.NewResponseController(w)}
+}
+
+func (l *listWriter) raw(s string) {
+	if l.err == nil {
+		_, l.err = io.WriteString(l.w, s)
+	}
+}
+
+// value writes v as JSON.
+func (l *listWriter) value(v interface{}) {
+	if l.err != nil {
+		return
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		l.err = err
+		return
+	}
+	_, l.err = l.w.Write(b)
+}
+
+// field writes `"name":` followed by v, after a comma unless first.
+func (l *listWriter) field(name string, v interface{}, first bool) {
+	if !first {
+		l.raw(",")
+	}
+	l.value(name)
+	l.raw(":")
+	l.value(v)
+}
+
+func (l *listWriter) begin() {
+	l.raw("[")
+	l.items = 0
+}
+
+// item writes v as the next item of the current list.
+func (l *listWriter) item(v interface{}) {
+	if l.items > 0 {
+		l.raw(",")
+	}
+	l.value(v)
+	l.items++
+	if l.total++; l.total == listFlushEvery && l.err == nil {
+		l.total = 0
+		// Writers that cannot flush just buffer the whole response.
+		l.rc.Flush()
+	}
+}
+
+func (l *listWriter) end() {
+	l.raw("]")
+}
+
+// done ends the response with the newline json.Encoder would write.
+func (l *listWriter) done() {
+	l.raw("\n")
+}
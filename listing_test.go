@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// Streamed lists reach the client in pieces through the full handler
+// chain, not only once the handler returns.
+func TestStreamedListsFlushThroughTheHandler(t *testing.T) {
+	cr, h := newTestRoom(t)
+	token := join(t, h, "alice")
+	for i := 0; i < 2*listFlushEvery; i++ {
+		if err := cr.AddClient(fmt.Sprintf("user%03d", i)); err != nil {
+			t.Fatal(err)
+		}
+		cr.audit.add(AuditEntry{Action: "test", Actor: actorAdmin, Detail: fmt.Sprint(i)})
+	}
+	for i := 0; i < 2*listFlushEvery; i++ {
+		if w := send(h, token, fmt.Sprint("message ", i)); w.Code != http.StatusOK {
+			t.Fatalf("send %d: %d %s", i, w.Code, w.Body)
+		}
+	}
+	eventually(t, "the messages to be recorded", func() bool {
+		return cr.events.last() >= uint64(4*listFlushEvery+1)
+	})
+	for _, tc := range []struct {
+		target  string
+		headers []string
+	}{
+		{"/clients", nil},
+		{"/admin/audit", asAdmin},
+		{"/me/export", []string{tokenHeader, token}},
+	} {
+		w := do(h, "GET", tc.target, tc.headers...)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: %d %s", tc.target, w.Code, w.Body)
+			continue
+		}
+		if !w.Flushed {
+			t.Errorf("%s was buffered whole instead of flushed as it was written", tc.target)
+		}
+	}
+}
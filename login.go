@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// LoginPolicy decides what happens when a client joins under an ID that is
+// already registered.
+type LoginPolicy string
+
+const (
+	LoginReplace LoginPolicy = "replace" // The new session takes over; the old one is notified and closed
+	LoginReject  LoginPolicy = "reject"  // The join fails with 409 Conflict
+	LoginCoexist LoginPolicy = "coexist" // Every session receives messages (multi-device)
+)
+
+const (
+	messageTypeReplaced = "replaced"
+	sessionHeader       = "X-Convo-Session"
+	// statusLoginTimeout is the non-standard 440 Login Time-out, answered to
+	// polls of a session that was replaced by a newer login.
+	statusLoginTimeout = 440
+)
+
+var errClientIDInUse = errors.New("client ID is already in use")
+
+// WithLoginPolicy sets how duplicate joins are handled. The default is
+// LoginReplace.
+func WithLoginPolicy(p LoginPolicy) Option {
+	return func(cr *ChatRoom) {
+		if _, err := parseLoginPolicy(string(p)); err == nil {
+			cr.loginPolicy = p
+		}
+	}
+}
+
+func parseLoginPolicy(s string) (LoginPolicy, error) {
+	switch p := LoginPolicy(s); p {
+	case LoginReplace, LoginReject, LoginCoexist:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown duplicate-login policy %q (want replace, reject or coexist)", s)
+}
+
+// registerLocked applies the login policy to a join of clientID by c. In
+// coexist mode c becomes an additional session of the existing client and
+// shares its role and language.
+func (cr *ChatRoom) registerLocked(clientID string, c *client) error {
+	old, exists := cr.clients[clientID]
+	if !exists {
+		cr.clients[clientID] = c
+		cr.rosterChangedLocked()
+		if !c.pending {
+			cr.recordPresenceLocked(eventJoin, clientID, c)
+		}
+		c.joinSeq = cr.events.last()
+		return nil
+	}
+	c.joinSeq = cr.events.last()
+	switch cr.loginPolicy {
+	case LoginReject:
+		return errClientIDInUse
+	case LoginCoexist:
+		old.extra = append(old.extra, c)
+		return nil
+	}
+	cr.clients[clientID] = c
+	cr.rosterChangedLocked()
+	cr.replaceLocked(clientID, old, c)
+	return nil
+}
+
+// replaceLocked retires old after c took over its ID. The old session's last
+// delivery is a "logged in elsewhere" notice, so a pending poll learns why
+// it ended.
+func (cr *ChatRoom) replaceLocked(clientID string, old, c *client) {
+	cr.audit.add(AuditEntry{
+		Action: "session_replaced",
+		Actor:  clientID,
+		Target: clientID,
+		Detail: fmt.Sprintf("old session from %s, new session from %s", old.origin(), c.origin()),
+	})
+	cr.retireLocked(old, messageTypeReplaced, "You logged in elsewhere")
+}
+
+// retireLocked closes a client that is no longer registered, leaving a notice
+// of the given type explaining why as the last delivery in its queue.
+func (cr *ChatRoom) retireLocked(old *client, typ, body string) {
+	notice := Message{
+		ID:   cr.ids.NewID(),
+		From: systemSender,
+		Body: body,
+		Time: cr.clock.Now(),
+		Type: typ,
+	}
+	notice.line = formatLine(notice)
+	cr.enqueueLocked(old, notice)
+	old.close()
+}
+
+// session returns the session of c named by id, or c itself when id is empty.
+func (c *client) session(id string) *client {
+	if id == "" || c.sessionID == id {
+		return c
+	}
+	for _, s := range c.extra {
+		if s.sessionID == id {
+			return s
+		}
+	}
+	return nil
+}
+
+func (c *client) origin() string {
+	if c.addr == "" {
+		return c.transport
+	}
+	return c.addr
+}
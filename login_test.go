@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"chatroom/testutil"
+)
+
+// joinFrom joins id from addr, returning the response.
+func joinFrom(h http.Handler, id, addr string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/join?id="+id, nil)
+	req.RemoteAddr = addr
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+// A replacing login ends the old session's pending poll with 440 and the
+// "logged in elsewhere" notice, and the audit log names both addresses.
+func TestLoginReplaceRetiresTheOldSession(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	cr, h := newTestRoom(t, WithClock(clk), WithLoginPolicy(LoginReplace))
+	tb := join(t, h, "bob")
+	first := joinFrom(h, "alice", "192.0.2.1:1000")
+	if first.Code != http.StatusOK {
+		t.Fatalf("first join: %d %s", first.Code, first.Body)
+	}
+	done := pollAsync(t, clk, h, "alice", first.Header().Get(tokenHeader), "30")
+
+	second := joinFrom(h, "alice", "198.51.100.7:2000")
+	if second.Code != http.StatusOK {
+		t.Fatalf("replacing join: %d %s", second.Code, second.Body)
+	}
+	if w := <-done; w.Code != statusLoginTimeout || !strings.Contains(w.Body.String(), "logged in elsewhere") {
+		t.Errorf("old session's poll: %d %q, want 440 with the notice", w.Code, w.Body)
+	}
+	var detail string
+	for _, e := range cr.audit.list() {
+		if e.Action == "session_replaced" && e.Target == "alice" {
+			detail = e.Detail
+		}
+	}
+	if !strings.Contains(detail, "192.0.2.1") || !strings.Contains(detail, "198.51.100.7") {
+		t.Errorf("audit detail %q, want both addresses", detail)
+	}
+
+	send(h, tb, "welcome back")
+	if w := pollOnce(h, "alice", second.Header().Get(tokenHeader)); w.Body.String() != "bob: welcome back\n" {
+		t.Errorf("new session's poll: %d %q", w.Code, w.Body)
+	}
+}
+
+func TestLoginRejectKeepsTheFirstSession(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	_, h := newTestRoom(t, WithClock(clk), WithLoginPolicy(LoginReject))
+	ta := join(t, h, "alice")
+	tb := join(t, h, "bob")
+	if w := do(h, "POST", "/join?id=alice"); w.Code != http.StatusConflict {
+		t.Errorf("duplicate join: %d %s, want 409", w.Code, w.Body)
+	}
+	send(h, tb, "still there?")
+	if w := pollOnce(h, "alice", ta); w.Body.String() != "bob: still there?\n" {
+		t.Errorf("first session's poll: %d %q", w.Code, w.Body)
+	}
+}
+
+// Coexisting sessions of one client each receive every message.
+func TestLoginCoexistDeliversToEverySession(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	_, h := newTestRoom(t, WithClock(clk), WithLoginPolicy(LoginCoexist))
+	tb := join(t, h, "bob")
+	var sessions []*httptest.ResponseRecorder
+	for _, addr := range []string{"192.0.2.1:1000", "198.51.100.7:2000"} {
+		w := joinFrom(h, "alice", addr)
+		if w.Code != http.StatusOK || w.Header().Get(sessionHeader) == "" {
+			t.Fatalf("join from %s: %d %s", addr, w.Code, w.Body)
+		}
+		sessions = append(sessions, w)
+	}
+	send(h, tb, "hello devices")
+	for i, s := range sessions {
+		w := do(h, "GET", "/messages?wait=0&id=alice&session="+s.Header().Get(sessionHeader), tokenHeader, s.Header().Get(tokenHeader))
+		if w.Body.String() != "bob: hello devices\n" {
+			t.Errorf("session %d's poll: %d %q", i, w.Code, w.Body)
+		}
+	}
+}
@@ -1,137 +1,1549 @@
-package main
-
-import (
-	"fmt"
-	"log"
-	"net/http"
-	"sync"
-	"time"
-)
-
-// ChatRoom manages clients and broadcasts messages.
-type ChatRoom struct {
-	clients   map[string]chan string // Map of clientID to their message channels
-	broadcast chan string            // Channel for broadcasting messages
-	leave     chan string            // Channel for clients leaving the chat room
-	mutex     sync.Mutex             // Ensures thread-safe access to clients map
-}
-
-func NewChatRoom() *ChatRoom {
-	return &ChatRoom{
-		clients:   make(map[string]chan string),
-		broadcast: make(chan string),
-		leave:     make(chan string),
-	}
-}
-
-func (cr *ChatRoom) AddClient(clientID string) {
-	cr.mutex.Lock()
-	defer cr.mutex.Unlock()
-	cr.clients[clientID] = make(chan string)
-}
-
-func (cr *ChatRoom) RemoveClient(clientID string) {
-	cr.mutex.Lock()
-	defer cr.mutex.Unlock()
-	if _, exists := cr.clients[clientID]; exists {
-		close(cr.clients[clientID])
-		delete(cr.clients, clientID)
-	}
-}
-
-func (cr *ChatRoom) BroadcastMessages() {
-	for msg := range cr.broadcast {
-		cr.mutex.Lock()
-		for _, ch := range cr.clients {
-			select {
-			case ch <- msg:
-			default:
-			}
-		}
-		cr.mutex.Unlock()
-	}
-}
-
-func (cr *ChatRoom) HandleJoin(w http.ResponseWriter, r *http.Request) {
-	clientID := r.URL.Query().Get("id")
-	if clientID == "" {
-		http.Error(w, "Client ID is required", http.StatusBadRequest)
-		return
-	}
-	cr.AddClient(clientID)
-	fmt.Fprintf(w, "Client %s joined the chat", clientID)
-}
-
-func (cr *ChatRoom) HandleSend(w http.ResponseWriter, r *http.Request) {
-	clientID := r.URL.Query().Get("id")
-	message := r.URL.Query().Get("message")
-
-	if clientID == "" || message == "" {
-		http.Error(w, "Client ID and message are required", http.StatusBadRequest)
-		return
-	}
-
-	cr.mutex.Lock()
-	_, exists := cr.clients[clientID]
-	cr.mutex.Unlock()
-	if !exists {
-		http.Error(w, "Invalid client ID", http.StatusNotFound)
-		return
-	}
-
-	cr.broadcast <- fmt.Sprintf("%s: %s", clientID, message)
-	fmt.Fprintf(w, "Message from %s sent", clientID)
-}
-
-func (cr *ChatRoom) HandleLeave(w http.ResponseWriter, r *http.Request) {
-	clientID := r.URL.Query().Get("id")
-	if clientID == "" {
-		http.Error(w, "Client ID is required", http.StatusBadRequest)
-		return
-	}
-	cr.RemoveClient(clientID)
-	fmt.Fprintf(w, "Client %s left the chat", clientID)
-}
-
-func (cr *ChatRoom) HandleMessages(w http.ResponseWriter, r *http.Request) {
-	clientID := r.URL.Query().Get("id")
-	if clientID == "" {
-		http.Error(w, "Client ID is required", http.StatusBadRequest)
-		return
-	}
-
-	cr.mutex.Lock()
-	ch, exists := cr.clients[clientID]
-	cr.mutex.Unlock()
-	if !exists {
-		http.Error(w, "Client not found", http.StatusNotFound)
-		return
-	}
-
-	timeout := time.After(30 * time.Second)
-	select {
-	case msg, ok := <-ch:
-		if !ok {
-			http.Error(w, "Client has left the chat", http.StatusGone)
-			return
-		}
-		fmt.Fprintln(w, msg)
-	case <-timeout:
-		http.Error(w, "Request timed out", http.StatusGatewayTimeout)
-	}
-}
-
-func (cr *ChatRoom) RunServer() {
-	http.HandleFunc("/join", cr.HandleJoin)
-	http.HandleFunc("/send", cr.HandleSend)
-	http.HandleFunc("/leave", cr.HandleLeave)
-	http.HandleFunc("/messages", cr.HandleMessages)
-	log.Println("Chat server running on http://localhost:8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
-}
-
-func main() {
-	chatRoom := NewChatRoom()
-	go chatRoom.BroadcastMessages()
-	chatRoom.RunServer()
-}
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"chatroom/clock"
+)
+
+const (
+	defaultBroadcastBuffer = 256
+	defaultSendTimeout     = 2 * time.Second
+	retryAfterSeconds      = "1"
+)
+
+const (
+	transportPoll   = "poll"
+	transportInproc = "inproc"
+)
+
+const messageTypePreview = "preview"
+
+// systemSender is the From of messages generated by the server itself. No
+// client may join under it.
+const systemSender = "system"
+
+var (
+	errRoomClosed      = errors.New("chat room is closed")
+	errShuttingDown    = errors.New("server is shutting down")
+	errBusy            = errors.New("broadcast queue is full")
+	errMessageNotFound = errors.New("message not found")
+)
+
+// Message is a single chat message as delivered to clients.
+type Message struct {
+	ID   string    `json:"id"`
+	From string    `json:"from"`
+	Body string    `json:"body"`
+	Time time.Time `json:"time"`
+	// Translated is Body in the receiving client's preferred language, set
+	// only on deliveries to clients that registered one.
+	Translated string `json:"translated,omitempty"`
+	// Type is empty for chat messages and names the event otherwise
+	// (e.g. "preview"); RefID then points at the message it is about.
+	Type    string       `json:"type,omitempty"`
+	RefID   string       `json:"ref_id,omitempty"`
+	Preview *LinkPreview `json:"preview,omitempty"`
+	// Kind names a structured message such as "code" or "location"; Payload
+	// then holds its JSON, validated for the kind, and Body is the fallback
+	// text for clients that show lines.
+	Kind    string          `json:"kind,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	// Seq numbers the messages a client sent through /send since it joined.
+	// Messages from one sender are delivered to every receiver in Seq order,
+	// however concurrently they were sent.
+	Seq uint64 `json:"seq,omitempty"`
+	// Verified is set when the sender has a registered key and signed Body.
+	Verified bool `json:"verified,omitempty"`
+	// Entities marks mentions, URLs and code spans in Body.
+	Entities []Entity `json:"entities,omitempty"`
+	// ClientMsgID echoes the sender's own ID for the message, so its UI can
+	// match the delivery to what it showed optimistically.
+	ClientMsgID string `json:"client_msg_id,omitempty"`
+	// EventSeq is the message's position in /events. A session is delivered
+	// exactly the messages with an EventSeq above the X-Convo-Join-Seq of its
+	// join, so history read from /events up to that seq and the live stream
+	// neither overlap nor leave a gap.
+	EventSeq uint64 `json:"event_seq,omitempty"`
+	// Highlight holds syntax highlighting of a code message: token
+	// entities over its payload's code rather than over Body.
+	Highlight []Entity `json:"highlight,omitempty"`
+	// Folded is set when Body was cut short for length; FullLength is then
+	// the length in bytes of the whole body, served by /messages/{id}/full.
+	Folded     bool `json:"folded,omitempty"`
+	FullLength int  `json:"full_length,omitempty"`
+
+	line     []byte      // "from: body\n", formatted once by Publish and shared read-only by every delivery
+	envelope *envelope   // JSON form shared by every delivery of the message as published; nil once altered
+	queued   time.Time   // When Publish enqueued the message, for delivery latency
+	departed *client     // Set on the marker that closes a leaving client once deliveries queued before it are done
+	shadow   bool        // From a shadow-muted sender; delivered only to the sender's own sessions
+	unfolded *unfolded   // The whole body of a folded message
+	sample   *sendSample // Timings for /admin/profile/send; nil unless the message was sampled
+
+	translation *translation // Translation for this delivery's recipient, applied when it is read
+
+	highlighting chan []Entity // Highlighting that overran its budget, published as a follow-up event
+}
+
+func (m Message) String() string {
+	return m.From + ": " + m.Body
+}
+
+// Line returns the message as written to long-poll responses. The returned
+// slice is shared between deliveries and must not be modified.
+func (m Message) Line() []byte {
+	if m.line != nil {
+		return m.line
+	}
+	return formatLine(m)
+}
+
+// jsonLine returns m as one line of JSON. Deliveries of a message as
+// published share the bytes, which must not be modified.
+func (m Message) jsonLine() []byte {
+	if m.envelope != nil {
+		if b := m.envelope.bytes(m); b != nil {
+			return b
+		}
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil
+	}
+	return append(b, '\n')
+}
+
+// envelope holds a message's JSON encoding, marshalled by the first JSON
+// poll to receive it and reused by the rest.
+type envelope struct {
+	once sync.Once
+	b    []byte
+}
+
+func (e *envelope) bytes(m Message) []byte {
+	e.once.Do(func() {
+		m.envelope = nil
+		if b, err := json.Marshal(m); err == nil {
+			e.b = append(b, '\n')
+		}
+	})
+	return e.b
+}
+
+// textIndent starts every line after the first of a multi-line body in the
+// text format. Client IDs never start with a space, so no line of a body can
+// pass for another client's "from: body" line.
+const textIndent = "  "
+
+// indentLines indents the continuation lines of s with textIndent.
+func indentLines(s string) string {
+	if strings.IndexByte(s, '\n') < 0 {
+		return s
+	}
+	return strings.ReplaceAll(s, "\n", "\n"+textIndent)
+}
+
+func formatLine(m Message) []byte {
+	body := indentLines(m.Body)
+	if m.Type != "" {
+		return []byte("[" + m.Type + "] " + m.From + ": " + body + "\n")
+	}
+	if m.Translated != "" {
+		return []byte(m.From + ": " + indentLines(m.Translated) + " (original: " + body + ")\n")
+	}
+	b := make([]byte, 0, len(m.From)+len(body)+3)
+	b = append(b, m.From...)
+	b = append(b, ": "...)
+	b = append(b, body...)
+	return append(b, '\n')
+}
+
+// client is a registered recipient of broadcasts.
+type client struct {
+	queue        deliveryQueue   // Deliveries waiting to be read; guarded by ChatRoom.mutex
+	ready        chan struct{}   // Signalled when queue gains a delivery
+	overflowed   int64           // Deliveries dropped from a full queue; guarded by ChatRoom.mutex
+	gone         chan struct{}   // Closed when the client is removed
+	transport    string          // How the client receives messages (poll, inproc)
+	role         string          // member or spectator; guarded by ChatRoom.mutex
+	lang         string          // Preferred language for translation; guarded by ChatRoom.mutex
+	sessionID    string          // Distinguishes sessions under one ID; sent in X-Convo-Session
+	addr         string          // Remote IP of the join request; empty for in-process clients
+	userAgent    string          // User-Agent of the join request
+	extra        []*client       // Further sessions under the same ID in coexist mode; guarded by ChatRoom.mutex
+	dnd          bool            // Do not disturb; guarded by ChatRoom.mutex
+	dndUntil     time.Time       // When DND lapses; zero while it lasts until turned off
+	invisible    bool            // Left out of /clients for everyone but admins and itself; guarded by ChatRoom.mutex
+	pending      bool            // Join awaits admin approval; guarded by ChatRoom.mutex
+	pendingSince time.Time       // When the join request was made
+	toldPending  bool            // The "pending approval" event was returned to a poll
+	bw           bandwidth       // Delivery accounting for /quota
+	seqMu        sync.Mutex      // Held while numbering and enqueueing a send, for per-sender FIFO
+	seq          uint64          // Last Seq assigned; guarded by seqMu
+	caps         map[string]bool // Capabilities declared on join; nil when none were declared
+	leaveTimer   clock.Timer     // Closes the client when its leave grace period ends
+	welcome      string          // Welcome still to be returned by this session's first poll; guarded by ChatRoom.mutex
+	tokenIssued  int64           // Issue time, in Unix nanoseconds, of the current send token; guarded by ChatRoom.mutex
+	prevIssued   int64           // Issue time of the token rotated out, valid until prevUntil
+	prevUntil    time.Time
+	keywords     keywordWatch // Words that raise a keyword event; guarded by ChatRoom.mutex
+	notices      []Message    // Keyword events, and messages a stream failed to write, awaiting this session's next poll; guarded by ChatRoom.mutex
+	joinSeq      uint64       // Event seq at registration; the session gets messages after it
+	provisioned  bool         // Must be provisioned to register: an HTTP join without admin credentials
+}
+
+func newClient(transport, role string) *client {
+	return &client{
+		ready:     make(chan struct{}, 1),
+		gone:      make(chan struct{}),
+		transport: transport,
+		role:      role,
+	}
+}
+
+// ChatRoom manages clients and broadcasts messages.
+type ChatRoom struct {
+	clients           map[string]*client // Map of clientID to their registration
+	broadcast         chan Message       // Channel for broadcasting messages
+	leave             chan string        // Channel for clients leaving the chat room
+	mutex             sync.Mutex         // Ensures thread-safe access to clients map
+	sendMu            sync.RWMutex       // Held for reading while enqueueing so Close never races a send
+	sendsStopped      bool               // Shutdown has stopped accepting sends; guarded by sendMu
+	sendTimeout       time.Duration      // How long HandleSend waits for room in the broadcast queue
+	sessionQueue      int                // How many deliveries a session may have waiting
+	closed            bool               // Set by Close; guarded by mutex
+	done              chan struct{}      // Closed by Close to stop internal goroutines
+	closeOnce         sync.Once
+	wg                sync.WaitGroup // Tracks internal goroutines so Close can wait for them
+	ids               IDGenerator    // Assigns message IDs
+	adminToken        string         // Bearer token for admin endpoints; empty disables them
+	maintenance       Maintenance    // Guarded by mutex
+	watchOnly         bool           // Joins without admin credentials become spectators
+	translator        Translator     // Translates deliveries for clients with a preferred language
+	activity          *activity      // Per-minute message counts for /activity
+	audit             auditLog
+	mutes             map[string]mute         // Keyed by client ID; guarded by mutex
+	spam              SpamConfig              // Automatic muting of repeated messages
+	recentBodies      map[string][]sentBody   // Recent sends per client for spam detection; guarded by mutex
+	recent            recentMessages          // Recently broadcast messages by ID
+	reports           map[string]*ReportGroup // Open reports by message ID; guarded by mutex
+	previews          *previewer              // Link preview fetcher; nil when previews are off
+	ctx               context.Context         // Canceled by Close to abort background work
+	cancel            context.CancelFunc
+	commands          map[string]command           // Slash commands by name; guarded by mutex
+	topic             string                       // Set with /topic; guarded by mutex
+	instanceID        string                       // Identifies this server among load-balanced instances
+	pollWait          PollWait                     // Long-poll bounds for /messages
+	loginPolicy       LoginPolicy                  // What a join under an ID already in use does
+	latency           latencyHistogram             // Enqueue-to-write delivery latency
+	chaos             *Chaos                       // Injected faults; nil unless chaos mode is on; guarded by mutex
+	joinApproval      time.Duration                // How long joins wait for approval; zero when approval is off
+	summarizer        Summarizer                   // Produces /summary text
+	trustedProxies    []netip.Prefix               // Peers whose X-Forwarded-For is believed
+	signingKeys       map[string]ed25519.PublicKey // Senders whose messages must be signed; guarded by mutex
+	signatureFailures atomic.Int64
+	kinds             map[string]KindValidator // Structured message kinds; guarded by mutex
+	anyKind           bool                     // Accept unregistered kinds
+	roleCaps          map[string]int64         // Bytes-per-minute delivery caps by role; guarded by mutex
+	clock             clock.Clock              // Source of time for timestamps, timeouts and expiry
+	embargoes         map[string]*embargo      // Held messages by ID; guarded by mutex
+	uiDisabled        bool                     // Whether /ui is turned off
+	joinGate          JoinGate                 // Proof-of-work and invite checks on /join
+	joins             joinRate                 // Recent joins, which scale the proof-of-work difficulty
+	delivered         atomic.Uint64            // Deliveries handed to a client
+	dropped           atomic.Uint64            // Deliveries skipped by chaos mode or a bandwidth cap, or evicted from a full session queue
+	alerts            alerts
+	leaveGrace        time.Duration      // How long a client that left is kept as a tombstone
+	departing         map[string]*client // Clients that left and are flushing queued deliveries; guarded by mutex
+	tokenKey          []byte             // Signs send tokens
+	workers           workers            // Internal goroutine accounting for /debug/internals
+	welcome           string             // Sent to each new joiner; guarded by mutex
+	polls             map[string]*poll   // Polls by message ID; guarded by mutex
+	handlerTimeout    time.Duration      // Per-request handler deadline; 0 for none
+	panics            atomic.Int64       // Handler panics recovered
+	shareLinks        ShareLinks
+	dlq               deadLetters                // Webhook deliveries that failed every retry
+	drafts            map[string]Draft           // Unsent drafts by client ID; guarded by mutex
+	protocols         map[string]protocolVersion // Supported wire protocol versions
+	warmup            admission                  // Join admission control after startup
+	notes             map[string][]Note          // Moderator notes by user ID; guarded by mutex
+	shares            map[string]*share          // Share links by token; guarded by mutex
+	exportJobs        map[string]*exportJob      // Exports being prepared or ready, by job ID; guarded by mutex
+	outbound          *http.Client               // Shared by server-initiated requests
+	outboundChecks    outboundChecks             // Results of the outbound self-test for /readyz
+	revokedBefore     time.Time                  // Send tokens issued earlier are rejected; guarded by mutex
+	summaries         summaryCache
+	registry          registry // Snapshots of cr.clients for /clients and /stats
+	foldLength        int      // Chat messages longer than this many bytes are folded; 0 for never
+	events            eventLog // Messages and presence changes for /events
+	sendProfile       sendProfile
+	rates             rateLimits         // Per-sender token buckets for /send; guarded by mutex
+	redactions        []RedactionPattern // Secrets removed from sent messages
+	requestSigning    requestSigning     // Replay protection for requests with a send token
+	draining          bool               // Shutdown has begun and joins are refused; guarded by mutex
+	shutdownHooks     []ShutdownHook     // Guarded by mutex
+	shutdownDeadlines map[string]time.Duration
+	highlightOff      bool                       // Code messages go out without syntax highlighting
+	provisionToken    string                     // Bearer token for /provision; empty leaves joins open
+	provisioned       map[string]ProvisionedUser // Who may join under provisioning; guarded by mutex
+	usage             usageLedger                // Per-sender daily usage for /admin/usage
+	e2ee              bool                       // Chat messages must be kind=e2ee ciphertext
+	publicKeys        map[string]PublicKey       // Published for E2EE by client ID; guarded by mutex
+}
+
+// Option configures a ChatRoom created by NewChatRoom.
+type Option func(*ChatRoom)
+
+// WithClock replaces the wall clock, e.g. with testutil.FakeClock.
+func WithClock(c clock.Clock) Option {
+	return func(cr *ChatRoom) {
+		if c != nil {
+			cr.clock = c
+		}
+	}
+}
+
+// WithBroadcastBuffer sets how many messages may wait for the broadcast loop.
+func WithBroadcastBuffer(size int) Option {
+	return func(cr *ChatRoom) {
+		if size >= 0 {
+			cr.broadcast = make(chan Message, size)
+		}
+	}
+}
+
+// WithSendTimeout sets how long a send waits for queue space before giving up.
+func WithSendTimeout(d time.Duration) Option {
+	return func(cr *ChatRoom) {
+		if d > 0 {
+			cr.sendTimeout = d
+		}
+	}
+}
+
+func NewChatRoom(opts ...Option) *ChatRoom {
+	cr := &ChatRoom{
+		clients:           make(map[string]*client),
+		broadcast:         make(chan Message, defaultBroadcastBuffer),
+		leave:             make(chan string),
+		sendTimeout:       defaultSendTimeout,
+		sessionQueue:      defaultSessionQueue,
+		done:              make(chan struct{}),
+		ids:               defaultIDs,
+		translator:        NoopTranslator{},
+		summarizer:        StatsSummarizer{},
+		mutes:             make(map[string]mute),
+		spam:              defaultSpamConfig,
+		recentBodies:      make(map[string][]sentBody),
+		reports:           make(map[string]*ReportGroup),
+		signingKeys:       make(map[string]ed25519.PublicKey),
+		roleCaps:          make(map[string]int64),
+		embargoes:         make(map[string]*embargo),
+		departing:         make(map[string]*client),
+		polls:             make(map[string]*poll),
+		shares:            make(map[string]*share),
+		exportJobs:        make(map[string]*exportJob),
+		drafts:            make(map[string]Draft),
+		notes:             make(map[string][]Note),
+		protocols:         make(map[string]protocolVersion, len(protocolVersions)),
+		shareLinks:        ShareLinks{MaxExpiry: defaultMaxShareExpiry},
+		outbound:          http.DefaultClient,
+		leaveGrace:        defaultLeaveGrace,
+		handlerTimeout:    defaultHandlerTimeout,
+		tokenKey:          newTokenKey(),
+		rates:             newRateLimits(),
+		provisioned:       make(map[string]ProvisionedUser),
+		publicKeys:        make(map[string]PublicKey),
+		shutdownDeadlines: make(map[string]time.Duration),
+		clock:             clock.Real{},
+	}
+	cr.ctx, cr.cancel = context.WithCancel(context.Background())
+	cr.instanceID = newInstanceID()
+	cr.pollWait = defaultPollWait
+	cr.loginPolicy = LoginReplace
+	cr.registerBuiltinCommands()
+	cr.registerBuiltinKinds()
+	cr.registerShutdownPhases()
+	for v, p := range protocolVersions {
+		cr.protocols[v] = p
+	}
+	for _, opt := range opts {
+		opt(cr)
+	}
+	cr.activity = newActivity(cr.clock.Now())
+	cr.warmup.start(cr.clock.Now())
+	cr.audit.now = cr.clock.Now
+	if cr.previews != nil {
+		cr.previews.route(cr.outboundTransport())
+	}
+	cr.startWorker(workerBroadcast)
+	go cr.broadcastMessages()
+	cr.startWorker(workerActivity)
+	go cr.rotateActivity()
+	if cr.alerts.cfg.enabled() {
+		cr.alerts.states = make(map[string]*alertState)
+		cr.startWorker(workerAlerts)
+		go cr.watchAlerts()
+	}
+	return cr
+}
+
+// Close stops accepting joins and sends, discards broadcasts that are still
+// queued, ends every session and waits for internal goroutines to
+// exit. It is safe to call more than once.
+func (cr *ChatRoom) Close() {
+	cr.closeOnce.Do(func() {
+		cr.sendMu.Lock()
+		cr.mutex.Lock()
+		cr.closed = true
+		close(cr.done)
+		for id, e := range cr.embargoes {
+			e.timer.Stop()
+			delete(cr.embargoes, id)
+		}
+		for _, p := range cr.polls {
+			if p.timer != nil {
+				p.timer.Stop()
+			}
+		}
+		cr.mutex.Unlock()
+		cr.sendMu.Unlock()
+		cr.cancel()
+
+		cr.wg.Wait()
+
+		cr.mutex.Lock()
+		for id, c := range cr.clients {
+			c.close()
+			delete(cr.clients, id)
+		}
+		cr.rosterChangedLocked()
+		for _, c := range cr.departing {
+			cr.finishDepartureLocked(c)
+		}
+		cr.mutex.Unlock()
+	})
+}
+
+// goTracked runs f in a goroutine of the given kind that Close waits for. It
+// does nothing once the room is closed.
+func (cr *ChatRoom) goTracked(kind string, f func()) {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	if cr.closed {
+		return
+	}
+	cr.startWorker(kind)
+	go func() {
+		defer cr.stopWorker(kind)
+		f()
+	}()
+}
+
+// close ends the session. Readers still get what is already queued.
+func (c *client) close() {
+	close(c.gone)
+	for _, s := range c.extra {
+		s.close()
+	}
+}
+
+func (cr *ChatRoom) AddClient(clientID string) error {
+	return cr.addClient(clientID, newClient(transportPoll, roleMember))
+}
+
+func (cr *ChatRoom) addClient(clientID string, c *client) error {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	return cr.addClientLocked(clientID, c)
+}
+
+func (cr *ChatRoom) addClientLocked(clientID string, c *client) error {
+	if cr.closed {
+		return errRoomClosed
+	}
+	if _, ok := cr.departing[clientID]; ok {
+		return errClientDeparting
+	}
+	// Checked under the same lock as Deprovision's removal, so a join
+	// cannot slip in between it and the revoke that follows.
+	if c.provisioned && !cr.provisionedLocked(clientID) {
+		return errNotProvisioned
+	}
+	c.sessionID = cr.ids.NewID()
+	return cr.registerLocked(clientID, c)
+}
+
+func (cr *ChatRoom) RemoveClient(clientID string) {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	if c, exists := cr.clients[clientID]; exists {
+		cr.departLocked(clientID, c)
+	}
+}
+
+// removeClientIf removes clientID only while it is still registered as c, so
+// a stale owner cannot remove a newer registration under the same ID.
+func (cr *ChatRoom) removeClientIf(clientID string, c *client) {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	owner, exists := cr.clients[clientID]
+	if !exists {
+		return
+	}
+	if owner == c {
+		c.close()
+		delete(cr.clients, clientID)
+		cr.rosterChangedLocked()
+		cr.recordPresenceLocked(eventLeave, clientID, c)
+		return
+	}
+	for i, s := range owner.extra {
+		if s == c {
+			owner.extra = append(owner.extra[:i:i], owner.extra[i+1:]...)
+			c.close()
+			return
+		}
+	}
+}
+
+// Subscribe registers clientID as an in-process client and returns the
+// channel its messages are delivered on. Deliveries wait in the session's
+// queue under the same limit and slow-consumer policy as HTTP clients, and
+// are handed to the channel in order as it is read. The subscription is
+// removed when ctx is canceled; the channel is closed once that happens.
+func (cr *ChatRoom) Subscribe(ctx context.Context, clientID string) (<-chan Message, error) {
+	if !validClientID(clientID) || clientID == systemSender {
+		return nil, errInvalidClientID
+	}
+	c := newClient(transportInproc, roleMember)
+	cr.mutex.Lock()
+	if err := cr.addClientLocked(clientID, c); err != nil {
+		cr.mutex.Unlock()
+		return nil, err
+	}
+	// Added under the mutex so it cannot race with Close's Wait.
+	cr.startWorker(workerSubscription)
+	cr.mutex.Unlock()
+	out := make(chan Message)
+	// Close cancels cr.ctx before waiting for workers, which ends this one.
+	sctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(cr.ctx, cancel)
+	go func() {
+		defer cr.stopWorker(workerSubscription)
+		defer close(out)
+		defer stop()
+		defer cancel()
+		for {
+			msg, _, res := cr.next(c, c, frameInproc, Message.jsonLine, nil, sctx.Done())
+			if res == nextMessage {
+				select {
+				case out <- msg:
+					cr.recordDelivery(msg)
+					continue
+				case <-sctx.Done():
+				}
+			}
+			if ctx.Err() != nil {
+				cr.removeClientIf(clientID, c)
+			}
+			return
+		}
+	}()
+	return out, nil
+}
+
+// Publish queues msg for broadcast, waiting at most the configured send
+// timeout for space in the queue.
+func (cr *ChatRoom) Publish(msg Message) error {
+	if err := validateMessage(msg.Body); err != nil {
+		return err
+	}
+	if msg.ID == "" {
+		msg.ID = cr.ids.NewID()
+	}
+	if msg.Time.IsZero() {
+		msg.Time = cr.clock.Now()
+	}
+	msg.line = formatLine(msg)
+	msg.envelope = &envelope{}
+	cr.sendMu.RLock()
+	defer cr.sendMu.RUnlock()
+	select {
+	case <-cr.done:
+		return errRoomClosed
+	default:
+	}
+	if cr.sendsStopped {
+		return errShuttingDown
+	}
+	msg.queued = cr.clock.Now()
+	// Only pay for a timer when the queue is actually full.
+	select {
+	case cr.broadcast <- msg:
+		return nil
+	default:
+	}
+	timer := cr.clock.NewTimer(cr.sendTimeout)
+	defer timer.Stop()
+	select {
+	case cr.broadcast <- msg:
+		return nil
+	case <-cr.done:
+		return errRoomClosed
+	case <-timer.C():
+		return errBusy
+	}
+}
+
+// publishFrom publishes a message sent by c, numbering it with c's next
+// sequence number. Numbering and enqueueing happen under c's lock and the
+// broadcast queue is FIFO, so c's messages reach every receiver in Seq
+// order. A send that fails does not use up a number. On success msg holds
+// the ID, time and Seq it was published with; a time already set is kept.
+func (cr *ChatRoom) publishFrom(c *client, msg *Message) error {
+	c.seqMu.Lock()
+	defer c.seqMu.Unlock()
+	msg.Seq = c.seq + 1
+	if msg.ID == "" {
+		msg.ID = cr.ids.NewID()
+	}
+	if msg.Time.IsZero() {
+		msg.Time = cr.clock.Now()
+	}
+	if err := cr.Publish(*msg); err != nil {
+		return err
+	}
+	// Shadowed sends reuse the next number rather than taking it, so
+	// receivers see no gap in the sender's Seq once the mute is lifted.
+	if !msg.shadow {
+		c.seq = msg.Seq
+	}
+	return nil
+}
+
+func (cr *ChatRoom) broadcastMessages() {
+	defer cr.stopWorker(workerBroadcast)
+	for {
+		select {
+		case msg := <-cr.broadcast:
+			cr.deliver(msg)
+		case <-cr.done:
+			// Discard whatever is still queued so Close is deterministic.
+			for {
+				select {
+				case <-cr.broadcast:
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (cr *ChatRoom) deliver(msg Message) {
+	if msg.departed != nil {
+		cr.mutex.Lock()
+		cr.finishDepartureLocked(msg.departed)
+		cr.mutex.Unlock()
+		return
+	}
+	if msg.sample != nil {
+		msg.sample.dequeued = cr.clock.Now()
+		defer cr.finishSample(msg.sample)
+	}
+	if !cr.chaosDelay() {
+		return
+	}
+	if msg.shadow {
+		now := cr.clock.Now()
+		cr.mutex.Lock()
+		defer cr.mutex.Unlock()
+		if c, ok := cr.clients[msg.From]; ok {
+			cr.deliverTo(c, msg, nil, now)
+		}
+		return
+	}
+	cr.activity.record()
+	cr.schedulePreview(msg)
+	cr.scheduleHighlight(msg)
+	translations := cr.translate(msg)
+	now := cr.clock.Now()
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	// Numbered under the mutex that also guards joins, so every client
+	// registered by now joined at a lower seq and gets the message, and
+	// every later one joins at a higher seq and does not.
+	msg.EventSeq = cr.recordMessageLocked(msg)
+	cr.recordStored(msg)
+	cr.recent.add(msg)
+	if msg.sample != nil {
+		cr.marshalSample(msg)
+	}
+	for id, c := range cr.clients {
+		if c.pending {
+			continue
+		}
+		cr.deliverTo(c, msg, translations, now)
+		cr.notifyKeywordLocked(id, c, msg, now)
+	}
+	for _, c := range cr.departing {
+		cr.deliverTo(c, msg, translations, now)
+	}
+}
+
+func (cr *ChatRoom) deliverTo(c *client, msg Message, translations map[string]*translation, now time.Time) {
+	m := msg
+	m.translation = translations[c.lang]
+	cr.sendTo(c, m)
+	for _, s := range c.extra {
+		cr.sendTo(s, m)
+	}
+}
+
+// sendTo queues m for session c. Deliveries are paced under the owner's
+// bandwidth cap, counted and have their latency recorded once the session
+// reads them.
+func (cr *ChatRoom) sendTo(c *client, m Message) {
+	m, ok := c.adapt(m)
+	if !ok {
+		return
+	}
+	if cr.chaosDropLocked() {
+		cr.dropped.Add(1)
+		return
+	}
+	cr.enqueueLocked(c, m)
+	if m.sample != nil {
+		m.sample.delivered(cr.clock.Now())
+	}
+}
+
+func (cr *ChatRoom) HandleJoin(w http.ResponseWriter, r *http.Request) {
+	clientID := r.URL.Query().Get("id")
+	if clientID == "" {
+		http.Error(w, "Client ID is required", http.StatusBadRequest)
+		return
+	}
+	if !validClientID(clientID) {
+		http.Error(w, errInvalidClientID.Error(), http.StatusBadRequest)
+		return
+	}
+	if clientID == systemSender {
+		http.Error(w, "Client ID is reserved", http.StatusBadRequest)
+		return
+	}
+	if m := cr.Maintenance(); m.Enabled {
+		w.Header().Set("Retry-After", retryAfterSeconds)
+		http.Error(w, maintenanceText(m), http.StatusServiceUnavailable)
+		return
+	}
+	cr.mutex.Lock()
+	draining := cr.draining
+	cr.mutex.Unlock()
+	if draining {
+		w.Header().Set("Retry-After", retryAfterSeconds)
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	if !cr.admitJoin(w, r) {
+		return
+	}
+	if !cr.checkJoinGate(w, r, clientID) {
+		return
+	}
+	role := r.URL.Query().Get("role")
+	switch role {
+	case "":
+		role = roleMember
+	case roleMember, roleSpectator:
+	default:
+		http.Error(w, "Role must be member or spectator", http.StatusBadRequest)
+		return
+	}
+	if cr.watchOnly && !cr.isAdmin(r) {
+		role = roleSpectator
+	}
+	c := newClient(transportPoll, role)
+	c.addr = cr.remoteIP(r)
+	c.userAgent = userAgent(r)
+	c.welcome = cr.Welcome().Text
+	c.provisioned = !cr.isAdmin(r)
+	if r.URL.Query().Has("capabilities") {
+		c.caps = parseCapabilities(r.URL.Query().Get("capabilities"))
+	}
+	if cr.joinApproval > 0 && !cr.isAdmin(r) {
+		c.pending = true
+		c.pendingSince = cr.clock.Now()
+	}
+	switch err := cr.addClient(clientID, c); err {
+	case nil:
+	case errClientIDInUse:
+		http.Error(w, "Client ID is already in use", http.StatusConflict)
+		return
+	case errNotProvisioned:
+		http.Error(w, "Client ID is not provisioned", http.StatusForbidden)
+		return
+	case errClientDeparting:
+		w.Header().Set("Retry-After", retryAfterSeconds)
+		http.Error(w, "Client ID is still leaving; retry shortly", http.StatusConflict)
+		return
+	default:
+		http.Error(w, "Chat room is closed", http.StatusGone)
+		return
+	}
+	cr.joins.add(cr.clock.Now())
+	cr.markInstance(w)
+	w.Header().Set(sessionHeader, c.sessionID)
+	cr.mutex.Lock()
+	token := cr.sendTokenLocked(clientID, c)
+	joinSeq := c.joinSeq
+	cr.mutex.Unlock()
+	w.Header().Set(tokenHeader, token)
+	if cr.requestSigning.enabled {
+		w.Header().Set(requestSigningHeader, requestSigningScheme)
+		w.Header().Set(requestSecretHeader, base64.RawURLEncoding.EncodeToString(cr.requestSecret(clientID, c.sessionID)))
+	}
+	w.Header().Set(capabilitiesHeader, capabilitiesList())
+	if !c.pending {
+		w.Header().Set(joinSeqHeader, strconv.FormatUint(joinSeq, 10))
+	}
+	if c.pending {
+		cr.awaitApproval(clientID, c)
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "Client %s is awaiting approval", clientID)
+		return
+	}
+	fmt.Fprintf(w, "Client %s joined the chat", clientID)
+}
+
+func (cr *ChatRoom) HandleSend(w http.ResponseWriter, r *http.Request) {
+	if cr.misdirected(w, r) {
+		return
+	}
+	select {
+	case <-cr.done:
+		// Close drops every session, so no token would check out either.
+		http.Error(w, "Chat room is closed", http.StatusGone)
+		return
+	default:
+	}
+	sample := cr.sample()
+	clientID, ok := cr.sender(w, r)
+	if !ok {
+		return
+	}
+	message := r.URL.Query().Get("message")
+	if message == "" {
+		http.Error(w, "Message is required", http.StatusBadRequest)
+		return
+	}
+	if !validClientID(clientID) {
+		http.Error(w, errInvalidClientID.Error(), http.StatusBadRequest)
+		return
+	}
+	switch err := validateMessage(message); err {
+	case nil:
+	case errMessageTooLong:
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opaque := r.URL.Query().Get("kind") == kindE2EE
+	if err := cr.checkE2EE(r.URL.Query().Get("kind"), message); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cr.mutex.Lock()
+	sender, exists := cr.clients[clientID]
+	_, departing := cr.departing[clientID]
+	spectator := exists && sender.role == roleSpectator
+	pending := exists && sender.pending
+	mutedUntil, muted := cr.mutedUntilLocked(clientID)
+	shadowed := cr.shadowMutedLocked(clientID)
+	closed := cr.closed
+	m := cr.maintenance
+	cr.mutex.Unlock()
+	if closed {
+		http.Error(w, "Chat room is closed", http.StatusGone)
+		return
+	}
+	if m.Enabled && !cr.isAdmin(r) {
+		w.Header().Set("Retry-After", retryAfterSeconds)
+		http.Error(w, maintenanceText(m), http.StatusServiceUnavailable)
+		return
+	}
+	if departing {
+		http.Error(w, "Client "+clientID+" has left and is departing", http.StatusConflict)
+		return
+	}
+	if !exists {
+		http.Error(w, "Invalid client ID", http.StatusNotFound)
+		return
+	}
+	if pending {
+		http.Error(w, "Your join request is awaiting approval", http.StatusForbidden)
+		return
+	}
+	if spectator {
+		http.Error(w, "Spectators cannot send messages", http.StatusForbidden)
+		return
+	}
+	if muted && !shadowed {
+		http.Error(w, "You are muted until "+mutedUntil.Format(time.RFC3339), http.StatusForbidden)
+		return
+	}
+	verified, err := cr.verifySignature(clientID, message, r.URL.Query().Get("sig"), r.URL.Query().Get("ts"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if !cr.limitSend(w, r, clientID) {
+		return
+	}
+	sent := message
+	if !opaque {
+		if message = cr.redactSend(clientID, message); message != sent {
+			verified = false // The signature was over the secret
+		}
+	}
+	if !shadowed && !opaque && cr.checkSpam(clientID, message) {
+		http.Error(w, "You have been muted for sending the same message repeatedly", http.StatusForbidden)
+		return
+	}
+	if sample != nil {
+		sample.validated = cr.clock.Now()
+	}
+
+	if cr.chaosSendError() {
+		w.Header().Set("Retry-After", retryAfterSeconds)
+		http.Error(w, "Server is busy, try again later", http.StatusServiceUnavailable)
+		return
+	}
+
+	clientMsgID := r.URL.Query().Get("client_msg_id")
+	if clientMsgID != "" && !validClientID(clientMsgID) {
+		http.Error(w, "client_msg_id may only use letters, digits and . _ - @", http.StatusBadRequest)
+		return
+	}
+	kind := r.URL.Query().Get("kind")
+	payload := json.RawMessage(r.URL.Query().Get("payload"))
+	if err := cr.validateKind(kind, payload); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var embargoUntil time.Time
+	if s := r.URL.Query().Get("embargo_until"); s != "" {
+		var err error
+		if embargoUntil, err = parseEmbargo(s, cr.clock.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if kind == kindPoll {
+			http.Error(w, "Polls cannot be embargoed", http.StatusBadRequest)
+			return
+		}
+	}
+
+	msg := &Message{From: clientID, Body: message}
+	if kind != "" && kind != kindText {
+		msg.Kind, msg.Payload = kind, payload
+	} else if name, args, ok := parseCommand(message); ok {
+		var err error
+		msg, err = cr.runCommand(clientID, name, args, cr.isAdmin(r))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if msg == nil {
+			fmt.Fprintf(w, "Command /%s executed", name)
+			return
+		}
+		if msg.Type == messageTypeEphemeral {
+			if err := cr.SendEphemeral(clientID, *msg); err != nil {
+				writeEphemeralError(w, err)
+				return
+			}
+			fmt.Fprintf(w, "Command /%s executed: %s", name, msg.Body)
+			return
+		}
+	} else if strings.HasPrefix(message, "//") {
+		msg.Body = message[1:]
+	}
+	msg.Verified = verified && msg.From == clientID
+	msg.shadow = shadowed
+	if !msg.opaque() {
+		msg.Entities = cr.entities(msg.Body)
+	}
+	cr.highlight(msg)
+	cr.fold(msg)
+	msg.ClientMsgID = clientMsgID
+	canonical := r.URL.Query().Get("format") == "json"
+
+	if !embargoUntil.IsZero() {
+		id, err := cr.Embargo(*msg, embargoUntil)
+		if err != nil {
+			http.Error(w, "Chat room is closed", http.StatusGone)
+			return
+		}
+		cr.ClearDraft(clientID)
+		if canonical {
+			msg.ID, msg.Time = id, embargoUntil
+			writeSendResult(w, *msg, sent)
+			return
+		}
+		fmt.Fprintf(w, "Message %s from %s embargoed until %s", id, clientID, embargoUntil.UTC().Format(time.RFC3339Nano))
+		return
+	}
+
+	if msg.Kind == kindPoll {
+		if err := cr.openPoll(msg); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+	}
+	if sample != nil {
+		sample.published = cr.clock.Now()
+		msg.sample = sample
+	}
+	err = cr.publishFrom(sender, msg)
+	if err != nil && msg.Kind == kindPoll {
+		cr.discardPoll(msg.ID)
+	}
+	switch err {
+	case nil:
+	case errRoomClosed:
+		http.Error(w, "Chat room is closed", http.StatusGone)
+		return
+	case errShuttingDown:
+		w.Header().Set("Retry-After", retryAfterSeconds)
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	case errBusy:
+		w.Header().Set("Retry-After", retryAfterSeconds)
+		http.Error(w, "Server is busy, try again later", http.StatusServiceUnavailable)
+		return
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cr.ClearDraft(clientID)
+	if canonical {
+		writeSendResult(w, *msg, sent)
+		return
+	}
+	if msg.Kind == kindPoll {
+		fmt.Fprintf(w, "Poll %s from %s sent", msg.ID, clientID)
+		return
+	}
+	fmt.Fprintf(w, "Message from %s sent", clientID)
+}
+
+func (cr *ChatRoom) HandleLeave(w http.ResponseWriter, r *http.Request) {
+	if cr.misdirected(w, r) {
+		return
+	}
+	clientID, ok := cr.sender(w, r)
+	if !ok {
+		return
+	}
+	cr.RemoveClient(clientID)
+	fmt.Fprintf(w, "Client %s left the chat", clientID)
+}
+
+func (cr *ChatRoom) HandleMessages(w http.ResponseWriter, r *http.Request) {
+	if cr.misdirected(w, r) {
+		return
+	}
+	advertise := func() {
+		w.Header().Set(pollWaitHeader, strconv.Itoa(int(cr.advertisedWait()/time.Second)))
+	}
+	// Even a rejected poll tells the client how long to back off.
+	advertise()
+	clientID, ok := cr.sender(w, r)
+	if !ok {
+		return
+	}
+	if !validClientID(clientID) {
+		http.Error(w, errInvalidClientID.Error(), http.StatusBadRequest)
+		return
+	}
+	wait, ok := cr.requestedWait(r)
+	if !ok {
+		http.Error(w, "wait must be a whole number of seconds", http.StatusBadRequest)
+		return
+	}
+
+	cr.mutex.Lock()
+	owner, exists := cr.clients[clientID]
+	if !exists {
+		owner, exists = cr.departing[clientID]
+	}
+	var c *client
+	if exists {
+		c = owner.session(r.URL.Query().Get("session"))
+	}
+	cr.mutex.Unlock()
+	if !exists {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+	if c == nil {
+		http.Error(w, "Session has ended; the client logged in elsewhere", statusLoginTimeout)
+		return
+	}
+	if r.URL.Query().Get("stream") == "true" {
+		limit, ok := streamLimit(r)
+		if !ok {
+			http.Error(w, "max must be a positive number of messages", http.StatusBadRequest)
+			return
+		}
+		cr.streamMessages(w, r, owner, c, wait, limit)
+		return
+	}
+	if msg, ok := cr.pendingNotice(owner); ok {
+		writeMessage(w, r, msg, http.StatusOK)
+		return
+	}
+	if msg, ok := cr.welcomeNotice(owner, c); ok {
+		writeMessage(w, r, msg, http.StatusOK)
+		return
+	}
+	if msg, ok := cr.keywordNotice(c); ok {
+		writeMessage(w, r, msg, http.StatusOK)
+		return
+	}
+
+	encode := func(m Message) []byte { return pollBytes(r, m) }
+	msg, b, res := cr.next(owner, c, framePoll, encode, cr.clock.After(wait), r.Context().Done())
+	advertise()
+	switch res {
+	case nextMessage:
+		status := http.StatusOK
+		if msg.Type == messageTypeReplaced || msg.Type == messageTypeRevoked {
+			status = statusLoginTimeout
+		}
+		writePoll(w, r, b, status)
+		cr.recordDelivery(msg)
+	case nextGone:
+		http.Error(w, "Client has left the chat", http.StatusGone)
+	case nextTimeout:
+		http.Error(w, "Request timed out", http.StatusGatewayTimeout)
+	}
+}
+
+// SendResult is the /send response with format=json: the message exactly
+// as it is delivered, and whether the server changed the text the client
+// sent (a command or the // escape), so an optimistic UI can correct it.
+type SendResult struct {
+	Message  Message `json:"message"`
+	Modified bool    `json:"modified"`
+}
+
+func writeSendResult(w http.ResponseWriter, msg Message, sent string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SendResult{Message: msg, Modified: msg.Body != sent})
+}
+
+// writeMessage answers a poll with msg, as a JSON envelope for format=json
+// and as its line otherwise.
+func writeMessage(w http.ResponseWriter, r *http.Request, msg Message, status int) {
+	writePoll(w, r, pollBytes(r, msg), status)
+}
+
+// pollBytes encodes msg as a long-poll response body: JSON with
+// format=json, otherwise its line.
+func pollBytes(r *http.Request, msg Message) []byte {
+	if r.URL.Query().Get("format") == "json" {
+		return msg.jsonLine()
+	}
+	return msg.Line()
+}
+
+// writePoll writes a long-poll response body encoded by pollBytes.
+func writePoll(w http.ResponseWriter, r *http.Request, b []byte, status int) {
+	if r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+// Stats is the snapshot reported by /stats.
+type Stats struct {
+	Topic                  string         `json:"topic,omitempty"`
+	Generation             uint64         `json:"generation"` // Registry generation the counts come from, as in /clients
+	Clients                int            `json:"clients"`
+	Spectators             int            `json:"spectators"`
+	Pending                int            `json:"pending,omitempty"` // Joins awaiting approval, not counted in Clients
+	BroadcastQueueDepth    int            `json:"broadcast_queue_depth"`
+	BroadcastQueueCapacity int            `json:"broadcast_queue_capacity"`
+	DeliveryLatency        LatencySummary `json:"delivery_latency"`
+	SignatureFailures      int64          `json:"signature_failures"`
+	Panics                 int64          `json:"panics"`     // Handler panics recovered since start
+	Deliveries             uint64         `json:"deliveries"` // Since start; the sum of deliveries in /admin/usage
+	Admission              AdmissionStats `json:"admission"`
+}
+
+func (cr *ChatRoom) Stats() Stats {
+	cr.mutex.Lock()
+	topic := cr.topic
+	cr.mutex.Unlock()
+	r := cr.roster()
+	return Stats{
+		Topic:                  topic,
+		Generation:             r.gen,
+		Clients:                len(r.entries),
+		Spectators:             r.spectators,
+		Pending:                r.pending,
+		BroadcastQueueDepth:    len(cr.broadcast),
+		BroadcastQueueCapacity: cap(cr.broadcast),
+		DeliveryLatency:        cr.latency.summary(),
+		SignatureFailures:      cr.signatureFailures.Load(),
+		Panics:                 cr.panics.Load(),
+		Deliveries:             cr.delivered.Load(),
+		Admission:              cr.warmup.stats(cr.clock.Now()),
+	}
+}
+
+func (cr *ChatRoom) HandleStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cr.Stats())
+}
+
+// ClientInfo describes a registered client in /clients.
+type ClientInfo struct {
+	ID        string `json:"id"`
+	Transport string `json:"transport"`
+	Status    string `json:"status"` // online, or busy while in do-not-disturb
+	// IP and UserAgent describe the join request and are shown to admins only.
+	IP        string `json:"ip,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	Notes     []Note `json:"notes,omitempty"`     // Moderator notes, shown to admins only
+	Invisible bool   `json:"invisible,omitempty"` // Hidden from other clients; only admins see the client at all
+}
+
+// ClientList is the body of /clients, with spectators listed apart from
+// members.
+type ClientList struct {
+	Generation uint64       `json:"generation"` // Registry generation, as in /stats
+	Members    []ClientInfo `json:"members"`
+	Spectators []ClientInfo `json:"spectators"`
+}
+
+// ClientSummary is the body of /clients?summary=true: counts kept with the
+// roster, so a large room is summarised without listing it.
+type ClientSummary struct {
+	Generation uint64 `json:"generation"`
+	Members    int    `json:"members"`
+	Spectators int    `json:"spectators"`
+	Online     int    `json:"online"` // Members and spectators by status
+	Busy       int    `json:"busy"`
+	Pending    int    `json:"pending"` // Joins awaiting approval, not counted above
+}
+
+// ClientSummary counts every client, invisible ones included, as an admin
+// sees them.
+func (cr *ChatRoom) ClientSummary() ClientSummary {
+	return cr.roster().summary(cr.clock.Now(), nil)
+}
+
+func (e rosterEntry) info(now time.Time) ClientInfo {
+	return ClientInfo{ID: e.id, Transport: e.transport, Status: e.status(now), IP: e.addr, UserAgent: e.userAgent, Invisible: e.invisible}
+}
+
+func (cr *ChatRoom) Clients() ClientList {
+	r := cr.roster()
+	list := ClientList{Generation: r.gen, Members: []ClientInfo{}, Spectators: []ClientInfo{}}
+	now := cr.clock.Now()
+	for _, e := range r.entries {
+		if e.spectator {
+			list.Spectators = append(list.Spectators, e.info(now))
+		} else {
+			list.Members = append(list.Members, e.info(now))
+		}
+	}
+	return list
+}
+
+// HandleClients serves /clients, streaming it from the roster as a
+// ClientList. A paged request pages members and spectators together by ID,
+// and ?summary=true returns a ClientSummary instead. Invisible clients are
+// left out of both for everyone but admins and themselves.
+func (cr *ChatRoom) HandleClients(w http.ResponseWriter, r *http.Request) {
+	admin := cr.isAdmin(r)
+	// Clients see themselves even when invisible; a missing or bad token
+	// just means seeing no invisible clients.
+	var caller string
+	if !admin {
+		caller, _, _ = cr.senderSession(r)
+	}
+	shown := func(e rosterEntry) bool { return admin || !e.invisible || e.id == caller }
+	rs := cr.roster()
+	if r.URL.Query().Get("summary") == "true" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rs.summary(cr.clock.Now(), shown))
+		return
+	}
+	p, err := parsePage(r, "clients")
+	if err != nil {
+		pageFailed(w, err)
+		return
+	}
+	var notes map[string][]Note
+	if admin {
+		notes = cr.notesSnapshot()
+	}
+	entries := rs.entries
+	if p.limit > 0 {
+		var next string
+		entries, next = rs.page(p, shown)
+		setNextCursor(w, next)
+	}
+	now := cr.clock.Now()
+	w.Header().Set("Content-Type", "application/json")
+	lw := newListWriter(w)
+	lw.raw(`{"generation":` + strconv.FormatUint(rs.gen, 10))
+	for _, spectators := range []bool{false, true} {
+		if spectators {
+			lw.raw(`,"spectators":`)
+		} else {
+			lw.raw(`,"members":`)
+		}
+		lw.begin()
+		for _, e := range entries {
+			if e.spectator != spectators || !shown(e) {
+				continue
+			}
+			info := e.info(now)
+			if admin {
+				info.Notes = notes[e.id]
+			} else {
+				info.IP, info.UserAgent, info.Invisible = "", "", false
+			}
+			lw.item(info)
+		}
+		lw.end()
+	}
+	lw.raw("}")
+	lw.done()
+}
+
+func (cr *ChatRoom) RunServer() {
+	srv := &http.Server{
+		Addr:              ":8080",
+		Handler:           cr.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		MaxHeaderBytes:    maxHeaderBytes,
+	}
+	cr.OnShutdown(ShutdownHook{Name: "listeners", Priority: shutdownListeners, Run: srv.Shutdown})
+	stopped := make(chan struct{})
+	go func() {
+		sigs := make(chan os.Signal, 2)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+		<-sigs
+		go func() {
+			<-sigs
+			log.Println("Second signal; exiting now")
+			os.Exit(1)
+		}()
+		log.Println("Shutting down; signal again to exit at once")
+		cr.Shutdown()
+		close(stopped)
+	}()
+	log.Println("Chat server running on http://localhost:8080")
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-stopped
+}
+
+func main() {
+	broadcastBuffer := flag.Int("broadcast-buffer", defaultBroadcastBuffer, "number of messages that may wait to be broadcast")
+	sendTimeout := flag.Duration("send-timeout", defaultSendTimeout, "how long /send waits for broadcast queue space before returning 503")
+	sessionQueue := flag.Int("session-queue", defaultSessionQueue, "deliveries each session may have waiting before the oldest is dropped")
+	adminToken := flag.String("admin-token", "", "bearer token for /admin endpoints (admin API disabled when empty)")
+	watchOnly := flag.Bool("watch-only", false, "make every join without admin credentials a spectator")
+	translateURL := flag.String("translate-url", "", "endpoint of an HTTP translation service (translation disabled when empty)")
+	instanceID := flag.String("instance-id", "", "name of this instance behind a load balancer (random when empty)")
+	pollMin := flag.Duration("poll-min", defaultPollWait.Min, "shortest long-poll a client may request")
+	pollMax := flag.Duration("poll-max", defaultPollWait.Max, "longest long-poll a client may request")
+	pollPreferred := flag.Duration("poll-wait", defaultPollWait.Preferred, "long-poll duration used by default and advertised in X-Poll-Wait")
+	linkPreviews := flag.Bool("link-previews", false, "fetch URLs in messages and broadcast link previews")
+	spamEnabled := flag.Bool("spam-detection", defaultSpamConfig.Enabled, "automatically mute clients that repeat the same message")
+	spamRepeats := flag.Int("spam-repeats", defaultSpamConfig.Repeats, "identical messages within -spam-window that trigger an auto-mute")
+	spamWindow := flag.Duration("spam-window", defaultSpamConfig.Window, "window in which repeated messages are counted")
+	duplicateLogin := flag.String("duplicate-login", string(LoginReplace), "what a join under an ID already in use does: replace, reject or coexist")
+	spamMute := flag.Duration("spam-mute", defaultSpamConfig.MuteFor, "how long an automatic mute lasts")
+	joinApproval := flag.Duration("join-approval", 0, "require admin approval for joins, expiring requests after this long (0 disables)")
+	trustedProxies := flag.String("trusted-proxies", "", "comma-separated addresses or CIDRs of proxies whose X-Forwarded-For is trusted")
+	anyKind := flag.Bool("allow-any-kind", false, "accept message kinds that are not registered")
+	joinPoW := flag.Int("join-pow", 0, "leading zero bits of proof of work required to join, raised automatically under load (0 disables)")
+	joinPoWSecret := flag.String("join-pow-secret", "", "HMAC key for join challenges; set it so challenges survive restarts (random when empty)")
+	inviteCodes := flag.String("invite-codes", "", "comma-separated invite codes, one of which /join must present as invite=")
+	alertDropRate := flag.Float64("alert-drop-rate", 0, "alert when this fraction of deliveries is dropped (0 disables)")
+	alertQueueDepth := flag.Int("alert-queue-depth", 0, "alert when the broadcast queue holds this many messages (0 disables)")
+	alertP99 := flag.Duration("alert-p99", 0, "alert when delivery latency p99 reaches this (0 disables)")
+	alertFor := flag.Duration("alert-for", time.Minute, "how long a condition must hold before an alert fires or resolves")
+	alertNotify := flag.String("alert-notify", "", "client ID sent alert events as ephemeral system messages")
+	alertWebhook := flag.String("alert-webhook", "", "URL alert events are POSTed to as JSON")
+	leaveGrace := flag.Duration("leave-grace", defaultLeaveGrace, "how long a client that left still receives messages queued before it left (0 closes at once)")
+	shareLinks := flag.Bool("share-links", false, "let clients create expiring links that show a recent message to anyone")
+	shareMaxExpiry := flag.Duration("share-max-expiry", defaultMaxShareExpiry, "longest expiry a share link may have")
+	warmup := flag.Duration("warmup", 0, "after startup, admit at most -warmup-join-rate joins per second for this long, turning the rest away with a jittered Retry-After (0 disables)")
+	warmupJoinRate := flag.Int("warmup-join-rate", defaultWarmupJoinRate, "joins per second admitted during -warmup")
+	handlerTimeout := flag.Duration("handler-timeout", defaultHandlerTimeout, "how long a request handler may run before the client gets 503; long polls, profiles and streamed lists are exempt (0 disables)")
+	foldLength := flag.Int("fold-length", 0, "fold chat messages longer than this many bytes, serving the whole body from /messages/{id}/full (0 disables)")
+	welcome := flag.String("welcome", "", "text sent to each new joiner before anything else, e.g. the room rules")
+	outboundProxy := flag.String("outbound-proxy", "", "proxy URL for server-initiated HTTP (HTTP_PROXY and friends when empty)")
+	outboundCA := flag.String("outbound-ca", "", "PEM bundle of extra CAs trusted for server-initiated HTTPS")
+	outboundTimeout := flag.Duration("outbound-timeout", defaultOutboundTimeout, "timeout for server-initiated HTTP requests")
+	outboundIdle := flag.Int("outbound-max-idle", defaultOutboundIdle, "idle connections kept for server-initiated HTTP")
+	outboundCheck := flag.Bool("outbound-check", false, "probe outbound integrations at startup and report them in /readyz")
+	ui := flag.Bool("ui", true, "serve the embedded web client at /ui")
+	highlight := flag.Bool("highlight", true, "attach syntax highlighting to code messages")
+	redact := flag.Bool("redact", false, "replace API tokens, card numbers and phone numbers in sent messages with placeholders")
+	redactFile := flag.String("redact-file", "", "file of further redaction patterns, one name and regular expression per line; implies -redact")
+	signRequests := flag.Bool("sign-requests", false, "require clients to sign every request made with a send token, refusing replays (for deployments without TLS)")
+	signSkew := flag.Duration("sign-requests-skew", defaultRequestSkew, "how far a signed request's timestamp may be from server time")
+	e2ee := flag.Bool("e2ee", false, "end-to-end encrypted room: only kind=e2ee ciphertext messages, with public keys at /keys")
+	provisionToken := flag.String("provision-token", "", "bearer token for /provision; when set only provisioned client IDs (and admins) can join")
+	shutdownDeadlines := flag.String("shutdown-deadlines", "", "per-phase shutdown deadlines as phase=duration,... for joins, broadcast, pollers, webhooks, room and listeners (5s each by default)")
+	rateLimit := flag.Bool("rate-limit", false, "limit how fast each client sends by its rate class (human, bot or firehose), tunable at /admin/rate-classes")
+	chaos := flag.Bool("chaos", false, "enable fault injection for client testing (never use in production)")
+	chaosLatency := flag.Duration("chaos-latency", 0, "delivery latency added in chaos mode")
+	chaosDrop := flag.Float64("chaos-drop-rate", 0, "fraction of deliveries dropped in chaos mode")
+	chaosSendErrors := flag.Float64("chaos-send-error-rate", 0, "fraction of /send requests answered 503 in chaos mode")
+	flag.Parse()
+
+	loginPolicy, err := parseLoginPolicy(*duplicateLogin)
+	if err != nil {
+		log.Fatal(err)
+	}
+	proxies, err := parseTrustedProxies(*trustedProxies)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *joinPoW < 0 || *joinPoW > maxDifficulty {
+		log.Fatalf("-join-pow must be between 0 and %d", maxDifficulty)
+	}
+
+	outbound, err := Outbound{Proxy: *outboundProxy, CAFile: *outboundCA, Timeout: *outboundTimeout, MaxIdleConns: *outboundIdle}.Client()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var translator Translator = NoopTranslator{}
+	if *translateURL != "" {
+		translator = &HTTPTranslator{Endpoint: *translateURL, Client: outbound}
+	}
+
+	opts := []Option{
+		WithBroadcastBuffer(*broadcastBuffer),
+		WithSendTimeout(*sendTimeout),
+		WithSessionQueue(*sessionQueue),
+		WithAdminToken(*adminToken),
+		WithWatchOnly(*watchOnly),
+		WithTranslator(translator),
+		WithLinkPreviews(*linkPreviews),
+		WithInstanceID(*instanceID),
+		WithLoginPolicy(loginPolicy),
+		WithJoinApproval(*joinApproval),
+		WithTrustedProxies(proxies),
+		WithArbitraryKinds(*anyKind),
+		WithUI(*ui),
+		WithOutboundClient(outbound),
+		WithWelcome(*welcome),
+		WithFolding(*foldLength),
+		WithHighlighting(*highlight),
+		WithProvisioning(*provisionToken),
+		WithE2EE(*e2ee),
+		WithLeaveGrace(*leaveGrace),
+		WithHandlerTimeout(*handlerTimeout),
+		WithWarmup(Warmup{Window: *warmup, JoinRate: *warmupJoinRate}),
+		WithShareLinks(ShareLinks{Enabled: *shareLinks, MaxExpiry: *shareMaxExpiry}),
+		WithAlerts(AlertConfig{
+			DropRate:   *alertDropRate,
+			QueueDepth: *alertQueueDepth,
+			P99:        *alertP99,
+			For:        *alertFor,
+			Notify:     *alertNotify,
+			Webhook:    *alertWebhook,
+		}),
+		WithJoinGate(JoinGate{Difficulty: *joinPoW, Secret: []byte(*joinPoWSecret), Invites: parseInvites(*inviteCodes)}),
+		WithPollWait(PollWait{Min: *pollMin, Max: *pollMax, Preferred: *pollPreferred}),
+		WithSpamDetection(SpamConfig{
+			Enabled: *spamEnabled,
+			Repeats: *spamRepeats,
+			Window:  *spamWindow,
+			MuteFor: *spamMute,
+		}),
+	}
+	if *chaos {
+		c := Chaos{
+			LatencyMs:     int(*chaosLatency / time.Millisecond),
+			DropRate:      *chaosDrop,
+			SendErrorRate: *chaosSendErrors,
+		}
+		if err := c.validate(); err != nil {
+			log.Fatal(err)
+		}
+		log.Println("Chaos mode is on: deliveries may be delayed or dropped and sends may fail")
+		opts = append(opts, WithChaos(c))
+	}
+	if *rateLimit {
+		opts = append(opts, WithRateLimits(nil))
+	}
+	if *signRequests {
+		opts = append(opts, WithRequestSigning(*signSkew))
+	}
+	if *redact || *redactFile != "" {
+		patterns := builtinRedactions
+		if *redactFile != "" {
+			extra, err := loadRedactions(*redactFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			patterns = append(append([]RedactionPattern(nil), patterns...), extra...)
+		}
+		opts = append(opts, WithRedaction(patterns))
+	}
+	deadlines, err := parseShutdownDeadlines(*shutdownDeadlines)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts = append(opts, WithShutdownDeadlines(deadlines))
+	cr := NewChatRoom(opts...)
+	if *outboundCheck {
+		targets := make(map[string]string)
+		if *translateURL != "" {
+			targets["translate"] = *translateURL
+		}
+		if *alertWebhook != "" {
+			targets["webhook"] = *alertWebhook
+		}
+		go cr.CheckOutbound(targets)
+	}
+	cr.RunServer()
+}
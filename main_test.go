@@ -0,0 +1,486 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"chatroom/testutil"
+)
+
+// testPollWait keeps polls in tests short: wait=0 clamps to Min.
+var testPollWait = PollWait{Min: 20 * time.Millisecond, Preferred: 200 * time.Millisecond, Max: time.Second}
+
+// asAdmin holds the headers that authenticate a request to a test room as
+// an admin.
+var asAdmin = []string{"Authorization", "Bearer admin-secret"}
+
+// newTestRoom returns a room with test-friendly defaults, closed when the
+// test ends, and its HTTP handler.
+func newTestRoom(t testing.TB, opts ...Option) (*ChatRoom, http.Handler) {
+	t.Helper()
+	defaults := []Option{WithAdminToken("admin-secret"), WithPollWait(testPollWait), WithLeaveGrace(0)}
+	cr := NewChatRoom(append(defaults, opts...)...)
+	t.Cleanup(cr.Close)
+	return cr, cr.Handler()
+}
+
+// do serves one request, with headers given as name, value pairs.
+func do(h http.Handler, method, target string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+// join joins id and returns its send token.
+func join(t testing.TB, h http.Handler, id string) string {
+	t.Helper()
+	w := do(h, "POST", "/join?id="+url.QueryEscape(id))
+	if w.Code != http.StatusOK {
+		t.Fatalf("join %s: %d %s", id, w.Code, w.Body)
+	}
+	return w.Header().Get(tokenHeader)
+}
+
+func send(h http.Handler, token, message string) *httptest.ResponseRecorder {
+	return do(h, "POST", "/send?message="+url.QueryEscape(message), tokenHeader, token)
+}
+
+// pollOnce long-polls /messages for id with the shortest wait.
+func pollOnce(h http.Handler, id, token string) *httptest.ResponseRecorder {
+	return do(h, "GET", "/messages?wait=0&id="+url.QueryEscape(id), tokenHeader, token)
+}
+
+// eventually fails the test unless cond becomes true within a few seconds.
+func eventually(t testing.TB, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestCloseLeavesNoGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for i := 0; i < 3; i++ {
+		cr := NewChatRoom(WithPollWait(testPollWait))
+		h := cr.Handler()
+		ta := join(t, h, "alice")
+		tb := join(t, h, "bob")
+		ctx, cancel := context.WithCancel(context.Background())
+		if _, err := cr.Subscribe(ctx, "bot"); err != nil {
+			t.Fatal(err)
+		}
+		polled := make(chan int)
+		go func() { polled <- do(h, "GET", "/messages?wait=1&id=bob", tokenHeader, tb).Code }()
+		if w := send(h, ta, "hello"); w.Code != http.StatusOK {
+			t.Fatalf("send: %d %s", w.Code, w.Body)
+		}
+		<-polled
+		go pollOnce(h, "alice", ta) // Left waiting for Close to end it
+		cr.Close()
+		cancel()
+	}
+	eventually(t, "goroutines to return to baseline", func() bool { return runtime.NumGoroutine() <= before })
+}
+
+func TestCloseIsIdempotentAndRefusesSends(t *testing.T) {
+	cr, h := newTestRoom(t)
+	token := join(t, h, "alice")
+	cr.Close()
+	cr.Close()
+	if w := send(h, token, "late"); w.Code != http.StatusGone {
+		t.Errorf("send after Close: got %d, want %d", w.Code, http.StatusGone)
+	}
+	if err := cr.Publish(Message{From: systemSender, Body: "late"}); err != errRoomClosed {
+		t.Errorf("Publish after Close: got %v, want errRoomClosed", err)
+	}
+	if w := do(h, "POST", "/join?id=bob"); w.Code != http.StatusGone {
+		t.Errorf("join after Close: got %d %s, want %d", w.Code, w.Body, http.StatusGone)
+	}
+}
+
+// A send that finds the broadcast queue full waits the send timeout and is
+// then refused with 503 and Retry-After; /stats shows the queue filling.
+func TestSendsGet503WhenTheBroadcastQueueIsFull(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	// Chaos latency holds the broadcast loop on the first message until
+	// the clock passes a minute.
+	_, h := newTestRoom(t, WithClock(clk), WithBroadcastBuffer(2), WithSendTimeout(time.Second),
+		WithChaos(Chaos{LatencyMs: 60000}))
+	token := join(t, h, "alice")
+	stats := func() Stats {
+		t.Helper()
+		var s Stats
+		w := do(h, "GET", "/stats")
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &s) != nil {
+			t.Fatalf("stats: %d %s", w.Code, w.Body)
+		}
+		return s
+	}
+
+	idle := clk.Waiters()
+	if w := send(h, token, "held"); w.Code != http.StatusOK {
+		t.Fatalf("first send: %d %s", w.Code, w.Body)
+	}
+	eventually(t, "the broadcast loop to hold the first message", func() bool {
+		return clk.Waiters() > idle && stats().BroadcastQueueDepth == 0
+	})
+	held := clk.Waiters()
+	for _, body := range []string{"queued 1", "queued 2"} {
+		if w := send(h, token, body); w.Code != http.StatusOK {
+			t.Fatalf("send %q: %d %s", body, w.Code, w.Body)
+		}
+	}
+	if s := stats(); s.BroadcastQueueDepth != 2 || s.BroadcastQueueCapacity != 2 {
+		t.Errorf("stats with the queue full: depth %d of %d, want 2 of 2", s.BroadcastQueueDepth, s.BroadcastQueueCapacity)
+	}
+
+	refused := make(chan *httptest.ResponseRecorder)
+	go func() { refused <- send(h, token, "too many") }()
+	eventually(t, "the send to wait for queue space", func() bool { return clk.Waiters() > held })
+	select {
+	case w := <-refused:
+		t.Fatalf("send answered %d before the send timeout", w.Code)
+	default:
+	}
+	clk.Advance(time.Second)
+	w := <-refused
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != retryAfterSeconds {
+		t.Errorf("send to a full queue: %d, Retry-After %q, want 503 and %s", w.Code, w.Header().Get("Retry-After"), retryAfterSeconds)
+	}
+
+	// Each message is held for its own minute.
+	eventually(t, "the queue to drain", func() bool {
+		clk.Advance(time.Minute)
+		return stats().BroadcastQueueDepth == 0
+	})
+	if w := send(h, token, "room again"); w.Code != http.StatusOK {
+		t.Errorf("send after the queue drained: %d %s", w.Code, w.Body)
+	}
+}
+
+// One client's sends, made from many goroutines at once, reach every
+// receiver in the order they were numbered: Seq without gaps, EventSeq
+// increasing.
+func TestConcurrentSendsFromOneSenderKeepTheirOrder(t *testing.T) {
+	const sends, goroutines, receivers = 1000, 50, 4
+	cr, h := newTestRoom(t, WithSessionQueue(2*sends))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var bad errorsOf
+	var readers sync.WaitGroup
+	for i := 0; i < receivers; i++ {
+		ch, err := cr.Subscribe(ctx, fmt.Sprintf("receiver%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		readers.Add(1)
+		go func(name string, ch <-chan Message) {
+			defer readers.Done()
+			var seq, eventSeq uint64
+			timeout := time.After(30 * time.Second)
+			for seq < sends {
+				select {
+				case m := <-ch:
+					if m.From != "alice" {
+						continue
+					}
+					if m.Seq != seq+1 || m.EventSeq <= eventSeq {
+						bad.add("%s: Seq %d (event %d) after Seq %d (event %d)", name, m.Seq, m.EventSeq, seq, eventSeq)
+					}
+					seq, eventSeq = m.Seq, m.EventSeq
+				case <-timeout:
+					bad.add("%s: timed out after Seq %d", name, seq)
+					return
+				}
+			}
+		}(fmt.Sprintf("receiver%d", i), ch)
+	}
+	token := join(t, h, "alice")
+
+	var senders sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		senders.Add(1)
+		go func(g int) {
+			defer senders.Done()
+			for i := 0; i < sends/goroutines; i++ {
+				if w := send(h, token, fmt.Sprintf("%d.%d", g, i)); w.Code != http.StatusOK {
+					bad.add("send %d.%d: %d %s", g, i, w.Code, w.Body)
+				}
+			}
+		}(g)
+	}
+	senders.Wait()
+	readers.Wait()
+	bad.report(t)
+}
+
+// BenchmarkPublishDeliver publishes one message at a time to a room of poll
+// clients and waits for an in-process subscriber to receive it: the
+// send-to-deliver latency and allocations of the broadcast path. Poll
+// clients are never read, so their queues are filled first and then run
+// full, as an idle room's would.
+func BenchmarkPublishDeliver(b *testing.B) {
+	for _, n := range []int{100, 1000, 10000} {
+		b.Run(fmt.Sprintf("clients=%d", n), func(b *testing.B) {
+			cr, _ := newTestRoom(b)
+			for i := 0; i < n; i++ {
+				if err := cr.AddClient(fmt.Sprintf("user%d", i)); err != nil {
+					b.Fatal(err)
+				}
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			probe, err := cr.Subscribe(ctx, "probe")
+			if err != nil {
+				b.Fatal(err)
+			}
+			msg := Message{From: "bench", Body: "the quick brown fox jumps over the lazy dog"}
+			publish := func() {
+				if err := cr.Publish(msg); err != nil {
+					b.Fatal(err)
+				}
+				for m := range probe {
+					if m.From == "bench" {
+						break
+					}
+				}
+			}
+			for i := 0; i < defaultSessionQueue; i++ {
+				publish()
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				publish()
+			}
+		})
+	}
+}
+
+// pollAsync starts a long poll with the given wait on a room driven by clk,
+// returning once the poll is waiting on the clock.
+func pollAsync(t *testing.T, clk *testutil.FakeClock, h http.Handler, id, token, wait string) <-chan *httptest.ResponseRecorder {
+	t.Helper()
+	base := clk.Waiters()
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() { done <- do(h, "GET", "/messages?wait="+wait+"&id="+id, tokenHeader, token) }()
+	eventually(t, "the poll to start waiting", func() bool { return clk.Waiters() > base })
+	return done
+}
+
+func TestHandleMessagesTimesOutAfterTheWait(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	_, h := newTestRoom(t, WithClock(clk), WithPollWait(PollWait{Min: time.Second, Preferred: 30 * time.Second, Max: time.Minute}))
+	token := join(t, h, "bob")
+	done := pollAsync(t, clk, h, "bob", token, "30")
+	clk.Advance(30*time.Second - time.Millisecond)
+	select {
+	case w := <-done:
+		t.Fatalf("poll ended early: %d %s", w.Code, w.Body)
+	case <-time.After(20 * time.Millisecond):
+	}
+	clk.Advance(time.Millisecond)
+	w := <-done
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("got %d %s, want %d", w.Code, w.Body, http.StatusGatewayTimeout)
+	}
+	if got := w.Header().Get(pollWaitHeader); got != "30" {
+		t.Errorf("%s = %q, want 30", pollWaitHeader, got)
+	}
+}
+
+func TestHandleMessagesClampsTheWait(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	_, h := newTestRoom(t, WithClock(clk), WithPollWait(PollWait{Min: 5 * time.Second, Preferred: 30 * time.Second, Max: time.Minute}))
+	token := join(t, h, "bob")
+	for _, tc := range []struct {
+		wait  string
+		after time.Duration
+	}{{"0", 5 * time.Second}, {"3600", time.Minute}, {"", 30 * time.Second}} {
+		done := pollAsync(t, clk, h, "bob", token, tc.wait)
+		clk.Advance(tc.after - time.Millisecond)
+		select {
+		case w := <-done:
+			t.Fatalf("wait=%q ended before %s: %d", tc.wait, tc.after, w.Code)
+		case <-time.After(20 * time.Millisecond):
+		}
+		clk.Advance(time.Millisecond)
+		if w := <-done; w.Code != http.StatusGatewayTimeout {
+			t.Errorf("wait=%q: got %d %s", tc.wait, w.Code, w.Body)
+		}
+	}
+}
+
+func TestHandleMessagesReturnsAMessageBeforeTheWait(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	_, h := newTestRoom(t, WithClock(clk), WithPollWait(PollWait{Min: time.Second, Preferred: 30 * time.Second, Max: time.Minute}))
+	ta := join(t, h, "alice")
+	tb := join(t, h, "bob")
+	done := pollAsync(t, clk, h, "bob", tb, "30")
+	clk.Advance(10 * time.Second)
+	send(h, ta, "hello")
+	if w := <-done; w.Code != http.StatusOK || w.Body.String() != "alice: hello\n" {
+		t.Errorf("got %d %q", w.Code, w.Body)
+	}
+}
+
+// pollFrom polls id's queue in JSON until a message from sender arrives and
+// returns the raw body.
+func pollFrom(t *testing.T, h http.Handler, id, token, sender string) []byte {
+	t.Helper()
+	for i := 0; i < 20; i++ {
+		w := do(h, "GET", "/messages?format=json&wait=0&id="+id, tokenHeader, token)
+		var m Message
+		if w.Code == http.StatusOK && json.Unmarshal(w.Body.Bytes(), &m) == nil && m.From == sender {
+			return w.Body.Bytes()
+		}
+	}
+	t.Fatalf("%s never received a message from %s", id, sender)
+	return nil
+}
+
+// Deliveries that share the published envelope write exactly what encoding
+// the message for each delivery would.
+func TestSharedEnvelopeMatchesPerDeliveryEncoding(t *testing.T) {
+	cr, h := newTestRoom(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	probe, err := cr.Subscribe(ctx, "probe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cr.mutex.Lock()
+	cr.clients["probe"].caps = parseCapabilities("entities,kinds,highlight")
+	cr.mutex.Unlock()
+	ta := join(t, h, "alice")
+	joinWith := func(id string) string {
+		w := do(h, "POST", "/join?capabilities=entities,kinds,highlight&id="+id)
+		if w.Code != http.StatusOK {
+			t.Fatalf("join %s: %d %s", id, w.Code, w.Body)
+		}
+		return w.Header().Get(tokenHeader)
+	}
+	tb, tc := joinWith("bob"), joinWith("carol")
+	// Dave declares no capabilities, so entities and highlighting are
+	// stripped from his copies and they are encoded for him alone.
+	td := join(t, h, "dave")
+	cases := []url.Values{
+		{"message": {"plain text"}},
+		{"message": {"<b>&amp;</b>   héllo ✓"}},
+		{"message": {"@bob see https://example.com and `code`"}},
+		{"message": {"/me waves"}},
+		{"message": {"snippet"}, "kind": {kindCode}, "payload": {`{"language":"go","code":"x := <-ch"}`}},
+	}
+	for _, params := range cases {
+		if w := do(h, "POST", "/send?"+params.Encode(), tokenHeader, ta); w.Code != http.StatusOK {
+			t.Fatalf("send %v: %d %s", params, w.Code, w.Body)
+		}
+		var m Message
+		for m = range probe {
+			if m.From == "alice" {
+				break
+			}
+		}
+		if m.envelope == nil {
+			t.Errorf("send %v: delivered without the shared envelope", params)
+		}
+		generic := m
+		generic.envelope = nil
+		var want bytes.Buffer
+		json.NewEncoder(&want).Encode(generic)
+		for _, c := range []struct{ id, token string }{{"bob", tb}, {"carol", tc}} {
+			if got := pollFrom(t, h, c.id, c.token, "alice"); !bytes.Equal(got, want.Bytes()) {
+				t.Errorf("send %v: %s got\n%s\nwant\n%s", params, c.id, got, want.Bytes())
+			}
+		}
+		generic.Entities, generic.Highlight = nil, nil
+		want.Reset()
+		json.NewEncoder(&want).Encode(generic)
+		if got := pollFrom(t, h, "dave", td, "alice"); !bytes.Equal(got, want.Bytes()) {
+			t.Errorf("send %v: dave got\n%s\nwant\n%s", params, got, want.Bytes())
+		}
+	}
+}
+
+// BenchmarkJSONDelivery compares encoding a message once for all of its
+// recipients with encoding it per delivery.
+func BenchmarkJSONDelivery(b *testing.B) {
+	const recipients = 100
+	cr, _ := newTestRoom(b)
+	body := "the quick brown fox jumps over the lazy dog"
+	msg := Message{ID: "m1", Seq: 1, From: "bench", Body: body, Time: time.Now(), Entities: cr.entities(body)}
+	b.Run("shared", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			m := msg
+			m.envelope = &envelope{}
+			for j := 0; j < recipients; j++ {
+				m.jsonLine()
+			}
+		}
+	})
+	b.Run("per-delivery", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for j := 0; j < recipients; j++ {
+				msg.jsonLine()
+			}
+		}
+	})
+}
+
+// With format=json, /send returns the message exactly as the sender's own
+// poll and everyone else's will deliver it, echoing client_msg_id, and says
+// when the server changed the text.
+func TestSendReturnsTheCanonicalMessage(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	_, h := newTestRoom(t, WithClock(clk))
+	ta, tb := join(t, h, "alice"), join(t, h, "bob")
+	sendJSON := func(message, clientMsgID string) SendResult {
+		t.Helper()
+		q := url.Values{"format": {"json"}, "message": {message}, "client_msg_id": {clientMsgID}}
+		var res SendResult
+		if w := do(h, "POST", "/send?"+q.Encode(), tokenHeader, ta); w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &res) != nil {
+			t.Fatalf("send: %d %s", w.Code, w.Body)
+		}
+		return res
+	}
+
+	res := sendJSON("hello", "draft-1")
+	if res.Modified || res.Message.ID == "" || res.Message.Seq != 1 || !res.Message.Time.Equal(clk.Now()) || res.Message.ClientMsgID != "draft-1" {
+		t.Errorf("send result %+v", res)
+	}
+	for _, c := range []struct{ id, token string }{{"alice", ta}, {"bob", tb}} {
+		var m Message
+		if err := json.Unmarshal(pollFrom(t, h, c.id, c.token, "alice"), &m); err != nil {
+			t.Fatal(err)
+		}
+		if m.ID != res.Message.ID || m.Seq != res.Message.Seq || !m.Time.Equal(res.Message.Time) || m.ClientMsgID != "draft-1" || m.Body != "hello" {
+			t.Errorf("%s received %+v, want %+v", c.id, m, res.Message)
+		}
+	}
+
+	if res := sendJSON("//not a command", "draft-2"); !res.Modified || res.Message.Body != "/not a command" || res.Message.Seq != 2 {
+		t.Errorf("escaped send result %+v, want the slash removed and marked modified", res)
+	}
+	if w := do(h, "POST", "/send?message=hi&client_msg_id=no+spaces", tokenHeader, ta); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid client_msg_id: %d, want 400", w.Code)
+	}
+}
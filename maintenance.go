@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+const backFromMaintenance = "Maintenance is over, we're back"
+
+// Maintenance is the server's maintenance mode state.
+type Maintenance struct {
+	Enabled bool       `json:"enabled"`
+	Message string     `json:"message,omitempty"`
+	Since   *time.Time `json:"since,omitempty"`
+}
+
+func (cr *ChatRoom) Maintenance() Maintenance {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	return cr.maintenance
+}
+
+// SetMaintenance switches maintenance mode and announces the change to every
+// connected client, who keep receiving messages while it is on.
+func (cr *ChatRoom) SetMaintenance(enabled bool, message string) {
+	cr.mutex.Lock()
+	was := cr.maintenance.Enabled
+	if enabled {
+		now := cr.clock.Now()
+		cr.maintenance = Maintenance{Enabled: true, Message: message, Since: &now}
+	} else {
+		cr.maintenance = Maintenance{}
+	}
+	cr.mutex.Unlock()
+
+	switch {
+	case enabled && message != "":
+		cr.Publish(Message{From: systemSender, Body: message})
+	case !enabled && was:
+		cr.Publish(Message{From: systemSender, Body: backFromMaintenance})
+	}
+}
+
+func (cr *ChatRoom) HandleMaintenance(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req struct {
+			Enabled bool   `json:"enabled"`
+			Message string `json:"message"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxMessageLength)).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		if err := validateMessage(req.Message); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		cr.SetMaintenance(req.Enabled, req.Message)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cr.Maintenance())
+}
+
+func maintenanceText(m Maintenance) string {
+	if m.Message != "" {
+		return "Server is in maintenance: " + m.Message
+	}
+	return "Server is in maintenance"
+}
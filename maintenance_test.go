@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"chatroom/testutil"
+)
+
+// setMaintenance posts body to /admin/maintenance as an admin and returns
+// the state it reports.
+func setMaintenance(t *testing.T, h http.Handler, body string) Maintenance {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/admin/maintenance", strings.NewReader(body))
+	req.Header.Set(asAdmin[0], asAdmin[1])
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	var m Maintenance
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &m) != nil {
+		t.Fatalf("POST /admin/maintenance %s: %d %s", body, w.Code, w.Body)
+	}
+	return m
+}
+
+// During maintenance joins and member sends get 503 while clients already
+// in the room keep receiving; turning it off announces that the room is back.
+func TestMaintenanceRefusesJoinsAndSendsButKeepsDelivering(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	_, h := newTestRoom(t, WithClock(clk))
+	ta := join(t, h, "alice")
+	tb := join(t, h, "bob")
+	health := func() Health {
+		t.Helper()
+		var hl Health
+		if w := do(h, "GET", "/healthz"); json.Unmarshal(w.Body.Bytes(), &hl) != nil {
+			t.Fatalf("healthz: %d %s", w.Code, w.Body)
+		}
+		return hl
+	}
+
+	m := setMaintenance(t, h, `{"enabled": true, "message": "upgrading"}`)
+	if !m.Enabled || m.Message != "upgrading" || m.Since == nil || !m.Since.Equal(clk.Now()) {
+		t.Errorf("state after enabling: %+v", m)
+	}
+	var announced Message
+	if err := json.Unmarshal(pollFrom(t, h, "bob", tb, systemSender), &announced); err != nil || announced.Body != "upgrading" {
+		t.Errorf("bob's announcement: %+v %v, want upgrading", announced, err)
+	}
+
+	if w := do(h, "POST", "/join?id=carol"); w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "upgrading") {
+		t.Errorf("join during maintenance: %d %q, want 503 with the message", w.Code, w.Body)
+	}
+	if w := send(h, ta, "anyone?"); w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("member send during maintenance: %d, Retry-After %q, want 503 with Retry-After", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := do(h, "POST", "/send?id=alice&message=almost+done", asAdmin...); w.Code != http.StatusOK {
+		t.Errorf("admin send during maintenance: %d %s", w.Code, w.Body)
+	}
+	pollFrom(t, h, "bob", tb, "alice")
+
+	var got Maintenance
+	if w := do(h, "GET", "/admin/maintenance", asAdmin...); json.Unmarshal(w.Body.Bytes(), &got) != nil || !got.Enabled {
+		t.Errorf("GET /admin/maintenance: %d %s", w.Code, w.Body)
+	}
+	if hl := health(); hl.Status != "maintenance" || hl.Maintenance.Message != "upgrading" {
+		t.Errorf("healthz during maintenance: %+v", hl)
+	}
+
+	if m := setMaintenance(t, h, `{"enabled": false}`); m.Enabled || m.Since != nil {
+		t.Errorf("state after disabling: %+v", m)
+	}
+	var back Message
+	if err := json.Unmarshal(pollFrom(t, h, "bob", tb, systemSender), &back); err != nil || back.Body != backFromMaintenance {
+		t.Errorf("bob's notice after maintenance: %+v %v", back, err)
+	}
+	if hl := health(); hl.Status != "ok" {
+		t.Errorf("healthz after maintenance: %+v", hl)
+	}
+	if w := do(h, "POST", "/join?id=carol"); w.Code != http.StatusOK {
+		t.Errorf("join after maintenance: %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the delivery latency
+// histogram. Anything slower lands in the implicit +Inf bucket.
+var latencyBuckets = [...]float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// latencyHistogram records how long messages spend between being queued for
+// broadcast and being written to a client. Recording is lock-free.
+type latencyHistogram struct {
+	counts [len(latencyBuckets) + 1]atomic.Uint64
+	sum    atomic.Int64 // Nanoseconds
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	s := d.Seconds()
+	i := 0
+	for i < len(latencyBuckets) && s > latencyBuckets[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+}
+
+// recordDelivery observes the latency of msg if it went through the
+// broadcast queue; ephemeral messages and notices never do.
+func (cr *ChatRoom) recordDelivery(msg Message) {
+	if !msg.queued.IsZero() {
+		cr.latency.observe(cr.clock.Now().Sub(msg.queued))
+	}
+}
+
+// LatencySummary gives delivery latency percentiles in milliseconds,
+// estimated from the histogram buckets.
+type LatencySummary struct {
+	Count uint64  `json:"count"`
+	P50   float64 `json:"p50_ms"`
+	P95   float64 `json:"p95_ms"`
+	P99   float64 `json:"p99_ms"`
+}
+
+func (h *latencyHistogram) snapshot() (counts [len(latencyBuckets) + 1]uint64, total uint64) {
+	for i := range h.counts {
+		counts[i] = h.counts[i].Load()
+		total += counts[i]
+	}
+	return counts, total
+}
+
+func (h *latencyHistogram) summary() LatencySummary {
+	counts, total := h.snapshot()
+	return LatencySummary{
+		Count: total,
+		P50:   roundMillis(quantile(counts, total, 0.50) * 1000),
+		P95:   roundMillis(quantile(counts, total, 0.95) * 1000),
+		P99:   roundMillis(quantile(counts, total, 0.99) * 1000),
+	}
+}
+
+// quantile interpolates linearly inside the bucket holding the q-th
+// observation. Observations in the +Inf bucket are reported as the largest
+// finite bound.
+func quantile(counts [len(latencyBuckets) + 1]uint64, total uint64, q float64) float64 {
+	if total == 0 {
+		return 0
+	}
+	rank := q * float64(total)
+	var seen float64
+	for i, n := range counts {
+		if n == 0 || seen+float64(n) < rank {
+			seen += float64(n)
+			continue
+		}
+		if i == len(latencyBuckets) {
+			return latencyBuckets[i-1]
+		}
+		lower := 0.0
+		if i > 0 {
+			lower = latencyBuckets[i-1]
+		}
+		return lower + (latencyBuckets[i]-lower)*(rank-seen)/float64(n)
+	}
+	return latencyBuckets[len(latencyBuckets)-1]
+}
+
+// HandleMetrics serves metrics in the Prometheus text exposition format.
+func (cr *ChatRoom) HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	counts, total := cr.latency.snapshot()
+	fmt.Fprintln(w, "# HELP convo_delivery_latency_seconds Time from broadcast enqueue to write to a client.")
+	fmt.Fprintln(w, "# TYPE convo_delivery_latency_seconds histogram")
+	var cumulative uint64
+	for i, le := range latencyBuckets {
+		cumulative += counts[i]
+		fmt.Fprintf(w, "convo_delivery_latency_seconds_bucket{le=%q} %d\n", strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+	}
+	fmt.Fprintf(w, "convo_delivery_latency_seconds_bucket{le=\"+Inf\"} %d\n", total)
+	fmt.Fprintf(w, "convo_delivery_latency_seconds_sum %s\n", strconv.FormatFloat(float64(cr.latency.sum.Load())/1e9, 'g', -1, 64))
+	fmt.Fprintf(w, "convo_delivery_latency_seconds_count %d\n", total)
+
+	s := cr.Stats()
+	fmt.Fprintln(w, "# HELP convo_broadcast_queue_depth Messages waiting for the broadcast loop.")
+	fmt.Fprintln(w, "# TYPE convo_broadcast_queue_depth gauge")
+	fmt.Fprintf(w, "convo_broadcast_queue_depth %d\n", s.BroadcastQueueDepth)
+	fmt.Fprintln(w, "# HELP convo_deliveries_total Messages handed to a client.")
+	fmt.Fprintln(w, "# TYPE convo_deliveries_total counter")
+	fmt.Fprintf(w, "convo_deliveries_total %d\n", cr.delivered.Load())
+	fmt.Fprintln(w, "# HELP convo_deliveries_dropped_total Deliveries skipped by chaos mode or a bandwidth cap, or evicted from a full session queue.")
+	fmt.Fprintln(w, "# TYPE convo_deliveries_dropped_total counter")
+	fmt.Fprintf(w, "convo_deliveries_dropped_total %d\n", cr.dropped.Load())
+	fmt.Fprintln(w, "# HELP convo_clients Registered clients.")
+	fmt.Fprintln(w, "# TYPE convo_clients gauge")
+	fmt.Fprintf(w, "convo_clients %d\n", s.Clients)
+	fmt.Fprintln(w, "# HELP convo_dead_letters Webhook deliveries that failed every retry, awaiting replay.")
+	fmt.Fprintln(w, "# TYPE convo_dead_letters gauge")
+	fmt.Fprintf(w, "convo_dead_letters %d\n", cr.dlq.len())
+}
+
+// roundMillis keeps /stats readable; sub-microsecond precision is noise.
+func roundMillis(ms float64) float64 {
+	return math.Round(ms*1000) / 1000
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"chatroom/testutil"
+)
+
+func TestLatencyPercentilesInterpolateWithinBuckets(t *testing.T) {
+	var h latencyHistogram
+	for i := 0; i < 98; i++ {
+		h.observe(3 * time.Millisecond)
+	}
+	h.observe(2 * time.Second)
+	h.observe(2 * time.Second)
+
+	s := h.summary()
+	if s.Count != 100 {
+		t.Errorf("count %d, want 100", s.Count)
+	}
+	if s.P50 <= 2.5 || s.P50 > 5 {
+		t.Errorf("p50 %vms, want inside the 2.5-5ms bucket", s.P50)
+	}
+	// The 99th observation is the first of two in the 1-2.5s bucket.
+	if s.P99 != 1750 {
+		t.Errorf("p99 %vms, want 1750", s.P99)
+	}
+	if s := (&latencyHistogram{}).summary(); s != (LatencySummary{}) {
+		t.Errorf("empty histogram summary: %+v", s)
+	}
+}
+
+// The time a message waits in a session queue counts toward its delivery
+// latency, which /stats and /metrics both report.
+func TestDeliveryLatencyRunsFromEnqueueToWrite(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	cr, h := newTestRoom(t, WithClock(clk))
+	ta := join(t, h, "alice")
+	tb := join(t, h, "bob")
+	send(h, ta, "slow reader")
+	eventually(t, "the message to reach bob's queue", func() bool {
+		cr.mutex.Lock()
+		defer cr.mutex.Unlock()
+		return cr.clients["bob"].queue.len() > 0
+	})
+	clk.Advance(2 * time.Second)
+	if w := pollOnce(h, "bob", tb); w.Code != http.StatusOK {
+		t.Fatalf("poll: %d %s", w.Code, w.Body)
+	}
+
+	var s Stats
+	if w := do(h, "GET", "/stats"); json.Unmarshal(w.Body.Bytes(), &s) != nil {
+		t.Fatalf("stats: %d %s", w.Code, w.Body)
+	}
+	if l := s.DeliveryLatency; l.Count != 1 || l.P50 <= 1000 || l.P50 > 2500 {
+		t.Errorf("delivery latency %+v, want one delivery in the 1-2.5s bucket", l)
+	}
+	metrics := do(h, "GET", "/metrics").Body.String()
+	for _, line := range []string{
+		`convo_delivery_latency_seconds_bucket{le="1"} 0`,
+		`convo_delivery_latency_seconds_bucket{le="2.5"} 1`,
+		`convo_delivery_latency_seconds_sum 2`,
+		`convo_delivery_latency_seconds_count 1`,
+	} {
+		if !strings.Contains(metrics, line+"\n") {
+			t.Errorf("/metrics lacks %q", line)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const actorAdmin = "admin"
+
+// mute stops a client from sending until it expires.
+type mute struct {
+	until  time.Time
+	auto   bool // Set by the spam detector rather than a moderator
+	shadow bool // Sends are accepted but delivered only back to the sender
+	reason string
+}
+
+// Mute prevents clientID from sending for d. Mutes outlive the client's
+// registration, so leaving and rejoining does not lift them.
+func (cr *ChatRoom) Mute(clientID string, d time.Duration, reason, actor string, auto bool) time.Time {
+	until := cr.clock.Now().Add(d)
+	entry := AuditEntry{Action: "mute", Actor: actor, Target: clientID, Detail: reason}
+	cr.mutex.Lock()
+	cr.mutes[clientID] = mute{until: until, auto: auto, reason: reason}
+	if c, ok := cr.clients[clientID]; ok {
+		entry.IP, entry.UserAgent = c.addr, c.userAgent
+	}
+	cr.mutex.Unlock()
+	cr.audit.add(entry)
+	return until
+}
+
+// ShadowMute mutes clientID for d without telling it: its sends succeed and
+// come back to its own sessions, but nobody else receives them. Unmute
+// lifts it like any mute.
+func (cr *ChatRoom) ShadowMute(clientID string, d time.Duration, reason, actor string) time.Time {
+	until := cr.clock.Now().Add(d)
+	entry := AuditEntry{Action: "shadow_mute", Actor: actor, Target: clientID, Detail: reason}
+	cr.mutex.Lock()
+	cr.mutes[clientID] = mute{until: until, shadow: true, reason: reason}
+	if c, ok := cr.clients[clientID]; ok {
+		entry.IP, entry.UserAgent = c.addr, c.userAgent
+	}
+	cr.mutex.Unlock()
+	cr.audit.add(entry)
+	return until
+}
+
+// Unmute lifts any mute on clientID and reports whether there was one.
+func (cr *ChatRoom) Unmute(clientID, actor string) bool {
+	cr.mutex.Lock()
+	_, muted := cr.mutes[clientID]
+	delete(cr.mutes, clientID)
+	cr.mutex.Unlock()
+	if muted {
+		cr.audit.add(AuditEntry{Action: "unmute", Actor: actor, Target: clientID})
+	}
+	return muted
+}
+
+// mutedUntilLocked returns when clientID's mute expires, dropping it if it
+// already has. The caller must hold cr.mutex.
+func (cr *ChatRoom) mutedUntilLocked(clientID string) (time.Time, bool) {
+	m, ok := cr.mutes[clientID]
+	if !ok {
+		return time.Time{}, false
+	}
+	if !cr.clock.Now().Before(m.until) {
+		delete(cr.mutes, clientID)
+		return time.Time{}, false
+	}
+	return m.until, true
+}
+
+// shadowMutedLocked reports whether clientID's sends are to be echoed back
+// to it alone. The caller must hold cr.mutex.
+func (cr *ChatRoom) shadowMutedLocked(clientID string) bool {
+	_, muted := cr.mutedUntilLocked(clientID)
+	return muted && cr.mutes[clientID].shadow
+}
+
+func (cr *ChatRoom) HandleMute(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID := r.URL.Query().Get("id")
+	if clientID == "" {
+		http.Error(w, "Client ID is required", http.StatusBadRequest)
+		return
+	}
+	d, err := time.ParseDuration(r.URL.Query().Get("duration"))
+	if err != nil || d <= 0 {
+		http.Error(w, "A positive duration is required (e.g. 10m)", http.StatusBadRequest)
+		return
+	}
+	if r.URL.Query().Get("shadow") == "true" {
+		until := cr.ShadowMute(clientID, d, r.URL.Query().Get("reason"), actorAdmin)
+		fmt.Fprintf(w, "Client %s shadow-muted until %s", clientID, until.Format(time.RFC3339))
+		return
+	}
+	until := cr.Mute(clientID, d, r.URL.Query().Get("reason"), actorAdmin, false)
+	fmt.Fprintf(w, "Client %s muted until %s", clientID, until.Format(time.RFC3339))
+}
+
+func (cr *ChatRoom) HandleUnmute(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID := r.URL.Query().Get("id")
+	if clientID == "" {
+		http.Error(w, "Client ID is required", http.StatusBadRequest)
+		return
+	}
+	if !cr.Unmute(clientID, actorAdmin) {
+		http.Error(w, "Client is not muted", http.StatusNotFound)
+		return
+	}
+	fmt.Fprintf(w, "Client %s unmuted", clientID)
+}
+
+// MuteInfo describes an active mute in /admin/mutes.
+type MuteInfo struct {
+	ClientID string    `json:"client_id"`
+	Until    time.Time `json:"until"`
+	Auto     bool      `json:"auto"`
+	Shadow   bool      `json:"shadow,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+}
+
+func (cr *ChatRoom) HandleMutes(w http.ResponseWriter, r *http.Request) {
+	p, err := parsePage(r, "mutes")
+	if err != nil {
+		pageFailed(w, err)
+		return
+	}
+	infos := []MuteInfo{}
+	cr.mutex.Lock()
+	for id := range cr.mutes {
+		if _, active := cr.mutedUntilLocked(id); active {
+			m := cr.mutes[id]
+			infos = append(infos, MuteInfo{ClientID: id, Until: m.until, Auto: m.auto, Shadow: m.shadow, Reason: m.reason})
+		}
+	}
+	cr.mutex.Unlock()
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ClientID < infos[j].ClientID })
+	if p.limit > 0 {
+		idx, next := p.page(len(infos), func(i int) string { return infos[i].ClientID })
+		paged := make([]MuteInfo, 0, len(idx))
+		for _, i := range idx {
+			paged = append(paged, infos[i])
+		}
+		infos = paged
+		setNextCursor(w, next)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(infos)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"chatroom/testutil"
+)
+
+// sentSeq sends message as token's client and returns its Seq.
+func sentSeq(t *testing.T, h http.Handler, token, message string) uint64 {
+	t.Helper()
+	w := do(h, "POST", "/send?format=json&message="+url.QueryEscape(message), tokenHeader, token)
+	var res SendResult
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &res) != nil {
+		t.Fatalf("send: %d %s", w.Code, w.Body)
+	}
+	return res.Message.Seq
+}
+
+// A shadow-muted client's sends succeed and come back to it, but nobody
+// else sees them, and lifting the mute leaves no gap in its Seq.
+func TestShadowMuteEchoesOnlyToTheSender(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	cr, h := newTestRoom(t, WithClock(clk))
+	ta, tb := join(t, h, "alice"), join(t, h, "bob")
+	first := sentSeq(t, h, ta, "before")
+	if w := do(h, "POST", "/admin/mute?id=alice&duration=10m&shadow=true&reason=heated", asAdmin...); w.Code != http.StatusOK {
+		t.Fatalf("shadow mute: %d %s", w.Code, w.Body)
+	}
+	shadowed := sentSeq(t, h, ta, "nobody hears this")
+	if shadowed != first+1 {
+		t.Errorf("shadowed send got Seq %d, want %d", shadowed, first+1)
+	}
+	if w := pollOnce(h, "alice", ta); !strings.Contains(w.Body.String(), "alice: before") {
+		t.Fatalf("alice's poll: %d %q", w.Code, w.Body)
+	}
+	if w := pollOnce(h, "alice", ta); w.Body.String() != "alice: nobody hears this\n" {
+		t.Errorf("alice's echo: %d %q, want the shadowed message", w.Code, w.Body)
+	}
+
+	w := do(h, "GET", "/admin/mutes", asAdmin...)
+	if !strings.Contains(w.Body.String(), `"shadow":true`) {
+		t.Errorf("/admin/mutes: %s, want the mute marked shadow", w.Body)
+	}
+	var audited bool
+	for _, e := range cr.audit.list() {
+		audited = audited || e.Action == "shadow_mute" && e.Target == "alice" && e.Detail == "heated"
+	}
+	if !audited {
+		t.Error("the shadow mute was not audited")
+	}
+
+	if w := do(h, "POST", "/admin/unmute?id=alice", asAdmin...); w.Code != http.StatusOK {
+		t.Fatalf("unmute: %d %s", w.Code, w.Body)
+	}
+	if seq := sentSeq(t, h, ta, "after"); seq != first+1 {
+		t.Errorf("first send after the mute got Seq %d, want %d", seq, first+1)
+	}
+	for _, want := range []string{"alice: before\n", "alice: after\n"} {
+		if w := pollOnce(h, "bob", tb); w.Body.String() != want {
+			t.Errorf("bob's poll: %d %q, want %q", w.Code, w.Body, want)
+		}
+	}
+}
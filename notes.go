@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	maxNoteLength   = 1000
+	maxNotesPerUser = 50
+)
+
+var (
+	errBadNote      = errors.New("note must be non-empty text of at most 1000 bytes")
+	errTooManyNotes = errors.New("too many notes on this user")
+	errNoteNotFound = errors.New("note not found")
+)
+
+// Note is a moderator's private note on a user. Notes are shown to admins
+// only and outlive the user's registration.
+type Note struct {
+	ID      string    `json:"id"`
+	Author  string    `json:"author"`
+	Text    string    `json:"text"`
+	Created time.Time `json:"created"`
+}
+
+func (cr *ChatRoom) AddNote(userID, author, text string) (Note, error) {
+	if strings.TrimSpace(text) == "" || len(text) > maxNoteLength || validateMessage(text) != nil {
+		return Note{}, errBadNote
+	}
+	n := Note{ID: cr.ids.NewID(), Author: author, Text: text, Created: cr.clock.Now()}
+	cr.mutex.Lock()
+	if len(cr.notes[userID]) >= maxNotesPerUser {
+		cr.mutex.Unlock()
+		return Note{}, errTooManyNotes
+	}
+	cr.notes[userID] = append(cr.notes[userID], n)
+	cr.mutex.Unlock()
+	cr.audit.add(AuditEntry{Action: "note_add", Actor: author, Target: userID, Detail: n.ID})
+	return n, nil
+}
+
+// Notes returns the notes on userID, oldest first.
+func (cr *ChatRoom) Notes(userID string) []Note {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	return append([]Note{}, cr.notes[userID]...)
+}
+
+// DeleteNotes removes the note with ID noteID from userID, or all of
+// userID's notes when noteID is empty.
+func (cr *ChatRoom) DeleteNotes(userID, noteID, actor string) error {
+	cr.mutex.Lock()
+	notes := cr.notes[userID]
+	found := noteID == "" && len(notes) > 0
+	if noteID == "" {
+		delete(cr.notes, userID)
+	}
+	for i, n := range notes {
+		if noteID != "" && n.ID == noteID {
+			cr.notes[userID] = append(notes[:i:i], notes[i+1:]...)
+			if len(cr.notes[userID]) == 0 {
+				delete(cr.notes, userID)
+			}
+			found = true
+			break
+		}
+	}
+	cr.mutex.Unlock()
+	if !found {
+		return errNoteNotFound
+	}
+	cr.audit.add(AuditEntry{Action: "note_delete", Actor: actor, Target: userID, Detail: noteID})
+	return nil
+}
+
+// notesSnapshot copies the moderator notes for an admin's view of /clients.
+func (cr *ChatRoom) notesSnapshot() map[string][]Note {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	notes := make(map[string][]Note, len(cr.notes))
+	for id, n := range cr.notes {
+		if len(n) > 0 {
+			notes[id] = append([]Note{}, n...)
+		}
+	}
+	return notes
+}
+
+// HandleNotes serves GET /admin/notes/{userID}, POST with ?text=<note> and
+// an optional ?by=<moderator>, and DELETE with ?note=<id> to remove one
+// note or without it to remove them all.
+func (cr *ChatRoom) HandleNotes(w http.ResponseWriter, r *http.Request) {
+	userID := strings.TrimPrefix(r.URL.Path, "/admin/notes/")
+	if !validClientID(userID) {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		author := r.URL.Query().Get("by")
+		if author == "" {
+			author = actorAdmin
+		} else if !validClientID(author) {
+			http.Error(w, errInvalidClientID.Error(), http.StatusBadRequest)
+			return
+		}
+		n, err := cr.AddNote(userID, author, r.URL.Query().Get("text"))
+		switch err {
+		case nil:
+		case errTooManyNotes:
+			http.Error(w, fmt.Sprintf("At most %d notes can be kept on a user", maxNotesPerUser), http.StatusConflict)
+			return
+		default:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(n)
+		return
+	case http.MethodDelete:
+		if err := cr.DeleteNotes(userID, r.URL.Query().Get("note"), actorAdmin); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p, err := parsePage(r, "notes/"+userID)
+	if err != nil {
+		pageFailed(w, err)
+		return
+	}
+	notes := cr.Notes(userID)
+	if p.limit > 0 {
+		idx, next := p.page(len(notes), func(i int) string { return timeKey(notes[i].Created, notes[i].ID) })
+		paged := make([]Note, 0, len(idx))
+		for _, i := range idx {
+			paged = append(paged, notes[i])
+		}
+		notes = paged
+		setNextCursor(w, next)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notes)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"chatroom/testutil"
+)
+
+// Notes are for admins only: what a member sees of /clients and of its own
+// /me routes is byte-identical with and without notes on anyone.
+func TestNotesNeverReachMembers(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	_, h := newTestRoom(t, WithClock(clk), WithIDGenerator(&countingIDs{}))
+	ta := join(t, h, "alice")
+	tb := join(t, h, "bob")
+	if w := send(h, ta, "hello bob"); w.Code != http.StatusOK {
+		t.Fatalf("send: %d %s", w.Code, w.Body)
+	}
+	if w := send(h, tb, "hi alice"); w.Code != http.StatusOK {
+		t.Fatalf("send: %d %s", w.Code, w.Body)
+	}
+	// The broadcast loop records the messages asynchronously; the exports
+	// must hold them before the baseline is taken.
+	eventually(t, "both messages in the exports", func() bool {
+		return strings.Contains(do(h, "GET", "/me/export", tokenHeader, ta).Body.String(), "hello bob") &&
+			strings.Contains(do(h, "GET", "/me/export", tokenHeader, tb).Body.String(), "hi alice")
+	})
+	views := []string{"/clients", "/clients?limit=1", "/clients?summary=true", "/me/export", "/me/presence", "/me/keywords"}
+	look := func() map[string][]byte {
+		seen := make(map[string][]byte)
+		for _, target := range views {
+			w := do(h, "GET", target, tokenHeader, tb)
+			if w.Code != http.StatusOK {
+				t.Fatalf("%s as bob: %d %s", target, w.Code, w.Body)
+			}
+			seen[target] = w.Body.Bytes()
+		}
+		return seen
+	}
+	before := look()
+
+	const secret = "warned on 3/5 for spam"
+	for _, about := range []string{"alice", "bob"} {
+		if w := do(h, "POST", "/admin/notes/"+about+"?text="+url.QueryEscape(secret), asAdmin...); w.Code != http.StatusCreated {
+			t.Fatalf("note on %s: %d %s", about, w.Code, w.Body)
+		}
+	}
+	if w := do(h, "GET", "/clients", asAdmin...); !strings.Contains(w.Body.String(), secret) {
+		t.Fatalf("admin /clients does not show the notes: %s", w.Body)
+	}
+
+	after := look()
+	for _, target := range views {
+		if !bytes.Equal(before[target], after[target]) {
+			t.Errorf("%s as bob changed once notes exist:\nbefore %s\nafter  %s", target, before[target], after[target])
+		}
+		if bytes.Contains(after[target], []byte(secret)) {
+			t.Errorf("%s as bob shows a note: %s", target, after[target])
+		}
+	}
+	if w := do(h, "GET", "/admin/notes/bob", tokenHeader, tb); w.Code == http.StatusOK {
+		t.Errorf("bob read the notes on bob: %s", w.Body)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+//go:embed docs.html
+var docsPage []byte
+
+// openAPI builds an OpenAPI 3 document from the route table.
+func (cr *ChatRoom) openAPI() map[string]any {
+	errorResponse := map[string]any{
+		"description": "Error; the body is a plain-text message",
+		"content": map[string]any{
+			"text/plain": map[string]any{"schema": map[string]any{"type": "string"}},
+		},
+	}
+	paths := make(map[string]any)
+	for _, rt := range cr.routes() {
+		path := rt.path
+		if path == "" {
+			path = rt.pattern
+		}
+		params := make([]map[string]any, 0, len(rt.params))
+		for _, p := range rt.params {
+			params = append(params, map[string]any{
+				"name":        p.name,
+				"in":          p.in,
+				"description": p.description,
+				"required":    p.required,
+				"schema":      map[string]any{"type": "string"},
+			})
+		}
+		contentType := "text/plain"
+		if rt.json {
+			contentType = "application/json"
+		}
+		ops := make(map[string]any, len(rt.methods))
+		for _, method := range rt.methods {
+			op := map[string]any{
+				"summary":    rt.summary,
+				"parameters": params,
+				"responses": map[string]any{
+					"200": map[string]any{
+						"description": "Success",
+						"content":     map[string]any{contentType: map[string]any{}},
+					},
+					"default": errorResponse,
+				},
+			}
+			if rt.admin {
+				op["security"] = []map[string]any{{"adminToken": []string{}}}
+			}
+			if rt.body != "" && method == http.MethodPost {
+				op["requestBody"] = map[string]any{
+					"description": rt.body,
+					"content":     map[string]any{"application/json": map[string]any{}},
+				}
+			}
+			ops[strings.ToLower(method)] = op
+		}
+		paths[path] = ops
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "ConvoSphere",
+			"version": "1.0",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"securitySchemes": map[string]any{
+				"adminToken": map[string]any{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+}
+
+func (cr *ChatRoom) HandleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cr.openAPI())
+}
+
+func (cr *ChatRoom) HandleDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(docsPage)
+}
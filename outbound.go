@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	defaultOutboundTimeout = 10 * time.Second
+	defaultOutboundIdle    = 100
+	outboundCheckTimeout   = 5 * time.Second
+)
+
+// Outbound configures the HTTP client shared by everything the server
+// fetches on its own: link previews, translation and alert webhooks.
+type Outbound struct {
+	Proxy        string        // Proxy URL; empty uses HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+	CAFile       string        // PEM bundle trusted in addition to the system roots
+	Timeout      time.Duration // Whole-request timeout
+	MaxIdleConns int
+}
+
+// Client builds the shared client. Features with their own client, such as
+// HTTPTranslator.Client and AlertConfig.Client, keep it.
+func (o Outbound) Client() (*http.Client, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if o.Proxy != "" {
+		u, err := url.Parse(o.Proxy)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid outbound proxy %q", o.Proxy)
+		}
+		t.Proxy = http.ProxyURL(u)
+	}
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", o.CAFile)
+		}
+		t.TLSClientConfig = &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}
+	}
+	if o.MaxIdleConns > 0 {
+		t.MaxIdleConns = o.MaxIdleConns
+	}
+	timeout := o.Timeout
+	if timeout <= 0 {
+		timeout = defaultOutboundTimeout
+	}
+	return &http.Client{Transport: t, Timeout: timeout}, nil
+}
+
+// WithOutboundClient sets the shared outbound client. Link previews keep
+// their own timeouts and address checks but use its proxy and TLS settings.
+func WithOutboundClient(c *http.Client) Option {
+	return func(cr *ChatRoom) {
+		if c != nil {
+			cr.outbound = c
+		}
+	}
+}
+
+// outboundTransport is the shared client's transport, or nil when it is not
+// an *http.Transport.
+func (cr *ChatRoom) outboundTransport() *http.Transport {
+	t, _ := cr.outbound.Transport.(*http.Transport)
+	return t
+}
+
+// OutboundCheck is the result of probing one outbound integration.
+type OutboundCheck struct {
+	URL       string    `json:"url"`
+	Reachable bool      `json:"reachable"`
+	Status    string    `json:"status,omitempty"` // HTTP status of the probe; any status proves reachability
+	Error     string    `json:"error,omitempty"`
+	Checked   time.Time `json:"checked"`
+}
+
+type outboundChecks struct {
+	mu      sync.Mutex
+	results map[string]OutboundCheck
+}
+
+// CheckOutbound probes each integration with a HEAD request through the
+// client it uses and records the results for /readyz.
+func (cr *ChatRoom) CheckOutbound(targets map[string]string) {
+	var wg sync.WaitGroup
+	for name, target := range targets {
+		wg.Add(1)
+		go func(name, target string) {
+			defer wg.Done()
+			res := cr.probe(target)
+			cr.outboundChecks.mu.Lock()
+			if cr.outboundChecks.results == nil {
+				cr.outboundChecks.results = make(map[string]OutboundCheck)
+			}
+			cr.outboundChecks.results[name] = res
+			cr.outboundChecks.mu.Unlock()
+		}(name, target)
+	}
+	wg.Wait()
+}
+
+func (cr *ChatRoom) probe(target string) OutboundCheck {
+	res := OutboundCheck{URL: target, Checked: cr.clock.Now()}
+	ctx, cancel := context.WithTimeout(cr.ctx, outboundCheckTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, target, nil)
+	if err == nil {
+		var resp *http.Response
+		if resp, err = cr.outbound.Do(req); err == nil {
+			resp.Body.Close()
+			res.Reachable, res.Status = true, resp.Status
+		}
+	}
+	if err != nil {
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		res.Error = err.Error()
+	}
+	return res
+}
+
+// Ready is the body of /readyz.
+type Ready struct {
+	Status   string                   `json:"status"`
+	Outbound map[string]OutboundCheck `json:"outbound,omitempty"` // Present once -outbound-check has run
+}
+
+// HandleReadyz reports whether this instance should get traffic: 503 while
+// closed or in maintenance, and the outbound self-test results if any.
+// Unreachable integrations are reported but do not fail readiness, since
+// chat works without them.
+func (cr *ChatRoom) HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	ready := Ready{Status: "ready"}
+	cr.mutex.Lock()
+	closed, maintenance := cr.closed, cr.maintenance.Enabled
+	cr.mutex.Unlock()
+	status := http.StatusOK
+	switch {
+	case closed:
+		ready.Status, status = "closed", http.StatusServiceUnavailable
+	case maintenance:
+		ready.Status, status = "maintenance", http.StatusServiceUnavailable
+	}
+	cr.outboundChecks.mu.Lock()
+	if len(cr.outboundChecks.results) > 0 {
+		ready.Outbound = make(map[string]OutboundCheck, len(cr.outboundChecks.results))
+		for name, res := range cr.outboundChecks.results {
+			ready.Outbound[name] = res
+		}
+	}
+	cr.outboundChecks.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ready)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// nextCursorHeader carries the cursor of the next page of a list
+	// response. It is absent on the last page.
+	nextCursorHeader = "X-Next-Cursor"
+	maxPageLimit     = 500
+)
+
+var (
+	errInvalidCursor = errors.New("cursor is invalid or belongs to another list")
+	errInvalidLimit  = fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+	errInvalidOrder  = errors.New("order must be asc or desc")
+)
+
+// pageRequest is what a list request asked for with cursor=, limit= and
+// order=. Lists are paged by key, not offset: a cursor names the last key
+// seen, so it stays valid however the list changes in between.
+type pageRequest struct {
+	list  string // The list the cursor is for
+	desc  bool
+	after string // Key of the last item already seen; empty for the first page
+	limit int    // 0 when the request is not paged
+}
+
+// parsePage reads the paging parameters of a request for list. Requests
+// with neither cursor nor limit are not paged and get the whole list as
+// before. A cursor carries its list and direction, so order= only matters
+// on the first page.
+func parsePage(r *http.Request, list string) (pageRequest, error) {
+	q := r.URL.Query()
+	p := pageRequest{list: list}
+	if !q.Has("cursor") && !q.Has("limit") {
+		return p, nil
+	}
+	p.limit = maxPageLimit
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxPageLimit {
+			return p, errInvalidLimit
+		}
+		p.limit = n
+	}
+	switch q.Get("order") {
+	case "", "asc":
+	case "desc":
+		p.desc = true
+	default:
+		return p, errInvalidOrder
+	}
+	if s := q.Get("cursor"); s != "" {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return p, errInvalidCursor
+		}
+		parts := strings.SplitN(string(b), "\x00", 3)
+		if len(parts) != 3 || parts[0] != list || parts[1] != "asc" && parts[1] != "desc" || parts[2] == "" {
+			return p, errInvalidCursor
+		}
+		p.desc, p.after = parts[1] == "desc", parts[2]
+	}
+	return p, nil
+}
+
+func (p pageRequest) cursor(key string) string {
+	dir := "asc"
+	if p.desc {
+		dir = "desc"
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(p.list + "\x00" + dir + "\x00" + key))
+}
+
+// page picks the items of the requested page from a list of n items whose
+// keys, given by key, are unique. It returns their indices in page order
+// and the cursor of the next page, empty on the last one.
+func (p pageRequest) page(n int, key func(i int) string) ([]int, string) {
+	keys := make([]string, n)
+	order := make([]int, n)
+	for i := range order {
+		keys[i], order[i] = key(i), i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		if p.desc {
+			return keys[order[a]] > keys[order[b]]
+		}
+		return keys[order[a]] < keys[order[b]]
+	})
+	start := sort.Search(n, func(i int) bool {
+		k := keys[order[i]]
+		if p.after == "" {
+			return true
+		}
+		if p.desc {
+			return k < p.after
+		}
+		return k > p.after
+	})
+	end := min(n, start+p.limit)
+	var next string
+	if end < n {
+		next = p.cursor(keys[order[end-1]])
+	}
+	return order[start:end], next
+}
+
+// pageFailed answers a request whose paging parameters parsePage rejected.
+func pageFailed(w http.ResponseWriter, err error) {
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+func setNextCursor(w http.ResponseWriter, next string) {
+	if next != "" {
+		w.Header().Set(nextCursorHeader, next)
+	}
+}
+
+// timeKey orders by t and then by id in a page key.
+func timeKey(t time.Time, id string) string {
+	return fmt.Sprintf("%020d\x00%s", t.UnixNano(), id)
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pagedList is one list endpoint under the pagination contract. setup
+// returns a room holding at least contractItems items, the headers that
+// may read the list, and ways to add an item and, where the list allows
+// it, remove one by key.
+type pagedList struct {
+	route string // The route's documented path
+	path  string // What to request
+	items func(body []byte) ([]string, error)
+	setup func(t *testing.T) (h http.Handler, headers []string, add func(i int), remove func(key string))
+}
+
+const contractItems = 7
+
+// keysOf reads the field named by key from each item of a JSON array;
+// a dotted key reaches into nested objects.
+func keysOf(key string) func([]byte) ([]string, error) {
+	return func(body []byte) ([]string, error) {
+		var items []map[string]interface{}
+		if err := json.Unmarshal(body, &items); err != nil {
+			return nil, err
+		}
+		keys := make([]string, 0, len(items))
+		for _, item := range items {
+			var v interface{} = item
+			for _, name := range strings.Split(key, ".") {
+				obj, _ := v.(map[string]interface{})
+				v = obj[name]
+			}
+			if v == nil {
+				return nil, fmt.Errorf("item without %s: %v", key, item)
+			}
+			keys = append(keys, fmt.Sprint(v))
+		}
+		return keys, nil
+	}
+}
+
+// retained waits until the broadcast loop has recorded message id.
+func retained(t *testing.T, cr *ChatRoom, id string) string {
+	t.Helper()
+	eventually(t, "message "+id+" to be retained", func() bool {
+		_, ok := cr.recent.get(id)
+		return ok
+	})
+	return id
+}
+
+// fill calls add for each of the first contractItems items.
+func fill(add func(i int)) {
+	for i := 0; i < contractItems; i++ {
+		add(i)
+	}
+}
+
+func pagedLists() []pagedList {
+	return []pagedList{
+		{route: "/clients", path: "/clients",
+			items: func(body []byte) ([]string, error) {
+				var list struct{ Members, Spectators []ClientInfo }
+				err := json.Unmarshal(body, &list)
+				var keys []string
+				for _, c := range append(list.Members, list.Spectators...) {
+					keys = append(keys, c.ID)
+				}
+				return keys, err
+			},
+			setup: func(t *testing.T) (http.Handler, []string, func(int), func(string)) {
+				cr, h := newTestRoom(t)
+				add := func(i int) { cr.AddClient(fmt.Sprintf("user%02d", i)) }
+				fill(add)
+				return h, nil, add, cr.RemoveClient
+			}},
+		{route: "/admin/pending", path: "/admin/pending", items: keysOf("id"),
+			setup: func(t *testing.T) (http.Handler, []string, func(int), func(string)) {
+				cr, h := newTestRoom(t, WithJoinApproval(time.Hour))
+				add := func(i int) { do(h, "POST", fmt.Sprintf("/join?id=p%02d", i)) }
+				fill(add)
+				return h, asAdmin, add, func(id string) { cr.Deny(id, "", actorAdmin) }
+			}},
+		{route: "/admin/audit", path: "/admin/audit", items: keysOf("seq"),
+			setup: func(t *testing.T) (http.Handler, []string, func(int), func(string)) {
+				cr, h := newTestRoom(t)
+				add := func(i int) { cr.audit.add(AuditEntry{Action: "test", Actor: actorAdmin, Detail: fmt.Sprint(i)}) }
+				fill(add)
+				return h, asAdmin, add, nil
+			}},
+		{route: "/admin/dlq", path: "/admin/dlq", items: keysOf("id"),
+			setup: func(t *testing.T) (http.Handler, []string, func(int), func(string)) {
+				cr, h := newTestRoom(t)
+				add := func(i int) {
+					cr.dlq.add(DeadLetter{ID: cr.ids.NewID(), URL: "http://hook.example", Payload: json.RawMessage(`{}`), Created: cr.clock.Now()})
+				}
+				fill(add)
+				return h, asAdmin, add, func(id string) { cr.dlq.take(id) }
+			}},
+		{route: "/admin/mutes", path: "/admin/mutes", items: keysOf("client_id"),
+			setup: func(t *testing.T) (http.Handler, []string, func(int), func(string)) {
+				cr, h := newTestRoom(t)
+				add := func(i int) { cr.Mute(fmt.Sprintf("user%02d", i), time.Hour, "", actorAdmin, false) }
+				fill(add)
+				return h, asAdmin, add, func(id string) { cr.Unmute(id, actorAdmin) }
+			}},
+		{route: "/admin/reports", path: "/admin/reports", items: keysOf("message.id"),
+			setup: func(t *testing.T) (http.Handler, []string, func(int), func(string)) {
+				cr, h := newTestRoom(t)
+				ta := join(t, h, "alice")
+				join(t, h, "bob")
+				add := func(i int) {
+					id := retained(t, cr, sentID(t, h, ta, fmt.Sprint("message ", i)))
+					if err := cr.ReportMessage(id, "bob", "spam"); err != nil {
+						t.Fatal(err)
+					}
+				}
+				fill(add)
+				return h, asAdmin, add, func(id string) { cr.ResolveReports(id, "dismiss", 0) }
+			}},
+		{route: "/admin/notes/{userID}", path: "/admin/notes/bob", items: keysOf("id"),
+			setup: func(t *testing.T) (http.Handler, []string, func(int), func(string)) {
+				cr, h := newTestRoom(t)
+				add := func(i int) { cr.AddNote("bob", actorAdmin, fmt.Sprint("note ", i)) }
+				fill(add)
+				return h, asAdmin, add, func(id string) { cr.DeleteNotes("bob", id, actorAdmin) }
+			}},
+		{route: "/me/shares", path: "/me/shares", items: keysOf("token"),
+			setup: func(t *testing.T) (http.Handler, []string, func(int), func(string)) {
+				cr, h := newTestRoom(t, WithShareLinks(ShareLinks{Enabled: true}))
+				ta := join(t, h, "alice")
+				msgID := retained(t, cr, sentID(t, h, ta, "worth sharing"))
+				add := func(i int) {
+					if _, err := cr.Share("alice", msgID, 0, time.Hour+time.Duration(i)*time.Minute); err != nil {
+						t.Fatal(err)
+					}
+				}
+				fill(add)
+				return h, []string{tokenHeader, ta}, add, nil
+			}},
+	}
+}
+
+// Every list endpoint is in the contract suite.
+func TestEveryPagedRouteIsInTheContractSuite(t *testing.T) {
+	cr, _ := newTestRoom(t)
+	covered := make(map[string]bool)
+	for _, l := range pagedLists() {
+		covered[l.route] = true
+	}
+	for _, rt := range cr.routes() {
+		for _, p := range rt.params {
+			// /events and /sync take a cursor too, but it is an event seq.
+			if p != pageParams[0] {
+				continue
+			}
+			path := rt.path
+			if path == "" {
+				path = rt.pattern
+			}
+			if !covered[path] {
+				t.Errorf("%s is paged but not in TestPaginationContract", path)
+			}
+		}
+	}
+}
+
+func TestPaginationContract(t *testing.T) {
+	for _, l := range pagedLists() {
+		t.Run(l.route, func(t *testing.T) {
+			h, headers, add, remove := l.setup(t)
+			get := func(query string) (*http.Response, []string) {
+				t.Helper()
+				target := l.path
+				if query != "" {
+					target += "?" + query
+				}
+				w := do(h, "GET", target, headers...)
+				if w.Code != http.StatusOK {
+					t.Fatalf("GET %s: %d %s", target, w.Code, w.Body)
+				}
+				keys, err := l.items(w.Body.Bytes())
+				if err != nil {
+					t.Fatalf("GET %s: %v in %s", target, err, w.Body)
+				}
+				return w.Result(), keys
+			}
+			// walk follows the cursors from the first page of order,
+			// calling between after each page.
+			walk := func(order string, between func()) ([]string, int) {
+				t.Helper()
+				var all []string
+				pages := 0
+				q := url.Values{"limit": {"3"}, "order": {order}}
+				for {
+					resp, keys := get(q.Encode())
+					pages++
+					if len(keys) > 3 {
+						t.Fatalf("page of %d items with limit=3", len(keys))
+					}
+					all = append(all, keys...)
+					next := resp.Header.Get(nextCursorHeader)
+					if next == "" {
+						return all, pages
+					}
+					if pages > 2*contractItems {
+						t.Fatal("cursors never reach the last page")
+					}
+					if between != nil {
+						between()
+					}
+					q = url.Values{"cursor": {next}, "limit": {"3"}}
+				}
+			}
+
+			resp, whole := get("")
+			if len(whole) < contractItems {
+				t.Fatalf("unpaged list has %d items, want at least %d", len(whole), contractItems)
+			}
+			if next := resp.Header.Get(nextCursorHeader); next != "" {
+				t.Errorf("unpaged list has a next cursor %q", next)
+			}
+
+			t.Run("pages cover the list once", func(t *testing.T) {
+				asc, pages := walk("asc", nil)
+				if want := (len(whole) + 2) / 3; pages != want {
+					t.Errorf("%d pages of 3 for %d items, want %d", pages, len(whole), want)
+				}
+				if !sameSet(asc, whole) {
+					t.Errorf("pages hold %v, the whole list %v", asc, whole)
+				}
+				desc, _ := walk("desc", nil)
+				for i := range desc {
+					if i >= len(asc) || desc[i] != asc[len(asc)-1-i] {
+						t.Fatalf("order=desc gives %v, the reverse of %v", desc, asc)
+					}
+				}
+			})
+
+			t.Run("cursors survive changes", func(t *testing.T) {
+				_, before := get("")
+				var seen []string
+				changed := false
+				asc, _ := walk("asc", func() {
+					if changed {
+						return
+					}
+					changed = true
+					add(contractItems)
+					if remove != nil {
+						// Remove an item the first page already returned;
+						// nothing after the cursor may go missing.
+						_, first := get("limit=3")
+						seen = first
+						remove(first[0])
+					}
+				})
+				if !changed {
+					t.Fatal("the list fit on one page")
+				}
+				if dup := duplicate(asc); dup != "" {
+					t.Errorf("%s returned twice across a change: %v", dup, asc)
+				}
+				got := make(map[string]bool)
+				for _, k := range asc {
+					got[k] = true
+				}
+				for _, k := range before {
+					if !got[k] && (len(seen) == 0 || k != seen[0]) {
+						t.Errorf("%s, in the list throughout, was skipped: %v", k, asc)
+					}
+				}
+			})
+
+			t.Run("bad parameters are 400", func(t *testing.T) {
+				other := pageRequest{list: "elsewhere"}.cursor("x")
+				for query, want := range map[string]error{
+					"cursor=%21%21":       errInvalidCursor,
+					"cursor=" + other:     errInvalidCursor,
+					"cursor=" + garbled(): errInvalidCursor,
+					"limit=0":             errInvalidLimit,
+					"limit=501":           errInvalidLimit,
+					"limit=ten":           errInvalidLimit,
+					"limit=2&order=up":    errInvalidOrder,
+				} {
+					w := do(h, "GET", l.path+"?"+query, headers...)
+					if w.Code != http.StatusBadRequest || strings.TrimSpace(w.Body.String()) != want.Error() {
+						t.Errorf("?%s: %d %q, want 400 %q", query, w.Code, w.Body, want)
+					}
+				}
+			})
+		})
+	}
+}
+
+// garbled is a cursor with a valid encoding but no direction.
+func garbled() string {
+	return pageRequest{list: "x"}.cursor("y")[:6]
+}
+
+func sameSet(a, b []string) bool {
+	if len(a) != len(b) || duplicate(a) != "" {
+		return false
+	}
+	in := make(map[string]bool, len(a))
+	for _, k := range a {
+		in[k] = true
+	}
+	for _, k := range b {
+		if !in[k] {
+			return false
+		}
+	}
+	return true
+}
+
+func duplicate(keys []string) string {
+	seen := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		if seen[k] {
+			return k
+		}
+		seen[k] = true
+	}
+	return ""
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const pollWaitHeader = "X-Poll-Wait"
+
+// PollWait bounds how long /messages holds a request open. Clients pick a
+// wait within [Min, Max] with ?wait=<seconds>; Preferred is used otherwise
+// and is advertised in X-Poll-Wait.
+type PollWait struct {
+	Min       time.Duration
+	Max       time.Duration
+	Preferred time.Duration
+}
+
+var defaultPollWait = PollWait{Min: time.Second, Max: 60 * time.Second, Preferred: 30 * time.Second}
+
+// WithPollWait sets the long-poll bounds. Invalid combinations are ignored.
+func WithPollWait(pw PollWait) Option {
+	return func(cr *ChatRoom) {
+		if pw.Min > 0 && pw.Min <= pw.Preferred && pw.Preferred <= pw.Max {
+			cr.pollWait = pw
+		}
+	}
+}
+
+// clamp limits d to [Min, Max].
+func (pw PollWait) clamp(d time.Duration) time.Duration {
+	return min(max(d, pw.Min), pw.Max)
+}
+
+// requestedWait returns how long to hold this poll open.
+func (cr *ChatRoom) requestedWait(r *http.Request) (time.Duration, bool) {
+	v := r.URL.Query().Get("wait")
+	if v == "" {
+		return cr.pollWait.Preferred, true
+	}
+	secs, err := strconv.Atoi(v)
+	if err != nil || secs < 0 {
+		return 0, false
+	}
+	return cr.pollWait.clamp(time.Duration(secs) * time.Second), true
+}
+
+// overloaded reports whether the broadcast queue is at least three quarters
+// full.
+func (cr *ChatRoom) overloaded() bool {
+	c := cap(cr.broadcast)
+	return c > 0 && len(cr.broadcast)*4 >= c*3
+}
+
+// advertisedWait is the wait well-behaved clients should use next. It drops
+// to zero once shutdown has begun, or while the room is overloaded, so
+// clients drain quickly instead of parking more requests.
+func (cr *ChatRoom) advertisedWait() time.Duration {
+	cr.mutex.Lock()
+	stopping := cr.closed || cr.draining
+	cr.mutex.Unlock()
+	if stopping || cr.overloaded() {
+		return 0
+	}
+	return cr.pollWait.Preferred
+}
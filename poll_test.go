@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var clampWait = PollWait{Min: 2 * time.Second, Preferred: 10 * time.Second, Max: 30 * time.Second}
+
+func TestRequestedWaitClamps(t *testing.T) {
+	cr, _ := newTestRoom(t, WithPollWait(clampWait))
+	for _, tc := range []struct {
+		wait string
+		want time.Duration
+		ok   bool
+	}{
+		{"", 10 * time.Second, true},
+		{"0", 2 * time.Second, true},
+		{"1", 2 * time.Second, true},
+		{"15", 15 * time.Second, true},
+		{"30", 30 * time.Second, true},
+		{"3600", 30 * time.Second, true},
+		{"-1", 0, false},
+		{"1.5", 0, false},
+		{"soon", 0, false},
+	} {
+		got, ok := cr.requestedWait(httptest.NewRequest("GET", "/messages?wait="+tc.wait, nil))
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("wait=%q: got %s, %v; want %s, %v", tc.wait, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestAdvertisedWaitDropsWhenShuttingDown(t *testing.T) {
+	cr, h := newTestRoom(t, WithPollWait(clampWait))
+	header := func() string {
+		return do(h, "GET", "/messages?id=nobody").Header().Get(pollWaitHeader)
+	}
+	if got := header(); got != "10" {
+		t.Errorf("%s = %q, want 10", pollWaitHeader, got)
+	}
+	// What the "joins" shutdown phase does.
+	cr.mutex.Lock()
+	cr.draining = true
+	cr.mutex.Unlock()
+	if got := header(); got != "0" {
+		t.Errorf("%s while draining = %q, want 0", pollWaitHeader, got)
+	}
+	cr.Close()
+	if got := header(); got != "0" {
+		t.Errorf("%s once closed = %q, want 0", pollWaitHeader, got)
+	}
+}
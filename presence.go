@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+const (
+	presenceVisible   = "visible"
+	presenceInvisible = "invisible"
+)
+
+var errBadVisibility = errors.New("visibility must be visible or invisible")
+
+// Presence is the body of /me/presence.
+type Presence struct {
+	Visibility string `json:"visibility"`
+}
+
+// SetPresence hides clientID from other clients' view of who is here, or
+// shows it again. An invisible client still sends, receives and counts
+// toward /stats like any other; its messages carry its ID as usual. The
+// setting lasts until the client leaves.
+func (cr *ChatRoom) SetPresence(clientID, visibility string) error {
+	if visibility != presenceVisible && visibility != presenceInvisible {
+		return errBadVisibility
+	}
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	c, exists := cr.clients[clientID]
+	if !exists {
+		return errClientNotFound
+	}
+	c.invisible = visibility == presenceInvisible
+	cr.rosterChangedLocked()
+	return nil
+}
+
+func (cr *ChatRoom) Presence(clientID string) (Presence, error) {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	c, exists := cr.clients[clientID]
+	if !exists {
+		return Presence{}, errClientNotFound
+	}
+	if c.invisible {
+		return Presence{Visibility: presenceInvisible}, nil
+	}
+	return Presence{Visibility: presenceVisible}, nil
+}
+
+// HandlePresence serves the caller's presence visibility: GET /me/presence,
+// and PATCH /me/presence with {"visibility": "visible" or "invisible"}.
+func (cr *ChatRoom) HandlePresence(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodPatch:
+	default:
+		w.Header().Set("Allow", "GET, PATCH")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID, ok := cr.sender(w, r)
+	if !ok {
+		return
+	}
+	if r.Method == http.MethodPatch {
+		var req Presence
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxMessageLength)).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		switch err := cr.SetPresence(clientID, req.Visibility); err {
+		case nil:
+		case errClientNotFound:
+			http.Error(w, "Client not found", http.StatusNotFound)
+			return
+		default:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	p, err := cr.Presence(clientID)
+	if err != nil {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// Invisibility hides presence, not authorship: an invisible client's
+// messages are attributed to it everywhere they appear.
+func TestInvisibleClientsStillAuthorTheirMessages(t *testing.T) {
+	cr, h := newTestRoom(t)
+	ta := join(t, h, "alice")
+	tb := join(t, h, "bob")
+	if err := cr.SetPresence("bob", presenceInvisible); err != nil {
+		t.Fatal(err)
+	}
+	w := do(h, "POST", "/send?format=json&message=still+me", tokenHeader, tb)
+	var res SendResult
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &res) != nil {
+		t.Fatalf("send while invisible: %d %s", w.Code, w.Body)
+	}
+	if res.Message.From != "bob" {
+		t.Errorf("send result is from %q, want bob", res.Message.From)
+	}
+
+	var m Message
+	if err := json.Unmarshal(pollFrom(t, h, "alice", ta, "bob"), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.From != "bob" || m.Body != "still me" {
+		t.Errorf("alice received %+v, want bob's message", m)
+	}
+
+	var page EventPage
+	w = do(h, "GET", "/events?types=message", tokenHeader, ta)
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &page) != nil {
+		t.Fatalf("events: %d %s", w.Code, w.Body)
+	}
+	if len(page.Events) != 1 || page.Events[0].Message.From != "bob" {
+		t.Errorf("alice's /events: %+v, want bob's message", page.Events)
+	}
+}
+
+// An invisible client is left out of /clients, its summary counts and
+// every page of a paged listing, except for admins and itself.
+func TestInvisibleClientsAreLeftOutOfClients(t *testing.T) {
+	cr, h := newTestRoom(t)
+	tokens := make(map[string]string)
+	for _, id := range []string{"alice", "bob", "carol", "dave", "erin"} {
+		tokens[id] = join(t, h, id)
+	}
+	if err := cr.SetPresence("bob", presenceInvisible); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cr.SetDoNotDisturb("bob", 0); err != nil {
+		t.Fatal(err)
+	}
+	listed := func(headers ...string) map[string]ClientInfo {
+		t.Helper()
+		var list ClientList
+		w := do(h, "GET", "/clients", headers...)
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &list) != nil {
+			t.Fatalf("clients: %d %s", w.Code, w.Body)
+		}
+		got := make(map[string]ClientInfo)
+		for _, c := range append(list.Members, list.Spectators...) {
+			got[c.ID] = c
+		}
+		return got
+	}
+	paged := func(headers ...string) map[string]bool {
+		t.Helper()
+		got := make(map[string]bool)
+		q := url.Values{"limit": {"2"}}
+		for pages := 0; pages < 10; pages++ {
+			var list ClientList
+			w := do(h, "GET", "/clients?"+q.Encode(), headers...)
+			if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &list) != nil {
+				t.Fatalf("clients?%s: %d %s", q.Encode(), w.Code, w.Body)
+			}
+			for _, c := range list.Members {
+				got[c.ID] = true
+			}
+			next := w.Header().Get(nextCursorHeader)
+			if next == "" {
+				return got
+			}
+			q.Set("cursor", next)
+		}
+		t.Fatal("cursors never reach the last page")
+		return nil
+	}
+	summary := func(headers ...string) ClientSummary {
+		t.Helper()
+		var s ClientSummary
+		w := do(h, "GET", "/clients?summary=true", headers...)
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &s) != nil {
+			t.Fatalf("clients?summary=true: %d %s", w.Code, w.Body)
+		}
+		return s
+	}
+
+	for name, headers := range map[string][]string{
+		"alice":     {tokenHeader, tokens["alice"]},
+		"anonymous": nil,
+	} {
+		if got := listed(headers...); len(got) != 4 || got["bob"].ID != "" {
+			t.Errorf("/clients for %s: %v, want everyone but bob", name, got)
+		}
+		if got := paged(headers...); len(got) != 4 || got["bob"] {
+			t.Errorf("paged /clients for %s: %v, want everyone but bob", name, got)
+		}
+		if s := summary(headers...); s.Members != 4 || s.Online != 4 || s.Busy != 0 {
+			t.Errorf("summary for %s: %+v, want 4 members online and no one busy", name, s)
+		}
+	}
+
+	if got := listed(tokenHeader, tokens["bob"]); len(got) != 5 || got["bob"].ID == "" {
+		t.Errorf("/clients for bob: %v, want everyone", got)
+	}
+	if s := summary(tokenHeader, tokens["bob"]); s.Members != 5 || s.Busy != 1 {
+		t.Errorf("summary for bob: %+v, want 5 members, 1 busy", s)
+	}
+	if got := paged(asAdmin...); len(got) != 5 || !got["bob"] {
+		t.Errorf("paged /clients for an admin: %v, want everyone", got)
+	}
+	if got := listed(asAdmin...); !got["bob"].Invisible {
+		t.Errorf("admin sees bob as %+v, want bob marked invisible", got["bob"])
+	}
+	if s := summary(asAdmin...); s.Members != 5 || s.Online != 4 || s.Busy != 1 {
+		t.Errorf("summary for an admin: %+v, want 5 members, 1 busy", s)
+	}
+}
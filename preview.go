@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode"
+)
+
+const (
+	previewTimeout      = 5 * time.Second
+	previewMaxBytes     = 512 << 10
+	previewCacheTTL     = time.Hour
+	previewCacheSize    = 1000
+	previewMaxInFlight  = 8
+	previewMaxFieldSize = 300
+)
+
+// LinkPreview is what a preview message carries about a URL.
+type LinkPreview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Image       string `json:"image,omitempty"`
+}
+
+// WithLinkPreviews enables server-side link previews. They are off by default
+// because every message with a URL makes the server fetch it.
+func WithLinkPreviews(enabled bool) Option {
+	return func(cr *ChatRoom) {
+		if enabled {
+			cr.previews = newPreviewer()
+		}
+	}
+}
+
+type cachedPreview struct {
+	preview *LinkPreview // nil when the fetch failed
+	expires time.Time
+}
+
+// previewer fetches and caches link previews.
+type previewer struct {
+	client   *http.Client
+	inFlight chan struct{} // Semaphore bounding concurrent fetches
+
+	// With an outbound proxy, requests it applies to go through proxied. The
+	// proxy makes the connection, so the dial-time address check cannot
+	// apply; fetch checks the target's resolved addresses instead.
+	proxy   func(*http.Request) (*url.URL, error)
+	proxied *http.Client
+
+	mu    sync.Mutex
+	cache map[string]cachedPreview
+}
+
+var errPrivateAddress = errors.New("refusing to fetch a private address")
+
+func newPreviewer() *previewer {
+	dialer := &net.Dialer{
+		Timeout: previewTimeout,
+		// Checked after DNS resolution so redirects and rebinding cannot reach
+		// internal hosts either.
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip, err := netip.ParseAddr(host)
+			if err != nil || !publicAddr(ip) {
+				return errPrivateAddress
+			}
+			return nil
+		},
+	}
+	return &previewer{
+		client: &http.Client{
+			Timeout: previewTimeout,
+			Transport: &http.Transport{
+				DialContext:           dialer.DialContext,
+				TLSHandshakeTimeout:   previewTimeout,
+				ResponseHeaderTimeout: previewTimeout,
+				MaxIdleConns:          previewMaxInFlight,
+			},
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= 3 {
+					return errors.New("too many redirects")
+				}
+				return nil
+			},
+		},
+		inFlight: make(chan struct{}, previewMaxInFlight),
+		cache:    make(map[string]cachedPreview),
+	}
+}
+
+// route makes the previewer use base's proxy and TLS settings.
+func (p *previewer) route(base *http.Transport) {
+	if base == nil {
+		return
+	}
+	direct := p.client.Transport.(*http.Transport)
+	direct.TLSClientConfig = base.TLSClientConfig.Clone()
+	if base.Proxy == nil {
+		return
+	}
+	p.proxy = base.Proxy
+	p.proxied = &http.Client{
+		Timeout: previewTimeout,
+		Transport: &http.Transport{
+			Proxy:                 base.Proxy,
+			DialContext:           (&net.Dialer{Timeout: previewTimeout}).DialContext,
+			TLSClientConfig:       base.TLSClientConfig.Clone(),
+			TLSHandshakeTimeout:   previewTimeout,
+			ResponseHeaderTimeout: previewTimeout,
+			MaxIdleConns:          previewMaxInFlight,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 3 {
+				return errors.New("too many redirects")
+			}
+			return checkPublicHost(req.Context(), req.URL.Hostname())
+		},
+	}
+}
+
+// checkPublicHost resolves host and refuses it if any address is private.
+func checkPublicHost(ctx context.Context, host string) error {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		if !publicAddr(ip) {
+			return errPrivateAddress
+		}
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return err
+	}
+	for _, a := range addrs {
+		if !publicAddr(a) {
+			return errPrivateAddress
+		}
+	}
+	return nil
+}
+
+// deniedPrefixes are the ranges previews never fetch from: everything the
+// IANA special-purpose registries mark as not globally reachable, plus
+// NAT64 and 6to4, which embed IPv4 addresses that could be any of those.
+var deniedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),       // "This network"
+	netip.MustParsePrefix("10.0.0.0/8"),      // Private
+	netip.MustParsePrefix("100.64.0.0/10"),   // Carrier-grade NAT
+	netip.MustParsePrefix("127.0.0.0/8"),     // Loopback
+	netip.MustParsePrefix("169.254.0.0/16"),  // Link-local, including cloud metadata
+	netip.MustParsePrefix("172.16.0.0/12"),   // Private
+	netip.MustParsePrefix("192.0.0.0/24"),    // IETF protocol assignments
+	netip.MustParsePrefix("192.0.2.0/24"),    // Documentation
+	netip.MustParsePrefix("192.88.99.0/24"),  // 6to4 relay anycast
+	netip.MustParsePrefix("192.168.0.0/16"),  // Private
+	netip.MustParsePrefix("198.18.0.0/15"),   // Benchmarking
+	netip.MustParsePrefix("198.51.100.0/24"), // Documentation
+	netip.MustParsePrefix("203.0.113.0/24"),  // Documentation
+	netip.MustParsePrefix("224.0.0.0/4"),     // Multicast
+	netip.MustParsePrefix("240.0.0.0/4"),     // Reserved, and broadcast
+	netip.MustParsePrefix("::/128"),          // Unspecified
+	netip.MustParsePrefix("::1/128"),         // Loopback
+	netip.MustParsePrefix("64:ff9b::/96"),    // NAT64
+	netip.MustParsePrefix("64:ff9b:1::/48"),  // Local-use NAT64
+	netip.MustParsePrefix("100::/64"),        // Discard-only
+	netip.MustParsePrefix("2001::/23"),       // IETF protocol assignments, including Teredo
+	netip.MustParsePrefix("2001:db8::/32"),   // Documentation
+	netip.MustParsePrefix("2002::/16"),       // 6to4
+	netip.MustParsePrefix("fc00::/7"),        // Unique local
+	netip.MustParsePrefix("fe80::/10"),       // Link-local
+	netip.MustParsePrefix("fec0::/10"),       // Deprecated site-local
+	netip.MustParsePrefix("ff00::/8"),        // Multicast
+}
+
+// publicAddr reports whether previews may connect to ip. IPv4-mapped IPv6
+// addresses are judged as the IPv4 address they carry.
+func publicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap().WithZone("")
+	if !ip.IsValid() {
+		return false
+	}
+	for _, p := range deniedPrefixes {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+var urlPattern = regexp.MustCompile(`https?://[^\s<>"]+`)
+
+// firstURL returns the first http(s) URL in body, without trailing
+// punctuation that is more likely part of the sentence.
+func firstURL(body string) string {
+	return strings.TrimRight(urlPattern.FindString(body), ".,;:!?)]}'")
+}
+
+func (p *previewer) cached(url string) (*LinkPreview, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c, ok := p.cache[url]
+	if !ok || time.Now().After(c.expires) {
+		return nil, false
+	}
+	return c.preview, true
+}
+
+func (p *previewer) store(url string, preview *LinkPreview) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.cache) >= previewCacheSize {
+		now := time.Now()
+		for u, c := range p.cache {
+			if now.After(c.expires) {
+				delete(p.cache, u)
+			}
+		}
+		// Still full of live entries: drop an arbitrary one.
+		for u := range p.cache {
+			if len(p.cache) < previewCacheSize {
+				break
+			}
+			delete(p.cache, u)
+		}
+	}
+	p.cache[url] = cachedPreview{preview: preview, expires: time.Now().Add(previewCacheTTL)}
+}
+
+// preview returns a preview for url, using the cache when possible. Any
+// failure yields nil.
+func (p *previewer) preview(ctx context.Context, url string) *LinkPreview {
+	if preview, ok := p.cached(url); ok {
+		return preview
+	}
+	select {
+	case p.inFlight <- struct{}{}:
+		defer func() { <-p.inFlight }()
+	case <-ctx.Done():
+		return nil
+	}
+	preview, err := p.fetch(ctx, url)
+	if err != nil {
+		preview = nil
+	}
+	p.store(url, preview)
+	return preview
+}
+
+func (p *previewer) fetch(ctx context.Context, url string) (*LinkPreview, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("User-Agent", "ConvoSphere-LinkPreview/1.0")
+	client := p.client
+	if p.proxy != nil {
+		if u, err := p.proxy(req); err == nil && u != nil {
+			if err := checkPublicHost(ctx, req.URL.Hostname()); err != nil {
+				return nil, err
+			}
+			client = p.proxied
+		}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", url, resp.Status)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		return nil, fmt.Errorf("fetching %s: unsupported content type %q", url, ct)
+	}
+	page, err := io.ReadAll(io.LimitReader(resp.Body, previewMaxBytes))
+	if err != nil {
+		return nil, err
+	}
+	preview := parsePreview(url, string(page))
+	if preview.Title == "" && preview.Description == "" {
+		return nil, fmt.Errorf("fetching %s: nothing to preview", url)
+	}
+	return preview, nil
+}
+
+var (
+	titlePattern = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	metaPattern  = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	attrPattern  = regexp.MustCompile(`(?is)(property|name|content)\s*=\s*("[^"]*"|'[^']*')`)
+)
+
+func parsePreview(url, page string) *LinkPreview {
+	preview := &LinkPreview{URL: url}
+	if m := titlePattern.FindStringSubmatch(page); m != nil {
+		preview.Title = m[1]
+	}
+	for _, tag := range metaPattern.FindAllString(page, -1) {
+		var key, content string
+		for _, a := range attrPattern.FindAllStringSubmatch(tag, -1) {
+			value := a[2][1 : len(a[2])-1]
+			if strings.EqualFold(a[1], "content") {
+				content = value
+			} else {
+				key = strings.ToLower(value)
+			}
+		}
+		switch key {
+		case "og:title":
+			preview.Title = content
+		case "og:description":
+			preview.Description = content
+		case "description":
+			if preview.Description == "" {
+				preview.Description = content
+			}
+		case "og:image":
+			if strings.HasPrefix(content, "https://") || strings.HasPrefix(content, "http://") {
+				preview.Image = content
+			}
+		}
+	}
+	preview.Title = cleanPreviewText(preview.Title)
+	preview.Description = cleanPreviewText(preview.Description)
+	preview.Image = cleanPreviewText(preview.Image)
+	return preview
+}
+
+// cleanPreviewText unescapes entities, collapses whitespace and control
+// characters, and caps the length at a rune boundary.
+func cleanPreviewText(s string) string {
+	s = strings.Join(strings.FieldsFunc(html.UnescapeString(s), func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsControl(r)
+	}), " ")
+	s = strings.ToValidUTF8(s, "")
+	if len(s) > previewMaxFieldSize {
+		cut := 0
+		for i := range s {
+			if i > previewMaxFieldSize {
+				break
+			}
+			cut = i
+		}
+		s = s[:cut]
+	}
+	return s
+}
+
+// schedulePreview fetches a preview for the first URL in msg in the
+// background and broadcasts it as a follow-up "preview" message.
+func (cr *ChatRoom) schedulePreview(msg Message) {
+	if cr.previews == nil || msg.Type != "" || msg.opaque() {
+		return
+	}
+	url := firstURL(msg.Body)
+	if url == "" {
+		return
+	}
+	cr.goTracked(workerPreview, func() {
+		preview := cr.previews.preview(cr.ctx, url)
+		if preview == nil {
+			return
+		}
+		body := preview.Title
+		if body == "" {
+			body = preview.Description
+		}
+		cr.Publish(Message{
+			From:    systemSender,
+			Type:    messageTypePreview,
+			RefID:   msg.ID,
+			Body:    body,
+			Preview: preview,
+		})
+	})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+)
+
+func TestPublicAddr(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"93.184.216.34":        true,
+		"2606:4700::6810:85e5": true,
+		"8.8.8.8":              true,
+		"0.0.0.0":              false,
+		"0.1.2.3":              false,
+		"10.1.2.3":             false,
+		"100.64.0.1":           false,
+		"100.127.255.255":      false,
+		"127.0.0.1":            false,
+		"169.254.169.254":      false,
+		"172.31.0.1":           false,
+		"192.0.0.8":            false,
+		"192.168.1.1":          false,
+		"198.18.0.1":           false,
+		"198.19.255.255":       false,
+		"224.0.0.1":            false,
+		"255.255.255.255":      false,
+		"::":                   false,
+		"::1":                  false,
+		"::ffff:127.0.0.1":     false,
+		"::ffff:10.0.0.1":      false,
+		"64:ff9b::a9fe:a9fe":   false,
+		"64:ff9b::808:808":     false,
+		"2001:db8::1":          false,
+		"2002:7f00:1::":        false,
+		"fd00::1":              false,
+		"fe80::1%eth0":         false,
+		"ff02::1":              false,
+	} {
+		if got := publicAddr(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("publicAddr(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestCheckPublicHostRefusesLiterals(t *testing.T) {
+	for _, host := range []string{"127.0.0.1", "100.64.0.1", "::1", "64:ff9b::7f00:1"} {
+		if err := checkPublicHost(context.Background(), host); err != errPrivateAddress {
+			t.Errorf("checkPublicHost(%s) = %v, want errPrivateAddress", host, err)
+		}
+	}
+}
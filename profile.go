@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"chatroom/clock"
+)
+
+const (
+	defaultProfileDuration = 30 * time.Second
+	maxProfileDuration     = 10 * time.Minute
+	defaultProfileRate     = 0.01
+	maxProfileSamples      = 2000
+)
+
+// Stages of the send path timed for a sampled message, in order.
+var profileStages = []string{"validate", "filter", "queue", "marshal", "first_delivery", "last_delivery"}
+
+// sendSample times one message through the send path. Handler marks are
+// written by the sending request and delivery marks by the broadcast loop,
+// which the broadcast queue orders after them.
+type sendSample struct {
+	start, validated, published time.Time // Set by HandleSend
+	dequeued, fanout            time.Time // Set by the broadcast loop; fanout is when encoding finished
+	first, last                 time.Time
+	marshal                     time.Duration
+}
+
+// stages returns how long each of profileStages took. Delivery stages are
+// measured from when encoding finished, and are zero when nobody received
+// the message.
+func (s *sendSample) stages() []time.Duration {
+	since := func(from, to time.Time) time.Duration {
+		if to.IsZero() {
+			return 0
+		}
+		return to.Sub(from)
+	}
+	return []time.Duration{
+		s.validated.Sub(s.start),
+		s.published.Sub(s.validated),
+		s.dequeued.Sub(s.published),
+		s.marshal,
+		since(s.fanout, s.first),
+		since(s.fanout, s.last),
+	}
+}
+
+// sendProfile samples messages through the send path for a bounded run.
+// While no run is active the send path pays one atomic load.
+type sendProfile struct {
+	on atomic.Bool
+
+	mu      sync.Mutex
+	rate    float64
+	started time.Time
+	ends    time.Time
+	timer   clock.Timer
+	samples [][]time.Duration // Stage durations of finished samples, at most maxProfileSamples
+}
+
+// sample starts timing a message with the run's probability, returning nil
+// when there is no run or the message is not picked.
+func (cr *ChatRoom) sample() *sendSample {
+	if !cr.sendProfile.on.Load() {
+		return nil
+	}
+	p := &cr.sendProfile
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.samples) >= maxProfileSamples || rand.Float64() >= p.rate {
+		return nil
+	}
+	return &sendSample{start: cr.clock.Now()}
+}
+
+// marshalSample times the JSON encoding of msg, which the first JSON poll
+// would otherwise pay for. It runs once msg has its event seq, just before
+// fan-out.
+func (cr *ChatRoom) marshalSample(msg Message) {
+	s := msg.sample
+	start := cr.clock.Now()
+	if msg.envelope != nil {
+		msg.envelope.bytes(msg)
+	}
+	s.fanout = cr.clock.Now()
+	s.marshal = s.fanout.Sub(start)
+}
+
+func (s *sendSample) delivered(now time.Time) {
+	if s.first.IsZero() {
+		s.first = now
+	}
+	s.last = now
+}
+
+// finishSample records s once the broadcast loop is done with its message.
+func (cr *ChatRoom) finishSample(s *sendSample) {
+	p := &cr.sendProfile
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.samples) < maxProfileSamples {
+		p.samples = append(p.samples, s.stages())
+	}
+}
+
+// StartSendProfile samples about rate of the messages sent over the next d,
+// discarding the previous run's results.
+func (cr *ChatRoom) StartSendProfile(d time.Duration, rate float64) {
+	p := &cr.sendProfile
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	now := cr.clock.Now()
+	p.rate, p.started, p.ends, p.samples = rate, now, now.Add(d), nil
+	p.timer = cr.clock.AfterFunc(d, func() { p.on.Store(false) })
+	p.on.Store(true)
+	cr.audit.add(AuditEntry{Action: "send_profile", Actor: actorAdmin, Detail: fmt.Sprintf("%s at rate %g", d, rate)})
+}
+
+// StageTiming gives percentiles of one stage in milliseconds.
+type StageTiming struct {
+	Stage string  `json:"stage"`
+	P50   float64 `json:"p50_ms"`
+	P95   float64 `json:"p95_ms"`
+	P99   float64 `json:"p99_ms"`
+}
+
+// SendProfileReport is the body of /admin/profile/send. Slowest names the
+// stage with the highest value at each percentile.
+type SendProfileReport struct {
+	Running bool              `json:"running"`
+	Started time.Time         `json:"started,omitempty"`
+	Ends    time.Time         `json:"ends,omitempty"`
+	Rate    float64           `json:"rate"`
+	Samples int               `json:"samples"`
+	Stages  []StageTiming     `json:"stages"`
+	Slowest map[string]string `json:"slowest,omitempty"`
+}
+
+func (cr *ChatRoom) SendProfile() SendProfileReport {
+	p := &cr.sendProfile
+	p.mu.Lock()
+	report := SendProfileReport{Running: p.on.Load(), Started: p.started, Ends: p.ends, Rate: p.rate, Samples: len(p.samples), Stages: []StageTiming{}}
+	samples := p.samples
+	p.mu.Unlock()
+	if len(samples) == 0 {
+		return report
+	}
+	report.Slowest = make(map[string]string)
+	percentiles := []string{"p50", "p95", "p99"}
+	slowest := make([]float64, len(percentiles))
+	for i, stage := range profileStages {
+		ds := make([]time.Duration, len(samples))
+		for j, s := range samples {
+			ds[j] = s[i]
+		}
+		sort.Slice(ds, func(a, b int) bool { return ds[a] < ds[b] })
+		at := func(q float64) float64 {
+			return roundMillis(float64(ds[int(q*float64(len(ds)-1))]) / float64(time.Millisecond))
+		}
+		t := StageTiming{Stage: stage, P50: at(0.5), P95: at(0.95), P99: at(0.99)}
+		report.Stages = append(report.Stages, t)
+		for k, v := range []float64{t.P50, t.P95, t.P99} {
+			if i == 0 || v > slowest[k] {
+				report.Slowest[percentiles[k]], slowest[k] = stage, v
+			}
+		}
+	}
+	return report
+}
+
+// HandleSendProfile serves GET /admin/profile/send, the report of the
+// current or last run, and POST /admin/profile/send?duration=30s&rate=0.01,
+// which starts a run.
+func (cr *ChatRoom) HandleSendProfile(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		d, rate := defaultProfileDuration, defaultProfileRate
+		if s := r.URL.Query().Get("duration"); s != "" {
+			var err error
+			if d, err = time.ParseDuration(s); err != nil || d <= 0 || d > maxProfileDuration {
+				http.Error(w, "duration must be a positive Go duration of at most "+maxProfileDuration.String(), http.StatusBadRequest)
+				return
+			}
+		}
+		if s := r.URL.Query().Get("rate"); s != "" {
+			var err error
+			if rate, err = strconv.ParseFloat(s, 64); err != nil || rate <= 0 || rate > 1 {
+				http.Error(w, "rate must be a fraction above 0 and at most 1", http.StatusBadRequest)
+				return
+			}
+		}
+		cr.StartSendProfile(d, rate)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cr.SendProfile())
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"chatroom/testutil"
+)
+
+func sendProfileReport(t *testing.T, h http.Handler, method, query string) SendProfileReport {
+	t.Helper()
+	var report SendProfileReport
+	if w := do(h, method, "/admin/profile/send"+query, asAdmin...); w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &report) != nil {
+		t.Fatalf("%s send profile: %d %s", method, w.Code, w.Body)
+	}
+	return report
+}
+
+// A run samples messages sent while it lasts and stops by itself.
+func TestSendProfileSamplesForABoundedRun(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	cr, h := newTestRoom(t, WithClock(clk))
+	ta, tb := join(t, h, "alice"), join(t, h, "bob")
+	if s := cr.sample(); s != nil {
+		t.Fatal("sampled a message with no run")
+	}
+	for _, q := range []string{"?duration=11m", "?duration=soon", "?rate=0", "?rate=1.5"} {
+		if w := do(h, "POST", "/admin/profile/send"+q, asAdmin...); w.Code != http.StatusBadRequest {
+			t.Errorf("start with %s: %d, want 400", q, w.Code)
+		}
+	}
+
+	report := sendProfileReport(t, h, "POST", "?duration=1m&rate=1")
+	if !report.Running || report.Rate != 1 || !report.Ends.Equal(clk.Now().Add(time.Minute)) {
+		t.Errorf("started run %+v", report)
+	}
+	for _, body := range []string{"one", "two", "three"} {
+		send(h, ta, body)
+		pollOnce(h, "bob", tb)
+	}
+	eventually(t, "three samples", func() bool { return cr.SendProfile().Samples == 3 })
+	report = cr.SendProfile()
+	if len(report.Stages) != len(profileStages) || report.Slowest["p99"] == "" {
+		t.Errorf("report %+v, want every stage and the slowest", report)
+	}
+
+	clk.Advance(time.Minute)
+	send(h, ta, "after the run")
+	pollOnce(h, "bob", tb)
+	if report := sendProfileReport(t, h, "GET", ""); report.Running || report.Samples != 3 {
+		t.Errorf("report after the run %+v, want it stopped with 3 samples", report)
+	}
+}
+
+// The report gives percentiles per stage and names the slowest stage at
+// each percentile.
+func TestSendProfileReportFindsTheSlowestStage(t *testing.T) {
+	cr, _ := newTestRoom(t)
+	for i := 1; i <= 100; i++ {
+		s := make([]time.Duration, len(profileStages))
+		s[0] = time.Millisecond                          // validate: always 1ms
+		s[2] = time.Duration(i) * 100 * time.Microsecond // queue: 0.1ms to 10ms
+		cr.sendProfile.samples = append(cr.sendProfile.samples, s)
+	}
+	report := cr.SendProfile()
+	if report.Samples != 100 {
+		t.Fatalf("%d samples, want 100", report.Samples)
+	}
+	queue := report.Stages[2]
+	if queue.Stage != "queue" || queue.P50 != 5 || queue.P95 != 9.5 || queue.P99 != 9.9 {
+		t.Errorf("queue timings %+v", queue)
+	}
+	want := map[string]string{"p50": "queue", "p95": "queue", "p99": "queue"}
+	for q, stage := range want {
+		if report.Slowest[q] != stage {
+			t.Errorf("slowest at %s: %q, want %q", q, report.Slowest[q], stage)
+		}
+	}
+
+	// With queueing fast, validation is the slowest stage at the median.
+	for _, s := range cr.sendProfile.samples {
+		s[2] /= 20
+	}
+	if got := cr.SendProfile().Slowest["p50"]; got != "validate" {
+		t.Errorf("slowest at p50: %q, want validate", got)
+	}
+}
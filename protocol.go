@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// protocolHeader names the wire protocol version a client speaks. The
+// server answers in that version and echoes it back.
+const protocolHeader = "X-Convo-Protocol-Version"
+
+// protocolVersion is one supported wire protocol. Handlers that differ
+// between versions ask requestProtocol rather than reading the header.
+type protocolVersion struct {
+	sunset time.Time // When support ends; zero while it is not scheduled
+}
+
+// protocolVersions lists the supported versions. Version 1 is the query
+// string API with plain-text responses and errors.
+var protocolVersions = map[string]protocolVersion{
+	"1": {},
+}
+
+// WithProtocolSunset schedules the end of support for a protocol version.
+// Responses in that version carry Deprecation and Sunset headers until then.
+func WithProtocolSunset(version string, t time.Time) Option {
+	return func(cr *ChatRoom) {
+		if v, ok := cr.protocols[version]; ok {
+			v.sunset = t
+			cr.protocols[version] = v
+		}
+	}
+}
+
+type protocolKey struct{}
+
+// requestProtocol returns the protocol version r is served in.
+func requestProtocol(r *http.Request) string {
+	v, _ := r.Context().Value(protocolKey{}).(string)
+	return v
+}
+
+func (cr *ChatRoom) oldestProtocol() string {
+	versions := make([]string, 0, len(cr.protocols))
+	for v := range cr.protocols {
+		versions = append(versions, v)
+	}
+	// Versions are whole numbers; compare by length first so "10" > "9".
+	sort.Slice(versions, func(i, j int) bool {
+		if len(versions[i]) != len(versions[j]) {
+			return len(versions[i]) < len(versions[j])
+		}
+		return versions[i] < versions[j]
+	})
+	return versions[0]
+}
+
+// negotiateProtocol settles the protocol version of each request: the one
+// the client asked for, or the oldest supported one with a warning when it
+// did not ask. Unsupported versions get 400 listing what is supported.
+func (cr *ChatRoom) negotiateProtocol(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version := r.Header.Get(protocolHeader)
+		if version == "" {
+			version = cr.oldestProtocol()
+			w.Header().Set("Warning", `299 - "No `+protocolHeader+` header; assuming version `+version+`"`)
+		}
+		v, ok := cr.protocols[version]
+		if !ok || !v.sunset.IsZero() && !cr.clock.Now().Before(v.sunset) {
+			supported := make([]string, 0, len(cr.protocols))
+			for s, v := range cr.protocols {
+				if v.sunset.IsZero() || cr.clock.Now().Before(v.sunset) {
+					supported = append(supported, s)
+				}
+			}
+			sort.Strings(supported)
+			http.Error(w, "Unsupported protocol version "+version+"; supported: "+strings.Join(supported, ", "), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set(protocolHeader, version)
+		if !v.sunset.IsZero() {
+			w.Header().Set("Deprecation", "true")
+			w.Header().Set("Sunset", v.sunset.UTC().Format(http.TimeFormat))
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), protocolKey{}, version)))
+	})
+}
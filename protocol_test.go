@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"chatroom/testutil"
+)
+
+// countingIDs numbers IDs so pinned output does not depend on the time or
+// on crypto/rand.
+type countingIDs struct{ n atomic.Int64 }
+
+func (g *countingIDs) NewID() string { return fmt.Sprintf("id%d", g.n.Add(1)) }
+
+// exchange is one request and the exact response a protocol version gives.
+type exchange struct {
+	method, target string
+	admin          bool
+	status         int
+	contentType    string
+	body           string
+}
+
+// protocolPins is what each supported protocol version answers, byte for
+// byte. Every version in protocolVersions must be pinned here.
+var protocolPins = map[string][]exchange{
+	"1": {
+		{"POST", "/join?id=alice", false, 200, "", "Client alice joined the chat"},
+		{"POST", "/join?id=bob", false, 200, "", "Client bob joined the chat"},
+		{"POST", "/send?id=alice&message=hi", true, 200, "", "Message from alice sent"},
+		{"POST", "/send?id=alice&message=%3Cb%3E&format=json", true, 200, "application/json",
+			`{"message":{"id":"id4","from":"alice","body":"\u003cb\u003e","time":"2026-01-02T03:04:05Z","seq":2},"modified":false}` + "\n"},
+		{"GET", "/messages?id=bob&wait=0", true, 200, "", "alice: hi\n"},
+		{"GET", "/messages?id=bob&wait=0&format=json", true, 200, "application/json",
+			`{"id":"id4","from":"alice","body":"\u003cb\u003e","time":"2026-01-02T03:04:05Z","seq":2,"event_seq":4}` + "\n"},
+		{"GET", "/messages?wait=0", false, 401, "text/plain; charset=utf-8", "Sending requires the X-Convo-Token header returned by /join\n"},
+		{"GET", "/clients", false, 200, "application/json",
+			`{"generation":2,"members":[{"id":"alice","transport":"poll","status":"online"},{"id":"bob","transport":"poll","status":"online"}],"spectators":[]}` + "\n"},
+		{"POST", "/join?id=", false, 400, "text/plain; charset=utf-8", "Client ID is required\n"},
+	},
+}
+
+func TestProtocolOutputIsPinnedPerVersion(t *testing.T) {
+	cr, _ := newTestRoom(t)
+	oldest := cr.oldestProtocol()
+	for version := range protocolVersions {
+		pins, ok := protocolPins[version]
+		if !ok {
+			t.Errorf("protocol version %s has no pinned output", version)
+			continue
+		}
+		// Unversioned requests get the oldest version, so pin them too.
+		asked := []string{version}
+		if version == oldest {
+			asked = append(asked, "")
+		}
+		for _, header := range asked {
+			t.Run(fmt.Sprintf("v%s/header=%q", version, header), func(t *testing.T) {
+				clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+				_, h := newTestRoom(t, WithClock(clk), WithIDGenerator(&countingIDs{}))
+				for _, ex := range pins {
+					var headers []string
+					if header != "" {
+						headers = append(headers, protocolHeader, header)
+					}
+					if ex.admin {
+						headers = append(headers, asAdmin...)
+					}
+					w := do(h, ex.method, ex.target, headers...)
+					if w.Code != ex.status || w.Body.String() != ex.body || w.Header().Get("Content-Type") != ex.contentType {
+						t.Errorf("%s %s: got %d %q %q\nwant %d %q %q", ex.method, ex.target,
+							w.Code, w.Header().Get("Content-Type"), w.Body, ex.status, ex.contentType, ex.body)
+					}
+					if got := w.Header().Get(protocolHeader); got != version {
+						t.Errorf("%s %s: %s = %q, want %s", ex.method, ex.target, protocolHeader, got, version)
+					}
+					if warned := w.Header().Get("Warning") != ""; warned != (header == "") {
+						t.Errorf("%s %s: Warning %q with %s %q", ex.method, ex.target, w.Header().Get("Warning"), protocolHeader, header)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestUnsupportedProtocolVersionIs400(t *testing.T) {
+	_, h := newTestRoom(t)
+	w := do(h, "POST", "/join?id=alice", protocolHeader, "9")
+	if w.Code != http.StatusBadRequest || w.Body.String() != "Unsupported protocol version 9; supported: 1\n" {
+		t.Errorf("join with version 9: %d %q", w.Code, w.Body)
+	}
+}
+
+func TestProtocolSunset(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	sunset := clk.Now().Add(24 * time.Hour)
+	_, h := newTestRoom(t, WithClock(clk), WithProtocolSunset("1", sunset))
+	w := do(h, "GET", "/stats", protocolHeader, "1")
+	if w.Code != http.StatusOK || w.Header().Get("Deprecation") != "true" || w.Header().Get("Sunset") != "Sat, 03 Jan 2026 03:04:05 GMT" {
+		t.Errorf("before the sunset: %d, Deprecation %q, Sunset %q", w.Code, w.Header().Get("Deprecation"), w.Header().Get("Sunset"))
+	}
+	clk.Advance(24 * time.Hour)
+	if w := do(h, "GET", "/stats", protocolHeader, "1"); w.Code != http.StatusBadRequest {
+		t.Errorf("after the sunset: %d %q, want 400", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	actorProvisioning = "provisioning"
+	maxProvisionBody  = 1 << 20
+	maxAttributes     = 32
+	maxAttributeSize  = 256
+)
+
+var (
+	errBadAttributes  = fmt.Errorf("attributes must be at most %d string values of at most %d bytes", maxAttributes, maxAttributeSize)
+	errNotProvisioned = errors.New("client ID is not provisioned")
+)
+
+// ProvisionedUser is a client ID an external directory allows to join.
+type ProvisionedUser struct {
+	ID         string            `json:"id"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Updated    time.Time         `json:"updated"`
+}
+
+// WithProvisioning hands the list of who may join to an external system,
+// which manages it under /provision with "Authorization: Bearer <token>".
+// Once it is set, joins without admin credentials are refused for IDs that
+// are not provisioned.
+func WithProvisioning(token string) Option {
+	return func(cr *ChatRoom) {
+		cr.provisionToken = token
+	}
+}
+
+func (cr *ChatRoom) isProvisioner(r *http.Request) bool {
+	if cr.provisionToken == "" {
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(cr.provisionToken)) == 1
+}
+
+// provisionedLocked reports whether clientID may join under provisioning.
+func (cr *ChatRoom) provisionedLocked(clientID string) bool {
+	_, ok := cr.provisioned[clientID]
+	return cr.provisionToken == "" || ok
+}
+
+func validAttributes(attrs map[string]string) bool {
+	if len(attrs) > maxAttributes {
+		return false
+	}
+	for k, v := range attrs {
+		if k == "" || len(k) > maxAttributeSize || len(v) > maxAttributeSize {
+			return false
+		}
+	}
+	return true
+}
+
+func sameAttributes(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Provision creates or updates a user, reporting whether it was new.
+func (cr *ChatRoom) Provision(clientID string, attrs map[string]string) (ProvisionedUser, bool, error) {
+	if !validClientID(clientID) || clientID == systemSender {
+		return ProvisionedUser{}, false, errInvalidClientID
+	}
+	if !validAttributes(attrs) {
+		return ProvisionedUser{}, false, errBadAttributes
+	}
+	cr.mutex.Lock()
+	old, exists := cr.provisioned[clientID]
+	u := ProvisionedUser{ID: clientID, Attributes: attrs, Updated: cr.clock.Now()}
+	if exists && sameAttributes(old.Attributes, attrs) {
+		u = old
+	} else {
+		cr.provisioned[clientID] = u
+	}
+	cr.mutex.Unlock()
+	switch {
+	case !exists:
+		cr.audit.add(AuditEntry{Action: "provision", Actor: actorProvisioning, Target: clientID})
+	case u.Updated != old.Updated:
+		cr.audit.add(AuditEntry{Action: "provision_update", Actor: actorProvisioning, Target: clientID})
+	}
+	return u, !exists, nil
+}
+
+// Deprovision removes a user and ends its sessions. With purge it also
+// deletes what the user left behind: its draft, embargoed messages and
+// share links. It reports false when the user was not provisioned.
+func (cr *ChatRoom) Deprovision(clientID string, purge bool) bool {
+	cr.mutex.Lock()
+	_, exists := cr.provisioned[clientID]
+	delete(cr.provisioned, clientID)
+	cr.mutex.Unlock()
+	if !exists {
+		return false
+	}
+	cr.Revoke(clientID)
+	detail := ""
+	if purge {
+		cr.purgeUser(clientID)
+		detail = "purged"
+	}
+	cr.audit.add(AuditEntry{Action: "deprovision", Actor: actorProvisioning, Target: clientID, Detail: detail})
+	return true
+}
+
+func (cr *ChatRoom) purgeUser(clientID string) {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	delete(cr.drafts, clientID)
+	for id, e := range cr.embargoes {
+		if e.Message.From == clientID {
+			e.timer.Stop()
+			delete(cr.embargoes, id)
+		}
+	}
+	for token, s := range cr.shares {
+		if s.creator == clientID {
+			delete(cr.shares, token)
+		}
+	}
+	for id, j := range cr.exportJobs {
+		if j.owner == clientID {
+			delete(cr.exportJobs, id)
+		}
+	}
+}
+
+// SyncDiff reports what a sync changed, or would change on a dry run.
+type SyncDiff struct {
+	Added   []string `json:"added"`
+	Updated []string `json:"updated"`
+	Removed []string `json:"removed"`
+	DryRun  bool     `json:"dry_run,omitempty"`
+}
+
+// Sync makes the provisioned users exactly users. It works from the state
+// it finds rather than from what the last sync did, so running it again
+// after one was cut short finishes the job.
+func (cr *ChatRoom) Sync(users []ProvisionedUser, purge, dryRun bool) (SyncDiff, error) {
+	want := make(map[string]ProvisionedUser, len(users))
+	for _, u := range users {
+		if !validClientID(u.ID) || u.ID == systemSender {
+			return SyncDiff{}, fmt.Errorf("%w: %q", errInvalidClientID, u.ID)
+		}
+		if !validAttributes(u.Attributes) {
+			return SyncDiff{}, fmt.Errorf("%s: %w", u.ID, errBadAttributes)
+		}
+		if _, dup := want[u.ID]; dup {
+			return SyncDiff{}, fmt.Errorf("%s is listed twice", u.ID)
+		}
+		want[u.ID] = u
+	}
+	diff := SyncDiff{Added: []string{}, Updated: []string{}, Removed: []string{}, DryRun: dryRun}
+	cr.mutex.Lock()
+	for id, u := range want {
+		if old, ok := cr.provisioned[id]; !ok {
+			diff.Added = append(diff.Added, id)
+		} else if !sameAttributes(old.Attributes, u.Attributes) {
+			diff.Updated = append(diff.Updated, id)
+		}
+	}
+	for id := range cr.provisioned {
+		if _, ok := want[id]; !ok {
+			diff.Removed = append(diff.Removed, id)
+		}
+	}
+	cr.mutex.Unlock()
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Updated)
+	sort.Strings(diff.Removed)
+	if dryRun {
+		return diff, nil
+	}
+	for _, id := range append(diff.Added, diff.Updated...) {
+		cr.Provision(id, want[id].Attributes)
+	}
+	for _, id := range diff.Removed {
+		cr.Deprovision(id, purge)
+	}
+	return diff, nil
+}
+
+func (cr *ChatRoom) ProvisionedUsers() []ProvisionedUser {
+	cr.mutex.Lock()
+	users := make([]ProvisionedUser, 0, len(cr.provisioned))
+	for _, u := range cr.provisioned {
+		users = append(users, u)
+	}
+	cr.mutex.Unlock()
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users
+}
+
+// HandleProvision serves the provisioning API: GET /provision/users,
+// GET, PUT (a JSON body with attributes) and DELETE (?purge=true to delete
+// the user's data too) on /provision/users/{id}, and POST /provision/sync
+// with the full desired list of users (?dry_run=true to only report the
+// diff).
+func (cr *ChatRoom) HandleProvision(w http.ResponseWriter, r *http.Request) {
+	if cr.provisionToken == "" {
+		http.Error(w, "Provisioning is off; start the server with -provision-token", http.StatusNotFound)
+		return
+	}
+	if !cr.isProvisioner(r) {
+		http.Error(w, "Provisioning credentials required", http.StatusForbidden)
+		return
+	}
+	q := r.URL.Query()
+	purge := q.Get("purge") == "true"
+	path := strings.TrimPrefix(r.URL.Path, "/provision/")
+	if path == "sync" {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			Users []ProvisionedUser `json:"users"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxProvisionBody)).Decode(&req); err != nil || req.Users == nil {
+			http.Error(w, "Body must be a JSON object with a users list", http.StatusBadRequest)
+			return
+		}
+		diff, err := cr.Sync(req.Users, purge, q.Get("dry_run") == "true")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(diff)
+		return
+	}
+	if path == "users" {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(cr.ProvisionedUsers())
+		return
+	}
+	clientID, ok := strings.CutPrefix(path, "users/")
+	if !ok || clientID == "" {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		cr.mutex.Lock()
+		u, exists := cr.provisioned[clientID]
+		cr.mutex.Unlock()
+		if !exists {
+			http.Error(w, "User is not provisioned", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(u)
+	case http.MethodPut:
+		var req struct {
+			Attributes map[string]string `json:"attributes"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxProvisionBody)).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		u, created, err := cr.Provision(clientID, req.Attributes)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if created {
+			w.WriteHeader(http.StatusCreated)
+		}
+		json.NewEncoder(w).Encode(u)
+	case http.MethodDelete:
+		if !cr.Deprovision(clientID, purge) {
+			http.Error(w, "User is not provisioned", http.StatusNotFound)
+			return
+		}
+		if purge {
+			fmt.Fprintf(w, "%s deprovisioned and their data deleted", clientID)
+			return
+		}
+		fmt.Fprintf(w, "%s deprovisioned", clientID)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestJoinsRequireProvisioning(t *testing.T) {
+	cr, h := newTestRoom(t, WithProvisioning("directory-secret"))
+	if _, _, err := cr.Provision("alice", nil); err != nil {
+		t.Fatal(err)
+	}
+	if w := do(h, "POST", "/join?id=mallory"); w.Code != http.StatusForbidden {
+		t.Errorf("unprovisioned join: %d %s, want 403", w.Code, w.Body)
+	}
+	if w := do(h, "POST", "/join?id=alice"); w.Code != http.StatusOK {
+		t.Errorf("provisioned join: %d %s", w.Code, w.Body)
+	}
+	if w := do(h, "POST", "/join?id=operator", asAdmin...); w.Code != http.StatusOK {
+		t.Errorf("admin join of an unprovisioned ID: %d %s", w.Code, w.Body)
+	}
+	if err := cr.AddClient("bot"); err != nil {
+		t.Errorf("in-process client under provisioning: %v", err)
+	}
+}
+
+// A join racing Deprovision either is refused or is signed out by it;
+// it never outlives the deprovisioning.
+func TestDeprovisionRacingAJoinLeavesNoSession(t *testing.T) {
+	cr, h := newTestRoom(t, WithProvisioning("directory-secret"))
+	for i := 0; i < 500; i++ {
+		id := fmt.Sprintf("user%d", i)
+		if _, _, err := cr.Provision(id, nil); err != nil {
+			t.Fatal(err)
+		}
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			do(h, "POST", "/join?id="+id)
+		}()
+		cr.Deprovision(id, false)
+		wg.Wait()
+		cr.mutex.Lock()
+		_, joined := cr.clients[id]
+		cr.mutex.Unlock()
+		if joined {
+			t.Fatalf("%s is still joined after being deprovisioned", id)
+		}
+	}
+}
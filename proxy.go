@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+const maxUserAgentLength = 256
+
+// WithTrustedProxies lists the networks of reverse proxies whose
+// X-Forwarded-For header is believed. Requests from anywhere else are
+// attributed to their TCP peer, so clients cannot spoof their address.
+func WithTrustedProxies(prefixes []netip.Prefix) Option {
+	return func(cr *ChatRoom) {
+		cr.trustedProxies = prefixes
+	}
+}
+
+// parseTrustedProxies parses a comma-separated list of CIDRs or single
+// addresses.
+func parseTrustedProxies(s string) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if p, err := netip.ParsePrefix(f); err == nil {
+			out = append(out, p.Masked())
+			continue
+		}
+		a, err := netip.ParseAddr(f)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q is neither an address nor a CIDR", f)
+		}
+		out = append(out, netip.PrefixFrom(a, a.BitLen()))
+	}
+	return out, nil
+}
+
+func (cr *ChatRoom) trustedProxy(addr string) bool {
+	a, err := netip.ParseAddr(addr)
+	if err != nil {
+		return false
+	}
+	a = a.Unmap()
+	for _, p := range cr.trustedProxies {
+		if p.Contains(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIP is the address a request came from, without the port. Behind
+// trusted proxies it is the right-most X-Forwarded-For entry that is not
+// itself a trusted proxy.
+func (cr *ChatRoom) remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !cr.trustedProxy(ip) {
+		return ip
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if _, err := netip.ParseAddr(hop); err != nil {
+			break
+		}
+		ip = hop
+		if !cr.trustedProxy(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// userAgent returns the request's User-Agent, cut to a length that is safe
+// to keep per client.
+func userAgent(r *http.Request) string {
+	ua := r.UserAgent()
+	if len(ua) > maxUserAgentLength {
+		ua = ua[:maxUserAgentLength]
+	}
+	return strings.ToValidUTF8(ua, "")
+}
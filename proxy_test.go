@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRemoteIPBelievesOnlyTrustedProxies(t *testing.T) {
+	cr := NewChatRoom(WithTrustedProxies([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}))
+	defer cr.Close()
+	for _, tc := range []struct {
+		peer, forwarded, want string
+	}{
+		{"192.0.2.1:4000", "", "192.0.2.1"},
+		{"192.0.2.1:4000", "203.0.113.9", "192.0.2.1"}, // Not a proxy: the header is spoofed
+		{"10.0.0.5:4000", "203.0.113.9", "203.0.113.9"},
+		{"10.0.0.5:4000", "198.51.100.1, 203.0.113.9, 10.0.0.7", "203.0.113.9"},
+		{"10.0.0.5:4000", "garbage, 10.0.0.7", "10.0.0.7"},
+		{"10.0.0.5:4000", "", "10.0.0.5"},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tc.peer
+		if tc.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", tc.forwarded)
+		}
+		if got := cr.remoteIP(r); got != tc.want {
+			t.Errorf("peer %s forwarded for %q: got %s, want %s", tc.peer, tc.forwarded, got, tc.want)
+		}
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	got, err := parseTrustedProxies("10.1.2.3/8, 192.0.2.7,,")
+	want := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("192.0.2.7/32")}
+	if err != nil || len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("parsed %v, %v; want %v", got, err, want)
+	}
+	if _, err := parseTrustedProxies("proxy.internal"); err == nil {
+		t.Error("a host name was accepted as a trusted proxy")
+	}
+}
+
+// A client's address and User-Agent are shown to admins only, and are
+// attached to moderation entries in the audit log.
+func TestConnectionMetadataIsForAdminsAndTheAuditLog(t *testing.T) {
+	cr, h := newTestRoom(t, WithTrustedProxies([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}))
+	req := httptest.NewRequest("POST", "/join?id=alice", nil)
+	req.RemoteAddr = "10.0.0.5:4000"
+	req.Header.Set("X-Forwarded-For", "203.0.113.9")
+	req.Header.Set("User-Agent", "convo-test/1.0 "+strings.Repeat("x", maxUserAgentLength))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("join: %d %s", w.Code, w.Body)
+	}
+	alice := func(headers ...string) ClientInfo {
+		t.Helper()
+		var list ClientList
+		if w := do(h, "GET", "/clients", headers...); json.Unmarshal(w.Body.Bytes(), &list) != nil || len(list.Members) != 1 {
+			t.Fatalf("clients: %d %s", w.Code, w.Body)
+		}
+		return list.Members[0]
+	}
+	got := alice(asAdmin...)
+	if got.IP != "203.0.113.9" || !strings.HasPrefix(got.UserAgent, "convo-test/1.0 ") || len(got.UserAgent) != maxUserAgentLength {
+		t.Errorf("admin view: IP %q, User-Agent of %d bytes", got.IP, len(got.UserAgent))
+	}
+	if got.Transport != transportPoll {
+		t.Errorf("transport %q, want %q", got.Transport, transportPoll)
+	}
+	if got := alice(); got.IP != "" || got.UserAgent != "" {
+		t.Errorf("anonymous view shows IP %q and User-Agent %q", got.IP, got.UserAgent)
+	}
+
+	cr.Mute("alice", time.Minute, "testing", actorAdmin, false)
+	var entry AuditEntry
+	for _, e := range cr.audit.list() {
+		if e.Action == "mute" {
+			entry = e
+		}
+	}
+	if entry.IP != "203.0.113.9" || entry.UserAgent != got.UserAgent {
+		t.Errorf("mute audit entry: %+v, want alice's address and User-Agent", entry)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"time"
+)
+
+// defaultSessionQueue is how many deliveries a session may have waiting.
+const defaultSessionQueue = 256
+
+// WithSessionQueue sets how many deliveries each session, HTTP or
+// in-process, may have waiting before the slow-consumer policy applies.
+func WithSessionQueue(n int) Option {
+	return func(cr *ChatRoom) {
+		if n > 0 {
+			cr.sessionQueue = n
+		}
+	}
+}
+
+// deliveryQueue is a ring of deliveries, oldest first. It grows by doubling
+// up to the session limit, so idle sessions stay small and a full one
+// evicts without allocating.
+type deliveryQueue struct {
+	buf     []Message
+	head, n int
+	removed uint64 // Deliveries ever taken from the front, read or evicted
+}
+
+func (q *deliveryQueue) len() int { return q.n }
+
+// push appends m, evicting the oldest delivery when limit are already
+// waiting, and reports whether it did.
+func (q *deliveryQueue) push(m Message, limit int) bool {
+	if q.n >= limit {
+		q.buf[q.head] = m
+		q.head = (q.head + 1) % len(q.buf)
+		q.removed++
+		return true
+	}
+	if q.n == len(q.buf) {
+		buf := make([]Message, min(max(2*len(q.buf), 4), limit))
+		for i := 0; i < q.n; i++ {
+			buf[i] = q.buf[(q.head+i)%len(q.buf)]
+		}
+		q.buf, q.head = buf, 0
+	}
+	q.buf[(q.head+q.n)%len(q.buf)] = m
+	q.n++
+	return false
+}
+
+// peek returns the oldest delivery and its position, which changes once it
+// is popped or evicted.
+func (q *deliveryQueue) peek() (Message, uint64, bool) {
+	if q.n == 0 {
+		return Message{}, q.removed, false
+	}
+	return q.buf[q.head], q.removed, true
+}
+
+func (q *deliveryQueue) pop() (Message, bool) {
+	if q.n == 0 {
+		return Message{}, false
+	}
+	m := q.buf[q.head]
+	q.buf[q.head] = Message{}
+	q.head = (q.head + 1) % len(q.buf)
+	q.n--
+	q.removed++
+	return m, true
+}
+
+// enqueueLocked adds m to session c's queue and wakes its reader. This is
+// the slow-consumer policy: a session that falls sessionQueue deliveries
+// behind loses its oldest one for each new one, counted as dropped. It is
+// never disconnected for being slow, and the broadcast loop never waits for
+// it.
+func (cr *ChatRoom) enqueueLocked(c *client, m Message) {
+	if c.queue.push(m, cr.sessionQueue) {
+		c.overflowed++
+		cr.dropped.Add(1)
+	}
+	select {
+	case c.ready <- struct{}{}:
+	default:
+	}
+}
+
+// offerLocked queues m for session c unless its queue is full, for
+// deliveries that must not evict the ones already waiting.
+func (cr *ChatRoom) offerLocked(c *client, m Message) bool {
+	if c.queue.len() >= cr.sessionQueue {
+		return false
+	}
+	cr.enqueueLocked(c, m)
+	return true
+}
+
+// Outcomes of waiting for a delivery.
+const (
+	nextMessage  = iota
+	nextGone     // The session ended and its queue is empty
+	nextTimeout  // timeout fired
+	nextCanceled // cancel was closed
+)
+
+// next waits for session c of owner's next delivery, translated for c if it
+// asked for that, and returns it with its bytes as encode gives them for
+// writing in framing f. Those bytes, with the framing's overhead, are what
+// the delivery is charged against owner's bandwidth cap. A delivery that
+// does not fit this minute waits for the next one, while the queue behind
+// it keeps evicting its oldest; one bigger than the whole cap is dropped.
+//
+// Deliveries queued before the session ended are still handed out; only
+// then does it report nextGone. A nil timeout or cancel never fires.
+func (cr *ChatRoom) next(owner, c *client, f int, encode func(Message) []byte, timeout <-chan time.Time, cancel <-chan struct{}) (Message, []byte, int) {
+	gone, ended := false, c.gone
+	for {
+		var paced <-chan time.Time
+		cr.mutex.Lock()
+		m, pos, ok := c.queue.peek()
+		cr.mutex.Unlock()
+		if ok {
+			m = translated(m)
+			b := encode(m)
+			cost := wireCost(b, f)
+			now := cr.clock.Now()
+			cr.mutex.Lock()
+			if _, current, _ := c.queue.peek(); current != pos {
+				cr.mutex.Unlock()
+				continue // Evicted while it was being translated
+			}
+			wait, fits := cr.paceLocked(owner, cost, now)
+			if fits || wait == 0 {
+				c.queue.pop()
+			}
+			if fits {
+				owner.bw.used += cost
+				cr.delivered.Add(1)
+				cr.recordDelivered(m, cost, now)
+				cr.mutex.Unlock()
+				return m, b, nextMessage
+			}
+			if wait == 0 {
+				owner.bw.dropped++
+				cr.dropped.Add(1)
+				cr.mutex.Unlock()
+				continue
+			}
+			cr.mutex.Unlock()
+			paced = cr.clock.After(wait)
+		} else if gone {
+			return Message{}, nil, nextGone
+		}
+		select {
+		case <-c.ready:
+		case <-paced:
+		case <-ended:
+			gone, ended = true, nil
+		case <-timeout:
+			return Message{}, nil, nextTimeout
+		case <-cancel:
+			return Message{}, nil, nextCanceled
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// A subscriber gets every message of a burst that fits its queue, in order,
+// however fast they are published.
+func TestSubscribeReceivesEveryMessage(t *testing.T) {
+	const n = 1000
+	cr, _ := newTestRoom(t, WithSessionQueue(n))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := cr.Subscribe(ctx, "bot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(chan []string)
+	go func() {
+		var bodies []string
+		for m := range ch {
+			if m.From == "pub" {
+				bodies = append(bodies, m.Body)
+			}
+			if len(bodies) == n {
+				break
+			}
+		}
+		got <- bodies
+	}()
+	for i := 0; i < n; i++ {
+		if err := cr.Publish(Message{From: "pub", Body: fmt.Sprint(i)}); err != nil {
+			t.Fatalf("Publish %d: %v", i, err)
+		}
+	}
+	bodies := <-got
+	for i, b := range bodies {
+		if b != fmt.Sprint(i) {
+			t.Fatalf("message %d: got %q", i, b)
+		}
+	}
+	if n := cr.dropped.Load(); n != 0 {
+		t.Errorf("dropped %d deliveries", n)
+	}
+}
+
+// Messages sent while a poller is between polls wait for its next ones.
+func TestPollerGetsMessagesSentBetweenPolls(t *testing.T) {
+	_, h := newTestRoom(t)
+	ta := join(t, h, "alice")
+	tb := join(t, h, "bob")
+	for _, m := range []string{"one", "two", "three"} {
+		if w := send(h, ta, m); w.Code != http.StatusOK {
+			t.Fatalf("send: %d %s", w.Code, w.Body)
+		}
+	}
+	var got []string
+	for len(got) < 3 {
+		w := pollOnce(h, "bob", tb)
+		if w.Code != http.StatusOK {
+			t.Fatalf("poll after %q: %d %s", got, w.Code, w.Body)
+		}
+		if line := strings.TrimSpace(w.Body.String()); strings.Contains(line, "alice:") {
+			got = append(got, line[strings.LastIndex(line, " ")+1:])
+		}
+	}
+	if strings.Join(got, ",") != "one,two,three" {
+		t.Errorf("got %q, want one, two, three", got)
+	}
+}
+
+// A session that falls behind loses its oldest deliveries, not its newest,
+// and stays connected.
+func TestFullSessionQueueDropsOldest(t *testing.T) {
+	cr, h := newTestRoom(t, WithSessionQueue(4))
+	tb := join(t, h, "bob")
+	for i := 0; i < 10; i++ {
+		if err := cr.Publish(Message{From: "pub", Body: fmt.Sprint(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	eventually(t, "the queue to overflow", func() bool { return cr.Internals().SessionOverflows == 6 })
+	for i := 6; i < 10; i++ {
+		w := do(h, "GET", "/messages?wait=0&format=json&id=bob", tokenHeader, tb)
+		var m Message
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &m) != nil {
+			t.Fatalf("poll: %d %s", w.Code, w.Body)
+		}
+		if m.Body != fmt.Sprint(i) {
+			t.Fatalf("got %q, want %d", m.Body, i)
+		}
+	}
+	if w := pollOnce(h, "bob", tb); w.Code != http.StatusGatewayTimeout {
+		t.Errorf("empty queue: got %d %s, want %d", w.Code, w.Body, http.StatusGatewayTimeout)
+	}
+	if n := cr.dropped.Load(); n != 6 {
+		t.Errorf("dropped = %d, want 6", n)
+	}
+}
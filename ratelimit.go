@@ -0,0 +1,320 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sender rate classes. Every client is a human until an admin assigns it
+// another class.
+const (
+	rateHuman    = "human"
+	rateBot      = "bot"
+	rateFirehose = "firehose"
+
+	// maxRateBuckets bounds the buckets kept for clients that have left;
+	// beyond it, the buckets of clients no longer in the room that have
+	// refilled completely are forgotten. One that comes back starts again
+	// at its burst.
+	maxRateBuckets = 4096
+)
+
+// RateClass is the token bucket for one class of sender. PerMinute tokens
+// accrue each minute and each message takes one. Burst is what a sender can
+// always send at once; capacity it leaves unused keeps accruing as credits,
+// up to Credits more, so a batch poster that is quiet for an hour can send
+// its report in one go.
+type RateClass struct {
+	PerMinute float64 `json:"per_minute"`
+	Burst     int     `json:"burst"`
+	Credits   int     `json:"credits"`
+}
+
+var defaultRateClasses = map[string]RateClass{
+	rateHuman:    {PerMinute: 30, Burst: 10},
+	rateBot:      {PerMinute: 120, Burst: 30, Credits: 600},
+	rateFirehose: {PerMinute: 1200, Burst: 200, Credits: 2000},
+}
+
+func (rc RateClass) validate() error {
+	if rc.PerMinute <= 0 || rc.Burst < 1 || rc.Credits < 0 {
+		return fmt.Errorf("per_minute must be positive, burst at least 1 and credits non-negative")
+	}
+	return nil
+}
+
+func (rc RateClass) capacity() float64 { return float64(rc.Burst + rc.Credits) }
+
+// rateLimits is the send limiter. Guarded by ChatRoom.mutex.
+type rateLimits struct {
+	enabled bool
+	classes map[string]RateClass
+	assign  map[string]string      // Client ID to class; humans are left out
+	buckets map[string]*rateBucket // By client ID, so leaving and joining again does not refill one
+}
+
+type rateBucket struct {
+	tokens float64
+	at     time.Time // When tokens was last brought up to date
+}
+
+// WithRateLimits turns on per-sender rate limiting with the given classes,
+// which replace the defaults of the same name; nil keeps the defaults.
+func WithRateLimits(classes map[string]RateClass) Option {
+	return func(cr *ChatRoom) {
+		cr.rates.enabled = true
+		for name, rc := range classes {
+			if _, ok := cr.rates.classes[name]; ok && rc.validate() == nil {
+				cr.rates.classes[name] = rc
+			}
+		}
+	}
+}
+
+func newRateLimits() rateLimits {
+	rl := rateLimits{
+		classes: make(map[string]RateClass, len(defaultRateClasses)),
+		assign:  make(map[string]string),
+		buckets: make(map[string]*rateBucket),
+	}
+	for name, rc := range defaultRateClasses {
+		rl.classes[name] = rc
+	}
+	return rl
+}
+
+func (rl *rateLimits) classOf(clientID string) string {
+	if class, ok := rl.assign[clientID]; ok {
+		return class
+	}
+	return rateHuman
+}
+
+// refill brings b up to now under rc. A new bucket starts with rc.Burst
+// tokens: credits are only earned by not sending.
+func (b *rateBucket) refill(rc RateClass, now time.Time) {
+	if b.at.IsZero() {
+		b.tokens, b.at = float64(rc.Burst), now
+		return
+	}
+	if elapsed := now.Sub(b.at); elapsed > 0 {
+		b.tokens += elapsed.Minutes() * rc.PerMinute
+		b.at = now
+	}
+	b.tokens = math.Min(b.tokens, rc.capacity())
+}
+
+// untilTokens is how long b takes to reach n tokens under rc.
+func (b *rateBucket) untilTokens(rc RateClass, n float64) time.Duration {
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / rc.PerMinute * float64(time.Minute))
+}
+
+// RateStatus is where a sender stands after a send was counted, as
+// reported in the X-RateLimit headers.
+type RateStatus struct {
+	Class      string
+	Limit      int           // The class's burst
+	Remaining  int           // Whole tokens left, credits included
+	Reset      time.Duration // Until the full burst is available again
+	RetryAfter time.Duration // Until the next send is allowed; 0 when this one was
+}
+
+// takeRate charges one send to clientID's bucket, reporting false when the
+// bucket is empty.
+func (cr *ChatRoom) takeRate(clientID string) (RateStatus, bool) {
+	now := cr.clock.Now()
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	rl := &cr.rates
+	class := rl.classOf(clientID)
+	rc := rl.classes[class]
+	b, ok := rl.buckets[clientID]
+	if !ok {
+		if len(rl.buckets) >= maxRateBuckets {
+			cr.pruneRatesLocked(now)
+		}
+		b = &rateBucket{}
+		rl.buckets[clientID] = b
+	}
+	b.refill(rc, now)
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	st := RateStatus{Class: class, Limit: rc.Burst, Remaining: int(b.tokens), Reset: b.untilTokens(rc, float64(rc.Burst))}
+	if !allowed {
+		st.RetryAfter = b.untilTokens(rc, 1)
+	}
+	return st, allowed
+}
+
+// pruneRatesLocked forgets the full buckets of clients that have left. The
+// buckets of clients still in the room are kept whatever they hold, so none
+// loses its credits.
+func (cr *ChatRoom) pruneRatesLocked(now time.Time) {
+	rl := &cr.rates
+	for id, b := range rl.buckets {
+		if _, ok := cr.clients[id]; ok {
+			continue
+		}
+		rc := rl.classes[rl.classOf(id)]
+		if b.refill(rc, now); b.tokens >= rc.capacity() {
+			delete(rl.buckets, id)
+		}
+	}
+}
+
+// seconds rounds d up to whole seconds for a header.
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
+
+// limitSend applies the sender's rate class to a /send, setting the
+// X-RateLimit headers either way and answering 429 when the sender is out
+// of tokens. Admins and a room without limits are not limited.
+func (cr *ChatRoom) limitSend(w http.ResponseWriter, r *http.Request, clientID string) bool {
+	cr.mutex.Lock()
+	enabled := cr.rates.enabled
+	cr.mutex.Unlock()
+	if !enabled || cr.isAdmin(r) {
+		return true
+	}
+	st, ok := cr.takeRate(clientID)
+	h := w.Header()
+	h.Set("X-RateLimit-Class", st.Class)
+	h.Set("X-RateLimit-Limit", strconv.Itoa(st.Limit))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(st.Remaining))
+	h.Set("X-RateLimit-Reset", seconds(st.Reset))
+	if !ok {
+		h.Set("Retry-After", seconds(st.RetryAfter))
+		http.Error(w, "You are sending too fast for the "+st.Class+" rate class", http.StatusTooManyRequests)
+	}
+	return ok
+}
+
+// SetRateClass assigns clientID a rate class; rateHuman removes any
+// assignment. The client keeps its bucket, which the new class's limits
+// apply to from its next send.
+func (cr *ChatRoom) SetRateClass(clientID, class string) error {
+	cr.mutex.Lock()
+	_, ok := cr.rates.classes[class]
+	if ok {
+		if class == rateHuman {
+			delete(cr.rates.assign, clientID)
+		} else {
+			cr.rates.assign[clientID] = class
+		}
+	}
+	cr.mutex.Unlock()
+	if !ok {
+		return fmt.Errorf("rate class must be %s, %s or %s", rateHuman, rateBot, rateFirehose)
+	}
+	cr.audit.add(AuditEntry{Action: "rate_class", Actor: actorAdmin, Target: clientID, Detail: class})
+	return nil
+}
+
+// SetRateClasses replaces the parameters of the named classes. Buckets keep
+// their tokens, clamped to the new capacity on their next send.
+func (cr *ChatRoom) SetRateClasses(classes map[string]RateClass) error {
+	for name, rc := range classes {
+		if _, ok := defaultRateClasses[name]; !ok {
+			return fmt.Errorf("unknown rate class %q", name)
+		}
+		if err := rc.validate(); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	cr.mutex.Lock()
+	for name, rc := range classes {
+		cr.rates.classes[name] = rc
+	}
+	cr.mutex.Unlock()
+	names := make([]string, 0, len(classes))
+	for name, rc := range classes {
+		names = append(names, fmt.Sprintf("%s=%g/%d/%d", name, rc.PerMinute, rc.Burst, rc.Credits))
+	}
+	sort.Strings(names)
+	cr.audit.add(AuditEntry{Action: "rate_classes", Actor: actorAdmin, Detail: strings.Join(names, ", ")})
+	return nil
+}
+
+// RateLimits is the body of /admin/rate-classes.
+type RateLimits struct {
+	Classes map[string]RateClass `json:"classes"`
+	Assign  map[string]string    `json:"assignments"` // Client ID to class, for everyone who is not a human
+}
+
+func (cr *ChatRoom) RateLimits() RateLimits {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	rl := RateLimits{Classes: make(map[string]RateClass, len(cr.rates.classes)), Assign: make(map[string]string, len(cr.rates.assign))}
+	for name, rc := range cr.rates.classes {
+		rl.Classes[name] = rc
+	}
+	for id, class := range cr.rates.assign {
+		rl.Assign[id] = class
+	}
+	return rl
+}
+
+// HandleRateClasses serves GET /admin/rate-classes and POST with a JSON
+// object of classes to change, which takes effect on the next send without
+// a restart.
+func (cr *ChatRoom) HandleRateClasses(w http.ResponseWriter, r *http.Request) {
+	cr.mutex.Lock()
+	enabled := cr.rates.enabled
+	cr.mutex.Unlock()
+	if !enabled {
+		http.Error(w, "Rate limiting is off; start the server with -rate-limit", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var classes map[string]RateClass
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxMessageLength)).Decode(&classes); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		if err := cr.SetRateClasses(classes); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cr.RateLimits())
+}
+
+// HandleRateClass assigns a client a rate class:
+// POST /admin/rate-class?id=<id>&class=<human|bot|firehose>.
+func (cr *ChatRoom) HandleRateClass(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	clientID := q.Get("id")
+	if !validClientID(clientID) {
+		http.Error(w, errInvalidClientID.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := cr.SetRateClass(clientID, q.Get("class")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fmt.Fprintf(w, "%s is now in the %s rate class", clientID, q.Get("class"))
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"chatroom/testutil"
+)
+
+// newRateRoom returns a room limiting humans to a burst of 3 at 60 a minute
+// and bots to a burst of 2 with 5 credits, on a fake clock.
+func newRateRoom(t *testing.T) (*ChatRoom, http.Handler, *testutil.FakeClock) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	cr, h := newTestRoom(t, WithClock(clk), WithRateLimits(map[string]RateClass{
+		rateHuman: {PerMinute: 60, Burst: 3},
+		rateBot:   {PerMinute: 60, Burst: 2, Credits: 5},
+	}))
+	return cr, h, clk
+}
+
+// sendsAllowed sends as token until a send is refused, returning how many
+// got through and the refusal.
+func sendsAllowed(t *testing.T, h http.Handler, token string) (int, http.Header) {
+	t.Helper()
+	for n := 0; n < 100; n++ {
+		w := send(h, token, fmt.Sprint("message ", n))
+		switch w.Code {
+		case http.StatusOK:
+		case http.StatusTooManyRequests:
+			return n, w.Header()
+		default:
+			t.Fatalf("send %d: %d %s", n, w.Code, w.Body)
+		}
+	}
+	t.Fatal("never rate limited")
+	return 0, nil
+}
+
+func TestRateLimitBurstAndRefill(t *testing.T) {
+	_, h, clk := newRateRoom(t)
+	token := join(t, h, "alice")
+
+	w := send(h, token, "first")
+	if w.Code != http.StatusOK {
+		t.Fatalf("first send: %d %s", w.Code, w.Body)
+	}
+	for name, want := range map[string]string{
+		"X-RateLimit-Class":     rateHuman,
+		"X-RateLimit-Limit":     "3",
+		"X-RateLimit-Remaining": "2",
+		"X-RateLimit-Reset":     "1",
+	} {
+		if got := w.Header().Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	n, refused := sendsAllowed(t, h, token)
+	if n != 2 {
+		t.Errorf("%d more sends within the burst of 3, want 2", n)
+	}
+	if got := refused.Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After = %q, want 1 at 60 a minute", got)
+	}
+
+	clk.Advance(time.Second)
+	if n, _ := sendsAllowed(t, h, token); n != 1 {
+		t.Errorf("%d sends after a second, want 1", n)
+	}
+	// A long silence refills the burst but, for a class without credits,
+	// no more.
+	clk.Advance(time.Hour)
+	if n, _ := sendsAllowed(t, h, token); n != 3 {
+		t.Errorf("%d sends after an hour, want the burst of 3", n)
+	}
+}
+
+func TestRateLimitCreditsAccrueWhileQuiet(t *testing.T) {
+	cr, h, clk := newRateRoom(t)
+	token := join(t, h, "bot")
+	if err := cr.SetRateClass("bot", rateBot); err != nil {
+		t.Fatal(err)
+	}
+	// A new bucket holds only the burst: credits are earned, not given.
+	if n, _ := sendsAllowed(t, h, token); n != 2 {
+		t.Errorf("%d sends from a new bot, want its burst of 2", n)
+	}
+	clk.Advance(3 * time.Second)
+	if n, _ := sendsAllowed(t, h, token); n != 3 {
+		t.Errorf("%d sends after 3s, want 3", n)
+	}
+	clk.Advance(time.Hour)
+	if n, _ := sendsAllowed(t, h, token); n != 7 {
+		t.Errorf("%d sends after a quiet hour, want burst 2 plus 5 credits", n)
+	}
+}
+
+// Past maxRateBuckets, the full buckets of clients that left are forgotten,
+// and those of clients still in the room are kept with their credits.
+func TestRateBucketsArePrunedOnlyForAbsentClients(t *testing.T) {
+	cr, h, clk := newRateRoom(t)
+	token := join(t, h, "bot")
+	if err := cr.SetRateClass("bot", rateBot); err != nil {
+		t.Fatal(err)
+	}
+	cr.takeRate("bot")
+	for i := 0; len(cr.rates.buckets) < maxRateBuckets; i++ {
+		cr.takeRate(fmt.Sprintf("gone%d", i))
+	}
+	clk.Advance(time.Hour)
+
+	cr.takeRate("newcomer")
+	cr.mutex.Lock()
+	buckets := len(cr.rates.buckets)
+	_, kept := cr.rates.buckets["bot"]
+	cr.mutex.Unlock()
+	if buckets != 2 || !kept {
+		t.Fatalf("after pruning %d buckets remain (bot's kept: %v), want bot's and the newcomer's", buckets, kept)
+	}
+	if n, _ := sendsAllowed(t, h, token); n != 7 {
+		t.Errorf("bot sent %d after the prune, want burst 2 plus 5 credits", n)
+	}
+}
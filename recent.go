@@ -0,0 +1,91 @@
+package main
+
+import "sync"
+
+const recentMessagesSize = 1000
+
+// recentMessages indexes the last recentMessagesSize broadcast messages by ID
+// so endpoints that refer to a message (reports, summaries, ...) can look it
+// up. It is not a history: older messages are forgotten.
+type recentMessages struct {
+	mu   sync.Mutex
+	ring [recentMessagesSize]Message
+	next int
+	byID map[string]int // Message ID to ring index
+}
+
+func (rm *recentMessages) add(msg Message) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	if rm.byID == nil {
+		rm.byID = make(map[string]int)
+	}
+	if old := rm.ring[rm.next]; old.ID != "" {
+		delete(rm.byID, old.ID)
+	}
+	rm.ring[rm.next] = msg
+	rm.byID[msg.ID] = rm.next
+	rm.next = (rm.next + 1) % recentMessagesSize
+}
+
+func (rm *recentMessages) get(id string) (Message, bool) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	i, ok := rm.byID[id]
+	if !ok {
+		return Message{}, false
+	}
+	return rm.ring[i], true
+}
+
+// since returns the chat messages broadcast after the message with ID after,
+// oldest first, or all retained chat messages when after is empty. It
+// reports false when after is no longer retained.
+func (rm *recentMessages) since(after string) ([]Message, bool) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	if _, ok := rm.byID[after]; after != "" && !ok {
+		return nil, false
+	}
+	var out []Message
+	found := after == ""
+	for k := 0; k < recentMessagesSize; k++ {
+		m := rm.ring[(rm.next+k)%recentMessagesSize]
+		if !found {
+			found = m.ID == after
+			continue
+		}
+		if m.ID != "" && m.Type == "" {
+			out = append(out, m)
+		}
+	}
+	return out, true
+}
+
+// around returns the retained chat message with ID id and up to n chat
+// messages either side of it.
+func (rm *recentMessages) around(id string, n int) (msg Message, before, after []Message, ok bool) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	if _, ok := rm.byID[id]; !ok {
+		return Message{}, nil, nil, false
+	}
+	var chat []Message
+	at := -1
+	for k := 0; k < recentMessagesSize; k++ {
+		m := rm.ring[(rm.next+k)%recentMessagesSize]
+		if m.ID == "" || m.Type != "" {
+			continue
+		}
+		if m.ID == id {
+			at = len(chat)
+		}
+		chat = append(chat, m)
+	}
+	if at < 0 {
+		return Message{}, nil, nil, false
+	}
+	before = chat[max(0, at-n):at]
+	after = chat[at+1 : min(len(chat), at+1+n)]
+	return chat[at], before, after, true
+}
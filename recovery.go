@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+const defaultHandlerTimeout = 30 * time.Second
+
+// WithHandlerTimeout bounds how long a handler may run before the client
+// gets 503. Long polls, profiles and streamed lists are exempt. Zero turns
+// it off.
+func WithHandlerTimeout(d time.Duration) Option {
+	return func(cr *ChatRoom) {
+		if d >= 0 {
+			cr.handlerTimeout = d
+		}
+	}
+}
+
+// withTimeout applies the handler timeout to routes that are not long-running.
+func (cr *ChatRoom) withTimeout(rt route, h http.HandlerFunc) http.Handler {
+	if rt.longRunning || cr.handlerTimeout == 0 {
+		return h
+	}
+	return http.TimeoutHandler(h, cr.handlerTimeout, "Request timed out")
+}
+
+// recoverPanics turns a panicking handler into a logged stack trace and a
+// 500, counted in /stats, instead of a dropped connection. Aborted handlers
+// keep their special meaning.
+func (cr *ChatRoom) recoverPanics(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			cr.panics.Add(1)
+			log.Printf("panic serving %s %s: %v\n%s", r.Method, r.URL.Path, p, debug.Stack())
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "internal server error"})
+		}()
+		h.ServeHTTP(w, r)
+	})
+}
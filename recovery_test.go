@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// A panic in a hook called from a handler becomes a counted 500 with a JSON
+// error, and the room keeps serving everyone afterwards.
+func TestPanickingHookKeepsTheServerServing(t *testing.T) {
+	cr, base := newLiveServer(t)
+	cr.RegisterCommand("boom", "/boom", func(*ChatRoom, CommandInvocation) (*Message, error) {
+		panic("injected command panic")
+	})
+	cr.RegisterKind("boom", func(json.RawMessage) error {
+		panic("injected kind panic")
+	})
+	alice := &liveClient{base: base, id: "alice"}
+	bob := &liveClient{base: base, id: "bob"}
+	for _, c := range []*liveClient{alice, bob} {
+		resp, err := http.Post(base+"/join?id="+c.id, "", nil)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("join %s: %v %v", c.id, resp, err)
+		}
+		c.token = resp.Header.Get(tokenHeader)
+		resp.Body.Close()
+	}
+
+	injected := []url.Values{
+		{"message": {"/boom"}},
+		{"message": {"x"}, "kind": {"boom"}, "payload": {`{}`}},
+	}
+	for i, params := range injected {
+		req, _ := http.NewRequest("POST", base+"/send?"+params.Encode(), nil)
+		req.Header.Set(tokenHeader, alice.token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("send %v: %v", params, err)
+		}
+		var body struct{ Error string }
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusInternalServerError || err != nil || body.Error == "" {
+			t.Errorf("send %v: %d, error %q (%v); want 500 with a JSON error", params, resp.StatusCode, body.Error, err)
+		}
+		if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+			t.Errorf("send %v: Content-Type %q", params, ct)
+		}
+		if got := cr.Stats().Panics; got != int64(i+1) {
+			t.Errorf("after %d panics, stats report %d", i+1, got)
+		}
+	}
+
+	// No lock or registration was left half-done by the panics.
+	if code, body, err := alice.send("still here"); err != nil || code != http.StatusOK {
+		t.Fatalf("send after the panics: %d %s %v", code, body, err)
+	}
+	code, body, err := bob.poll()
+	if err != nil || code != http.StatusOK || !strings.Contains(body, "still here") {
+		t.Errorf("bob's poll after the panics: %d %q %v", code, body, err)
+	}
+	if code, _, err := bob.call("GET", "/clients", nil); err != nil || code != http.StatusOK {
+		t.Errorf("/clients after the panics: %d %v", code, err)
+	}
+	if code, _, err := (&liveClient{base: base, id: "carol"}).call("POST", "/join", nil); err != nil || code != http.StatusOK {
+		t.Errorf("join after the panics: %d %v", code, err)
+	}
+}
+
+// Routes that stay open by design are exempt from the handler timeout.
+func TestLongRunningRoutesAreExemptFromTheTimeout(t *testing.T) {
+	cr, _ := newTestRoom(t)
+	want := map[string]bool{"/messages": true, "/admin/profile/send": true, "/clients": true, "/admin/audit": true, "/me/export": true}
+	for _, rt := range cr.routes() {
+		if want[rt.pattern] && !rt.longRunning {
+			t.Errorf("%s is cut off by the handler timeout", rt.pattern)
+		}
+		delete(want, rt.pattern)
+	}
+	for pattern := range want {
+		t.Errorf("no route %s", pattern)
+	}
+}
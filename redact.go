@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// RedactionPattern is one kind of secret /send removes from messages.
+// Matches are replaced with [redacted:<Name>].
+type RedactionPattern struct {
+	Name   string
+	Regexp *regexp.Regexp
+	check  func(match string) bool // Rules out false positives; nil accepts every match
+}
+
+// builtinRedactions cover common token formats, card numbers and
+// international phone numbers.
+var builtinRedactions = []RedactionPattern{
+	{Name: "aws_key", Regexp: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{Name: "github_token", Regexp: regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{50,})\b`)},
+	{Name: "slack_token", Regexp: regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`)},
+	{Name: "stripe_key", Regexp: regexp.MustCompile(`\b[sr]k_(?:live|test)_[A-Za-z0-9]{16,}\b`)},
+	{Name: "jwt", Regexp: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{8,}\.eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}`)},
+	{Name: "private_key", Regexp: regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----(?s:.*?)(?:-----END [A-Z ]*PRIVATE KEY-----|$)`)},
+	{Name: "card", Regexp: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`), check: luhn},
+	{Name: "phone", Regexp: regexp.MustCompile(`\+\d{1,3}(?:[ .-]?\(?\d{2,4}\)?){2,4}\b`)},
+}
+
+// luhn reports whether the digits of s pass the Luhn checksum card numbers
+// carry, which most other long digit runs do not.
+func luhn(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		d := int(s[i] - '0')
+		if d < 0 || d > 9 {
+			continue
+		}
+		if n%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && sum%10 == 0
+}
+
+// WithRedaction removes secrets from every message sent through /send
+// before anything else sees it. Nil uses builtinRedactions.
+func WithRedaction(patterns []RedactionPattern) Option {
+	return func(cr *ChatRoom) {
+		if patterns == nil {
+			patterns = builtinRedactions
+		}
+		cr.redactions = patterns
+	}
+}
+
+// loadRedactions reads patterns from path, one per line as a name and a
+// regular expression separated by whitespace. Blank lines and lines
+// starting with # are skipped.
+func loadRedactions(path string) ([]RedactionPattern, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var patterns []RedactionPattern
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		name, expr, ok := strings.Cut(text, " ")
+		if !ok {
+			name, expr, ok = strings.Cut(text, "\t")
+		}
+		if !ok || !validClientID(name) {
+			return nil, fmt.Errorf("%s:%d: want a name and a regular expression", path, line)
+		}
+		re, err := regexp.Compile(strings.TrimSpace(expr))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		patterns = append(patterns, RedactionPattern{Name: name, Regexp: re})
+	}
+	return patterns, sc.Err()
+}
+
+// redact replaces every secret in body with its placeholder and returns
+// the names of the patterns that matched, sorted, without the secrets.
+func (cr *ChatRoom) redact(body string) (string, []string) {
+	var names []string
+	for _, p := range cr.redactions {
+		matched := false
+		body = p.Regexp.ReplaceAllStringFunc(body, func(s string) string {
+			if p.check != nil && !p.check(s) {
+				return s
+			}
+			matched = true
+			return "[redacted:" + p.Name + "]"
+		})
+		if matched {
+			names = append(names, p.Name)
+		}
+	}
+	sort.Strings(names)
+	return body, names
+}
+
+// redactSend removes secrets from a message clientID is sending, audits
+// which patterns matched and tells the sender. It runs before commands,
+// spam detection, entities and folding, so the secret is never stored.
+func (cr *ChatRoom) redactSend(clientID, body string) string {
+	if len(cr.redactions) == 0 {
+		return body
+	}
+	redacted, names := cr.redact(body)
+	if names == nil {
+		return body
+	}
+	list := strings.Join(names, ", ")
+	cr.audit.add(AuditEntry{Action: "redact", Actor: "redactor", Target: clientID, Detail: list})
+	cr.SendEphemeral(clientID, Message{Body: "Your message was redacted before it was sent: it contained " + list})
+	return redacted
+}
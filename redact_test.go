@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"chatroom/testutil"
+)
+
+func TestBuiltinRedactions(t *testing.T) {
+	cr := &ChatRoom{redactions: builtinRedactions}
+	token := "ghp_" + strings.Repeat("a1B2", 9)
+	for _, tc := range []struct {
+		body, want string
+		names      []string
+	}{
+		{"use " + token + " for CI", "use [redacted:github_token] for CI", []string{"github_token"}},
+		{"card 4111 1111 1111 1111 please", "card [redacted:card] please", []string{"card"}},
+		{"order 4111 1111 1111 1112 shipped", "order 4111 1111 1111 1112 shipped", nil}, // Fails the Luhn check
+		{"call +1 415 555 0100 or " + token, "call [redacted:phone] or [redacted:github_token]", []string{"github_token", "phone"}},
+		{"nothing to see", "nothing to see", nil},
+	} {
+		body, names := cr.redact(tc.body)
+		if body != tc.want || strings.Join(names, ",") != strings.Join(tc.names, ",") {
+			t.Errorf("redact(%q) = %q, %v; want %q, %v", tc.body, body, names, tc.want, tc.names)
+		}
+	}
+}
+
+func TestLoadRedactions(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "patterns")
+	os.WriteFile(path, []byte("# internal ticket ids\n\nticket\tTICKET-[0-9]+\nhost  [a-z0-9]+\\.corp\\.example\n"), 0o600)
+	patterns, err := loadRedactions(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cr := &ChatRoom{redactions: patterns}
+	if body, _ := cr.redact("see TICKET-42 on db1.corp.example"); body != "see [redacted:ticket] on [redacted:host]" {
+		t.Errorf("redacted %q", body)
+	}
+
+	for _, bad := range []string{"lonely\n", "ticket TICKET-[\n", "bad/name x\n"} {
+		os.WriteFile(path, []byte(bad), 0o600)
+		if _, err := loadRedactions(path); err == nil {
+			t.Errorf("loaded %q without an error", bad)
+		}
+	}
+}
+
+// A secret is replaced before it is delivered or logged, the audit log names
+// only the pattern, and the sender is told.
+func TestSentSecretsAreRedacted(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	cr, h := newTestRoom(t, WithClock(clk), WithRedaction(nil))
+	ta, tb := join(t, h, "alice"), join(t, h, "bob")
+	secret := "AKIA" + strings.Repeat("Q", 16)
+	q := url.Values{"format": {"json"}, "message": {"deploy with " + secret}}
+	var res SendResult
+	if w := do(h, "POST", "/send?"+q.Encode(), tokenHeader, ta); w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &res) != nil {
+		t.Fatalf("send: %d %s", w.Code, w.Body)
+	}
+	const want = "deploy with [redacted:aws_key]"
+	if !res.Modified || res.Message.Body != want {
+		t.Errorf("send result %+v, want the redacted body marked modified", res)
+	}
+
+	var m Message
+	json.Unmarshal(pollFrom(t, h, "bob", tb, "alice"), &m)
+	if m.Body != want {
+		t.Errorf("bob received %q", m.Body)
+	}
+	m = Message{}
+	json.Unmarshal(pollFrom(t, h, "alice", ta, systemSender), &m)
+	if m.Type != "ephemeral" || !strings.Contains(m.Body, "aws_key") || strings.Contains(m.Body, secret) {
+		t.Errorf("alice was told %+v", m)
+	}
+
+	var audited bool
+	for _, e := range cr.audit.list() {
+		audited = audited || e.Action == "redact" && e.Target == "alice" && e.Detail == "aws_key"
+	}
+	if !audited {
+		t.Error("the redaction was not audited")
+	}
+	for _, path := range []string{"/events?cursor=0", "/admin/audit"} {
+		if w := do(h, "GET", path, append([]string{tokenHeader, ta}, asAdmin...)...); strings.Contains(w.Body.String(), secret) {
+			t.Errorf("%s leaked the secret: %s", path, w.Body)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	maxReportReason      = 500
+	defaultReportMuteFor = 10 * time.Minute
+)
+
+// Report is one client's complaint about a message.
+type Report struct {
+	Reporter string    `json:"reporter"`
+	Reason   string    `json:"reason,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// ReportGroup collects every open report against one message together with
+// a snapshot of the message taken when it was first reported.
+type ReportGroup struct {
+	Message   Message  `json:"message"`
+	Reporters int      `json:"reporters"`
+	Reports   []Report `json:"reports"`
+}
+
+// ReportMessage files a report from reporter against a recent message.
+// Reporting the same message twice from one client has no further effect.
+func (cr *ChatRoom) ReportMessage(messageID, reporter, reason string) error {
+	msg, ok := cr.recent.get(messageID)
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	g, open := cr.reports[messageID]
+	if !open {
+		if !ok {
+			return errMessageNotFound
+		}
+		g = &ReportGroup{Message: msg}
+		cr.reports[messageID] = g
+	}
+	for _, rep := range g.Reports {
+		if rep.Reporter == reporter {
+			return nil
+		}
+	}
+	g.Reports = append(g.Reports, Report{Reporter: reporter, Reason: reason, Time: cr.clock.Now()})
+	g.Reporters = len(g.Reports)
+	return nil
+}
+
+func (cr *ChatRoom) Reports() []ReportGroup {
+	cr.mutex.Lock()
+	groups := make([]ReportGroup, 0, len(cr.reports))
+	for _, g := range cr.reports {
+		c := *g
+		c.Reports = append([]Report{}, g.Reports...)
+		groups = append(groups, c)
+	}
+	cr.mutex.Unlock()
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Reporters != groups[j].Reporters {
+			return groups[i].Reporters > groups[j].Reporters
+		}
+		return groups[i].Message.ID < groups[j].Message.ID
+	})
+	return groups
+}
+
+// ResolveReports closes every open report against messageID. Action "mute"
+// mutes the message's sender through the usual moderation path; "dismiss"
+// only closes the reports.
+func (cr *ChatRoom) ResolveReports(messageID, action string, muteFor time.Duration) error {
+	cr.mutex.Lock()
+	g, ok := cr.reports[messageID]
+	if ok {
+		delete(cr.reports, messageID)
+	}
+	cr.mutex.Unlock()
+	if !ok {
+		return errMessageNotFound
+	}
+	if action == "mute" {
+		cr.Mute(g.Message.From, muteFor, "reported message "+messageID, actorAdmin, false)
+	}
+	cr.audit.add(AuditEntry{
+		Action: "resolve_report",
+		Actor:  actorAdmin,
+		Target: g.Message.From,
+		Detail: fmt.Sprintf("%s on message %s (%d reporters)", action, messageID, g.Reporters),
+	})
+	return nil
+}
+
+// HandleMessageAction serves POST /messages/{id}/report.
+func (cr *ChatRoom) HandleMessageAction(w http.ResponseWriter, r *http.Request) {
+	messageID, action, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/messages/"), "/")
+	if ok && messageID != "" && action == "share" {
+		cr.HandleShare(w, r)
+		return
+	}
+	if ok && messageID != "" && action == "full" {
+		cr.HandleFullMessage(w, r)
+		return
+	}
+	if !ok || messageID == "" || action != "report" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	reporter, ok := cr.sender(w, r)
+	if !ok {
+		return
+	}
+	reason := r.URL.Query().Get("reason")
+	if len(reason) > maxReportReason || validateMessage(reason) != nil {
+		http.Error(w, "Invalid report reason", http.StatusBadRequest)
+		return
+	}
+	cr.mutex.Lock()
+	_, exists := cr.clients[reporter]
+	cr.mutex.Unlock()
+	if !exists {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+	if err := cr.ReportMessage(messageID, reporter, reason); err != nil {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	fmt.Fprintf(w, "Message %s reported", messageID)
+}
+
+// HandleReports lists open reports, most reported first. Paged requests
+// are ordered by message ID instead, since report counts change between
+// pages.
+func (cr *ChatRoom) HandleReports(w http.ResponseWriter, r *http.Request) {
+	p, err := parsePage(r, "reports")
+	if err != nil {
+		pageFailed(w, err)
+		return
+	}
+	groups := cr.Reports()
+	if p.limit > 0 {
+		idx, next := p.page(len(groups), func(i int) string { return groups[i].Message.ID })
+		paged := make([]ReportGroup, 0, len(idx))
+		for _, i := range idx {
+			paged = append(paged, groups[i])
+		}
+		groups = paged
+		setNextCursor(w, next)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(groups)
+}
+
+// HandleResolveReport serves POST /admin/reports/{id}/resolve?action=dismiss|mute.
+func (cr *ChatRoom) HandleResolveReport(w http.ResponseWriter, r *http.Request) {
+	messageID, rest, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/admin/reports/"), "/")
+	if !ok || messageID == "" || rest != "resolve" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	action := r.URL.Query().Get("action")
+	muteFor := defaultReportMuteFor
+	switch action {
+	case "dismiss":
+	case "mute":
+		if d := r.URL.Query().Get("duration"); d != "" {
+			parsed, err := time.ParseDuration(d)
+			if err != nil || parsed <= 0 {
+				http.Error(w, "Invalid duration", http.StatusBadRequest)
+				return
+			}
+			muteFor = parsed
+		}
+	default:
+		http.Error(w, "Action must be dismiss or mute", http.StatusBadRequest)
+		return
+	}
+	if err := cr.ResolveReports(messageID, action, muteFor); err != nil {
+		http.Error(w, "No open reports for message", http.StatusNotFound)
+		return
+	}
+	fmt.Fprintf(w, "Reports on message %s resolved: %s", messageID, action)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"chatroom/testutil"
+)
+
+func openReports(t *testing.T, h http.Handler) []ReportGroup {
+	t.Helper()
+	var groups []ReportGroup
+	if w := do(h, "GET", "/admin/reports", asAdmin...); w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &groups) != nil {
+		t.Fatalf("reports: %d %s", w.Code, w.Body)
+	}
+	return groups
+}
+
+// Reports group by message, count each reporter once and keep a snapshot
+// of the message; resolving with mute silences its sender and is audited.
+func TestReportsAreGroupedAndResolvedByMuting(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	cr, h := newTestRoom(t, WithClock(clk))
+	ta := join(t, h, "alice")
+	tb := join(t, h, "bob")
+	tc := join(t, h, "carol")
+	id := sentID(t, h, ta, "something rude")
+	eventually(t, "the message to be recent", func() bool {
+		_, ok := cr.recent.get(id)
+		return ok
+	})
+
+	for _, rep := range []struct{ token, reason string }{{tb, "rude"}, {tb, "still rude"}, {tc, ""}} {
+		if w := do(h, "POST", "/messages/"+id+"/report?reason="+url.QueryEscape(rep.reason), tokenHeader, rep.token); w.Code != http.StatusOK {
+			t.Fatalf("report: %d %s", w.Code, w.Body)
+		}
+	}
+	if w := do(h, "POST", "/messages/no-such-message/report", tokenHeader, tb); w.Code != http.StatusNotFound {
+		t.Errorf("reporting an unknown message: %d, want 404", w.Code)
+	}
+	groups := openReports(t, h)
+	if len(groups) != 1 || groups[0].Reporters != 2 || groups[0].Message.Body != "something rude" {
+		t.Fatalf("open reports: %+v, want one message reported by 2", groups)
+	}
+	if r := groups[0].Reports[0]; r.Reporter != "bob" || r.Reason != "rude" || !r.Time.Equal(clk.Now()) {
+		t.Errorf("bob's report: %+v, want his first reason at the time he filed it", r)
+	}
+
+	if w := do(h, "POST", "/admin/reports/"+id+"/resolve?action=mute&duration=1m", asAdmin...); w.Code != http.StatusOK {
+		t.Fatalf("resolve: %d %s", w.Code, w.Body)
+	}
+	if w := send(h, ta, "sorry"); w.Code != http.StatusForbidden {
+		t.Errorf("send from the muted sender: %d, want 403", w.Code)
+	}
+	if groups := openReports(t, h); len(groups) != 0 {
+		t.Errorf("reports still open after resolving: %+v", groups)
+	}
+	audited := false
+	for _, e := range cr.audit.list() {
+		audited = audited || e.Action == "resolve_report" && e.Target == "alice"
+	}
+	if !audited {
+		t.Error("resolving the reports left no audit entry")
+	}
+	if w := do(h, "POST", "/admin/reports/"+id+"/resolve?action=dismiss", asAdmin...); w.Code != http.StatusNotFound {
+		t.Errorf("resolving closed reports again: %d, want 404", w.Code)
+	}
+
+	clk.Advance(time.Minute)
+	if w := send(h, ta, "sorry"); w.Code != http.StatusOK {
+		t.Errorf("send after the mute ran out: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestDismissingReportsLeavesTheSenderAlone(t *testing.T) {
+	cr, h := newTestRoom(t)
+	ta := join(t, h, "alice")
+	tb := join(t, h, "bob")
+	id := sentID(t, h, ta, "fine, really")
+	eventually(t, "the message to be recent", func() bool {
+		_, ok := cr.recent.get(id)
+		return ok
+	})
+	do(h, "POST", "/messages/"+id+"/report", tokenHeader, tb)
+	if w := do(h, "POST", "/admin/reports/"+id+"/resolve?action=dismiss", asAdmin...); w.Code != http.StatusOK {
+		t.Fatalf("dismiss: %d %s", w.Code, w.Body)
+	}
+	if w := send(h, ta, "told you"); w.Code != http.StatusOK {
+		t.Errorf("send after a dismissal: %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Request signing stops a captured request from being replayed where there
+// is no TLS. The /join response then carries requestSigningHeader and the
+// session's secret. Every request with a send token must be signed:
+//
+//	signature = base64url(HMAC-SHA256(secret, method "\n" request URI "\n" timestamp "\n" nonce "\n" hex(SHA-256(body))))
+//
+// with the timestamp in Unix milliseconds. The server refuses timestamps
+// outside the skew window and nonces it has seen.
+const (
+	requestSigningHeader = "X-Convo-Request-Signing" // "hmac-sha256" on /join when signing is required
+	requestSecretHeader  = "X-Convo-Request-Secret"
+	timestampHeader      = "X-Convo-Timestamp"
+	nonceHeader          = "X-Convo-Nonce"
+	signatureHeader      = "X-Convo-Signature"
+	requestSigningScheme = "hmac-sha256"
+
+	defaultRequestSkew = 30 * time.Second
+	maxNonces          = 1 << 16
+	maxNonceLength     = 64
+	maxSignedBody      = 1 << 20
+)
+
+var (
+	errUnsignedRequest = errors.New("request must be signed: " + timestampHeader + ", " + nonceHeader + " and " + signatureHeader + " are required")
+	errStaleRequest    = errors.New("request timestamp is outside the allowed skew")
+	errReplayedRequest = errors.New("request nonce has already been used")
+	errBadRequestSig   = errors.New("request signature does not verify")
+)
+
+// requestSigning is the configuration and nonce cache of signing mode.
+type requestSigning struct {
+	enabled bool
+	skew    time.Duration
+	nonces  nonceCache
+}
+
+// WithRequestSigning requires clients to sign every request made with a
+// send token, accepting timestamps up to skew from the server clock. Zero
+// uses defaultRequestSkew.
+func WithRequestSigning(skew time.Duration) Option {
+	return func(cr *ChatRoom) {
+		if skew <= 0 {
+			skew = defaultRequestSkew
+		}
+		cr.requestSigning.enabled, cr.requestSigning.skew = true, skew
+	}
+}
+
+// nonceCache remembers the last maxNonces nonces. Once it is full, the
+// oldest is forgotten; if that nonce's timestamp was still inside the skew
+// window, requests at or before that timestamp are refused from then on,
+// since the cache can no longer tell whether they are replays.
+type nonceCache struct {
+	mu    sync.Mutex
+	seen  map[string]int64 // Session and nonce to the request's timestamp
+	ring  [maxNonces]string
+	next  int
+	floor int64
+}
+
+func (nc *nonceCache) add(key string, ts, oldest int64) bool {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	if nc.seen == nil {
+		nc.seen = make(map[string]int64)
+	}
+	if _, dup := nc.seen[key]; dup || ts <= nc.floor {
+		return false
+	}
+	if old := nc.ring[nc.next]; old != "" {
+		if t := nc.seen[old]; t >= oldest && t > nc.floor {
+			nc.floor = t
+		}
+		delete(nc.seen, old)
+	}
+	nc.ring[nc.next], nc.seen[key] = key, ts
+	nc.next = (nc.next + 1) % maxNonces
+	return true
+}
+
+// requestSecret is the signing secret of one session. It is derived from
+// the token key, so it survives token rotation and needs no storage.
+func (cr *ChatRoom) requestSecret(clientID, sessionID string) []byte {
+	derive := hmac.New(sha256.New, cr.tokenKey)
+	derive.Write([]byte("request signing"))
+	mac := hmac.New(sha256.New, derive.Sum(nil))
+	mac.Write([]byte(clientID + "\x00" + sessionID))
+	return mac.Sum(nil)
+}
+
+// requestSignature signs a request the way clients must.
+func requestSignature(secret []byte, method, uri, ts, nonce string, body []byte) string {
+	sum := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(method + "\n" + uri + "\n" + ts + "\n" + nonce + "\n" + hex.EncodeToString(sum[:])))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// checkRequest verifies the signature of a request from session sessionID
+// of clientID whose body has been read.
+func (cr *ChatRoom) checkRequest(r *http.Request, clientID, sessionID string, body []byte) error {
+	ts, nonce, sig := r.Header.Get(timestampHeader), r.Header.Get(nonceHeader), r.Header.Get(signatureHeader)
+	if ts == "" || nonce == "" || sig == "" || len(nonce) > maxNonceLength {
+		return errUnsignedRequest
+	}
+	ms, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errStaleRequest
+	}
+	now, skew := cr.clock.Now(), cr.requestSigning.skew
+	if d := now.Sub(time.UnixMilli(ms)); d > skew || d < -skew {
+		return errStaleRequest
+	}
+	want := requestSignature(cr.requestSecret(clientID, sessionID), r.Method, r.RequestURI, ts, nonce, body)
+	if !hmac.Equal([]byte(sig), []byte(want)) {
+		return errBadRequestSig
+	}
+	// Only a verified request may use up a nonce.
+	if !cr.requestSigning.nonces.add(sessionID+"\x00"+nonce, ms, now.Add(-skew).UnixMilli()) {
+		return errReplayedRequest
+	}
+	return nil
+}
+
+// verifyRequests enforces signing mode. Requests with a send token whose
+// session is gone pass through for the handler to refuse as usual.
+func (cr *ChatRoom) verifyRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get(tokenHeader)
+		if !cr.requestSigning.enabled || token == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		cr.mutex.Lock()
+		clientID, c, err := cr.tokenSessionLocked(token)
+		var sessionID string
+		if err == nil {
+			sessionID = c.sessionID
+		}
+		cr.mutex.Unlock()
+		if err != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSignedBody))
+		if err != nil {
+			http.Error(w, "Request body is too large to sign", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if err := cr.checkRequest(r, clientID, sessionID, body); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
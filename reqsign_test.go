@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"chatroom/testutil"
+)
+
+// A signed request goes through once, within the skew window. Unsigned,
+// badly signed, stale and replayed requests get 401.
+func TestSignedRequestsCannotBeReplayed(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	_, h := newTestRoom(t, WithClock(clk), WithRequestSigning(30*time.Second))
+	w := do(h, "POST", "/join?id=alice")
+	if w.Header().Get(requestSigningHeader) != requestSigningScheme {
+		t.Fatalf("join did not ask for signing: %v", w.Header())
+	}
+	token := w.Header().Get(tokenHeader)
+	secret, err := base64.RawURLEncoding.DecodeString(w.Header().Get(requestSecretHeader))
+	if err != nil || len(secret) == 0 {
+		t.Fatalf("request secret %q: %v", w.Header().Get(requestSecretHeader), err)
+	}
+	signed := func(method, target, nonce string, at time.Time, key []byte) *http.Request {
+		req := httptest.NewRequest(method, target, nil)
+		ts := strconv.FormatInt(at.UnixMilli(), 10)
+		req.Header.Set(tokenHeader, token)
+		req.Header.Set(timestampHeader, ts)
+		req.Header.Set(nonceHeader, nonce)
+		req.Header.Set(signatureHeader, requestSignature(key, method, target, ts, nonce, nil))
+		return req
+	}
+	serve := func(req *http.Request) int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := serve(signed("POST", "/send?message=hi", "n1", clk.Now(), secret)); code != http.StatusOK {
+		t.Fatalf("signed send: %d", code)
+	}
+	for name, req := range map[string]*http.Request{
+		"replayed":      signed("POST", "/send?message=hi", "n1", clk.Now(), secret),
+		"badly signed":  signed("POST", "/send?message=hi", "n2", clk.Now(), []byte("guess")),
+		"from the past": signed("POST", "/send?message=hi", "n3", clk.Now().Add(-31*time.Second), secret),
+	} {
+		if code := serve(req); code != http.StatusUnauthorized {
+			t.Errorf("%s send: %d, want 401", name, code)
+		}
+	}
+	if w := send(h, token, "unsigned"); w.Code != http.StatusUnauthorized {
+		t.Errorf("unsigned send: %d, want 401", w.Code)
+	}
+	// Signing covers the URI, so a signature cannot be moved to another message.
+	req := signed("POST", "/send?message=hi", "n4", clk.Now(), secret)
+	req.URL.RawQuery, req.RequestURI = "message=bye", "/send?message=bye"
+	if code := serve(req); code != http.StatusUnauthorized {
+		t.Errorf("send with a moved signature: %d, want 401", code)
+	}
+
+	clk.Advance(time.Minute)
+	if code := serve(signed("POST", "/send?message=later", "n5", clk.Now(), secret)); code != http.StatusOK {
+		t.Errorf("signed send a minute later: %d", code)
+	}
+	if w := do(h, "POST", "/leave?id=alice"); w.Code != http.StatusUnauthorized {
+		t.Errorf("leave without the token: %d, want 401", w.Code)
+	}
+	if code := serve(signed("POST", "/leave", "n6", clk.Now(), secret)); code != http.StatusOK {
+		t.Errorf("signed leave: %d", code)
+	}
+}
+
+// A full cache forgets the oldest nonce, then refuses timestamps up to it
+// while that nonce is inside the window.
+func TestNonceCacheStaysBounded(t *testing.T) {
+	var nc nonceCache
+	for i := 1; i <= maxNonces; i++ {
+		if !nc.add(fmt.Sprint("n", i), int64(i), 0) {
+			t.Fatalf("nonce %d refused", i)
+		}
+	}
+	if nc.add("n1", maxNonces+1, 0) {
+		t.Fatal("a replayed nonce was accepted")
+	}
+	if !nc.add("fresh", maxNonces+1, 0) || len(nc.seen) != maxNonces {
+		t.Fatalf("%d nonces cached, want %d", len(nc.seen), maxNonces)
+	}
+	if nc.add("n1", 1, 0) {
+		t.Error("the forgotten nonce n1 was accepted again")
+	}
+	if !nc.add("another", 2, 0) {
+		t.Error("a request after the forgotten nonce was refused")
+	}
+
+	// Forgetting a nonce already outside the window refuses nothing more.
+	var old nonceCache
+	for i := 1; i <= maxNonces+1; i++ {
+		old.add(fmt.Sprint("n", i), int64(i), 10)
+	}
+	if old.floor != 0 {
+		t.Errorf("floor %d after forgetting a nonce outside the window", old.floor)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// roster is an immutable view of the registered clients. /clients and
+// /stats read the current one instead of walking cr.clients under the
+// mutex, and both report its generation, so two responses with the same
+// generation describe the same registry.
+type roster struct {
+	gen        uint64
+	entries    []rosterEntry // Joined clients sorted by ID; pending joins are only counted
+	pending    int
+	spectators int
+	dnd        int         // Entries in do-not-disturb, whether or not it has expired
+	dndUntil   []time.Time // Expiry of each timed do-not-disturb, sorted
+	invisible  []int       // Indexes of the invisible entries
+}
+
+// busy counts the entries still in do-not-disturb at now.
+func (r *roster) busy(now time.Time) int {
+	expired := sort.Search(len(r.dndUntil), func(i int) bool { return r.dndUntil[i].After(now) })
+	return r.dnd - expired
+}
+
+// summary counts the entries, leaving out invisible ones that shown rejects;
+// a nil shown counts everyone. Invisible clients are few, so only they are
+// visited.
+func (r *roster) summary(now time.Time, shown func(rosterEntry) bool) ClientSummary {
+	busy := r.busy(now)
+	s := ClientSummary{
+		Generation: r.gen,
+		Members:    len(r.entries) - r.spectators,
+		Spectators: r.spectators,
+		Online:     len(r.entries) - busy,
+		Busy:       busy,
+		Pending:    r.pending,
+	}
+	for _, i := range r.invisible {
+		e := r.entries[i]
+		if shown == nil || shown(e) {
+			continue
+		}
+		if e.spectator {
+			s.Spectators--
+		} else {
+			s.Members--
+		}
+		if e.status(now) == statusBusy {
+			s.Busy--
+		} else {
+			s.Online--
+		}
+	}
+	return s
+}
+
+// page returns the entries on page p of those shown, in page order, and the
+// cursor of the next page. The entries are sorted by ID already, so unlike
+// pageRequest.page this finds the page without sorting or copying keys.
+func (r *roster) page(p pageRequest, shown func(rosterEntry) bool) ([]rosterEntry, string) {
+	n := len(r.entries)
+	i, step := sort.Search(n, func(i int) bool { return r.entries[i].id > p.after }), 1
+	if p.desc {
+		i, step = n-1, -1
+		if p.after != "" {
+			i = sort.Search(n, func(i int) bool { return r.entries[i].id >= p.after }) - 1
+		}
+	}
+	var page []rosterEntry
+	for ; i >= 0 && i < n; i += step {
+		if !shown(r.entries[i]) {
+			continue
+		}
+		if len(page) == p.limit {
+			return page, p.cursor(page[len(page)-1].id)
+		}
+		page = append(page, r.entries[i])
+	}
+	return page, ""
+}
+
+type rosterEntry struct {
+	id, transport   string
+	addr, userAgent string
+	spectator, dnd  bool
+	invisible       bool
+	dndUntil        time.Time
+}
+
+func (e rosterEntry) status(now time.Time) string {
+	if e.dnd && (e.dndUntil.IsZero() || now.Before(e.dndUntil)) {
+		return statusBusy
+	}
+	return statusOnline
+}
+
+// registry hands out rosters. gen counts changes to the clients map and to
+// the client fields a roster carries; a roster is rebuilt at most once per
+// generation, and only when someone asks for it.
+type registry struct {
+	gen     atomic.Uint64
+	current atomic.Pointer[roster]
+	rebuild sync.Mutex // Lets one reader rebuild while the others wait for its result
+}
+
+// rosterChangedLocked marks the current roster stale. Call it after any
+// change to cr.clients or to the pending, role, transport, do-not-disturb
+// or presence state of a registered client.
+func (cr *ChatRoom) rosterChangedLocked() {
+	cr.registry.gen.Add(1)
+}
+
+// roster returns a roster no older than the latest registry change. While
+// the registry is unchanged this takes no locks at all.
+func (cr *ChatRoom) roster() *roster {
+	if r := cr.registry.current.Load(); r != nil && r.gen == cr.registry.gen.Load() {
+		return r
+	}
+	cr.registry.rebuild.Lock()
+	defer cr.registry.rebuild.Unlock()
+	if r := cr.registry.current.Load(); r != nil && r.gen == cr.registry.gen.Load() {
+		return r
+	}
+	cr.mutex.Lock()
+	r := &roster{gen: cr.registry.gen.Load(), entries: make([]rosterEntry, 0, len(cr.clients))}
+	for id, c := range cr.clients {
+		if c.pending {
+			r.pending++
+			continue
+		}
+		r.entries = append(r.entries, rosterEntry{
+			id:        id,
+			transport: c.transport,
+			addr:      c.addr,
+			userAgent: c.userAgent,
+			spectator: c.role == roleSpectator,
+			dnd:       c.dnd,
+			dndUntil:  c.dndUntil,
+			invisible: c.invisible,
+		})
+		if c.role == roleSpectator {
+			r.spectators++
+		}
+		if c.dnd {
+			r.dnd++
+			if !c.dndUntil.IsZero() {
+				r.dndUntil = append(r.dndUntil, c.dndUntil)
+			}
+		}
+	}
+	cr.mutex.Unlock()
+	sort.Slice(r.entries, func(i, j int) bool { return r.entries[i].id < r.entries[j].id })
+	for i, e := range r.entries {
+		if e.invisible {
+			r.invisible = append(r.invisible, i)
+		}
+	}
+	sort.Slice(r.dndUntil, func(i, j int) bool { return r.dndUntil[i].Before(r.dndUntil[j]) })
+	cr.registry.current.Store(r)
+	return r
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+// BenchmarkPublishDeliverWhileReading is BenchmarkPublishDeliver with
+// goroutines reading /clients and /stats as fast as they can. Readers are
+// served from the roster, so the send path's latency should not depend on
+// how many there are beyond the CPU they take.
+func BenchmarkPublishDeliverWhileReading(b *testing.B) {
+	const clients = 10000
+	for _, readers := range []int{0, 1, 4} {
+		b.Run(fmt.Sprintf("readers=%d", readers), func(b *testing.B) {
+			cr, h := newTestRoom(b)
+			for i := 0; i < clients; i++ {
+				if err := cr.AddClient(fmt.Sprintf("user%d", i)); err != nil {
+					b.Fatal(err)
+				}
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			probe, err := cr.Subscribe(ctx, "probe")
+			if err != nil {
+				b.Fatal(err)
+			}
+			var wg sync.WaitGroup
+			defer wg.Wait()
+			defer cancel()
+			for i := 0; i < readers; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					targets := []string{"/stats", "/clients?limit=100", "/clients?summary=true"}
+					for j := i; ctx.Err() == nil; j++ {
+						do(h, "GET", targets[j%len(targets)])
+					}
+				}(i)
+			}
+			msg := Message{From: "bench", Body: "the quick brown fox jumps over the lazy dog"}
+			publish := func() {
+				if err := cr.Publish(msg); err != nil {
+					b.Fatal(err)
+				}
+				for m := range probe {
+					if m.From == "bench" {
+						break
+					}
+				}
+			}
+			for i := 0; i < defaultSessionQueue; i++ {
+				publish()
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				publish()
+			}
+		})
+	}
+}
+
+// BenchmarkClients measures /clients and /stats on a large, unchanging
+// room, where every request reuses the same roster.
+func BenchmarkClients(b *testing.B) {
+	cr, h := newTestRoom(b)
+	for i := 0; i < 50000; i++ {
+		if err := cr.AddClient(fmt.Sprintf("user%d", i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	targets := []struct{ name, target string }{
+		{"stats", "/stats"},
+		{"page", "/clients?limit=100"},
+		{"summary", "/clients?summary=true"},
+	}
+	for _, tc := range targets {
+		target := tc.target
+		b.Run(tc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if w := do(h, "GET", target); w.Code != http.StatusOK {
+					b.Fatalf("%s: %d", target, w.Code)
+				}
+			}
+		})
+	}
+}
+
+// /clients and /stats taken at the same generation agree, however the
+// registry changes around them.
+func TestClientsAndStatsAgreeWithinAGeneration(t *testing.T) {
+	cr, h := newTestRoom(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ctx.Err() == nil; i++ {
+			id := fmt.Sprintf("churn%d", i%50)
+			if cr.AddClient(id) != nil {
+				cr.RemoveClient(id)
+			}
+		}
+	}()
+	defer wg.Wait()
+	defer cancel()
+	eventually(t, "the churn to start", func() bool { return cr.registry.gen.Load() > 100 })
+
+	matched := 0
+	for i := 0; i < 2000 && matched < 20; i++ {
+		var list struct {
+			Generation uint64            `json:"generation"`
+			Members    []json.RawMessage `json:"members"`
+			Spectators []json.RawMessage `json:"spectators"`
+		}
+		if err := json.Unmarshal(do(h, "GET", "/clients").Body.Bytes(), &list); err != nil {
+			t.Fatal(err)
+		}
+		var stats Stats
+		if err := json.Unmarshal(do(h, "GET", "/stats").Body.Bytes(), &stats); err != nil {
+			t.Fatal(err)
+		}
+		if stats.Generation != list.Generation {
+			continue
+		}
+		matched++
+		if listed := len(list.Members) + len(list.Spectators); stats.Clients != listed {
+			t.Fatalf("generation %d: /stats counts %d clients, /clients lists %d", stats.Generation, stats.Clients, listed)
+		}
+	}
+	if matched == 0 {
+		t.Skip("the registry never held still between two reads")
+	}
+}
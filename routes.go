@@ -0,0 +1,313 @@
+package main
+
+import "net/http"
+
+// routeParam documents one query, header or path parameter of a route.
+type routeParam struct {
+	name        string
+	in          string // "query", "header" or "path"
+	description string
+	required    bool
+}
+
+// route is one registered endpoint together with the metadata the OpenAPI
+// document is generated from. Everything served by Handler comes from this
+// table, so the spec cannot miss an endpoint.
+type route struct {
+	pattern     string // ServeMux pattern
+	path        string // OpenAPI path when it differs from pattern (subtree patterns)
+	methods     []string
+	summary     string
+	params      []routeParam
+	body        string // Description of a JSON request body, if any
+	json        bool   // Whether successful responses are JSON rather than text
+	admin       bool
+	longRunning bool // Exempt from the handler timeout, whose buffering would also defeat flushes
+	handler     http.HandlerFunc
+}
+
+func query(name, description string, required bool) routeParam {
+	return routeParam{name: name, in: "query", description: description, required: required}
+}
+
+func header(name, description string, required bool) routeParam {
+	return routeParam{name: name, in: "header", description: description, required: required}
+}
+
+func pathParam(name, description string) routeParam {
+	return routeParam{name: name, in: "path", description: description, required: true}
+}
+
+var clientIDParam = query("id", "Client ID", true)
+
+// callerParams identify the client a per-user route acts for; see
+// ChatRoom.sender.
+func callerParams(params ...routeParam) []routeParam {
+	return append([]routeParam{
+		header(tokenHeader, "Send token from /join; identifies the caller (admins may pass id instead)", false),
+		query("id", "Client ID; must match the send token when both are given", false),
+	}, params...)
+}
+
+// pageParams are the paging parameters every list endpoint takes; see
+// parsePage. The next page's cursor comes back in X-Next-Cursor.
+var pageParams = []routeParam{
+	query("cursor", "X-Next-Cursor of the previous page", false),
+	query("limit", "Items per page, at most 500; the whole list when neither this nor cursor is given", false),
+	query("order", "asc (default) or desc by the list's key, on the first page", false),
+}
+
+func withPaging(params ...routeParam) []routeParam {
+	return append(params, pageParams...)
+}
+
+func (cr *ChatRoom) routes() []route {
+	return append([]route{
+		{pattern: "/join", methods: []string{"GET", "POST"}, summary: "Join the chat",
+			params: []routeParam{clientIDParam, query("role", "member (default) or spectator", false),
+				query("challenge", "Challenge from /join/challenge, when proof of work is required", false),
+				query("nonce", "Makes SHA-256(challenge:id:nonce) start with the challenge's difficulty in zero bits", false),
+				query("invite", "Invite code, when the server requires one", false),
+				query("capabilities", "Comma-separated features this session understands, e.g. kinds,previews; the server's set is echoed in X-Convo-Capabilities", false)},
+			handler: cr.HandleJoin},
+		{pattern: "/join/challenge", methods: []string{"GET"}, summary: "Get a proof-of-work challenge for /join",
+			handler: cr.HandleJoinChallenge},
+		{pattern: "/send", methods: []string{"GET", "POST"}, summary: "Broadcast a message or run a slash command",
+			params: []routeParam{header(tokenHeader, "Send token from /join; identifies the sender (admins may pass id instead)", false),
+				query("id", "Client ID; must match the send token when both are given", false),
+				query("message", "Message text", true),
+				query("kind", "text (default), code, location or another registered kind; with -e2ee always e2ee, for a base64 ciphertext message", false),
+				query("payload", "JSON object for kinds other than text, up to 4 KiB (16 KiB for code); message is then the fallback text", false),
+				query("embargo_until", "Hold the message until this RFC 3339 time or Unix milliseconds (at most 24h ahead)", false),
+				query("ts", "Unix seconds; required with sig", false),
+				query("sig", "Base64 Ed25519 signature of ts + \"\\n\" + message; required for senders with a registered key", false),
+				query("client_msg_id", "Sender's own ID for the message, echoed in every delivery of it", false),
+				query("format", "json to get the message as delivered instead of a text confirmation", false)},
+			handler: cr.HandleSend},
+		{pattern: "/polls/", path: "/polls/{pollID}", methods: []string{"GET"}, summary: "Current tally of a poll", json: true,
+			params:  []routeParam{pathParam("pollID", "ID of the poll message")},
+			handler: cr.HandlePoll},
+		{pattern: "/polls/", path: "/polls/{pollID}/vote", methods: []string{"POST"}, summary: "Vote in a poll, replacing any earlier vote",
+			params: []routeParam{pathParam("pollID", "ID of the poll message"), header(tokenHeader, "Send token from /join", true),
+				query("option", "Index of the chosen option", true)},
+			handler: cr.HandlePoll},
+		{pattern: "/polls/", path: "/polls/{pollID}/close", methods: []string{"POST"}, summary: "Close a poll (its creator or an admin) and publish the results",
+			params:  []routeParam{pathParam("pollID", "ID of the poll message"), header(tokenHeader, "Send token from /join; not needed with the admin token", false)},
+			handler: cr.HandlePoll},
+		{pattern: "/leave", methods: []string{"GET", "POST"}, summary: "Leave the chat",
+			params:  callerParams(),
+			handler: cr.HandleLeave},
+		{pattern: "/messages", methods: []string{"GET"}, summary: "Long-poll for the next message",
+			params: callerParams(query("format", "json for the full message envelope", false),
+				query("wait", "Seconds to wait, clamped to the server's bounds; see X-Poll-Wait", false),
+				query("session", "Session from X-Convo-Session; needed to tell devices apart in coexist mode", false),
+				query("stream", "true to keep the response open for the whole wait, writing each message as a line of JSON", false),
+				query("max", "With stream=true, messages after which the stream ends (default 100, at most 1000)", false)),
+			longRunning: true, handler: cr.HandleMessages},
+		{pattern: "/events", methods: []string{"GET"}, summary: "Messages and joins and leaves after a cursor, in one sequence", json: true,
+			params: []routeParam{header(tokenHeader, "Send token of a joined client; not needed by admins", false),
+				query("cursor", "Seq of the last event seen; 0 or absent for everything retained", false),
+				query("types", "Comma-separated event types to return, e.g. message,join,leave", false),
+				query("limit", "Most events to return (default 100, at most 1000)", false)},
+			handler: cr.HandleEvents},
+		{pattern: "/sync", methods: []string{"GET"}, summary: "Catch up after a long absence: the newest messages after a cursor, a gap marker for the rest, and net joins and leaves", json: true,
+			params: []routeParam{header(tokenHeader, "Send token of a joined client; not needed by admins", false),
+				query("cursor", "Seq of the last event seen", true),
+				query("budget", "Most messages to return (default 200, at most 1000)", false)},
+			handler: cr.HandleSync},
+		{pattern: "/messages/", path: "/messages/{messageID}/report", methods: []string{"POST"}, summary: "Report a message",
+			params:  callerParams(pathParam("messageID", "Message ID"), query("reason", "Why the message is reported", false)),
+			handler: cr.HandleMessageAction},
+		{pattern: "/messages/", path: "/messages/{messageID}/share", methods: []string{"POST"}, summary: "Create an expiring link that shows a recent message without joining", json: true,
+			params: []routeParam{pathParam("messageID", "Message ID"), header(tokenHeader, "Send token", true),
+				query("expires", "Go duration until the link expires (default 24h or the server's maximum, if shorter), at most the maximum", false),
+				query("context", "Messages to show either side, 0 to 20", false)},
+			handler: cr.HandleMessageAction},
+		{pattern: "/messages/", path: "/messages/{messageID}/full", methods: []string{"GET"}, summary: "Read the whole body of a folded message",
+			params: []routeParam{pathParam("messageID", "Message ID"), header(tokenHeader, "Send token", true),
+				query("format", "json for the full message envelope", false)},
+			handler: cr.HandleMessageAction},
+		{pattern: "/shared/", path: "/shared/{token}", methods: []string{"GET", "DELETE"}, summary: "Show a shared message (GET, no authentication) or revoke the link (DELETE, its creator or an admin)", json: true,
+			params:  []routeParam{pathParam("token", "Share token")},
+			handler: cr.HandleShared},
+		{pattern: "/me/draft", methods: []string{"GET", "PUT", "DELETE"}, summary: "Get, save (the request body as text) or clear the caller's unsent draft", json: true,
+			params:  []routeParam{header(tokenHeader, "Send token", true)},
+			handler: cr.HandleDraft},
+		{pattern: "/me/key", methods: []string{"GET", "PUT"}, summary: "Get or publish the caller's public key for end-to-end encryption (only with -e2ee)", json: true,
+			params:  []routeParam{header(tokenHeader, "Send token", true)},
+			body:    `{"key": "<base64>"}`,
+			handler: cr.HandleMyKey},
+		{pattern: "/keys", methods: []string{"GET"}, summary: "Public keys of the joined clients, for encrypting to the room (only with -e2ee)", json: true,
+			params:  []routeParam{header(tokenHeader, "Send token of a joined client", false)},
+			handler: cr.HandleKeys},
+		{pattern: "/me/presence", methods: []string{"GET", "PATCH"}, summary: "Get or set whether other clients see the caller in /clients", json: true,
+			params:  []routeParam{header(tokenHeader, "Send token", true)},
+			body:    `{"visibility": "visible" | "invisible"}`,
+			handler: cr.HandlePresence},
+		{pattern: "/me/shares", methods: []string{"GET"}, summary: "The caller's share links with their access counts", json: true,
+			params:  withPaging(header(tokenHeader, "Send token", true)),
+			handler: cr.HandleMyShares},
+		{pattern: "/me/export", methods: []string{"GET", "POST"}, summary: "A copy of the caller's own messages, drafts, embargoes, share links and settings (GET), or a job preparing it (POST)", json: true,
+			params:      []routeParam{header(tokenHeader, "Send token returned by /join", true)},
+			longRunning: true, handler: cr.HandleExport},
+		{pattern: "/me/export/", path: "/me/export/{jobID}", methods: []string{"GET"}, summary: "An export job: 202 while it is being prepared, then the export for an hour", json: true,
+			params:  []routeParam{pathParam("jobID", "ID returned by POST /me/export"), header(tokenHeader, "Send token of the client that started the job", true)},
+			handler: cr.HandleExportJob},
+		{pattern: "/me/language", methods: []string{"POST"}, summary: "Set the preferred translation language",
+			params:  callerParams(query("lang", "Language tag; empty disables translation", false)),
+			handler: cr.HandleLanguage},
+		{pattern: "/me/rotate", methods: []string{"POST"}, summary: "Replace the send token; the old one works for 30s more",
+			params:  []routeParam{header(tokenHeader, "Current send token", true)},
+			handler: cr.HandleRotate},
+		{pattern: "/me/keywords", methods: []string{"GET", "POST", "DELETE"}, summary: "List (GET), watch (POST) or unwatch (DELETE) keywords that raise a keyword event", json: true,
+			params:  []routeParam{header(tokenHeader, "Send token", true), query("keyword", "Word to watch or unwatch", false)},
+			handler: cr.HandleKeywords},
+		{pattern: "/me/dnd", methods: []string{"POST", "DELETE"}, summary: "Turn do-not-disturb on (POST) or off (DELETE)",
+			params:  callerParams(query("duration", "How long DND lasts, e.g. 30m; until turned off when omitted", false)),
+			handler: cr.HandleDoNotDisturb},
+		{pattern: "/me/bandwidth-cap", methods: []string{"POST"}, summary: "Cap the bytes delivered to this client per minute",
+			params:  callerParams(query("bytes", "Bytes per minute; 0 removes the cap", true)),
+			handler: cr.HandleBandwidthCap},
+		{pattern: "/quota", methods: []string{"GET"}, summary: "Bytes delivered this minute and the effective cap", json: true,
+			params:  callerParams(),
+			handler: cr.HandleQuota},
+		{pattern: "/embargoes", methods: []string{"GET", "DELETE"}, summary: "List your embargoed messages (all for admins) or cancel one", json: true,
+			params: []routeParam{header(tokenHeader, "Send token from /join; not needed by admins", false),
+				query("id", "Sender ID; must match the send token when both are given", false), query("message", "Message ID to cancel (DELETE)", false)},
+			handler: cr.HandleEmbargoes},
+		{pattern: "/clients", methods: []string{"GET"}, summary: "List connected members and spectators", json: true,
+			params:      withPaging(query("summary", "true for only counts by role and status", false)),
+			longRunning: true, handler: cr.HandleClients},
+		{pattern: "/stats", methods: []string{"GET"}, summary: "Room statistics", json: true,
+			handler: cr.HandleStats},
+		{pattern: "/metrics", methods: []string{"GET"}, summary: "Prometheus metrics, including the delivery latency histogram",
+			handler: cr.HandleMetrics},
+		{pattern: "/summary", methods: []string{"GET"}, summary: "Summarize recent messages", json: true,
+			params:  []routeParam{query("since", "Summarize messages after this message ID; all retained messages when omitted", false)},
+			handler: cr.HandleSummary},
+		{pattern: "/activity", methods: []string{"GET"}, summary: "Messages per minute over the last 24h", json: true,
+			handler: cr.HandleActivity},
+		{pattern: "/healthz", methods: []string{"GET"}, summary: "Health and maintenance state", json: true,
+			handler: cr.HandleHealthz},
+		{pattern: "/readyz", methods: []string{"GET"}, summary: "Readiness, with outbound integration checks when enabled", json: true,
+			handler: cr.HandleReadyz},
+		{pattern: "/openapi.json", methods: []string{"GET"}, summary: "This OpenAPI document", json: true,
+			handler: cr.HandleOpenAPI},
+		{pattern: "/docs", methods: []string{"GET"}, summary: "Human-readable API documentation",
+			handler: cr.HandleDocs},
+		{pattern: "/ui", methods: []string{"GET"}, summary: "Embedded web client (unless started with -ui=false)",
+			handler: cr.HandleUI},
+
+		{pattern: "/admin/maintenance", methods: []string{"GET", "POST"}, summary: "Get or set maintenance mode", json: true, admin: true,
+			body:    `{"enabled": bool, "message": string}`,
+			handler: cr.HandleMaintenance},
+		{pattern: "/admin/profile/send", methods: []string{"GET", "POST"}, summary: "Sample messages through the send path for a while (POST) and report where the time goes (GET)", json: true, admin: true,
+			params: []routeParam{query("duration", "With POST, how long to sample (default 30s, at most 10m)", false),
+				query("rate", "With POST, the fraction of messages to sample (default 0.01)", false)},
+			longRunning: true, handler: cr.HandleSendProfile},
+		{pattern: "/admin/chaos", methods: []string{"GET", "POST"}, summary: "Get or set chaos-mode faults (only with -chaos)", json: true, admin: true,
+			body:    `{"latency_ms": int, "drop_rate": float, "send_error_rate": float}`,
+			handler: cr.HandleChaos},
+		{pattern: "/admin/revoke", methods: []string{"POST"}, summary: "End a client's sessions, or invalidate every send token issued before a time", admin: true,
+			params: []routeParam{query("id", "Client whose sessions to end", false),
+				query("before", "RFC 3339 time or now; tokens issued earlier stop working", false)},
+			handler: cr.HandleRevoke},
+		{pattern: "/admin/welcome", methods: []string{"GET", "POST"}, summary: "Get or set the welcome sent to new joiners", json: true, admin: true,
+			body:    `{"text": string}`,
+			handler: cr.HandleWelcome},
+		{pattern: "/admin/dlq", methods: []string{"GET"}, summary: "Webhook deliveries that failed every retry", json: true, admin: true,
+			params: pageParams, handler: cr.HandleDeadLetters},
+		{pattern: "/admin/dlq/replay", methods: []string{"POST"}, summary: "Send dead-lettered webhook payloads again, marked as replays", json: true, admin: true,
+			params:  []routeParam{query("id", "Dead letter to replay; all of them when omitted", false)},
+			handler: cr.HandleReplay},
+		{pattern: "/admin/alerts", methods: []string{"GET"}, summary: "List firing alerts and recent alert events", json: true, admin: true,
+			handler: cr.HandleAlerts},
+		{pattern: "/admin/pending", methods: []string{"GET"}, summary: "List join requests awaiting approval", json: true, admin: true,
+			params: pageParams, handler: cr.HandlePending},
+		{pattern: "/admin/approve", methods: []string{"POST"}, summary: "Approve a pending join", admin: true,
+			params:  []routeParam{clientIDParam},
+			handler: cr.HandleApprove},
+		{pattern: "/admin/deny", methods: []string{"POST"}, summary: "Deny a pending join", admin: true,
+			params:  []routeParam{clientIDParam, query("reason", "Told to the requester", false)},
+			handler: cr.HandleApprove},
+		{pattern: "/admin/keys", methods: []string{"GET", "POST", "DELETE"}, summary: "List, register, rotate or remove sender signing keys", admin: true,
+			params:  []routeParam{query("id", "Sender ID (POST, DELETE)", false), query("key", "Base64 Ed25519 public key (POST)", false)},
+			handler: cr.HandleSigningKeys},
+		{pattern: "/admin/bandwidth-cap", methods: []string{"POST"}, summary: "Set a hard per-minute delivery cap for a role", admin: true,
+			params:  []routeParam{query("role", "member or spectator", true), query("bytes", "Bytes per minute; 0 removes the cap", true)},
+			handler: cr.HandleRoleBandwidthCap},
+		{pattern: "/admin/rate-classes", methods: []string{"GET", "POST"}, summary: "Get or change the send rate classes, effective from the next send (only with -rate-limit)", json: true, admin: true,
+			body:    `{"<human|bot|firehose>": {"per_minute": float, "burst": int, "credits": int}}`,
+			handler: cr.HandleRateClasses},
+		{pattern: "/admin/rate-class", methods: []string{"POST"}, summary: "Put a client in a send rate class", admin: true,
+			params:  []routeParam{clientIDParam, query("class", "human, bot or firehose", true)},
+			handler: cr.HandleRateClass},
+		{pattern: "/admin/promote", methods: []string{"POST"}, summary: "Promote a spectator to member", admin: true,
+			params:  []routeParam{clientIDParam},
+			handler: cr.HandlePromote},
+		{pattern: "/admin/usage", methods: []string{"GET"}, summary: "Daily usage per sender, system usage under \"unattributed\"", json: true, admin: true,
+			params: []routeParam{query("from", "First UTC day, YYYY-MM-DD", false), query("to", "Last UTC day, YYYY-MM-DD", false),
+				query("format", "json (default) or csv", false)},
+			handler: cr.HandleUsage},
+		{pattern: "/admin/audit", methods: []string{"GET"}, summary: "Audit log", json: true, admin: true,
+			params: pageParams, longRunning: true, handler: cr.HandleAudit},
+		{pattern: "/admin/mutes", methods: []string{"GET"}, summary: "List active mutes", json: true, admin: true,
+			params: pageParams, handler: cr.HandleMutes},
+		{pattern: "/admin/mute", methods: []string{"POST"}, summary: "Mute a client", admin: true,
+			params: []routeParam{clientIDParam, query("duration", "Go duration, e.g. 10m", true), query("reason", "Recorded in the audit log", false),
+				query("shadow", "true to accept the client's sends but deliver them only back to it", false)},
+			handler: cr.HandleMute},
+		{pattern: "/admin/unmute", methods: []string{"POST"}, summary: "Lift a mute, including auto-mutes", admin: true,
+			params:  []routeParam{clientIDParam},
+			handler: cr.HandleUnmute},
+		{pattern: "/admin/reports", methods: []string{"GET"}, summary: "Open reports grouped by message", json: true, admin: true,
+			params: pageParams, handler: cr.HandleReports},
+		{pattern: "/admin/reports/", path: "/admin/reports/{messageID}/resolve", methods: []string{"POST"}, summary: "Resolve the reports on a message", admin: true,
+			params: []routeParam{pathParam("messageID", "Message ID"), query("action", "dismiss or mute", true),
+				query("duration", "Mute duration when action is mute", false)},
+			handler: cr.HandleResolveReport},
+		{pattern: "/admin/notes/", path: "/admin/notes/{userID}", methods: []string{"GET", "POST", "DELETE"}, summary: "List, add or delete moderator notes on a user", json: true, admin: true,
+			params: withPaging(pathParam("userID", "Client ID the notes are about"), query("text", "Note text, for POST", false),
+				query("by", "Moderator writing the note, for POST (default admin)", false), query("note", "Note to delete; all of them when omitted", false)),
+			handler: cr.HandleNotes},
+		{pattern: "/admin/ephemeral", methods: []string{"POST"}, summary: "Send an ephemeral message to one client", admin: true,
+			params: []routeParam{clientIDParam, query("message", "Message text", true),
+				query("urgent", "true to deliver even if the recipient is in do-not-disturb", false)},
+			handler: cr.HandleEphemeral},
+		{pattern: "/provision/", path: "/provision/users", methods: []string{"GET"}, summary: "List provisioned users (provisioning token, only with -provision-token)", json: true,
+			handler: cr.HandleProvision},
+		{pattern: "/provision/", path: "/provision/users/{userID}", methods: []string{"GET", "PUT", "DELETE"}, summary: "Get, create or update (PUT), or deprovision and sign out (DELETE) a user", json: true,
+			params:  []routeParam{pathParam("userID", "Client ID"), query("purge", "true on DELETE to also delete the user's draft, embargoed messages and share links", false)},
+			body:    `{"attributes": {"<name>": "<value>"}}`,
+			handler: cr.HandleProvision},
+		{pattern: "/provision/", path: "/provision/sync", methods: []string{"POST"}, summary: "Replace the provisioned users with a full list, deprovisioning anyone left out", json: true,
+			params: []routeParam{query("dry_run", "true to report the changes without making them", false),
+				query("purge", "true to also delete the data of users who are removed", false)},
+			body:    `{"users": [{"id": string, "attributes": {"<name>": "<value>"}}]}`,
+			handler: cr.HandleProvision},
+	}, cr.debugRoutes()...)
+}
+
+// Handler returns the HTTP API of the room.
+func (cr *ChatRoom) Handler() http.Handler {
+	return cr.recoverPanics(cr.negotiateProtocol(cr.verifyRequests(cr.mux())))
+}
+
+// mux routes each pattern in the route table to its handler.
+func (cr *ChatRoom) mux() *http.ServeMux {
+	mux := http.NewServeMux()
+	registered := make(map[string]bool)
+	for _, rt := range cr.routes() {
+		// Rows documenting further paths of a subtree share its handler.
+		if registered[rt.pattern] {
+			continue
+		}
+		registered[rt.pattern] = true
+		h := rt.handler
+		if rt.admin {
+			h = cr.requireAdmin(h)
+		}
+		mux.Handle(rt.pattern, cr.withTimeout(rt, h))
+	}
+	return mux
+}
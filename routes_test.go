@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// specPaths returns the paths and methods documented in /openapi.json.
+func specPaths(t *testing.T, h http.Handler) map[string][]string {
+	t.Helper()
+	w := do(h, "GET", "/openapi.json")
+	var doc struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &doc) != nil {
+		t.Fatalf("openapi.json: %d %s", w.Code, w.Body)
+	}
+	out := make(map[string][]string, len(doc.Paths))
+	for path, ops := range doc.Paths {
+		for method := range ops {
+			out[path] = append(out[path], strings.ToUpper(method))
+		}
+		sort.Strings(out[path])
+	}
+	return out
+}
+
+var pathParamPattern = regexp.MustCompile(`\{[^}]+\}`)
+
+// The served document and the registered routes describe the same API:
+// every documented path reaches the handler the route table gives it, and
+// every route is documented.
+func TestOpenAPIMatchesRegisteredRoutes(t *testing.T) {
+	cr, h := newTestRoom(t)
+	spec := specPaths(t, h)
+	mux := cr.mux()
+	documented := make(map[string]string)
+	for _, rt := range cr.routes() {
+		path := rt.path
+		if path == "" {
+			path = rt.pattern
+		}
+		documented[path] = rt.pattern
+		methods := append([]string(nil), rt.methods...)
+		sort.Strings(methods)
+		if got := spec[path]; strings.Join(got, ",") != strings.Join(methods, ",") {
+			t.Errorf("%s: spec documents %v, route table has %v", path, got, methods)
+		}
+	}
+	for path, methods := range spec {
+		pattern, ok := documented[path]
+		if !ok {
+			t.Errorf("%s is in the spec but not the route table", path)
+			continue
+		}
+		target := pathParamPattern.ReplaceAllString(path, "x")
+		for _, method := range methods {
+			if _, got := mux.Handler(httptest.NewRequest(method, target, nil)); got != pattern {
+				t.Errorf("%s %s is routed to %q, want %q", method, target, got, pattern)
+			}
+		}
+	}
+}
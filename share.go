@@ -0,0 +1,314 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultShareExpiry    = 24 * time.Hour
+	defaultMaxShareExpiry = 7 * 24 * time.Hour
+	maxShareContext       = 20
+	maxShares             = 1024
+)
+
+var (
+	errSharingDisabled = errors.New("sharing is disabled")
+	errShareNotFound   = errors.New("share link not found")
+	errShareGone       = errors.New("share link has expired or been revoked")
+	errTooManyShares   = errors.New("too many share links")
+)
+
+// ShareLinks configures links that show one message to people outside the
+// chat.
+type ShareLinks struct {
+	Enabled   bool
+	MaxExpiry time.Duration // Longest expiry a creator may ask for
+}
+
+func WithShareLinks(cfg ShareLinks) Option {
+	return func(cr *ChatRoom) {
+		if cfg.MaxExpiry <= 0 {
+			cfg.MaxExpiry = defaultMaxShareExpiry
+		}
+		cr.shareLinks = cfg
+	}
+}
+
+// share is a link to one message. Guarded by ChatRoom.mutex.
+type share struct {
+	token     string
+	messageID string
+	creator   string
+	context   int // Messages shown either side
+	created   time.Time
+	expires   time.Time
+	revoked   bool
+	accesses  int
+}
+
+// ShareInfo describes a share link to its creator.
+type ShareInfo struct {
+	Token     string    `json:"token"`
+	URL       string    `json:"url"`
+	MessageID string    `json:"message_id"`
+	Context   int       `json:"context"`
+	Expires   time.Time `json:"expires"`
+	Revoked   bool      `json:"revoked,omitempty"`
+	Accesses  int       `json:"accesses"`
+}
+
+func (s *share) info() ShareInfo {
+	return ShareInfo{
+		Token:     s.token,
+		URL:       "/shared/" + s.token,
+		MessageID: s.messageID,
+		Context:   s.context,
+		Expires:   s.expires,
+		Revoked:   s.revoked,
+		Accesses:  s.accesses,
+	}
+}
+
+// Share creates a link to messageID, which must still be retained in the
+// recent messages, expiring after d.
+func (cr *ChatRoom) Share(creator, messageID string, context int, d time.Duration) (ShareInfo, error) {
+	if !cr.shareLinks.Enabled {
+		return ShareInfo{}, errSharingDisabled
+	}
+	if _, ok := cr.recent.get(messageID); !ok {
+		return ShareInfo{}, errMessageNotFound
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ShareInfo{}, err
+	}
+	now := cr.clock.Now()
+	s := &share{
+		token:     base64.RawURLEncoding.EncodeToString(b),
+		messageID: messageID,
+		creator:   creator,
+		context:   context,
+		created:   now,
+		expires:   now.Add(d),
+	}
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	if len(cr.shares) >= maxShares {
+		for token, old := range cr.shares {
+			if old.revoked || !now.Before(old.expires) {
+				delete(cr.shares, token)
+			}
+		}
+		if len(cr.shares) >= maxShares {
+			return ShareInfo{}, errTooManyShares
+		}
+	}
+	cr.shares[s.token] = s
+	return s.info(), nil
+}
+
+// RevokeShare ends a share link. Only its creator can, unless admin is set.
+func (cr *ChatRoom) RevokeShare(token, by string, admin bool) error {
+	cr.mutex.Lock()
+	s, ok := cr.shares[token]
+	switch {
+	case !ok:
+		cr.mutex.Unlock()
+		return errShareNotFound
+	case !admin && s.creator != by:
+		cr.mutex.Unlock()
+		return errNotCreator
+	}
+	s.revoked = true
+	cr.mutex.Unlock()
+	if admin {
+		cr.audit.add(AuditEntry{Action: "revoke_share", Actor: actorAdmin, Target: s.creator, Detail: s.messageID})
+	}
+	return nil
+}
+
+func (cr *ChatRoom) Shares(creator string) []ShareInfo {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	infos := []ShareInfo{}
+	for _, s := range cr.shares {
+		if s.creator == creator {
+			infos = append(infos, s.info())
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Expires.Before(infos[j].Expires) })
+	return infos
+}
+
+// Shared is the public view of a share link.
+type Shared struct {
+	Message Message   `json:"message"`
+	Before  []Message `json:"before,omitempty"`
+	After   []Message `json:"after,omitempty"`
+}
+
+// openShare counts an access to a share link and returns what it shows.
+// Links to messages no longer retained are gone like expired ones.
+func (cr *ChatRoom) openShare(token string) (Shared, error) {
+	cr.mutex.Lock()
+	s, ok := cr.shares[token]
+	if !ok {
+		cr.mutex.Unlock()
+		return Shared{}, errShareNotFound
+	}
+	if s.revoked || !cr.clock.Now().Before(s.expires) || !cr.shareLinks.Enabled {
+		cr.mutex.Unlock()
+		return Shared{}, errShareGone
+	}
+	s.accesses++
+	id, n := s.messageID, s.context
+	cr.mutex.Unlock()
+	msg, before, after, ok := cr.recent.around(id, n)
+	if !ok {
+		return Shared{}, errShareGone
+	}
+	return Shared{Message: msg, Before: before, After: after}, nil
+}
+
+// HandleShare serves POST /messages/{id}/share?expires=<duration>&context=<n>,
+// which creates a share link to a recent message.
+func (cr *ChatRoom) HandleShare(w http.ResponseWriter, r *http.Request) {
+	messageID, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/messages/"), "/")
+	if messageID == "" || action != "share" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	creator, ok := cr.sender(w, r)
+	if !ok {
+		return
+	}
+	d := min(defaultShareExpiry, cr.shareLinks.MaxExpiry)
+	if s := r.URL.Query().Get("expires"); s != "" {
+		var err error
+		if d, err = time.ParseDuration(s); err != nil || d <= 0 {
+			http.Error(w, "Invalid expires duration", http.StatusBadRequest)
+			return
+		}
+	}
+	if d > cr.shareLinks.MaxExpiry {
+		http.Error(w, "expires may be at most "+cr.shareLinks.MaxExpiry.String(), http.StatusBadRequest)
+		return
+	}
+	context := 0
+	if s := r.URL.Query().Get("context"); s != "" {
+		var err error
+		if context, err = strconv.Atoi(s); err != nil || context < 0 || context > maxShareContext {
+			http.Error(w, fmt.Sprintf("context must be between 0 and %d", maxShareContext), http.StatusBadRequest)
+			return
+		}
+	}
+	info, err := cr.Share(creator, messageID, context, d)
+	switch err {
+	case nil:
+	case errSharingDisabled:
+		http.Error(w, "Sharing is disabled on this server", http.StatusForbidden)
+		return
+	case errMessageNotFound:
+		http.Error(w, "Message not found or no longer retained", http.StatusNotFound)
+		return
+	case errTooManyShares:
+		w.Header().Set("Retry-After", retryAfterSeconds)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(info)
+}
+
+// HandleShared serves GET /shared/{token} to anyone with the link, and
+// DELETE /shared/{token} to its creator or an admin.
+func (cr *ChatRoom) HandleShared(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.URL.Path, "/shared/")
+	if token == "" || strings.Contains(token, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		shared, err := cr.openShare(token)
+		switch err {
+		case nil:
+		case errShareNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		default:
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(shared)
+	case http.MethodDelete:
+		by, admin := "", cr.isAdmin(r)
+		if !admin {
+			var ok bool
+			if by, ok = cr.sender(w, r); !ok {
+				return
+			}
+		}
+		switch err := cr.RevokeShare(token, by, admin); err {
+		case nil:
+			fmt.Fprintf(w, "Share link revoked")
+		case errShareNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			http.Error(w, "Only the link's creator or an admin can revoke it", http.StatusForbidden)
+		}
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleMyShares lists the caller's share links with their access counts.
+func (cr *ChatRoom) HandleMyShares(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	creator, ok := cr.sender(w, r)
+	if !ok {
+		return
+	}
+	p, err := parsePage(r, "shares")
+	if err != nil {
+		pageFailed(w, err)
+		return
+	}
+	infos := cr.Shares(creator)
+	if p.limit > 0 {
+		idx, next := p.page(len(infos), func(i int) string { return timeKey(infos[i].Expires, infos[i].Token) })
+		paged := make([]ShareInfo, 0, len(idx))
+		for _, i := range idx {
+			paged = append(paged, infos[i])
+		}
+		infos = paged
+		setNextCursor(w, next)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(infos)
+}
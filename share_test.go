@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"chatroom/testutil"
+)
+
+func shareLink(t *testing.T, h http.Handler, token, messageID, query string) ShareInfo {
+	t.Helper()
+	var info ShareInfo
+	w := do(h, "POST", "/messages/"+messageID+"/share?"+query, tokenHeader, token)
+	if w.Code != http.StatusCreated || json.Unmarshal(w.Body.Bytes(), &info) != nil {
+		t.Fatalf("share: %d %s", w.Code, w.Body)
+	}
+	return info
+}
+
+func TestShareLinksAreOffByDefault(t *testing.T) {
+	_, h := newTestRoom(t)
+	ta, tb := join(t, h, "alice"), join(t, h, "bob")
+	id := sentID(t, h, ta, "hello")
+	pollOnce(h, "bob", tb)
+	if w := do(h, "POST", "/messages/"+id+"/share", tokenHeader, ta); w.Code != http.StatusForbidden {
+		t.Errorf("share with sharing disabled: %d, want 403", w.Code)
+	}
+}
+
+// Anyone with the link sees the message and its context until the link
+// expires or its creator revokes it; the creator sees how often it was
+// opened.
+func TestShareLinksShowAMessageUntilExpiredOrRevoked(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	cr, h := newTestRoom(t, WithClock(clk), WithShareLinks(ShareLinks{Enabled: true, MaxExpiry: time.Hour}))
+	ta, tb := join(t, h, "alice"), join(t, h, "bob")
+	var ids []string
+	for _, body := range []string{"one", "two", "three"} {
+		ids = append(ids, sentID(t, h, ta, body))
+		pollOnce(h, "bob", tb) // Delivered, so retained in the recent messages
+	}
+
+	for query, want := range map[string]int{
+		"expires=2h":   http.StatusBadRequest,
+		"expires=-1m":  http.StatusBadRequest,
+		"context=21":   http.StatusBadRequest,
+		"context=many": http.StatusBadRequest,
+	} {
+		if w := do(h, "POST", "/messages/"+ids[1]+"/share?"+query, tokenHeader, ta); w.Code != want {
+			t.Errorf("share with %s: %d, want %d", query, w.Code, want)
+		}
+	}
+	if w := do(h, "POST", "/messages/"+cr.ids.NewID()+"/share", tokenHeader, ta); w.Code != http.StatusNotFound {
+		t.Errorf("share of an unknown message: %d, want 404", w.Code)
+	}
+
+	info := shareLink(t, h, ta, ids[1], "expires=10m&context=1")
+	if !info.Expires.Equal(clk.Now().Add(10 * time.Minute)) {
+		t.Errorf("link expires %s, want in 10m", info.Expires)
+	}
+	for i := 0; i < 2; i++ {
+		var shared Shared
+		w := do(h, "GET", info.URL)
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &shared) != nil {
+			t.Fatalf("open link: %d %s", w.Code, w.Body)
+		}
+		if shared.Message.Body != "two" || len(shared.Before) != 1 || shared.Before[0].Body != "one" || len(shared.After) != 1 || shared.After[0].Body != "three" {
+			t.Errorf("shared %+v, want two between one and three", shared)
+		}
+	}
+	var mine []ShareInfo
+	if w := do(h, "GET", "/me/shares", tokenHeader, ta); json.Unmarshal(w.Body.Bytes(), &mine) != nil || len(mine) != 1 || mine[0].Accesses != 2 {
+		t.Errorf("alice's shares: %d %s, want one opened twice", w.Code, w.Body)
+	}
+
+	if w := do(h, "DELETE", info.URL, tokenHeader, tb); w.Code != http.StatusForbidden {
+		t.Errorf("revoke by someone else: %d, want 403", w.Code)
+	}
+	if w := do(h, "DELETE", info.URL, tokenHeader, ta); w.Code != http.StatusOK {
+		t.Fatalf("revoke by the creator: %d %s", w.Code, w.Body)
+	}
+	if w := do(h, "GET", info.URL); w.Code != http.StatusGone {
+		t.Errorf("revoked link: %d, want 410", w.Code)
+	}
+
+	expiring := shareLink(t, h, ta, ids[0], "expires=10m")
+	clk.Advance(10 * time.Minute)
+	if w := do(h, "GET", expiring.URL); w.Code != http.StatusGone {
+		t.Errorf("expired link: %d, want 410", w.Code)
+	}
+	if w := do(h, "GET", "/shared/no-such-token"); w.Code != http.StatusNotFound {
+		t.Errorf("unknown link: %d, want 404", w.Code)
+	}
+
+	// Without expires, a link lasts the default or the maximum, if shorter.
+	moderated := shareLink(t, h, ta, ids[2], "")
+	if !moderated.Expires.Equal(clk.Now().Add(time.Hour)) {
+		t.Errorf("link without expires expires %s, want in the 1h maximum", moderated.Expires)
+	}
+	if w := do(h, "DELETE", moderated.URL, asAdmin...); w.Code != http.StatusOK {
+		t.Fatalf("revoke by an admin: %d %s", w.Code, w.Body)
+	}
+	if entries := cr.audit.list(); len(entries) == 0 || entries[len(entries)-1].Action != "revoke_share" {
+		t.Errorf("audit log %+v, want the admin's revoke", entries)
+	}
+}
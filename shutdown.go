@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Priorities of the built-in shutdown phases. Hooks run in ascending
+// priority; hooks other code registers slot in between.
+const (
+	shutdownJoins     = 10 // Stop accepting joins and sends
+	shutdownBroadcast = 20 // Deliver what is in the broadcast queue
+	shutdownPollers   = 30 // Let sessions collect their queued deliveries and notices
+	shutdownWebhooks  = 40 // Announce the shutdown and let webhook deliveries finish
+	shutdownRoom      = 50 // Close the room, ending long polls and streams
+	shutdownListeners = 60 // Stop the HTTP server once its requests finish
+
+	defaultShutdownDeadline = 5 * time.Second
+	shutdownPoll            = 10 * time.Millisecond
+)
+
+// ShutdownHook is one phase of Shutdown. Run should return when its work is
+// done or ctx expires, at the latest after Deadline.
+type ShutdownHook struct {
+	Name     string
+	Priority int
+	Deadline time.Duration
+	Run      func(ctx context.Context) error
+}
+
+// WithShutdownDeadlines overrides the deadlines of shutdown phases by name,
+// including phases registered after the room is created.
+func WithShutdownDeadlines(deadlines map[string]time.Duration) Option {
+	return func(cr *ChatRoom) {
+		for name, d := range deadlines {
+			if d > 0 {
+				cr.shutdownDeadlines[name] = d
+			}
+		}
+	}
+}
+
+// parseShutdownDeadlines reads "phase=duration,..." as for
+// -shutdown-deadlines.
+func parseShutdownDeadlines(s string) (map[string]time.Duration, error) {
+	deadlines := make(map[string]time.Duration)
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		name, v, ok := strings.Cut(part, "=")
+		d, err := time.ParseDuration(v)
+		if !ok || err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid shutdown deadline %q; want phase=duration", part)
+		}
+		deadlines[name] = d
+	}
+	return deadlines, nil
+}
+
+// OnShutdown registers a shutdown phase.
+func (cr *ChatRoom) OnShutdown(h ShutdownHook) {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	cr.shutdownHooks = append(cr.shutdownHooks, h)
+}
+
+// Shutdown runs the shutdown phases in priority order, each under its own
+// deadline, logging whether each completed or was cut short. A phase that
+// overruns is abandoned, not waited for, and the next one starts.
+func (cr *ChatRoom) Shutdown() {
+	cr.mutex.Lock()
+	hooks := append([]ShutdownHook(nil), cr.shutdownHooks...)
+	cr.mutex.Unlock()
+	sort.SliceStable(hooks, func(i, j int) bool { return hooks[i].Priority < hooks[j].Priority })
+	for _, h := range hooks {
+		deadline := h.Deadline
+		if d, ok := cr.shutdownDeadlines[h.Name]; ok {
+			deadline = d
+		}
+		if deadline <= 0 {
+			deadline = defaultShutdownDeadline
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), deadline)
+		start := cr.clock.Now()
+		done := make(chan error, 1)
+		go func(run func(context.Context) error) { done <- run(ctx) }(h.Run)
+		var err error
+		select {
+		case err = <-done:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		cancel()
+		took := cr.clock.Now().Sub(start).Round(time.Millisecond)
+		if err != nil {
+			log.Printf("shutdown: %s cut short after %s: %v", h.Name, took, err)
+		} else {
+			log.Printf("shutdown: %s completed in %s", h.Name, took)
+		}
+	}
+}
+
+// waitFor polls cond until it holds or ctx expires.
+func (cr *ChatRoom) waitFor(ctx context.Context, cond func() bool) error {
+	t := cr.clock.NewTicker(shutdownPoll)
+	defer t.Stop()
+	for !cond() {
+		select {
+		case <-t.C():
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// registerShutdownPhases registers the room's own phases. The HTTP
+// server's is registered by RunServer.
+func (cr *ChatRoom) registerShutdownPhases() {
+	cr.OnShutdown(ShutdownHook{Name: "joins", Priority: shutdownJoins, Run: func(context.Context) error {
+		cr.mutex.Lock()
+		cr.draining = true
+		cr.mutex.Unlock()
+		// Taking sendMu waits out sends already enqueueing, so everything
+		// accepted is in the queue the broadcast phase drains.
+		cr.sendMu.Lock()
+		cr.sendsStopped = true
+		cr.sendMu.Unlock()
+		return nil
+	}})
+	cr.OnShutdown(ShutdownHook{Name: "broadcast", Priority: shutdownBroadcast, Run: func(ctx context.Context) error {
+		return cr.waitFor(ctx, func() bool { return len(cr.broadcast) == 0 })
+	}})
+	cr.OnShutdown(ShutdownHook{Name: "pollers", Priority: shutdownPollers, Run: func(ctx context.Context) error {
+		// Sessions that never read again hold the phase to its deadline.
+		return cr.waitFor(ctx, cr.deliveriesCollected)
+	}})
+	cr.OnShutdown(ShutdownHook{Name: "webhooks", Priority: shutdownWebhooks, Run: func(ctx context.Context) error {
+		if url := cr.alerts.cfg.Webhook; url != "" {
+			payload, _ := json.Marshal(struct {
+				Event    string    `json:"event"`
+				Instance string    `json:"instance"`
+				Time     time.Time `json:"time"`
+			}{"shutdown", cr.instanceID, cr.clock.Now()})
+			if err := cr.postShutdown(ctx, url, payload); err != nil {
+				log.Printf("shutdown webhook %s: %v", url, err)
+			}
+		}
+		return cr.waitFor(ctx, func() bool {
+			live, _ := cr.workers.snapshot()
+			return live[workerWebhook] == 0
+		})
+	}})
+	cr.OnShutdown(ShutdownHook{Name: "room", Priority: shutdownRoom, Run: func(context.Context) error {
+		cr.Close()
+		return nil
+	}})
+}
+
+// deliveriesCollected reports whether every session has read what is
+// queued for it: its deliveries, and notices such as messages a stream
+// failed to write.
+func (cr *ChatRoom) deliveriesCollected() bool {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	for _, owner := range cr.clients {
+		for _, c := range append([]*client{owner}, owner.extra...) {
+			if c.queue.len() > 0 || len(c.notices) > 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// postShutdown tells the alert webhook this instance is going away. It is
+// sent once: there is no time left to retry or dead-letter it.
+func (cr *ChatRoom) postShutdown(ctx context.Context, url string, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := cr.webhookClient().Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A message accepted just before Shutdown reaches a client streaming
+// /messages and is kept in /events, and sends after the joins phase are
+// refused rather than lost when the room closes.
+func TestShutdownDeliversWhatItAccepted(t *testing.T) {
+	// Alice never polls, so the pollers phase gives up on her quickly.
+	cr, base := newLiveServer(t, WithPollWait(PollWait{Min: 20 * time.Millisecond, Preferred: time.Second, Max: time.Minute}),
+		WithShutdownDeadlines(map[string]time.Duration{"pollers": 100 * time.Millisecond}))
+	alice := &liveClient{base: base, id: "alice"}
+	bob := &liveClient{base: base, id: "bob"}
+	for _, c := range []*liveClient{alice, bob} {
+		resp, err := http.Post(base+"/join?id="+c.id, "", nil)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("join %s: %v %v", c.id, resp, err)
+		}
+		c.token = resp.Header.Get(tokenHeader)
+		resp.Body.Close()
+	}
+
+	q := url.Values{"stream": {"true"}, "wait": {"60"}, "format": {"json"}}
+	req, _ := http.NewRequest("GET", base+"/messages?"+q.Encode(), nil)
+	req.Header.Set(tokenHeader, bob.token)
+	stream, err := http.DefaultClient.Do(req)
+	if err != nil || stream.StatusCode != http.StatusOK {
+		t.Fatalf("bob's stream: %v %v", stream, err)
+	}
+	defer stream.Body.Close()
+	bodies := make(chan string)
+	go func() {
+		defer close(bodies)
+		lines := bufio.NewScanner(stream.Body)
+		for lines.Scan() {
+			var m Message
+			if json.Unmarshal(lines.Bytes(), &m) == nil {
+				bodies <- m.Body
+			}
+		}
+	}()
+
+	refused := make(chan error, 1)
+	cr.OnShutdown(ShutdownHook{Name: "late send", Priority: shutdownJoins + 1, Run: func(context.Context) error {
+		code, body, err := alice.send("too late")
+		if err == nil && code != http.StatusServiceUnavailable {
+			err = fmt.Errorf("/send gave %d %s", code, body)
+		}
+		if err == nil {
+			if perr := cr.Publish(Message{From: "alice", Body: "too late"}); perr != errShuttingDown {
+				err = fmt.Errorf("Publish gave %v, want %v", perr, errShuttingDown)
+			}
+		}
+		refused <- err
+		return nil
+	}})
+
+	if code, body, err := alice.send("last words"); err != nil || code != http.StatusOK {
+		t.Fatalf("send before shutdown: %d %s %v", code, body, err)
+	}
+	cr.Shutdown()
+
+	if err := <-refused; err != nil {
+		t.Errorf("a send after the joins phase was not refused: %v", err)
+	}
+	got := false
+	for body := range bodies {
+		if body == "too late" {
+			t.Error("bob was streamed a message sent after the joins phase")
+		}
+		got = got || body == "last words"
+	}
+	if !got {
+		t.Error("bob's stream ended without the message sent before shutdown")
+	}
+	page, err := cr.events.after(0, map[string]bool{eventMessage: true}, true, maxEventsLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := 0
+	for _, e := range page.Events {
+		if e.Message.Body == "last words" {
+			stored++
+		}
+	}
+	if stored != 1 {
+		t.Errorf("/events holds the message sent before shutdown %d times, want once", stored)
+	}
+}
+
+// The pollers phase waits for sessions to collect what is queued for them,
+// but no longer than its deadline.
+func TestShutdownWaitsForQueuedDeliveries(t *testing.T) {
+	cr, h := newTestRoom(t, WithShutdownDeadlines(map[string]time.Duration{"pollers": time.Minute}))
+	ta := join(t, h, "alice")
+	tb := join(t, h, "bob")
+	if w := send(h, ta, "queued"); w.Code != http.StatusOK {
+		t.Fatalf("send: %d %s", w.Code, w.Body)
+	}
+	eventually(t, "the deliveries to be queued", func() bool {
+		cr.mutex.Lock()
+		defer cr.mutex.Unlock()
+		return cr.clients["alice"].queue.len() > 0 && cr.clients["bob"].queue.len() > 0
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cr.Shutdown()
+	}()
+	eventually(t, "shutdown to reach the pollers phase", func() bool {
+		cr.mutex.Lock()
+		defer cr.mutex.Unlock()
+		return cr.draining
+	})
+	select {
+	case <-done:
+		t.Fatal("shutdown finished with deliveries still queued")
+	case <-time.After(100 * time.Millisecond):
+	}
+	for id, token := range map[string]string{"alice": ta, "bob": tb} {
+		if w := pollOnce(h, id, token); !strings.Contains(w.Body.String(), "alice: queued") {
+			t.Errorf("%s's poll during shutdown: %d %q", id, w.Code, w.Body)
+		}
+	}
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("shutdown still waiting after the deliveries were collected")
+	}
+}
+
+func TestShutdownStopsWaitingForIdleSessions(t *testing.T) {
+	cr, h := newTestRoom(t, WithShutdownDeadlines(map[string]time.Duration{"pollers": 50 * time.Millisecond}))
+	ta := join(t, h, "alice")
+	join(t, h, "bob")
+	if w := send(h, ta, "never read"); w.Code != http.StatusOK {
+		t.Fatalf("send: %d %s", w.Code, w.Body)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cr.Shutdown()
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("shutdown waited past the pollers deadline for a session that never polls")
+	}
+}
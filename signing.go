@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// signatureSkew is how far a signed message's timestamp may be from the
+// server clock, which bounds how long a captured signature can be replayed.
+const signatureSkew = 5 * time.Minute
+
+var (
+	errSignatureRequired = errors.New("sender has a registered key; sig and ts are required")
+	errBadSignature      = errors.New("signature does not verify")
+	errStaleSignature    = errors.New("signature timestamp is too far from server time")
+)
+
+// SetSigningKey registers or rotates the Ed25519 key clientID signs its
+// messages with. Once a sender has a key, its unsigned messages are rejected.
+func (cr *ChatRoom) SetSigningKey(clientID string, key ed25519.PublicKey, actor string) {
+	cr.mutex.Lock()
+	_, rotated := cr.signingKeys[clientID]
+	cr.signingKeys[clientID] = key
+	cr.mutex.Unlock()
+	action := "key_register"
+	if rotated {
+		action = "key_rotate"
+	}
+	cr.audit.add(AuditEntry{Action: action, Actor: actor, Target: clientID})
+}
+
+// RemoveSigningKey lets clientID send unsigned messages again.
+func (cr *ChatRoom) RemoveSigningKey(clientID, actor string) bool {
+	cr.mutex.Lock()
+	_, ok := cr.signingKeys[clientID]
+	delete(cr.signingKeys, clientID)
+	cr.mutex.Unlock()
+	if ok {
+		cr.audit.add(AuditEntry{Action: "key_remove", Actor: actor, Target: clientID})
+	}
+	return ok
+}
+
+// verifySignature checks the signature of body for senders with a key. It
+// returns whether the message is verified; senders without a key are never
+// verified and never fail. The signed payload is "<ts>\n<body>" with ts in
+// Unix seconds.
+func (cr *ChatRoom) verifySignature(clientID, body, sig, ts string) (bool, error) {
+	cr.mutex.Lock()
+	key, ok := cr.signingKeys[clientID]
+	cr.mutex.Unlock()
+	if !ok {
+		return false, nil
+	}
+	err := checkSignature(key, body, sig, ts, cr.clock.Now())
+	if err != nil {
+		cr.signatureFailures.Add(1)
+		cr.audit.add(AuditEntry{Action: "signature_failure", Actor: clientID, Target: clientID, Detail: err.Error()})
+		return false, err
+	}
+	return true, nil
+}
+
+func checkSignature(key ed25519.PublicKey, body, sig, ts string, now time.Time) error {
+	if sig == "" || ts == "" {
+		return errSignatureRequired
+	}
+	secs, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errStaleSignature
+	}
+	if d := now.Sub(time.Unix(secs, 0)); d > signatureSkew || d < -signatureSkew {
+		return errStaleSignature
+	}
+	raw, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil || !ed25519.Verify(key, []byte(ts+"\n"+body), raw) {
+		return errBadSignature
+	}
+	return nil
+}
+
+// HandleSigningKeys manages sender keys: GET lists senders with a key,
+// POST /admin/keys?id=<id>&key=<base64 public key> registers or rotates one
+// and DELETE /admin/keys?id=<id> removes it.
+func (cr *ChatRoom) HandleSigningKeys(w http.ResponseWriter, r *http.Request) {
+	clientID := r.URL.Query().Get("id")
+	switch r.Method {
+	case http.MethodGet:
+		cr.mutex.Lock()
+		ids := make([]string, 0, len(cr.signingKeys))
+		for id := range cr.signingKeys {
+			ids = append(ids, id)
+		}
+		cr.mutex.Unlock()
+		sort.Strings(ids)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ids)
+	case http.MethodPost:
+		if !validClientID(clientID) {
+			http.Error(w, errInvalidClientID.Error(), http.StatusBadRequest)
+			return
+		}
+		key, err := base64.StdEncoding.DecodeString(r.URL.Query().Get("key"))
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			http.Error(w, "key must be a base64 Ed25519 public key", http.StatusBadRequest)
+			return
+		}
+		cr.SetSigningKey(clientID, ed25519.PublicKey(key), actorAdmin)
+		fmt.Fprintf(w, "Signing key set for %s", clientID)
+	case http.MethodDelete:
+		if clientID == "" {
+			http.Error(w, "Client ID is required", http.StatusBadRequest)
+			return
+		}
+		if !cr.RemoveSigningKey(clientID, actorAdmin) {
+			http.Error(w, "No signing key for "+clientID, http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, "Signing key removed for %s", clientID)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
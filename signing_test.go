@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"chatroom/testutil"
+)
+
+// testKey derives a deterministic Ed25519 key from seed.
+func testKey(seed byte) ed25519.PrivateKey {
+	return ed25519.NewKeyFromSeed(bytes.Repeat([]byte{seed}, ed25519.SeedSize))
+}
+
+// signedSend sends body as token, signed by key at ts, and returns the
+// response and whether the delivered message is marked verified.
+func signedSend(t *testing.T, h http.Handler, token, body string, key ed25519.PrivateKey, ts time.Time) (int, bool) {
+	t.Helper()
+	q := url.Values{"format": {"json"}, "message": {body}}
+	if key != nil {
+		stamp := strconv.FormatInt(ts.Unix(), 10)
+		q.Set("ts", stamp)
+		q.Set("sig", base64.StdEncoding.EncodeToString(ed25519.Sign(key, []byte(stamp+"\n"+body))))
+	}
+	w := do(h, "POST", "/send?"+q.Encode(), tokenHeader, token)
+	var res SendResult
+	if w.Code == http.StatusOK && json.Unmarshal(w.Body.Bytes(), &res) != nil {
+		t.Fatalf("send: %s", w.Body)
+	}
+	return w.Code, res.Message.Verified
+}
+
+func registerKey(t *testing.T, h http.Handler, id string, key ed25519.PrivateKey) {
+	t.Helper()
+	pub := base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey))
+	if w := do(h, "POST", "/admin/keys?id="+id+"&key="+url.QueryEscape(pub), asAdmin...); w.Code != http.StatusOK {
+		t.Fatalf("register key: %d %s", w.Code, w.Body)
+	}
+}
+
+// Once a sender has a key, only messages it signed within the allowed skew
+// go out, marked verified; every failure is counted and audited.
+func TestKeyRegisteredSendersMustSign(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	cr, h := newTestRoom(t, WithClock(clk))
+	token := join(t, h, "bot")
+	key := testKey(1)
+	if code, verified := signedSend(t, h, token, "before any key", nil, time.Time{}); code != http.StatusOK || verified {
+		t.Errorf("unsigned send without a key: %d verified=%v, want 200 unverified", code, verified)
+	}
+	if w := do(h, "POST", "/admin/keys?id=bot&key=AAAA", asAdmin...); w.Code != http.StatusBadRequest {
+		t.Errorf("registering a malformed key: %d, want 400", w.Code)
+	}
+	registerKey(t, h, "bot", key)
+
+	if code, verified := signedSend(t, h, token, "deploy done", key, clk.Now()); code != http.StatusOK || !verified {
+		t.Errorf("signed send: %d verified=%v, want 200 verified", code, verified)
+	}
+	for name, send := range map[string]func() (int, bool){
+		"unsigned":  func() (int, bool) { return signedSend(t, h, token, "spoof", nil, time.Time{}) },
+		"wrong key": func() (int, bool) { return signedSend(t, h, token, "spoof", testKey(2), clk.Now()) },
+		"stale": func() (int, bool) {
+			return signedSend(t, h, token, "replay", key, clk.Now().Add(-signatureSkew-time.Second))
+		},
+		"from the future": func() (int, bool) {
+			return signedSend(t, h, token, "early", key, clk.Now().Add(signatureSkew+time.Second))
+		},
+	} {
+		if code, _ := send(); code != http.StatusUnauthorized {
+			t.Errorf("%s send: %d, want 401", name, code)
+		}
+	}
+	if n := cr.Stats().SignatureFailures; n != 4 {
+		t.Errorf("%d signature failures in /stats, want 4", n)
+	}
+	failures := 0
+	for _, e := range cr.audit.list() {
+		if e.Action == "signature_failure" && e.Target == "bot" {
+			failures++
+		}
+	}
+	if failures != 4 {
+		t.Errorf("%d signature failures audited, want 4", failures)
+	}
+
+	// The skew is measured against the room's clock.
+	signedAt := clk.Now()
+	clk.Advance(signatureSkew)
+	if code, _ := signedSend(t, h, token, "just in time", key, signedAt); code != http.StatusOK {
+		t.Errorf("send signed at the edge of the skew: %d, want 200", code)
+	}
+}
+
+func TestSigningKeysRotateAndAreRemoved(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	cr, h := newTestRoom(t, WithClock(clk))
+	token := join(t, h, "bot")
+	old, rotated := testKey(1), testKey(2)
+	if w := do(h, "POST", "/admin/keys?id=bot&key=x", tokenHeader, token); w.Code == http.StatusOK {
+		t.Error("a client registered a key without admin credentials")
+	}
+	registerKey(t, h, "bot", old)
+	registerKey(t, h, "bot", rotated)
+	if code, _ := signedSend(t, h, token, "old key", old, clk.Now()); code != http.StatusUnauthorized {
+		t.Errorf("send signed with the rotated-out key: %d, want 401", code)
+	}
+	if code, verified := signedSend(t, h, token, "new key", rotated, clk.Now()); code != http.StatusOK || !verified {
+		t.Errorf("send signed with the new key: %d verified=%v", code, verified)
+	}
+	var ids []string
+	if w := do(h, "GET", "/admin/keys", asAdmin...); json.Unmarshal(w.Body.Bytes(), &ids) != nil || len(ids) != 1 || ids[0] != "bot" {
+		t.Errorf("listed keys: %d %s", w.Code, w.Body)
+	}
+
+	if w := do(h, "DELETE", "/admin/keys?id=bot", asAdmin...); w.Code != http.StatusOK {
+		t.Fatalf("remove key: %d %s", w.Code, w.Body)
+	}
+	if code, verified := signedSend(t, h, token, "plain again", nil, time.Time{}); code != http.StatusOK || verified {
+		t.Errorf("unsigned send after the key was removed: %d verified=%v", code, verified)
+	}
+	var actions []string
+	for _, e := range cr.audit.list() {
+		actions = append(actions, e.Action)
+	}
+	want := []string{"key_register", "key_rotate", "signature_failure", "key_remove"}
+	if len(actions) != len(want) {
+		t.Fatalf("audit actions %v, want %v", actions, want)
+	}
+	for i := range want {
+		if actions[i] != want[i] {
+			t.Errorf("audit actions %v, want %v", actions, want)
+			break
+		}
+	}
+}
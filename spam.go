@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// SpamConfig controls automatic muting of clients that repeat themselves.
+// A client that sends the same body Repeats times within Window is muted for
+// MuteFor. Auto-mutes only ever expire or get lifted; they never escalate.
+// Detection is off unless enabled with WithSpamDetection or -spam-detection.
+type SpamConfig struct {
+	Enabled bool
+	Repeats int
+	Window  time.Duration
+	MuteFor time.Duration
+}
+
+var defaultSpamConfig = SpamConfig{
+	Enabled: false,
+	Repeats: 5,
+	Window:  30 * time.Second,
+	MuteFor: 2 * time.Minute,
+}
+
+// WithSpamDetection replaces the default spam detector settings.
+func WithSpamDetection(cfg SpamConfig) Option {
+	return func(cr *ChatRoom) {
+		if cfg.Repeats < 2 || cfg.Window <= 0 || cfg.MuteFor <= 0 {
+			cfg.Enabled = false
+		}
+		cr.spam = cfg
+	}
+}
+
+type sentBody struct {
+	body string
+	at   time.Time
+}
+
+// checkSpam records that clientID sent body and auto-mutes it when the body
+// has now been repeated too often. It reports whether the send was spam.
+func (cr *ChatRoom) checkSpam(clientID, body string) bool {
+	if !cr.spam.Enabled {
+		return false
+	}
+	now := cr.clock.Now()
+	cutoff := now.Add(-cr.spam.Window)
+
+	cr.mutex.Lock()
+	recent := cr.recentBodies[clientID][:0]
+	for _, s := range cr.recentBodies[clientID] {
+		if s.at.After(cutoff) {
+			recent = append(recent, s)
+		}
+	}
+	recent = append(recent, sentBody{body: body, at: now})
+	// Keep only as many entries as can matter for the threshold.
+	if len(recent) > 2*cr.spam.Repeats {
+		recent = recent[len(recent)-2*cr.spam.Repeats:]
+	}
+	repeats := 0
+	for _, s := range recent {
+		if s.body == body {
+			repeats++
+		}
+	}
+	spam := repeats >= cr.spam.Repeats
+	if spam {
+		delete(cr.recentBodies, clientID)
+	} else {
+		cr.recentBodies[clientID] = recent
+	}
+	cr.mutex.Unlock()
+
+	if spam {
+		reason := fmt.Sprintf("repeated the same message %d times within %s", repeats, cr.spam.Window)
+		cr.Mute(clientID, cr.spam.MuteFor, reason, "spam-detector", true)
+		cr.SendEphemeral(clientID, Message{Body: fmt.Sprintf("You have been muted for %s: %s", cr.spam.MuteFor, reason)})
+	}
+	return spam
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSpamDetectionIsOffByDefault(t *testing.T) {
+	_, h := newTestRoom(t)
+	token := join(t, h, "alice")
+	for i := 0; i < 2*defaultSpamConfig.Repeats; i++ {
+		if w := send(h, token, "same again"); w.Code != http.StatusOK {
+			t.Fatalf("send %d: %d %s", i, w.Code, w.Body)
+		}
+	}
+}
+
+func TestSpamDetectionMutesRepeats(t *testing.T) {
+	cfg := defaultSpamConfig
+	cfg.Enabled = true
+	cr, h := newTestRoom(t, WithSpamDetection(cfg))
+	token := join(t, h, "alice")
+	for i := 1; i < cfg.Repeats; i++ {
+		if w := send(h, token, "same again"); w.Code != http.StatusOK {
+			t.Fatalf("send %d: %d %s", i, w.Code, w.Body)
+		}
+	}
+	if w := send(h, token, "same again"); w.Code != http.StatusForbidden {
+		t.Fatalf("repeat %d: got %d %s, want %d", cfg.Repeats, w.Code, w.Body, http.StatusForbidden)
+	}
+	cr.mutex.Lock()
+	_, muted := cr.mutedUntilLocked("alice")
+	cr.mutex.Unlock()
+	if !muted {
+		t.Error("alice was not muted")
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+const (
+	roleMember    = "member"
+	roleSpectator = "spectator"
+)
+
+var errClientNotFound = errors.New("client not found")
+
+// WithWatchOnly makes the room watch-only: a join without admin credentials
+// always gets the spectator role, whatever it asked for.
+func WithWatchOnly(watchOnly bool) Option {
+	return func(cr *ChatRoom) {
+		cr.watchOnly = watchOnly
+	}
+}
+
+// Promote turns a spectator into a member. The client keeps its registration
+// and pending poll, so it does not need to reconnect.
+func (cr *ChatRoom) Promote(clientID string) error {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	c, exists := cr.clients[clientID]
+	if !exists {
+		return errClientNotFound
+	}
+	c.role = roleMember
+	cr.rosterChangedLocked()
+	return nil
+}
+
+func (cr *ChatRoom) HandlePromote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID := r.URL.Query().Get("id")
+	if clientID == "" {
+		http.Error(w, "Client ID is required", http.StatusBadRequest)
+		return
+	}
+	if err := cr.Promote(clientID); err != nil {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+	fmt.Fprintf(w, "Client %s promoted to member", clientID)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"chatroom/testutil"
+)
+
+// joinAs joins id with the given role and returns its send token.
+func joinAs(t *testing.T, h http.Handler, id, role string, headers ...string) string {
+	t.Helper()
+	w := do(h, "POST", "/join?id="+id+"&role="+role, headers...)
+	if w.Code != http.StatusOK {
+		t.Fatalf("join %s as %s: %d %s", id, role, w.Code, w.Body)
+	}
+	return w.Header().Get(tokenHeader)
+}
+
+// roles lists /clients by role.
+func roles(t *testing.T, h http.Handler) map[string]string {
+	t.Helper()
+	var list ClientList
+	if w := do(h, "GET", "/clients"); w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &list) != nil {
+		t.Fatalf("clients: %d %s", w.Code, w.Body)
+	}
+	got := make(map[string]string)
+	for _, c := range list.Members {
+		got[c.ID] = roleMember
+	}
+	for _, c := range list.Spectators {
+		got[c.ID] = roleSpectator
+	}
+	return got
+}
+
+// A spectator receives but cannot send until promoted, and a promotion
+// reaches the poll it already has open.
+func TestSpectatorsReceiveAndArePromotedInPlace(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	cr, h := newTestRoom(t, WithClock(clk))
+	ta := join(t, h, "alice")
+	tc := joinAs(t, h, "carol", roleSpectator)
+
+	if w := send(h, tc, "let me in"); w.Code != http.StatusForbidden {
+		t.Errorf("spectator send: %d %s, want 403", w.Code, w.Body)
+	}
+	if got := roles(t, h); got["alice"] != roleMember || got["carol"] != roleSpectator {
+		t.Errorf("/clients roles: %v, want alice a member and carol a spectator", got)
+	}
+	if s := cr.Stats(); s.Clients != 2 || s.Spectators != 1 {
+		t.Errorf("stats: %d clients, %d spectators, want 2 and 1", s.Clients, s.Spectators)
+	}
+
+	done := pollAsync(t, clk, h, "carol", tc, "30")
+	if w := do(h, "POST", "/admin/promote?id=carol", asAdmin...); w.Code != http.StatusOK {
+		t.Fatalf("promote: %d %s", w.Code, w.Body)
+	}
+	send(h, ta, "welcome aboard")
+	if w := <-done; w.Body.String() != "alice: welcome aboard\n" {
+		t.Errorf("carol's poll across the promotion: %d %q", w.Code, w.Body)
+	}
+	if w := send(h, tc, "thanks"); w.Code != http.StatusOK {
+		t.Errorf("send after promotion: %d %s", w.Code, w.Body)
+	}
+	if got := roles(t, h); got["carol"] != roleMember {
+		t.Errorf("carol is listed as %q after promotion, want member", got["carol"])
+	}
+	if w := do(h, "POST", "/admin/promote?id=nobody", asAdmin...); w.Code != http.StatusNotFound {
+		t.Errorf("promoting an unknown client: %d, want 404", w.Code)
+	}
+}
+
+// In a watch-only room every join without admin credentials is a
+// spectator, even one asking to be a member.
+func TestWatchOnlyRoomsMakeSpectators(t *testing.T) {
+	_, h := newTestRoom(t, WithWatchOnly(true))
+	join(t, h, "alice")
+	joinAs(t, h, "bob", roleMember)
+	joinAs(t, h, "operator", roleMember, asAdmin...)
+	want := map[string]string{"alice": roleSpectator, "bob": roleSpectator, "operator": roleMember}
+	got := roles(t, h)
+	for id, role := range want {
+		if got[id] != role {
+			t.Errorf("%s joined a watch-only room as %q, want %q", id, got[id], role)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultStreamMessages = 100
+	maxStreamMessages     = 1000
+)
+
+// streamLimit reads max= for a streamed poll, reporting false when it is
+// not a positive number. Larger values are clamped to maxStreamMessages.
+func streamLimit(r *http.Request) (int, bool) {
+	s := r.URL.Query().Get("max")
+	if s == "" {
+		return defaultStreamMessages, true
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return 0, false
+	}
+	return min(n, maxStreamMessages), true
+}
+
+// streamMessages answers GET /messages?stream=true: the response stays open
+// for the whole wait and each message is written as one line of JSON and
+// flushed as it arrives. The stream ends when the wait elapses, after limit
+// messages, or after a message that ends the session.
+//
+// A message is taken from the session's queue only once the previous one
+// has been written. If the client goes away, or writing a message fails,
+// that message is put back at the front of the session's notices, so the
+// next poll delivers it and nothing is lost beyond what the connection had
+// already accepted.
+func (cr *ChatRoom) streamMessages(w http.ResponseWriter, r *http.Request, owner, c *client, wait time.Duration, limit int) {
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	write := func(msg Message, b []byte) bool {
+		if b == nil {
+			return true
+		}
+		if _, err := w.Write(b); err != nil || rc.Flush() != nil {
+			cr.requeueNotice(c, msg)
+			return false
+		}
+		return true
+	}
+
+	sent := 0
+	for _, notice := range []func() (Message, bool){
+		func() (Message, bool) { return cr.pendingNotice(owner) },
+		func() (Message, bool) { return cr.welcomeNotice(owner, c) },
+	} {
+		if msg, ok := notice(); ok {
+			if !write(msg, msg.jsonLine()) {
+				return
+			}
+			sent++
+		}
+	}
+	timeout := cr.clock.After(wait)
+	for sent < limit {
+		if msg, ok := cr.keywordNotice(c); ok {
+			if !write(msg, msg.jsonLine()) {
+				return
+			}
+			sent++
+			continue
+		}
+		msg, b, res := cr.next(owner, c, frameStream, Message.jsonLine, timeout, r.Context().Done())
+		if res != nextMessage {
+			return
+		}
+		if r.Context().Err() != nil {
+			cr.requeueNotice(c, msg)
+			return
+		}
+		if !write(msg, b) {
+			return
+		}
+		cr.recordDelivery(msg)
+		sent++
+		if msg.Type == messageTypeReplaced || msg.Type == messageTypeRevoked {
+			return
+		}
+	}
+}
+
+// requeueNotice puts back a message a stream took from c but could not
+// write, ahead of anything else it has waiting.
+func (cr *ChatRoom) requeueNotice(c *client, msg Message) {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	c.notices = append([]Message{msg}, c.notices...)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	summaryTimeout   = 10 * time.Second
+	summaryCacheSize = 100
+)
+
+// Summarizer turns a run of chat messages, oldest first, into a text
+// summary. Deployments can plug in an LLM-backed implementation; the default
+// is StatsSummarizer.
+type Summarizer interface {
+	Summarize(ctx context.Context, msgs []Message) (string, error)
+}
+
+// WithSummarizer replaces the built-in StatsSummarizer.
+func WithSummarizer(s Summarizer) Option {
+	return func(cr *ChatRoom) {
+		if s != nil {
+			cr.summarizer = s
+		}
+	}
+}
+
+// StatsSummarizer reports the message count, the most active senders and
+// the time span covered.
+type StatsSummarizer struct{}
+
+func (StatsSummarizer) Summarize(_ context.Context, msgs []Message) (string, error) {
+	if len(msgs) == 0 {
+		return "No messages.", nil
+	}
+	counts := make(map[string]int)
+	for _, m := range msgs {
+		counts[m.From]++
+	}
+	senders := make([]string, 0, len(counts))
+	for from := range counts {
+		senders = append(senders, from)
+	}
+	sort.Slice(senders, func(i, j int) bool {
+		if counts[senders[i]] != counts[senders[j]] {
+			return counts[senders[i]] > counts[senders[j]]
+		}
+		return senders[i] < senders[j]
+	})
+	if len(senders) > 3 {
+		senders = senders[:3]
+	}
+	top := make([]string, len(senders))
+	for i, from := range senders {
+		top[i] = fmt.Sprintf("%s (%d)", from, counts[from])
+	}
+	return fmt.Sprintf("%d messages from %d senders between %s and %s. Most active: %s.",
+		len(msgs), len(counts),
+		msgs[0].Time.UTC().Format(time.RFC3339), msgs[len(msgs)-1].Time.UTC().Format(time.RFC3339),
+		strings.Join(top, ", ")), nil
+}
+
+// Summary is a stored summary of the chat messages after Since up to and
+// including Until (both message IDs; Since is empty for "from the start").
+type Summary struct {
+	Since    string    `json:"since,omitempty"`
+	Until    string    `json:"until"`
+	Messages int       `json:"messages"`
+	Text     string    `json:"text"`
+	Created  time.Time `json:"created"`
+}
+
+// summaryCache keeps recent summaries by the message range they cover, so
+// asking again for the same range does not call the summarizer.
+type summaryCache struct {
+	mu      sync.Mutex
+	byRange map[string]Summary
+	order   []string // Insertion order, for eviction
+}
+
+func (sc *summaryCache) get(key string) (Summary, bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	s, ok := sc.byRange[key]
+	return s, ok
+}
+
+func (sc *summaryCache) put(key string, s Summary) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.byRange == nil {
+		sc.byRange = make(map[string]Summary)
+	}
+	if _, ok := sc.byRange[key]; ok {
+		return
+	}
+	if len(sc.order) == summaryCacheSize {
+		delete(sc.byRange, sc.order[0])
+		sc.order = sc.order[1:]
+	}
+	sc.byRange[key] = s
+	sc.order = append(sc.order, key)
+}
+
+// Summarize summarizes the retained chat messages after the message with ID
+// since. It fails with errMessageNotFound once since has aged out of the
+// retained messages.
+func (cr *ChatRoom) Summarize(ctx context.Context, since string) (Summary, error) {
+	msgs, ok := cr.recent.since(since)
+	if !ok {
+		return Summary{}, errMessageNotFound
+	}
+	until := since
+	if len(msgs) > 0 {
+		until = msgs[len(msgs)-1].ID
+	}
+	key := since + ".." + until
+	if s, ok := cr.summaries.get(key); ok {
+		return s, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, summaryTimeout)
+	defer cancel()
+	text, err := cr.summarizer.Summarize(ctx, msgs)
+	if err != nil {
+		return Summary{}, err
+	}
+	s := Summary{Since: since, Until: until, Messages: len(msgs), Text: text, Created: cr.clock.Now()}
+	cr.summaries.put(key, s)
+	return s, nil
+}
+
+// HandleSummary serves GET /summary[?since=<message ID>].
+func (cr *ChatRoom) HandleSummary(w http.ResponseWriter, r *http.Request) {
+	s, err := cr.Summarize(r.Context(), r.URL.Query().Get("since"))
+	switch err {
+	case nil:
+	case errMessageNotFound:
+		http.Error(w, "Message is no longer retained", http.StatusNotFound)
+		return
+	default:
+		http.Error(w, "Summarizer failed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
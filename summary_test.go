@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"chatroom/testutil"
+)
+
+func TestStatsSummarizerCountsSendersAndSpan(t *testing.T) {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	var msgs []Message
+	for i, from := range []string{"alice", "bob", "alice", "carol", "dave", "alice", "bob"} {
+		msgs = append(msgs, Message{From: from, Body: "hi", Time: start.Add(time.Duration(i) * time.Minute)})
+	}
+	got, err := StatsSummarizer{}.Summarize(context.Background(), msgs)
+	want := "7 messages from 4 senders between 2026-01-02T03:04:05Z and 2026-01-02T03:10:05Z. Most active: alice (3), bob (2), carol (1)."
+	if err != nil || got != want {
+		t.Errorf("summary %q, %v\nwant %q", got, err, want)
+	}
+	if got, _ := (StatsSummarizer{}).Summarize(context.Background(), nil); got != "No messages." {
+		t.Errorf("summary of nothing: %q", got)
+	}
+}
+
+// countingSummarizer counts its calls and fails while err is set.
+type countingSummarizer struct {
+	calls atomic.Int32
+	err   error
+}
+
+func (s *countingSummarizer) Summarize(context.Context, []Message) (string, error) {
+	s.calls.Add(1)
+	return "summary", s.err
+}
+
+// A plugged-in summarizer is called once per message range; asking for the
+// same range again is served from the cache.
+func TestSummariesAreCachedByRange(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	summarizer := &countingSummarizer{}
+	cr, h := newTestRoom(t, WithClock(clk), WithSummarizer(summarizer))
+	token := join(t, h, "alice")
+	var ids []string
+	for _, body := range []string{"one", "two", "three"} {
+		id := sentID(t, h, token, body)
+		eventually(t, "the message to be retained", func() bool {
+			_, ok := cr.recent.get(id)
+			return ok
+		})
+		ids = append(ids, id)
+	}
+	summary := func(query string) (Summary, int) {
+		t.Helper()
+		var s Summary
+		w := do(h, "GET", "/summary"+query)
+		if w.Code == http.StatusOK && json.Unmarshal(w.Body.Bytes(), &s) != nil {
+			t.Fatalf("summary%s: %s", query, w.Body)
+		}
+		return s, w.Code
+	}
+
+	first, _ := summary("")
+	if first.Messages != 3 || first.Until != ids[2] || first.Text != "summary" || !first.Created.Equal(clk.Now()) {
+		t.Errorf("summary of everything: %+v", first)
+	}
+	clk.Advance(time.Minute)
+	if again, _ := summary(""); again != first || summarizer.calls.Load() != 1 {
+		t.Errorf("repeated summary: %+v after %d calls, want the cached one after 1", again, summarizer.calls.Load())
+	}
+	if s, _ := summary("?since=" + ids[0]); s.Since != ids[0] || s.Messages != 2 || summarizer.calls.Load() != 2 {
+		t.Errorf("summary since the first message: %+v after %d calls", s, summarizer.calls.Load())
+	}
+	if _, code := summary("?since=long-gone"); code != http.StatusNotFound {
+		t.Errorf("summary since an unknown message: %d, want 404", code)
+	}
+	summarizer.err = errors.New("model unavailable")
+	if _, code := summary("?since=" + ids[1]); code != http.StatusBadGateway {
+		t.Errorf("summary with a failing summarizer: %d, want 502", code)
+	}
+}
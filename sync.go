@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultSyncBudget = 200
+	maxSyncBudget     = 1000
+)
+
+// SyncGap describes messages /sync left out: the Count messages with event
+// seqs above After and below Before. /events?cursor=<After>&types=message
+// fetches them, up to Before.
+type SyncGap struct {
+	After  uint64 `json:"after"`
+	Before uint64 `json:"before"`
+	Count  int    `json:"count"`
+}
+
+// Sync is the body of /sync.
+type Sync struct {
+	Messages []Event  `json:"messages"`      // The newest messages after the cursor, oldest first
+	Gap      *SyncGap `json:"gap,omitempty"` // Set when older messages were left out
+	Presence []Event  `json:"presence"`      // Each client's latest join or leave after the cursor
+	Cursor   uint64   `json:"cursor"`        // Pass as cursor= on the next /sync or /events
+}
+
+// sync catches a client up from cursor with at most budget messages: the
+// newest ones, and a gap for the rest. Presence is collapsed to each
+// client's latest join or leave, since the ones before it no longer
+// matter. Only the events' headers are scanned for the gap; the skipped
+// messages are never copied.
+func (l *eventLog) sync(cursor uint64, budget int, admin bool) (Sync, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := Sync{Messages: []Event{}, Presence: []Event{}, Cursor: l.seq}
+	if cursor >= l.seq {
+		return s, nil
+	}
+	if l.seq > maxEvents && cursor < l.seq-maxEvents {
+		return s, errCursorExpired
+	}
+	latest := make(map[string]int) // Client ID to index in s.Presence
+	var messages []uint64
+	for seq := cursor + 1; seq <= l.seq; seq++ {
+		e := &l.ring[seq%maxEvents]
+		switch {
+		case e.hidden && !admin:
+		case e.Type == eventJoin || e.Type == eventLeave:
+			if i, ok := latest[e.ClientID]; ok {
+				s.Presence[i] = *e
+			} else {
+				latest[e.ClientID] = len(s.Presence)
+				s.Presence = append(s.Presence, *e)
+			}
+		case e.Message != nil:
+			messages = append(messages, seq)
+		}
+	}
+	if skipped := len(messages) - budget; skipped > 0 {
+		s.Gap = &SyncGap{After: cursor, Before: messages[skipped], Count: skipped}
+		messages = messages[skipped:]
+	}
+	for _, seq := range messages {
+		s.Messages = append(s.Messages, l.ring[seq%maxEvents])
+	}
+	return s, nil
+}
+
+// HandleSync serves GET /sync?cursor=<seq>&budget=<n> for a client coming
+// back after a long time away: the newest budget messages (default 200),
+// a gap marker for those left out, and the net presence changes, in one
+// response. Callers are authenticated as for /events.
+func (cr *ChatRoom) HandleSync(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	admin := cr.isAdmin(r)
+	if !admin && !cr.joinedCaller(r) {
+		http.Error(w, "Syncing requires the "+tokenHeader+" header of a joined client", http.StatusForbidden)
+		return
+	}
+	q := r.URL.Query()
+	cursor, err := strconv.ParseUint(q.Get("cursor"), 10, 64)
+	if err != nil {
+		http.Error(w, "cursor must be the seq of an event", http.StatusBadRequest)
+		return
+	}
+	budget := defaultSyncBudget
+	if s := q.Get("budget"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			http.Error(w, "budget must be a number of messages", http.StatusBadRequest)
+			return
+		}
+		budget = min(n, maxSyncBudget)
+	}
+	s, err := cr.events.sync(cursor, budget, admin)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
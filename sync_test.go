@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"chatroom/testutil"
+)
+
+func syncFrom(t *testing.T, h http.Handler, token string, cursor uint64, budget string) Sync {
+	t.Helper()
+	var s Sync
+	w := do(h, "GET", fmt.Sprintf("/sync?cursor=%d&budget=%s", cursor, budget), tokenHeader, token)
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &s) != nil {
+		t.Fatalf("sync: %d %s", w.Code, w.Body)
+	}
+	return s
+}
+
+// /sync returns the newest messages within the budget, a gap that
+// /events fills exactly, and each client's net presence change.
+func TestSyncLeavesAGapThatEventsFill(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	_, h := newTestRoom(t, WithClock(clk))
+	ta := join(t, h, "alice")
+	cursor := syncFrom(t, h, ta, 0, "").Cursor
+
+	tc := join(t, h, "carol")
+	do(h, "POST", "/leave", tokenHeader, tc)
+	join(t, h, "carol")
+	for i := 1; i <= 5; i++ {
+		send(h, ta, fmt.Sprintf("message %d", i))
+	}
+	var s Sync
+	eventually(t, "the messages to be logged", func() bool {
+		s = syncFrom(t, h, ta, cursor, "2")
+		return len(s.Messages) == 2 && s.Messages[1].Message.Body == "message 5"
+	})
+	if s.Messages[0].Message.Body != "message 4" {
+		t.Errorf("synced %q, want the newest two", s.Messages[0].Message.Body)
+	}
+	if s.Gap == nil || s.Gap.Count != 3 || s.Gap.After != cursor || s.Gap.Before != s.Messages[0].Seq {
+		t.Fatalf("gap %+v, want the 3 older messages before seq %d", s.Gap, s.Messages[0].Seq)
+	}
+	if len(s.Presence) != 1 || s.Presence[0].ClientID != "carol" || s.Presence[0].Type != eventJoin {
+		t.Errorf("presence %+v, want carol's latest join only", s.Presence)
+	}
+	if s.Cursor != s.Messages[1].Seq {
+		t.Errorf("cursor %d, want the last message's seq %d", s.Cursor, s.Messages[1].Seq)
+	}
+
+	var page EventPage
+	w := do(h, "GET", fmt.Sprintf("/events?cursor=%d&types=message", s.Gap.After), tokenHeader, ta)
+	if json.Unmarshal(w.Body.Bytes(), &page) != nil {
+		t.Fatalf("events: %d %s", w.Code, w.Body)
+	}
+	var filled []string
+	for _, e := range page.Events {
+		if e.Seq < s.Gap.Before {
+			filled = append(filled, e.Message.Body)
+		}
+	}
+	if fmt.Sprint(filled) != "[message 1 message 2 message 3]" {
+		t.Errorf("gap filled with %q", filled)
+	}
+
+	if s := syncFrom(t, h, ta, s.Cursor, ""); len(s.Messages) != 0 || s.Gap != nil {
+		t.Errorf("sync from the latest cursor %+v, want nothing new", s)
+	}
+}
+
+func TestSyncRequiresAJoinedClient(t *testing.T) {
+	_, h := newTestRoom(t)
+	token := join(t, h, "alice")
+	if w := do(h, "GET", "/sync?cursor=0"); w.Code != http.StatusForbidden {
+		t.Errorf("sync without a token: %d, want 403", w.Code)
+	}
+	for _, q := range []string{"cursor=latest", "cursor=0&budget=-1", "cursor=0&budget=all"} {
+		if w := do(h, "GET", "/sync?"+q, tokenHeader, token); w.Code != http.StatusBadRequest {
+			t.Errorf("sync with %s: %d, want 400", q, w.Code)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// Package testutil holds helpers for tests of the chat server.
+package testutil
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"chatroom/clock"
+)
+
+// FakeClock is a clock.Clock whose time only moves when Advance is called.
+// Timers, tickers and AfterFunc callbacks due by the new time fire during
+// Advance, in time order, so tests never sleep.
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+var _ clock.Clock = (*FakeClock)(nil)
+
+// NewFakeClock returns a fake clock reading start.
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+func (f *FakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *FakeClock) After(d time.Duration) <-chan time.Time {
+	return f.NewTimer(d).C()
+}
+
+func (f *FakeClock) NewTimer(d time.Duration) clock.Timer {
+	return f.add(d, 0, nil)
+}
+
+func (f *FakeClock) AfterFunc(d time.Duration, fn func()) clock.Timer {
+	return f.add(d, 0, fn)
+}
+
+func (f *FakeClock) NewTicker(d time.Duration) clock.Ticker {
+	if d <= 0 {
+		panic("testutil: non-positive interval for NewTicker")
+	}
+	return fakeTicker{f.add(d, d, nil)}
+}
+
+// Waiters reports how many timers and tickers are armed, so a test can wait
+// until the code under test has started waiting before advancing.
+func (f *FakeClock) Waiters() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.timers)
+}
+
+// Advance moves the clock forward by d, firing everything that falls due.
+func (f *FakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	target := f.now.Add(d)
+	for {
+		sort.Slice(f.timers, func(i, j int) bool { return f.timers[i].when.Before(f.timers[j].when) })
+		if len(f.timers) == 0 || f.timers[0].when.After(target) {
+			break
+		}
+		t := f.timers[0]
+		f.now = t.when
+		if t.period > 0 {
+			t.when = t.when.Add(t.period)
+		} else {
+			f.timers = f.timers[1:]
+		}
+		if t.fn != nil {
+			f.mu.Unlock()
+			t.fn()
+			f.mu.Lock()
+			continue
+		}
+		select {
+		case t.c <- f.now:
+		default: // Like a real ticker, drop ticks nobody is reading.
+		}
+	}
+	f.now = target
+	f.mu.Unlock()
+}
+
+func (f *FakeClock) add(d, period time.Duration, fn func()) *fakeTimer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t := &fakeTimer{clock: f, when: f.now.Add(d), period: period, fn: fn}
+	if fn == nil {
+		t.c = make(chan time.Time, 1)
+	}
+	f.timers = append(f.timers, t)
+	return t
+}
+
+func (f *FakeClock) remove(t *fakeTimer) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, x := range f.timers {
+		if x == t {
+			f.timers = append(f.timers[:i], f.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTimer struct {
+	clock  *FakeClock
+	when   time.Time
+	period time.Duration
+	fn     func()
+	c      chan time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+func (t *fakeTimer) Stop() bool          { return t.clock.remove(t) }
+
+type fakeTicker struct{ t *fakeTimer }
+
+func (t fakeTicker) C() <-chan time.Time { return t.t.c }
+func (t fakeTicker) Stop()               { t.t.clock.remove(t.t) }
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	translateTimeout = 500 * time.Millisecond
+	maxLanguageTag   = 35
+)
+
+// Translator translates a message body into lang for clients that registered
+// a preferred language via /me/language.
+type Translator interface {
+	Translate(ctx context.Context, text, lang string) (string, error)
+}
+
+// NoopTranslator leaves every message untranslated. It is the default.
+type NoopTranslator struct{}
+
+func (NoopTranslator) Translate(_ context.Context, text, _ string) (string, error) {
+	return text, nil
+}
+
+// HTTPTranslator calls an external translation service. It POSTs
+// {"text": ..., "target": ...} to Endpoint and expects {"translated": ...}.
+type HTTPTranslator struct {
+	Endpoint string
+	Client   *http.Client // http.DefaultClient when nil
+}
+
+func (t *HTTPTranslator) Translate(ctx context.Context, text, lang string) (string, error) {
+	body, err := json.Marshal(struct {
+		Text   string `json:"text"`
+		Target string `json:"target"`
+	}{text, lang})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("translator returned %s", resp.Status)
+	}
+	var out struct {
+		Translated string `json:"translated"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(nil, resp.Body, 4*maxMessageLength)).Decode(&out); err != nil {
+		return "", err
+	}
+	return out.Translated, nil
+}
+
+// WithTranslator sets the Translator used on delivery.
+func WithTranslator(t Translator) Option {
+	return func(cr *ChatRoom) {
+		if t != nil {
+			cr.translator = t
+		}
+	}
+}
+
+// translation is one message's body in one language. It is shared by every
+// delivery to a client with that language, so they cost one translator call,
+// and is resolved by whichever of them is read first.
+type translation struct {
+	done chan struct{} // Closed once text is final
+	text string        // Empty when translation failed or timed out
+}
+
+// translate returns the translation of msg into every language a client
+// currently prefers, each already under way, or nil when msg is not
+// translated: the translator is a no-op, msg is end-to-end encrypted or it
+// comes from the system. Called by the broadcast loop, which never waits
+// for the translator.
+func (cr *ChatRoom) translate(msg Message) map[string]*translation {
+	if _, noop := cr.translator.(NoopTranslator); noop || msg.opaque() || msg.From == systemSender {
+		return nil
+	}
+	var out map[string]*translation
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	for _, c := range cr.clients {
+		if c.lang == "" || out[c.lang] != nil {
+			continue
+		}
+		if out == nil {
+			out = make(map[string]*translation)
+		}
+		t := &translation{done: make(chan struct{})}
+		out[c.lang] = t
+		cr.startWorker(workerTranslate)
+		go func(lang string) {
+			defer cr.stopWorker(workerTranslate)
+			defer close(t.done)
+			ctx, cancel := context.WithTimeout(cr.ctx, translateTimeout)
+			defer cancel()
+			text, err := cr.translator.Translate(ctx, msg.Body, lang)
+			if err == nil && text != msg.Body && validateMessage(text) == nil {
+				t.text = text
+			}
+		}(c.lang)
+	}
+	return out
+}
+
+// translated returns m with its pending translation applied, waiting at
+// most translateTimeout for it. Without one it is m unchanged, original
+// text and all.
+func translated(m Message) Message {
+	t := m.translation
+	if t == nil {
+		return m
+	}
+	<-t.done
+	m.translation = nil
+	if t.text != "" {
+		m.Translated = t.text
+		m.line, m.envelope = formatLine(m), nil
+	}
+	return m
+}
+
+func validLanguageTag(lang string) bool {
+	if len(lang) < 2 || len(lang) > maxLanguageTag {
+		return false
+	}
+	for i := 0; i < len(lang); i++ {
+		c := lang[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// HandleLanguage sets (or with an empty lang, clears) the preferred language
+// messages are translated into for a client.
+func (cr *ChatRoom) HandleLanguage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID, ok := cr.sender(w, r)
+	if !ok {
+		return
+	}
+	lang := r.URL.Query().Get("lang")
+	if lang != "" && !validLanguageTag(lang) {
+		http.Error(w, "Invalid language tag", http.StatusBadRequest)
+		return
+	}
+	cr.mutex.Lock()
+	c, exists := cr.clients[clientID]
+	if exists {
+		c.lang = lang
+	}
+	cr.mutex.Unlock()
+	if !exists {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+	if lang == "" {
+		fmt.Fprintf(w, "Translation disabled for %s", clientID)
+		return
+	}
+	fmt.Fprintf(w, "Messages for %s will be translated to %s", clientID, lang)
+}
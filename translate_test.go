@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gatedTranslator translates to upper case once release is closed, or fails
+// for bodies starting with "fail".
+type gatedTranslator struct {
+	release chan struct{}
+	mu      sync.Mutex
+	calls   []string
+}
+
+func (g *gatedTranslator) Translate(ctx context.Context, text, lang string) (string, error) {
+	g.mu.Lock()
+	g.calls = append(g.calls, text+"/"+lang)
+	g.mu.Unlock()
+	select {
+	case <-g.release:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	if strings.HasPrefix(text, "fail") {
+		return "", errors.New("unavailable")
+	}
+	return strings.ToUpper(text), nil
+}
+
+func (g *gatedTranslator) callCount() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.calls)
+}
+
+func pollJSON(t *testing.T, h http.Handler, id, token string) Message {
+	t.Helper()
+	w := do(h, "GET", "/messages?wait=0&format=json&id="+id, tokenHeader, token)
+	var m Message
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &m) != nil {
+		t.Fatalf("poll %s: %d %s", id, w.Code, w.Body)
+	}
+	return m
+}
+
+func setLanguage(t *testing.T, h http.Handler, id, token, lang string) {
+	t.Helper()
+	if w := do(h, "POST", "/me/language?lang="+lang+"&id="+id, tokenHeader, token); w.Code != http.StatusOK {
+		t.Fatalf("set language: %d %s", w.Code, w.Body)
+	}
+}
+
+// A stuck translator holds back only the deliveries that need it.
+func TestTranslationIsOffTheFanOutPath(t *testing.T) {
+	tr := &gatedTranslator{release: make(chan struct{})}
+	_, h := newTestRoom(t, WithTranslator(tr))
+	ta := join(t, h, "alice")
+	tb := join(t, h, "bob")
+	tc := join(t, h, "carol")
+	tf := join(t, h, "fred")
+	setLanguage(t, h, "carol", tc, "fr")
+	setLanguage(t, h, "fred", tf, "fr")
+
+	if w := send(h, ta, "hello"); w.Code != http.StatusOK {
+		t.Fatalf("send: %d %s", w.Code, w.Body)
+	}
+	start := time.Now()
+	m := pollJSON(t, h, "bob", tb)
+	if m.Body != "hello" || m.Translated != "" {
+		t.Fatalf("bob got %+v", m)
+	}
+	if d := time.Since(start); d > translateTimeout/2 {
+		t.Errorf("untranslated delivery waited %s for the translator", d)
+	}
+
+	close(tr.release)
+	for _, c := range []struct{ id, token string }{{"carol", tc}, {"fred", tf}} {
+		if m := pollJSON(t, h, c.id, c.token); m.Body != "hello" || m.Translated != "HELLO" {
+			t.Errorf("%s got %+v, want the original with its translation", c.id, m)
+		}
+	}
+	if n := tr.callCount(); n != 1 {
+		t.Errorf("translator called %d times for one language, want 1", n)
+	}
+}
+
+func TestTranslationFallsBackToOriginal(t *testing.T) {
+	tr := &gatedTranslator{release: make(chan struct{})}
+	close(tr.release)
+	_, h := newTestRoom(t, WithTranslator(tr))
+	ta := join(t, h, "alice")
+	tc := join(t, h, "carol")
+	setLanguage(t, h, "carol", tc, "fr")
+	send(h, ta, "fail please")
+	if m := pollJSON(t, h, "carol", tc); m.Body != "fail please" || m.Translated != "" {
+		t.Errorf("got %+v, want the original text", m)
+	}
+
+	// A translator that never answers costs at most the deadline.
+	stuck := &gatedTranslator{release: make(chan struct{})}
+	_, h = newTestRoom(t, WithTranslator(stuck))
+	ta = join(t, h, "alice")
+	tc = join(t, h, "carol")
+	setLanguage(t, h, "carol", tc, "fr")
+	send(h, ta, "hello")
+	if m := pollJSON(t, h, "carol", tc); m.Body != "hello" || m.Translated != "" {
+		t.Errorf("got %+v, want the original text", m)
+	}
+}
+
+func TestSystemMessagesAreNotTranslated(t *testing.T) {
+	tr := &gatedTranslator{release: make(chan struct{})}
+	close(tr.release)
+	cr, h := newTestRoom(t, WithTranslator(tr))
+	tc := join(t, h, "carol")
+	setLanguage(t, h, "carol", tc, "fr")
+	if err := cr.Publish(Message{From: systemSender, Body: "maintenance at noon"}); err != nil {
+		t.Fatal(err)
+	}
+	if m := pollJSON(t, h, "carol", tc); m.Translated != "" {
+		t.Errorf("system message translated: %+v", m)
+	}
+	if n := tr.callCount(); n != 0 {
+		t.Errorf("translator called %d times", n)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"net/http"
+)
+
+//go:embed ui.html
+var uiPage []byte
+
+// uiETag lets browsers revalidate the page cheaply; it changes with every
+// build that changes the page.
+var uiETag = func() string {
+	sum := sha256.Sum256(uiPage)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}()
+
+// WithUI enables or disables the embedded web client at /ui. It is on by
+// default.
+func WithUI(enabled bool) Option {
+	return func(cr *ChatRoom) {
+		cr.uiDisabled = !enabled
+	}
+}
+
+// HandleUI serves a minimal browser client that talks to the public API like
+// any other client.
+func (cr *ChatRoom) HandleUI(w http.ResponseWriter, r *http.Request) {
+	if cr.uiDisabled {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("ETag", uiETag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if r.Header.Get("If-None-Match") == uiETag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+	w.Write(uiPage)
+}
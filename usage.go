@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// unattributed collects usage no sender caused: system messages,
+	// their deliveries and alert webhooks.
+	unattributed   = "unattributed"
+	usageDayFormat = "2006-01-02"
+	usageRetention = 92 // Days kept, enough for a quarter
+)
+
+// Usage is what one sender cost on one UTC day.
+type Usage struct {
+	Day            string `json:"day"`
+	Sender         string `json:"sender"`
+	Messages       int64  `json:"messages"`        // Published and recorded in history
+	StoredBytes    int64  `json:"stored_bytes"`    // Body and payload bytes those messages added to history
+	Deliveries     int64  `json:"deliveries"`      // Handed to a client, as counted by convo_deliveries_total
+	BytesDelivered int64  `json:"bytes_delivered"` // Wire bytes of those deliveries, as counted by bandwidth caps
+	Webhooks       int64  `json:"webhooks"`        // Webhook deliveries started
+}
+
+// usageLedger accumulates Usage as it happens, keyed by day then sender.
+type usageLedger struct {
+	mu   sync.Mutex
+	days map[string]map[string]*Usage
+}
+
+// add attributes usage to sender on the day of now, dropping days older than
+// usageRetention when a new one starts.
+func (l *usageLedger) add(now time.Time, sender string, f func(*Usage)) {
+	if sender == "" || sender == systemSender {
+		sender = unattributed
+	}
+	day := now.UTC().Format(usageDayFormat)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	senders, ok := l.days[day]
+	if !ok {
+		if l.days == nil {
+			l.days = make(map[string]map[string]*Usage)
+		}
+		cutoff := now.UTC().AddDate(0, 0, -usageRetention).Format(usageDayFormat)
+		for d := range l.days {
+			if d < cutoff {
+				delete(l.days, d)
+			}
+		}
+		senders = make(map[string]*Usage)
+		l.days[day] = senders
+	}
+	u, ok := senders[sender]
+	if !ok {
+		u = &Usage{Day: day, Sender: sender}
+		senders[sender] = u
+	}
+	f(u)
+}
+
+// report lists usage for days from through to (inclusive, either empty for
+// unbounded), by day then sender.
+func (l *usageLedger) report(from, to string) []Usage {
+	rows := []Usage{}
+	l.mu.Lock()
+	for day, senders := range l.days {
+		if from != "" && day < from || to != "" && day > to {
+			continue
+		}
+		for _, u := range senders {
+			rows = append(rows, *u)
+		}
+	}
+	l.mu.Unlock()
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Day != rows[j].Day {
+			return rows[i].Day < rows[j].Day
+		}
+		return rows[i].Sender < rows[j].Sender
+	})
+	return rows
+}
+
+func (cr *ChatRoom) recordStored(msg Message) {
+	cr.usage.add(msg.Time, msg.From, func(u *Usage) {
+		u.Messages++
+		u.StoredBytes += int64(len(msg.Body) + len(msg.Payload))
+	})
+}
+
+func (cr *ChatRoom) recordDelivered(m Message, cost int64, now time.Time) {
+	cr.usage.add(now, m.From, func(u *Usage) {
+		u.Deliveries++
+		u.BytesDelivered += cost
+	})
+}
+
+func (cr *ChatRoom) recordWebhook() {
+	cr.usage.add(cr.clock.Now(), unattributed, func(u *Usage) { u.Webhooks++ })
+}
+
+// HandleUsage serves GET /admin/usage?from=<day>&to=<day>&format=csv|json,
+// with days as YYYY-MM-DD in UTC.
+func (cr *ChatRoom) HandleUsage(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	for _, name := range []string{"from", "to"} {
+		if s := q.Get(name); s != "" {
+			if _, err := time.Parse(usageDayFormat, s); err != nil {
+				http.Error(w, name+" must be a day as YYYY-MM-DD", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	rows := cr.usage.report(q.Get("from"), q.Get("to"))
+	switch q.Get("format") {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rows)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"day", "sender", "messages", "stored_bytes", "deliveries", "bytes_delivered", "webhooks"})
+		for _, u := range rows {
+			cw.Write([]string{u.Day, u.Sender,
+				strconv.FormatInt(u.Messages, 10), strconv.FormatInt(u.StoredBytes, 10),
+				strconv.FormatInt(u.Deliveries, 10), strconv.FormatInt(u.BytesDelivered, 10),
+				strconv.FormatInt(u.Webhooks, 10)})
+		}
+		cw.Flush()
+	default:
+		http.Error(w, "format must be csv or json", http.StatusBadRequest)
+	}
+}
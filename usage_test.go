@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"chatroom/testutil"
+)
+
+func usageReport(t *testing.T, h http.Handler, query string) []Usage {
+	t.Helper()
+	var rows []Usage
+	if w := do(h, "GET", "/admin/usage"+query, asAdmin...); w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &rows) != nil {
+		t.Fatalf("usage: %d %s", w.Code, w.Body)
+	}
+	return rows
+}
+
+// Usage is attributed to the sender on the day it happens, and deliveries
+// add up to the /stats total.
+func TestUsageIsAttributedPerSenderAndDay(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	_, h := newTestRoom(t, WithClock(clk))
+	ta, tb := join(t, h, "alice"), join(t, h, "bob")
+	send(h, ta, "hello")
+	pollFrom(t, h, "alice", ta, "alice")
+	pollFrom(t, h, "bob", tb, "alice")
+	clk.Advance(24 * time.Hour)
+	send(h, tb, "next day")
+	pollFrom(t, h, "alice", ta, "bob")
+
+	rows := usageReport(t, h, "")
+	var deliveries int64
+	byKey := map[string]Usage{}
+	for _, u := range rows {
+		deliveries += u.Deliveries
+		byKey[u.Day+" "+u.Sender] = u
+	}
+	alice := byKey["2026-01-02 alice"]
+	if alice.Messages != 1 || alice.StoredBytes != 5 || alice.Deliveries != 2 || alice.BytesDelivered == 0 {
+		t.Errorf("alice's usage %+v", alice)
+	}
+	if bob := byKey["2026-01-03 bob"]; bob.Messages != 1 || bob.StoredBytes != 8 || bob.Deliveries < 1 {
+		t.Errorf("bob's usage %+v", bob)
+	}
+	var s Stats
+	json.Unmarshal(do(h, "GET", "/stats").Body.Bytes(), &s)
+	if uint64(deliveries) != s.Deliveries {
+		t.Errorf("usage adds up to %d deliveries, /stats counts %d", deliveries, s.Deliveries)
+	}
+
+	for _, u := range usageReport(t, h, "?from=2026-01-03&to=2026-01-03") {
+		if u.Day != "2026-01-03" {
+			t.Errorf("usage for %s outside the range", u.Day)
+		}
+	}
+	w := do(h, "GET", "/admin/usage?format=csv&to=2026-01-02", asAdmin...)
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if lines[0] != "day,sender,messages,stored_bytes,deliveries,bytes_delivered,webhooks" || len(lines) < 2 || !strings.HasPrefix(lines[1], "2026-01-02,") {
+		t.Errorf("csv usage:\n%s", w.Body)
+	}
+	for _, q := range []string{"?from=yesterday", "?to=2026-13-01", "?format=xml"} {
+		if w := do(h, "GET", "/admin/usage"+q, asAdmin...); w.Code != http.StatusBadRequest {
+			t.Errorf("usage%s: %d, want 400", q, w.Code)
+		}
+	}
+	if w := do(h, "GET", "/admin/usage"); w.Code != http.StatusForbidden {
+		t.Errorf("usage without the admin token: %d, want 403", w.Code)
+	}
+}
+
+// System usage goes to the unattributed bucket, and old days are dropped.
+func TestUsageLedger(t *testing.T) {
+	var l usageLedger
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	l.add(start, systemSender, func(u *Usage) { u.Messages++ })
+	l.add(start, "", func(u *Usage) { u.Webhooks++ })
+	if rows := l.report("", ""); len(rows) != 1 || rows[0].Sender != unattributed || rows[0].Messages != 1 || rows[0].Webhooks != 1 {
+		t.Errorf("usage %+v, want one unattributed row", rows)
+	}
+	l.add(start.AddDate(0, 0, usageRetention), "alice", func(u *Usage) { u.Messages++ })
+	if rows := l.report("", ""); len(rows) != 2 {
+		t.Errorf("usage %+v, want the first day kept for %d days", rows, usageRetention)
+	}
+	l.add(start.AddDate(0, 0, usageRetention+1), "alice", func(u *Usage) { u.Messages++ })
+	if rows := l.report("", ""); len(rows) != 2 || rows[0].Sender != "alice" {
+		t.Errorf("usage %+v, want the first day dropped", rows)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	maxClientIDLength = 64
+	maxMessageLength  = 4096     // bytes
+	maxHeaderBytes    = 64 << 10 // room for a body and a code payload percent-encoded in the query
+)
+
+var (
+	errInvalidClientID = errors.New("client ID must be 1-64 characters of letters, digits, '.', '_', '-' or '@'")
+	errMessageTooLong  = errors.New("message is too long")
+	errInvalidMessage  = errors.New("message must be valid UTF-8 without control characters")
+)
+
+// validClientID reports whether id is safe to use as a map key, in log lines
+// and in "from: body" output without being confused for another client.
+func validClientID(id string) bool {
+	if id == "" || len(id) > maxClientIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '.', c == '_', c == '-', c == '@':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// validateMessage rejects oversized bodies, invalid UTF-8 and control
+// characters. Newlines and tabs are allowed; carriage returns are not, so a
+// body can never smuggle CRLF into headers or line-framed output. The text
+// format indents the lines after a newline, so they cannot pose as
+// messages of their own.
+func validateMessage(body string) error {
+	if len(body) > maxMessageLength {
+		return errMessageTooLong
+	}
+	if !utf8.ValidString(body) {
+		return errInvalidMessage
+	}
+	if strings.IndexFunc(body, func(r rune) bool {
+		return unicode.IsControl(r) && r != '\n' && r != '\t'
+	}) >= 0 {
+		return errInvalidMessage
+	}
+	return nil
+}
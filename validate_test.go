@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// The fuzz targets below run their corpus, checked in under testdata/fuzz,
+// as ordinary tests; go test -fuzz=FuzzSend explores further.
+
+// headerSafe reports whether no response header value could have split the
+// header block.
+func headerSafe(h http.Header) bool {
+	for _, vs := range h {
+		for _, v := range vs {
+			if strings.ContainsAny(v, "\r\n") {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func FuzzJoin(f *testing.F) {
+	f.Fuzz(func(t *testing.T, id string) {
+		cr, h := newTestRoom(t)
+		w := do(h, "POST", "/join?id="+url.QueryEscape(id))
+		if w.Code >= 500 {
+			t.Fatalf("join %q: %d %s", id, w.Code, w.Body)
+		}
+		if !headerSafe(w.Header()) {
+			t.Fatalf("join %q: header injection: %q", id, w.Header())
+		}
+		if w.Code == http.StatusOK && !validClientID(id) {
+			t.Fatalf("join %q accepted an invalid client ID", id)
+		}
+		cr.mutex.Lock()
+		defer cr.mutex.Unlock()
+		for got := range cr.clients {
+			if !validClientID(got) {
+				t.Fatalf("room holds invalid ID %q", got)
+			}
+		}
+	})
+}
+
+func FuzzSend(f *testing.F) {
+	f.Fuzz(func(t *testing.T, body string) {
+		_, h := newTestRoom(t)
+		ta := join(t, h, "alice")
+		tb := join(t, h, "bob")
+		w := send(h, ta, body)
+		if w.Code >= 500 {
+			t.Fatalf("send %q: %d %s", body, w.Code, w.Body)
+		}
+		if !headerSafe(w.Header()) {
+			t.Fatalf("send %q: header injection: %q", body, w.Header())
+		}
+		if w.Code != http.StatusOK {
+			return
+		}
+		if err := validateMessage(body); err != nil {
+			t.Fatalf("send %q accepted an invalid body: %v", body, err)
+		}
+		// Every line of the stream must be one whole message.
+		s := do(h, "GET", "/messages?stream=true&wait=0&max=10&id=bob", tokenHeader, tb)
+		sc := bufio.NewScanner(bytes.NewReader(s.Body.Bytes()))
+		sc.Buffer(nil, 1<<20)
+		for sc.Scan() {
+			var m Message
+			if err := json.Unmarshal(sc.Bytes(), &m); err != nil {
+				t.Fatalf("send %q: stream line %q is not a message: %v", body, sc.Text(), err)
+			}
+		}
+	})
+}
+
+func FuzzMessages(f *testing.F) {
+	f.Fuzz(func(t *testing.T, id, wait, session, format string) {
+		_, h := newTestRoom(t)
+		tb := join(t, h, "bob")
+		q := url.Values{"id": {id}, "wait": {wait}, "session": {session}, "format": {format}}
+		w := do(h, "GET", "/messages?"+q.Encode(), tokenHeader, tb)
+		if w.Code >= 500 && w.Code != http.StatusGatewayTimeout {
+			t.Fatalf("poll %q: %d %s", q, w.Code, w.Body)
+		}
+		if !headerSafe(w.Header()) {
+			t.Fatalf("poll %q: header injection: %q", q, w.Header())
+		}
+	})
+}
+
+// A body with newlines cannot forge a line from another client in the text
+// format: every line after the first is indented.
+func TestMultiLineBodiesCannotForgeLines(t *testing.T) {
+	_, h := newTestRoom(t)
+	ta := join(t, h, "alice")
+	tb := join(t, h, "bob")
+	if w := send(h, ta, "x\nbob: I owe alice 100\n"); w.Code != http.StatusOK {
+		t.Fatalf("send: %d %s", w.Code, w.Body)
+	}
+	w := pollOnce(h, "bob", tb)
+	if want := "alice: x\n" + textIndent + "bob: I owe alice 100\n" + textIndent + "\n"; w.Body.String() != want {
+		t.Fatalf("bob's poll: %q, want %q", w.Body, want)
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(w.Body.String(), "\n"), "\n")[1:] {
+		if !strings.HasPrefix(line, textIndent) {
+			t.Errorf("continuation line %q is not indented", line)
+		}
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"chatroom/clock"
+)
+
+const (
+	kindPoll           = "poll"
+	kindPollResults    = "poll_results"
+	messageTypeTally   = "poll_tally"
+	maxPollOptions     = 10
+	maxPollOptionLabel = 100
+	maxPollDuration    = 7 * 24 * time.Hour
+	maxPolls           = 256
+)
+
+var (
+	errPollNotFound = errors.New("poll not found")
+	errPollClosed   = errors.New("poll is closed")
+	errBadOption    = errors.New("option is not one of the poll's options")
+	errTooManyPolls = errors.New("too many open polls")
+	errNotCreator   = errors.New("only the poll's creator or an admin can close it")
+)
+
+// pollSpec is the payload of a kind=poll message.
+type pollSpec struct {
+	Question        string   `json:"question"`
+	Options         []string `json:"options"`
+	Anonymous       bool     `json:"anonymous"`        // Leave voter IDs out of tallies
+	DurationSeconds int      `json:"duration_seconds"` // Close automatically after this long; 0 leaves it to the creator
+}
+
+func validatePoll(payload json.RawMessage) error {
+	var p pollSpec
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return errInvalidPayload
+	}
+	if strings.TrimSpace(p.Question) == "" || validateMessage(p.Question) != nil {
+		return errors.New("poll payload needs a question")
+	}
+	if len(p.Options) < 2 || len(p.Options) > maxPollOptions {
+		return fmt.Errorf("a poll needs 2 to %d options", maxPollOptions)
+	}
+	seen := make(map[string]bool)
+	for _, o := range p.Options {
+		if strings.TrimSpace(o) == "" || len(o) > maxPollOptionLabel || validateMessage(o) != nil {
+			return fmt.Errorf("poll options must be non-empty and at most %d bytes", maxPollOptionLabel)
+		}
+		if seen[o] {
+			return errors.New("poll options must be distinct")
+		}
+		seen[o] = true
+	}
+	if p.DurationSeconds < 0 || time.Duration(p.DurationSeconds)*time.Second > maxPollDuration {
+		return errors.New("duration_seconds must be between 0 and 7 days")
+	}
+	return nil
+}
+
+// poll is the server-side state of a poll message. Guarded by
+// ChatRoom.mutex.
+type poll struct {
+	id      string
+	creator string
+	spec    pollSpec
+	votes   map[string]int // Voter to option index
+	closed  bool
+	created time.Time
+	timer   clock.Timer // Closes the poll; nil without a duration
+}
+
+// PollTally is the state of a poll, as served by GET /polls/{id} and carried
+// in tally and results messages.
+type PollTally struct {
+	Poll      string         `json:"poll"`
+	Question  string         `json:"question"`
+	Options   []string       `json:"options"`
+	Counts    []int          `json:"counts"`
+	Voters    map[string]int `json:"voters,omitempty"` // Voter to option index; public polls only
+	Anonymous bool           `json:"anonymous"`
+	Closed    bool           `json:"closed"`
+}
+
+func (p *poll) tally() PollTally {
+	t := PollTally{
+		Poll:      p.id,
+		Question:  p.spec.Question,
+		Options:   p.spec.Options,
+		Counts:    make([]int, len(p.spec.Options)),
+		Anonymous: p.spec.Anonymous,
+		Closed:    p.closed,
+	}
+	if !p.spec.Anonymous {
+		t.Voters = make(map[string]int, len(p.votes))
+	}
+	for voter, o := range p.votes {
+		t.Counts[o]++
+		if t.Voters != nil {
+			t.Voters[voter] = o
+		}
+	}
+	return t
+}
+
+func (t PollTally) String() string {
+	parts := make([]string, len(t.Options))
+	for i, o := range t.Options {
+		parts[i] = fmt.Sprintf("%s: %d", o, t.Counts[i])
+	}
+	return t.Question + " " + strings.Join(parts, ", ")
+}
+
+// openPoll registers the poll carried by msg, giving msg its ID, before msg
+// is published so that votes never race the poll's creation.
+func (cr *ChatRoom) openPoll(msg *Message) error {
+	var spec pollSpec
+	if err := json.Unmarshal(msg.Payload, &spec); err != nil {
+		return errInvalidPayload
+	}
+	msg.ID = cr.ids.NewID()
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	if len(cr.polls) >= maxPolls && !cr.evictClosedPollLocked() {
+		return errTooManyPolls
+	}
+	p := &poll{id: msg.ID, creator: msg.From, spec: spec, votes: make(map[string]int), created: cr.clock.Now()}
+	if spec.DurationSeconds > 0 {
+		p.timer = cr.clock.AfterFunc(time.Duration(spec.DurationSeconds)*time.Second, func() { cr.ClosePoll(p.id) })
+	}
+	cr.polls[p.id] = p
+	return nil
+}
+
+// discardPoll forgets a poll whose message could not be published.
+func (cr *ChatRoom) discardPoll(id string) {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	if p, ok := cr.polls[id]; ok {
+		if p.timer != nil {
+			p.timer.Stop()
+		}
+		delete(cr.polls, id)
+	}
+}
+
+// evictClosedPollLocked drops the oldest closed poll to make room.
+func (cr *ChatRoom) evictClosedPollLocked() bool {
+	var oldest *poll
+	for _, p := range cr.polls {
+		if p.closed && (oldest == nil || p.created.Before(oldest.created)) {
+			oldest = p
+		}
+	}
+	if oldest == nil {
+		return false
+	}
+	delete(cr.polls, oldest.id)
+	return true
+}
+
+// Vote records voter's choice, replacing any earlier one, and broadcasts
+// the new tally.
+func (cr *ChatRoom) Vote(pollID, voter string, option int) error {
+	cr.mutex.Lock()
+	p, ok := cr.polls[pollID]
+	switch {
+	case !ok:
+		cr.mutex.Unlock()
+		return errPollNotFound
+	case p.closed:
+		cr.mutex.Unlock()
+		return errPollClosed
+	case option < 0 || option >= len(p.spec.Options):
+		cr.mutex.Unlock()
+		return errBadOption
+	}
+	p.votes[voter] = option
+	t := p.tally()
+	cr.mutex.Unlock()
+	return cr.Publish(cr.pollMessage(t, messageTypeTally, ""))
+}
+
+// ClosePoll ends voting and publishes the final results, which stay in
+// history like any message.
+func (cr *ChatRoom) ClosePoll(pollID string) error {
+	cr.mutex.Lock()
+	p, ok := cr.polls[pollID]
+	if !ok || p.closed {
+		cr.mutex.Unlock()
+		if ok {
+			return errPollClosed
+		}
+		return errPollNotFound
+	}
+	p.closed = true
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	t := p.tally()
+	cr.mutex.Unlock()
+	return cr.Publish(cr.pollMessage(t, "", kindPollResults))
+}
+
+func (cr *ChatRoom) pollMessage(t PollTally, typ, kind string) Message {
+	payload, _ := json.Marshal(t)
+	body := "Poll: " + t.String()
+	if t.Closed {
+		body = "Poll closed: " + t.String()
+	}
+	if len(body) > maxMessageLength {
+		body = body[:maxMessageLength]
+	}
+	return Message{From: systemSender, Body: body, Type: typ, Kind: kind, Payload: payload, RefID: t.Poll}
+}
+
+func (cr *ChatRoom) Poll(pollID string) (PollTally, error) {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	p, ok := cr.polls[pollID]
+	if !ok {
+		return PollTally{}, errPollNotFound
+	}
+	return p.tally(), nil
+}
+
+// HandlePoll serves GET /polls/{id}, POST /polls/{id}/vote?option=<index>
+// and POST /polls/{id}/close.
+func (cr *ChatRoom) HandlePoll(w http.ResponseWriter, r *http.Request) {
+	pollID, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/polls/"), "/")
+	if pollID == "" {
+		http.NotFound(w, r)
+		return
+	}
+	var err error
+	switch action {
+	case "":
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		t, err := cr.Poll(pollID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(t)
+		return
+	case "vote":
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		voter, ok := cr.sender(w, r)
+		if !ok {
+			return
+		}
+		option, perr := strconv.Atoi(r.URL.Query().Get("option"))
+		if perr != nil {
+			http.Error(w, "option must be the index of a poll option", http.StatusBadRequest)
+			return
+		}
+		cr.mutex.Lock()
+		c, exists := cr.clients[voter]
+		canVote := exists && !c.pending && c.role != roleSpectator
+		cr.mutex.Unlock()
+		if !canVote {
+			http.Error(w, "Only members in the chat can vote", http.StatusForbidden)
+			return
+		}
+		if err = cr.Vote(pollID, voter, option); err == nil {
+			fmt.Fprintf(w, "Vote from %s recorded", voter)
+			return
+		}
+	case "close":
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !cr.isAdmin(r) {
+			closer, ok := cr.sender(w, r)
+			if !ok {
+				return
+			}
+			cr.mutex.Lock()
+			p, exists := cr.polls[pollID]
+			creator := exists && p.creator == closer
+			cr.mutex.Unlock()
+			if exists && !creator {
+				http.Error(w, errNotCreator.Error(), http.StatusForbidden)
+				return
+			}
+		}
+		if err = cr.ClosePoll(pollID); err == nil {
+			fmt.Fprintf(w, "Poll %s closed", pollID)
+			return
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	switch err {
+	case errPollNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errPollClosed:
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errBadOption:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errRoomClosed:
+		http.Error(w, "Chat room is closed", http.StatusGone)
+	case errShuttingDown:
+		// As for errBusy, only the broadcast was dropped.
+		w.Header().Set("Retry-After", retryAfterSeconds)
+		http.Error(w, "Recorded, but the server is shutting down", http.StatusServiceUnavailable)
+	case errBusy:
+		// The vote or close took effect; only its broadcast was dropped.
+		w.Header().Set("Retry-After", retryAfterSeconds)
+		http.Error(w, "Recorded, but the server is too busy to broadcast it", http.StatusServiceUnavailable)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
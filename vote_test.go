@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"chatroom/testutil"
+)
+
+// createPoll sends a kind=poll message as token and returns the poll's ID.
+func createPoll(t *testing.T, h http.Handler, token, payload string) string {
+	t.Helper()
+	q := url.Values{"format": {"json"}, "message": {"poll"}, "kind": {kindPoll}, "payload": {payload}}
+	w := do(h, "POST", "/send?"+q.Encode(), tokenHeader, token)
+	var res SendResult
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &res) != nil || res.Message.ID == "" {
+		t.Fatalf("create poll: %d %s", w.Code, w.Body)
+	}
+	return res.Message.ID
+}
+
+func vote(h http.Handler, token, pollID, option string) int {
+	return do(h, "POST", "/polls/"+pollID+"/vote?option="+option, tokenHeader, token).Code
+}
+
+func tally(t *testing.T, h http.Handler, pollID string) PollTally {
+	t.Helper()
+	var pt PollTally
+	if w := do(h, "GET", "/polls/"+pollID); w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &pt) != nil {
+		t.Fatalf("tally: %d %s", w.Code, w.Body)
+	}
+	return pt
+}
+
+func TestPollPayloadValidation(t *testing.T) {
+	for _, payload := range []string{
+		`{"question": "", "options": ["a", "b"]}`,
+		`{"question": "Lunch?", "options": ["pizza"]}`,
+		`{"question": "Lunch?", "options": ["pizza", "pizza"]}`,
+		`{"question": "Lunch?", "options": ["pizza", " "]}`,
+		`{"question": "Lunch?", "options": ["pizza", "soup"], "duration_seconds": 700000}`,
+	} {
+		if err := validatePoll(json.RawMessage(payload)); err == nil {
+			t.Errorf("accepted poll %s", payload)
+		}
+	}
+	if err := validatePoll(json.RawMessage(`{"question": "Lunch?", "options": ["pizza", "soup"]}`)); err != nil {
+		t.Errorf("rejected a valid poll: %v", err)
+	}
+}
+
+// Members vote once each, may change their vote until the poll closes, and
+// see the tally move; only the creator or an admin closes it.
+func TestPollVotingAndClosing(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	_, h := newTestRoom(t, WithClock(clk))
+	ta, tb, tc := join(t, h, "alice"), join(t, h, "bob"), join(t, h, "carol")
+	watcher := joinAs(t, h, "dave", roleSpectator)
+	id := createPoll(t, h, ta, `{"question": "Lunch?", "options": ["pizza", "soup"]}`)
+
+	for _, v := range []struct {
+		token, option string
+		want          int
+	}{
+		{tb, "1", http.StatusOK},
+		{tc, "0", http.StatusOK},
+		{tb, "0", http.StatusOK}, // bob changes the vote
+		{tb, "2", http.StatusBadRequest},
+		{tb, "pizza", http.StatusBadRequest},
+		{watcher, "0", http.StatusForbidden},
+	} {
+		if code := vote(h, v.token, id, v.option); code != v.want {
+			t.Errorf("vote for option %s: %d, want %d", v.option, code, v.want)
+		}
+	}
+	if code := vote(h, tb, "no-such-poll", "0"); code != http.StatusNotFound {
+		t.Errorf("vote in a missing poll: %d, want 404", code)
+	}
+	pt := tally(t, h, id)
+	if pt.Counts[0] != 2 || pt.Counts[1] != 0 || pt.Voters["bob"] != 0 || pt.Voters["carol"] != 0 || pt.Closed {
+		t.Errorf("tally %+v, want 2 for pizza from bob and carol", pt)
+	}
+
+	if w := do(h, "POST", "/polls/"+id+"/close", tokenHeader, tb); w.Code != http.StatusForbidden {
+		t.Errorf("close by a voter: %d, want 403", w.Code)
+	}
+	if w := do(h, "POST", "/polls/"+id+"/close", tokenHeader, ta); w.Code != http.StatusOK {
+		t.Fatalf("close by the creator: %d %s", w.Code, w.Body)
+	}
+	if code := vote(h, tc, id, "1"); code != http.StatusConflict {
+		t.Errorf("vote in a closed poll: %d, want 409", code)
+	}
+	if w := do(h, "POST", "/polls/"+id+"/close", asAdmin...); w.Code != http.StatusConflict {
+		t.Errorf("closing a closed poll: %d, want 409", w.Code)
+	}
+	if pt := tally(t, h, id); !pt.Closed || pt.Counts[0] != 2 {
+		t.Errorf("tally after closing %+v", pt)
+	}
+}
+
+// Tally events reach only sessions that declared polls; everyone gets the
+// final results, and an anonymous poll never names its voters.
+func TestPollTalliesAndResults(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	_, h := newTestRoom(t, WithClock(clk))
+	ta := join(t, h, "alice")
+	w := do(h, "POST", "/join?id=bob&capabilities=kinds,polls")
+	if w.Code != http.StatusOK {
+		t.Fatalf("join: %d %s", w.Code, w.Body)
+	}
+	tb := w.Header().Get(tokenHeader)
+	tc := join(t, h, "carol")
+	id := createPoll(t, h, ta, `{"question": "Lunch?", "options": ["pizza", "soup"], "anonymous": true, "duration_seconds": 60}`)
+	if code := vote(h, tc, id, "1"); code != http.StatusOK {
+		t.Fatalf("vote: %d", code)
+	}
+
+	var m Message
+	var pt PollTally
+	if json.Unmarshal(pollFrom(t, h, "bob", tb, systemSender), &m) != nil || m.Type != messageTypeTally || m.RefID != id {
+		t.Fatalf("bob's first system message %+v, want the tally", m)
+	}
+	if json.Unmarshal(m.Payload, &pt) != nil || pt.Counts[1] != 1 || pt.Voters != nil {
+		t.Errorf("anonymous tally %s, want one vote for soup and no voters", m.Payload)
+	}
+
+	// The poll closes itself when its duration is up.
+	clk.Advance(time.Minute)
+	eventually(t, "the poll to close", func() bool { return tally(t, h, id).Closed })
+	for _, c := range []struct{ id, token string }{{"bob", tb}, {"carol", tc}} {
+		m = Message{}
+		if json.Unmarshal(pollFrom(t, h, c.id, c.token, systemSender), &m) != nil || m.Kind != kindPollResults || m.Body != "Poll closed: Lunch? pizza: 0, soup: 1" {
+			t.Errorf("%s's next system message %+v, want the results", c.id, m)
+		}
+	}
+}
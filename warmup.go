@@ -0,0 +1,106 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWarmupJoinRate = 50
+	maxWarmupRetryAfter   = 10 // seconds
+)
+
+// Warmup limits joins for a while after startup, when every client of the
+// previous instance reconnects at once.
+type Warmup struct {
+	Window   time.Duration // How long after startup the limit applies; zero turns it off
+	JoinRate int           // Joins admitted per second during the window
+}
+
+// WithWarmup enables startup admission control.
+func WithWarmup(cfg Warmup) Option {
+	return func(cr *ChatRoom) {
+		if cfg.JoinRate <= 0 {
+			cfg.JoinRate = defaultWarmupJoinRate
+		}
+		cr.warmup.cfg = cfg
+	}
+}
+
+// admission is the warm-up state. It ends at the end of the window, or
+// earlier once a whole second passes with fewer joins than the limit.
+type admission struct {
+	mu       sync.Mutex
+	cfg      Warmup
+	until    time.Time
+	started  int64 // Unix second of startup, which only partly falls in the window
+	second   int64 // Unix second that count covers
+	count    int   // Joins attempted in second
+	rejected int64
+}
+
+func (a *admission) start(now time.Time) {
+	if a.cfg.Window > 0 {
+		a.until = now.Add(a.cfg.Window)
+		a.started, a.second = now.Unix(), now.Unix()
+	}
+}
+
+func (a *admission) warmingLocked(now time.Time) bool {
+	return !a.until.IsZero() && now.Before(a.until)
+}
+
+// admit reports whether a join may go ahead and, if not, how many seconds
+// the client should wait. Waits are spread at random so rejected clients do
+// not all come back together.
+func (a *admission) admit(now time.Time) (bool, int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.warmingLocked(now) {
+		return true, 0
+	}
+	if s := now.Unix(); s != a.second {
+		// The herd has dispersed once a full second stays under the limit.
+		if a.second > a.started && a.count < a.cfg.JoinRate || s > a.second+1 {
+			a.until = time.Time{}
+			return true, 0
+		}
+		a.second, a.count = s, 0
+	}
+	a.count++
+	if a.count <= a.cfg.JoinRate {
+		return true, 0
+	}
+	a.rejected++
+	wait := 1 + rand.Intn(min(maxWarmupRetryAfter, int(a.until.Sub(now)/time.Second)+1))
+	return false, wait
+}
+
+// AdmissionStats is the warm-up state reported by /stats.
+type AdmissionStats struct {
+	Warming  bool  `json:"warming"`
+	Rejected int64 `json:"rejected_joins"`
+}
+
+func (a *admission) stats(now time.Time) AdmissionStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return AdmissionStats{Warming: a.warmingLocked(now), Rejected: a.rejected}
+}
+
+// admitJoin turns away joins over the warm-up limit with 503. Admins are
+// always admitted.
+func (cr *ChatRoom) admitJoin(w http.ResponseWriter, r *http.Request) bool {
+	if cr.isAdmin(r) {
+		return true
+	}
+	ok, wait := cr.warmup.admit(cr.clock.Now())
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(wait))
+		http.Error(w, "Server is starting up and admitting joins gradually; retry shortly", http.StatusServiceUnavailable)
+	}
+	return ok
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"chatroom/testutil"
+)
+
+// During the warm-up, joins over the rate get 503 with a Retry-After, until
+// a whole second stays under the rate.
+func TestWarmupAdmitsJoinsGradually(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	_, h := newTestRoom(t, WithClock(clk), WithWarmup(Warmup{Window: 30 * time.Second, JoinRate: 3}))
+	n := 0
+	joinNext := func() *http.Response {
+		n++
+		return do(h, "POST", fmt.Sprintf("/join?id=user%d", n)).Result()
+	}
+	warmupStats := func() AdmissionStats {
+		var s Stats
+		json.Unmarshal(do(h, "GET", "/stats").Body.Bytes(), &s)
+		return s.Admission
+	}
+
+	// Two busy seconds: three joins get in, the fourth is turned away.
+	for second := 0; second < 2; second++ {
+		for i := 0; i < 3; i++ {
+			if resp := joinNext(); resp.StatusCode != http.StatusOK {
+				t.Fatalf("join %d in second %d: %d", i+1, second, resp.StatusCode)
+			}
+		}
+		resp := joinNext()
+		wait, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		if resp.StatusCode != http.StatusServiceUnavailable || wait < 1 || wait > maxWarmupRetryAfter {
+			t.Errorf("join over the rate: %d Retry-After %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+		}
+		if w := do(h, "POST", fmt.Sprintf("/join?id=operator%d", second), asAdmin...); w.Code != http.StatusOK {
+			t.Errorf("admin join over the rate: %d", w.Code)
+		}
+		clk.Advance(time.Second)
+	}
+	if got := warmupStats(); !got.Warming || got.Rejected != 2 {
+		t.Errorf("admission %+v, want warming with 2 rejected", got)
+	}
+
+	// A quiet second ends the warm-up early.
+	joinNext()
+	clk.Advance(time.Second)
+	for i := 0; i < 5; i++ {
+		if resp := joinNext(); resp.StatusCode != http.StatusOK {
+			t.Errorf("join after the warm-up ended: %d", resp.StatusCode)
+		}
+	}
+	if got := warmupStats(); got.Warming {
+		t.Errorf("admission %+v after a quiet second, want the warm-up over", got)
+	}
+}
+
+// Retry-After is jittered and never points past the end of the window.
+func TestWarmupRetryAfterIsJittered(t *testing.T) {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	a := admission{cfg: Warmup{Window: 3 * time.Second, JoinRate: 1}}
+	a.start(start)
+	seen := map[int]bool{}
+	for i := 0; i < 100; i++ {
+		if ok, wait := a.admit(start); !ok {
+			seen[wait] = true
+		}
+	}
+	if len(seen) < 2 {
+		t.Errorf("waits %v, want them spread", seen)
+	}
+	for wait := range seen {
+		if wait < 1 || wait > 4 {
+			t.Errorf("wait %ds with 3s of the window left", wait)
+		}
+	}
+	if ok, _ := a.admit(start.Add(3 * time.Second)); !ok {
+		t.Error("a join was turned away after the window")
+	}
+}
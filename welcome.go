@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Welcome is the body of /admin/welcome.
+type Welcome struct {
+	Text string `json:"text"` // Empty when no welcome is sent
+}
+
+// WithWelcome sets the text sent to each new joiner before anything else.
+func WithWelcome(text string) Option {
+	return func(cr *ChatRoom) {
+		if validateMessage(text) == nil {
+			cr.welcome = text
+		}
+	}
+}
+
+func (cr *ChatRoom) Welcome() Welcome {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	return Welcome{Text: cr.welcome}
+}
+
+// SetWelcome changes the welcome for clients that join from now on; an
+// empty text turns it off.
+func (cr *ChatRoom) SetWelcome(text string) error {
+	if err := validateMessage(text); err != nil {
+		return err
+	}
+	cr.mutex.Lock()
+	cr.welcome = text
+	cr.mutex.Unlock()
+	cr.audit.add(AuditEntry{Action: "welcome", Actor: actorAdmin, Detail: text})
+	return nil
+}
+
+// welcomeNotice returns the welcome as an ephemeral message for the first
+// poll of session c, once owner has been let in. The text is the one set
+// when the session joined.
+func (cr *ChatRoom) welcomeNotice(owner, c *client) (Message, bool) {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+	if owner.pending || c.welcome == "" {
+		return Message{}, false
+	}
+	msg := Message{
+		ID:   cr.ids.NewID(),
+		From: systemSender,
+		Body: c.welcome,
+		Time: cr.clock.Now(),
+		Type: messageTypeEphemeral,
+	}
+	msg.line = formatLine(msg)
+	c.welcome = ""
+	return msg, true
+}
+
+// HandleWelcome gets or sets the welcome: POST /admin/welcome with
+// {"text": ...}.
+func (cr *ChatRoom) HandleWelcome(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req Welcome
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 2*maxMessageLength)).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		if err := cr.SetWelcome(req.Text); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cr.Welcome())
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"chatroom/testutil"
+)
+
+func setWelcome(h http.Handler, text string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(Welcome{Text: text})
+	req := httptest.NewRequest("POST", "/admin/welcome", strings.NewReader(string(body)))
+	req.Header.Set(asAdmin[0], asAdmin[1])
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+// The welcome is the first thing a new session's poll returns, ahead of
+// messages already queued for it, and only the first.
+func TestWelcomeComesFirstAndOnce(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	_, h := newTestRoom(t, WithClock(clk), WithWelcome("Be kind; no spoilers."))
+	ta, tb := join(t, h, "alice"), join(t, h, "bob")
+	send(h, tb, "hello alice")
+
+	if w := pollOnce(h, "alice", ta); w.Code != http.StatusOK || w.Body.String() != "[ephemeral] "+systemSender+": Be kind; no spoilers.\n" {
+		t.Errorf("first poll: %d %q, want the welcome", w.Code, w.Body)
+	}
+	if w := pollOnce(h, "alice", ta); !strings.Contains(w.Body.String(), "bob: hello alice") {
+		t.Errorf("second poll: %d %q, want bob's message", w.Code, w.Body)
+	}
+	send(h, tb, "again")
+	if w := pollOnce(h, "alice", ta); strings.Contains(w.Body.String(), "no spoilers") {
+		t.Errorf("the welcome was sent twice: %q", w.Body)
+	}
+}
+
+func TestAdminChangesTheWelcome(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	cr, h := newTestRoom(t, WithClock(clk), WithWelcome("Old rules"))
+	ta := join(t, h, "alice")
+	if w := setWelcome(h, "New rules"); w.Code != http.StatusOK {
+		t.Fatalf("set welcome: %d %s", w.Code, w.Body)
+	}
+	if got := cr.Welcome().Text; got != "New rules" {
+		t.Errorf("welcome %q after setting it", got)
+	}
+	// alice joined before the change and keeps the old welcome.
+	if w := pollOnce(h, "alice", ta); !strings.Contains(w.Body.String(), "Old rules") {
+		t.Errorf("alice's first poll: %q, want the welcome from when she joined", w.Body)
+	}
+	tb := join(t, h, "bob")
+	if w := pollOnce(h, "bob", tb); !strings.Contains(w.Body.String(), "New rules") {
+		t.Errorf("bob's first poll: %q, want the new welcome", w.Body)
+	}
+
+	if w := setWelcome(h, strings.Repeat("x", maxMessageLength+1)); w.Code != http.StatusBadRequest {
+		t.Errorf("oversized welcome: %d, want 400", w.Code)
+	}
+	if w := setWelcome(h, ""); w.Code != http.StatusOK {
+		t.Fatalf("clear welcome: %d %s", w.Code, w.Body)
+	}
+	tc := join(t, h, "carol")
+	send(h, tb, "welcome, carol")
+	if w := pollOnce(h, "carol", tc); !strings.Contains(w.Body.String(), "bob: welcome, carol") {
+		t.Errorf("first poll with the welcome off: %d %q, want bob's message", w.Code, w.Body)
+	}
+}
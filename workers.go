@@ -0,0 +1,72 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Kinds of internal goroutine, as counted in /debug/internals.
+const (
+	workerBroadcast    = "broadcast"
+	workerActivity     = "activity"
+	workerAlerts       = "alerts"
+	workerSubscription = "subscription"
+	workerPreview      = "preview"
+	workerWebhook      = "webhook"
+	workerHighlight    = "highlight"
+	workerTranslate    = "translate"
+	workerExport       = "export"
+)
+
+// workers is the room's own count of the goroutines it started and has not
+// seen finish, and of when each periodic one last did its work. Comparing it
+// with pprof's goroutine profile shows leaks.
+type workers struct {
+	mu      sync.Mutex
+	live    map[string]int
+	lastRun map[string]time.Time
+}
+
+// startWorker is wg.Add(1) for a goroutine of the given kind; the goroutine
+// must call stopWorker with the same kind when it exits.
+func (cr *ChatRoom) startWorker(kind string) {
+	cr.wg.Add(1)
+	cr.workers.add(kind, 1)
+}
+
+func (cr *ChatRoom) stopWorker(kind string) {
+	cr.workers.add(kind, -1)
+	cr.wg.Done()
+}
+
+func (w *workers) add(kind string, n int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.live == nil {
+		w.live = make(map[string]int)
+	}
+	w.live[kind] += n
+}
+
+func (w *workers) ran(kind string, t time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.lastRun == nil {
+		w.lastRun = make(map[string]time.Time)
+	}
+	w.lastRun[kind] = t
+}
+
+func (w *workers) snapshot() (live map[string]int, lastRun map[string]time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	live = make(map[string]int, len(w.live))
+	for k, n := range w.live {
+		live[k] = n
+	}
+	lastRun = make(map[string]time.Time, len(w.lastRun))
+	for k, t := range w.lastRun {
+		lastRun[k] = t
+	}
+	return live, lastRun
+}